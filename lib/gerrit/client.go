@@ -0,0 +1,504 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package gerrit
+
+import "bufio"
+import "bytes"
+import "context"
+import "errors"
+import "fmt"
+import "golang.org/x/crypto/ssh"
+import "sync"
+import "time"
+
+// Config controls the behaviour of a Client.
+type Config struct {
+	// The host:port of Gerrit's SSH daemon, e.g. codereview.qt-project.org:29418.
+	Host string
+
+	// Who to log in as, and the key to log in with.
+	User   string
+	Signer ssh.Signer
+
+	// How to verify Gerrit's host key. Ignored if HostKeyCallback is set.
+	HostKeys        HostKeyConfig
+	HostKeyCallback ssh.HostKeyCallback
+
+	// The ciphers and key exchange algorithms to offer. If empty, those of
+	// the ssh package are used.
+	Ciphers      []string
+	KeyExchanges []string
+
+	// How long to wait for a connection (default 10s), for something to read
+	// (default 5h; keepalives are sent every KeepAlive, default 10s, so this
+	// only expires if the connection dies) and to write (default 20s).
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	KeepAlive    time.Duration
+
+	// How long to wait before connecting again after failing to (default
+	// 10s), or after finding the host key changed (default 5m).
+	RetryDelay        time.Duration
+	HostKeyRetryDelay time.Duration
+
+	// A file to remember the time of the last event in, so events missed
+	// while not running can be replayed after a restart. It's written every
+	// few seconds while events arrive, and when Run returns. Optional.
+	StateFile string
+
+	// How far back to replay missed events. Zero disables replaying; events
+	// missed during a reconnect are then lost.
+	MaxReplay time.Duration
+}
+
+func (this Config) withDefaults() Config {
+	if this.DialTimeout <= 0 {
+		this.DialTimeout = 10 * time.Second
+	}
+	if this.ReadTimeout <= 0 {
+		this.ReadTimeout = 5 * time.Hour
+	}
+	if this.WriteTimeout <= 0 {
+		this.WriteTimeout = 20 * time.Second
+	}
+	if this.KeepAlive <= 0 {
+		this.KeepAlive = 10 * time.Second
+	}
+	if this.RetryDelay <= 0 {
+		this.RetryDelay = 10 * time.Second
+	}
+	if this.HostKeyRetryDelay <= 0 {
+		this.HostKeyRetryDelay = 5 * time.Minute
+	}
+	return this
+}
+
+// DiagnosticKind says what a Diagnostic is about.
+type DiagnosticKind int
+
+const (
+	Connecting     DiagnosticKind = iota // about to connect
+	Connected                            // streaming events
+	ConnectFailed                        // couldn't connect; will retry
+	HostKeyChanged                       // Err is a *HostKeyChangedError; will retry
+	HostKeyTrusted                       // a host key was trusted on first use
+	Disconnected                         // the stream broke; will reconnect
+	BadEvent                             // an event couldn't be decoded; Raw holds it
+	Replaying                            // about to replay missed events
+	ReplayFailed                         // couldn't find out what was missed
+	StateFailed                          // couldn't read or write the state file
+)
+
+var diagnosticKindNames = []string{
+	"connecting",
+	"connected",
+	"connect-failed",
+	"host-key-changed",
+	"host-key-trusted",
+	"disconnected",
+	"bad-event",
+	"replaying",
+	"replay-failed",
+	"state-failed",
+}
+
+func (this DiagnosticKind) String() string {
+	if this < 0 || int(this) >= len(diagnosticKindNames) {
+		return fmt.Sprintf("DiagnosticKind(%d)", int(this))
+	}
+	return diagnosticKindNames[this]
+}
+
+// A Diagnostic tells about something that happened to the connection, as
+// opposed to an event from Gerrit.
+type Diagnostic struct {
+	Kind    DiagnosticKind
+	Message string
+	Err     error  // what went wrong, if anything did
+	Raw     []byte // for BadEvent, the line that couldn't be decoded
+}
+
+func (this Diagnostic) String() string {
+	if this.Err == nil {
+		return this.Message
+	}
+	return this.Message + ": " + this.Err.Error()
+}
+
+// A Client follows Gerrit's event stream. Both its channels must be read
+// from, or it will stall.
+type Client struct {
+	config      Config
+	events      chan Event
+	diagnostics chan Diagnostic
+	dedup       *eventDeduplicator
+	savedEvent  time.Time // what's in the state file
+
+	mutex        sync.Mutex  // guards conn, reconnecting and lastEvent
+	conn         *ssh.Client // while connected
+	reconnecting bool        // whether Reconnect dropped conn
+	lastEvent    time.Time
+}
+
+// How often the time of the last event is saved to Config.StateFile, if it
+// changed. It's saved when Run returns, too.
+const stateSaveInterval = 10 * time.Second
+
+// NewClient creates a client for the Gerrit described by config. Nothing
+// happens until Run is called.
+func NewClient(config Config) *Client {
+	config = config.withDefaults()
+	return &Client{
+		config:      config,
+		events:      make(chan Event),
+		diagnostics: make(chan Diagnostic),
+		dedup:       newEventDeduplicator(config.MaxReplay + time.Hour),
+	}
+}
+
+// Events returns the channel events are delivered on, in the order they
+// happened, without duplicates.
+func (this *Client) Events() <-chan Event {
+	return this.events
+}
+
+// Diagnostics returns the channel diagnostics are delivered on.
+func (this *Client) Diagnostics() <-chan Diagnostic {
+	return this.diagnostics
+}
+
+// Run connects to Gerrit and streams events until ctx is done, reconnecting
+// whenever the connection is lost. It returns ctx.Err(), unless the client
+// can't be run at all.
+func (this *Client) Run(ctx context.Context) error {
+	if this.config.Signer == nil {
+		return errors.New("gerrit: no Signer to log in with")
+	}
+
+	if len(this.config.StateFile) > 0 {
+		var err error
+		this.lastEvent, err = loadLastEvent(this.config.StateFile)
+		if err != nil {
+			this.diagnose(ctx, Diagnostic{Kind: StateFailed, Message: "Failed to read the time of the last event", Err: err})
+		}
+		this.savedEvent = this.lastEvent
+
+		// rather than on every event, which could be many a second
+		ticker := time.NewTicker(stateSaveInterval)
+		stop, stopped := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(stopped)
+			for {
+				select {
+				case <-ticker.C:
+					this.saveState(ctx)
+				case <-stop:
+					return
+				}
+			}
+		}()
+		defer func() {
+			ticker.Stop()
+			close(stop)
+			<-stopped
+			this.saveState(ctx)
+		}()
+	}
+
+	for {
+		client, bio, err := this.connect(ctx)
+		if err != nil {
+			return err
+		}
+
+		this.mutex.Lock()
+		this.conn = client
+		this.mutex.Unlock()
+
+		this.replay(ctx, client)
+		err = this.stream(ctx, client, bio)
+		client.Close()
+
+		this.mutex.Lock()
+		reconnecting := this.reconnecting
+		this.conn, this.reconnecting = nil, false
+		this.mutex.Unlock()
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if reconnecting {
+			this.diagnose(ctx, Diagnostic{Kind: Disconnected, Message: "Reconnecting to Gerrit, as asked"})
+		} else {
+			this.diagnose(ctx, Diagnostic{Kind: Disconnected, Message: "Lost the connection to Gerrit", Err: err})
+		}
+	}
+}
+
+// Reconnect drops the connection to Gerrit, so that Run makes a new one
+// (and replays what was missed in between). Returns false if there's no
+// connection to drop.
+func (this *Client) Reconnect() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.conn == nil {
+		return false
+	}
+	this.reconnecting = true
+	this.conn.Close()
+	return true
+}
+
+// Pass on a diagnostic, unless we're done.
+func (this *Client) diagnose(ctx context.Context, diag Diagnostic) {
+	select {
+	case this.diagnostics <- diag:
+	case <-ctx.Done():
+	}
+}
+
+// Wait for d, returning false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (this *Client) clientConfig(ctx context.Context) (*ssh.ClientConfig, error) {
+	callback := this.config.HostKeyCallback
+	if callback == nil {
+		var err error
+		callback, err = HostKeyCallback(this.config.HostKeys, func(msg string) {
+			this.diagnose(ctx, Diagnostic{Kind: HostKeyTrusted, Message: msg})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &ssh.ClientConfig{
+		User: this.config.User,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(this.config.Signer),
+		},
+		Config: ssh.Config{
+			Ciphers:      this.config.Ciphers,
+			KeyExchanges: this.config.KeyExchanges,
+		},
+		HostKeyCallback: callback,
+	}, nil
+}
+
+// Connect to Gerrit and start streaming, and keep trying until we succeed or
+// ctx is done.
+func (this *Client) connect(ctx context.Context) (*ssh.Client, *bufio.Reader, error) {
+	this.diagnose(ctx, Diagnostic{Kind: Connecting, Message: "Attempting to connect to Gerrit"})
+
+	lastWarning := ""
+	for {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+
+		config, err := this.clientConfig(ctx)
+		if err != nil {
+			this.diagnose(ctx, Diagnostic{Kind: ConnectFailed, Message: "Failed to set up host key checking", Err: err})
+			sleep(ctx, this.config.RetryDelay)
+			continue
+		}
+
+		client, err := dialSSH(ctx, this.config.Host, config, this.config)
+		var changed *HostKeyChangedError
+		if errors.As(err, &changed) {
+			// don't repeat ourselves every time we retry
+			if warning := changed.Error(); warning != lastWarning {
+				this.diagnose(ctx, Diagnostic{Kind: HostKeyChanged, Message: "Refusing to connect to Gerrit", Err: changed})
+				lastWarning = warning
+			}
+			sleep(ctx, this.config.HostKeyRetryDelay)
+			continue
+		} else if err != nil {
+			if ctx.Err() == nil {
+				this.diagnose(ctx, Diagnostic{Kind: ConnectFailed, Message: "Failed to dial", Err: err})
+			}
+			sleep(ctx, this.config.RetryDelay)
+			continue
+		}
+
+		bio, err := this.startStream(client)
+		if err != nil {
+			client.Close()
+			this.diagnose(ctx, Diagnostic{Kind: ConnectFailed, Message: "Failed to stream", Err: err})
+			sleep(ctx, this.config.RetryDelay)
+			continue
+		}
+
+		this.diagnose(ctx, Diagnostic{Kind: Connected, Message: "Gerrit connection reestablished."})
+		return client, bio, nil
+	}
+}
+
+func (this *Client) startStream(client *ssh.Client) (*bufio.Reader, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := session.Start("gerrit stream-events"); err != nil {
+		return nil, err
+	}
+	return bufio.NewReader(stdout), nil
+}
+
+// Pass on events from the stream until it breaks, or ctx is done.
+func (this *Client) stream(ctx context.Context, client *ssh.Client, bio *bufio.Reader) error {
+	// closing the connection is the only way to interrupt a read
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
+
+	for {
+		line, err := bio.ReadBytes('\n')
+		if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		event, err := ParseEvent(line)
+		if err != nil {
+			this.diagnose(ctx, Diagnostic{Kind: BadEvent, Message: "Error processing JSON", Err: err, Raw: line})
+			continue
+		}
+		this.emit(ctx, event)
+	}
+}
+
+// Pass an event on, unless it was already seen, and remember when it was.
+func (this *Client) emit(ctx context.Context, event Event) {
+	now := time.Now()
+	if this.dedup.Seen(event, now) {
+		return
+	}
+
+	select {
+	case this.events <- event:
+	case <-ctx.Done():
+		return
+	}
+
+	when := event.CreatedOn()
+	if when.IsZero() {
+		when = now
+	}
+	this.mutex.Lock()
+	if when.After(this.lastEvent) {
+		this.lastEvent = when
+	}
+	this.mutex.Unlock()
+}
+
+// Save the time of the last event to the state file, if it changed since
+// it last was.
+func (this *Client) saveState(ctx context.Context) {
+	this.mutex.Lock()
+	when := this.lastEvent
+	this.mutex.Unlock()
+	if !when.After(this.savedEvent) {
+		return
+	}
+	if err := saveLastEvent(this.config.StateFile, when); err != nil {
+		this.diagnose(ctx, Diagnostic{Kind: StateFailed, Message: "Failed to save the time of the last event", Err: err})
+		return
+	}
+	this.savedEvent = when
+}
+
+// Query Gerrit for what happened since the last event we saw, and pass on
+// the events that were missed while we weren't connected.
+func (this *Client) replay(ctx context.Context, client *ssh.Client) {
+	this.mutex.Lock()
+	since := this.lastEvent
+	this.mutex.Unlock()
+	if since.IsZero() || this.config.MaxReplay <= 0 {
+		return
+	}
+
+	if limit := time.Now().Add(-this.config.MaxReplay); since.Before(limit) {
+		this.diagnose(ctx, Diagnostic{
+			Kind:    Replaying,
+			Message: fmt.Sprintf("Last event was at %s, only replaying the last %s", since.UTC().Format(time.RFC3339), this.config.MaxReplay),
+		})
+		since = limit
+	}
+
+	var changes []*queryChange
+	for {
+		session, err := client.NewSession()
+		if err != nil {
+			this.diagnose(ctx, Diagnostic{Kind: ReplayFailed, Message: "Failed to create session for replay", Err: err})
+			return
+		}
+		out, err := session.Output(queryCommand(since, len(changes)))
+		session.Close()
+		if err != nil {
+			this.diagnose(ctx, Diagnostic{Kind: ReplayFailed, Message: "Failed to query for missed events", Err: err})
+			return
+		}
+
+		page, more, err := parseQueryResults(bytes.NewReader(out))
+		if err != nil {
+			this.diagnose(ctx, Diagnostic{Kind: ReplayFailed, Message: "Failed to query for missed events", Err: err})
+			return
+		}
+		changes = append(changes, page...)
+		if !more || len(page) == 0 {
+			break
+		}
+	}
+
+	events := synthesizeEvents(changes, since)
+	if len(events) > 0 {
+		this.diagnose(ctx, Diagnostic{
+			Kind:    Replaying,
+			Message: fmt.Sprintf("Replaying %d events missed since %s", len(events), since.UTC().Format(time.RFC3339)),
+		})
+	}
+	for _, event := range events {
+		if ctx.Err() != nil {
+			return
+		}
+		this.emit(ctx, event)
+	}
+}
@@ -0,0 +1,263 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package gerrit
+
+import "context"
+import "errors"
+import "fmt"
+import "github.com/rburchell/gobo/lib/gerrit/gerrittest"
+import "golang.org/x/crypto/ssh"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "strings"
+import "testing"
+import "time"
+
+// A Client running against a gerrittest.Server, with its diagnostics
+// buffered so the test can read events without missing them.
+type testClient struct {
+	*Client
+	t           *testing.T
+	cancel      context.CancelFunc
+	done        chan error
+	diagnostics chan Diagnostic
+	stopped     bool
+}
+
+func startTestClient(t *testing.T, server *gerrittest.Server, config Config) *testClient {
+	config.Host = server.Addr()
+	config.User = "bot"
+	config.Signer = gerrittest.NewKey()
+	config.RetryDelay = 10 * time.Millisecond
+	if len(config.HostKeys.Fingerprint) == 0 {
+		config.HostKeys.Fingerprint = server.Fingerprint()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tc := &testClient{
+		Client:      NewClient(config),
+		t:           t,
+		cancel:      cancel,
+		done:        make(chan error, 1),
+		diagnostics: make(chan Diagnostic, 100),
+	}
+	go func() {
+		tc.done <- tc.Run(ctx)
+	}()
+	go func() {
+		for diag := range tc.Client.Diagnostics() {
+			tc.diagnostics <- diag
+		}
+	}()
+	return tc
+}
+
+func (this *testClient) nextEvent() Event {
+	select {
+	case event := <-this.Events():
+		return event
+	case <-time.After(5 * time.Second):
+		this.t.Fatalf("Timed out waiting for an event")
+	}
+	return nil
+}
+
+func (this *testClient) waitForDiagnostic(kind DiagnosticKind) Diagnostic {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case diag := <-this.diagnostics:
+			if diag.Kind == kind {
+				return diag
+			}
+		case <-timeout:
+			this.t.Fatalf("Timed out waiting for a %s diagnostic", kind)
+			return Diagnostic{}
+		}
+	}
+}
+
+func (this *testClient) stop() {
+	if this.stopped {
+		return
+	}
+	this.stopped = true
+	this.cancel()
+	select {
+	case err := <-this.done:
+		if !errors.Is(err, context.Canceled) {
+			this.t.Errorf("Expected: %#v, got %#v", context.Canceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		this.t.Errorf("Timed out waiting for Run to return")
+	}
+}
+
+func TestClientStream(t *testing.T) {
+	server := gerrittest.NewServer()
+	defer server.Close()
+
+	client := startTestClient(t, server, Config{})
+	defer client.stop()
+
+	client.waitForDiagnostic(Connected)
+	server.Send(`{"type": "change-merged", "change": {"number": "1234", "project": "qt/qtbase"}, "submitter": {"name": "Alice"}}`)
+	server.Send(`not json`)
+	server.Send(`{"type": "ref-updated", "refUpdate": {"project": "qt/qtbase", "refName": "refs/heads/dev", "newRev": "abcd"}}`)
+
+	merged, ok := client.nextEvent().(*ChangeMerged)
+	if !ok || merged.Change.Number != 1234 || merged.Submitter.Name != "Alice" {
+		t.Errorf("Expected change-merged for 1234, got %#v", merged)
+	}
+
+	bad := client.waitForDiagnostic(BadEvent)
+	if string(bad.Raw) != "not json\n" || bad.Err == nil {
+		t.Errorf("Expected the bad line and an error, got %#v", bad)
+	}
+
+	updated, ok := client.nextEvent().(*RefUpdated)
+	if !ok || updated.RefUpdate.NewRev != "abcd" {
+		t.Errorf("Expected ref-updated, got %#v", updated)
+	}
+}
+
+func TestClientReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gerrit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the last event we processed was an hour ago; since then, Bob reviewed
+	// 1234.
+	base := time.Now().Add(-time.Hour).Unix()
+	stateFile := filepath.Join(dir, "state")
+	saveLastEvent(stateFile, time.Unix(base, 0))
+
+	review := fmt.Sprintf(`{"type": "comment-added", "change": {"number": 1234}, "author": {"name": "Bob", "username": "bob"}, "comment": "Patch Set 1: Code-Review+2", "eventCreatedOn": %d}`, base+10)
+	server := gerrittest.NewServer()
+	server.Query = func(command string) (string, bool) {
+		if strings.Contains(command, fmt.Sprintf(`'since:"%s"'`, time.Unix(base, 0).UTC().Format("2006-01-02 15:04:05 -0700"))) {
+			return fmt.Sprintf(`{"project":"qt/qtbase","number":1234,"lastUpdated":%d,"status":"NEW","patchSets":[{"number":1,"createdOn":%d}],"comments":[{"timestamp":%d,"reviewer":{"name":"Bob","username":"bob"},"message":"Patch Set 1: Code-Review+2"}]}`+"\n", base+10, base-100, base+10), true
+		}
+		return `{"type":"stats","rowCount":0}` + "\n", true
+	}
+	defer server.Close()
+
+	client := startTestClient(t, server, Config{StateFile: stateFile, MaxReplay: 6 * time.Hour})
+	defer client.stop()
+
+	replayed, ok := client.nextEvent().(*CommentAdded)
+	if !ok || !replayed.IsReplayed() || replayed.Author.Name != "Bob" {
+		t.Fatalf("Expected Bob's replayed review, got %#v", replayed)
+	}
+	// the same review arriving live is a duplicate
+	server.WaitForStreams(1, 5*time.Second)
+	server.Send(review)
+	server.Send(`{"type": "change-merged", "change": {"number": "1234"}}`)
+	if merged, ok := client.nextEvent().(*ChangeMerged); !ok || merged.IsReplayed() {
+		t.Errorf("Expected the live change-merged, got %#v", merged)
+	}
+
+	// after losing the connection, it reconnects and asks what it missed
+	server.DropConnections()
+	client.waitForDiagnostic(Disconnected)
+	if !server.WaitForStreams(2, 5*time.Second) {
+		t.Fatalf("Expected the client to reconnect")
+	}
+	server.Send(`{"type": "change-abandoned", "change": {"number": "1234"}}`)
+	if _, ok := client.nextEvent().(*ChangeAbandoned); !ok {
+		t.Errorf("Expected change-abandoned")
+	}
+	if commands := server.Commands(); len(commands) != 2 {
+		t.Errorf("Expected a query on each connect, got %#v", commands)
+	}
+
+	// the time of the last event is saved on the way out, if not before
+	client.stop()
+	if when, _ := loadLastEvent(stateFile); when.Unix() < base+10 {
+		t.Errorf("Expected at least %#v, got %#v", base+10, when.Unix())
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	server := gerrittest.NewServer()
+	defer server.Close()
+
+	client := startTestClient(t, server, Config{})
+	defer client.stop()
+
+	client.waitForDiagnostic(Connected)
+	if !server.WaitForStreams(1, 5*time.Second) {
+		t.Fatalf("Expected the client to stream")
+	}
+	if !client.Reconnect() {
+		t.Fatalf("Expected Reconnect to drop the connection")
+	}
+	if diag := client.waitForDiagnostic(Disconnected); diag.Err != nil || diag.Message != "Reconnecting to Gerrit, as asked" {
+		t.Errorf("Expected a diagnostic saying it was asked to reconnect, got %#v", diag)
+	}
+	client.waitForDiagnostic(Connected)
+	if !server.WaitForStreams(2, 5*time.Second) {
+		t.Fatalf("Expected the client to reconnect")
+	}
+	server.Send(`{"type": "change-merged", "change": {"number": "1234"}}`)
+	if _, ok := client.nextEvent().(*ChangeMerged); !ok {
+		t.Errorf("Expected change-merged")
+	}
+}
+
+func TestClientHostKeyChanged(t *testing.T) {
+	server := gerrittest.NewServer()
+	defer server.Close()
+
+	client := startTestClient(t, server, Config{HostKeys: HostKeyConfig{Fingerprint: ssh.FingerprintSHA256(gerrittest.NewKey().PublicKey())}})
+	diag := client.waitForDiagnostic(HostKeyChanged)
+	var changed *HostKeyChangedError
+	if !errors.As(diag.Err, &changed) || changed.Fingerprint != server.Fingerprint() {
+		t.Errorf("Expected a HostKeyChangedError for %s, got %#v", server.Fingerprint(), diag.Err)
+	}
+
+	// it waits a while before trying again, but can still be stopped
+	client.stop()
+}
+
+func TestClientCancel(t *testing.T) {
+	server := gerrittest.NewServer()
+	client := startTestClient(t, server, Config{})
+	client.waitForDiagnostic(Connected)
+	client.stop()
+
+	// and while retrying
+	server.Close()
+	client = startTestClient(t, server, Config{})
+	client.waitForDiagnostic(ConnectFailed)
+	client.stop()
+
+	if err := NewClient(Config{}).Run(context.Background()); err == nil {
+		t.Errorf("Expected an error without a Signer")
+	}
+}
@@ -0,0 +1,396 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Package gerrit is a client for Gerrit's SSH interface: it follows
+// `gerrit stream-events`, reconnecting as needed and replaying the events
+// missed while it wasn't connected, and decodes each event into a struct of
+// its own. It also has a client for the parts of Gerrit's REST API that are
+// useful for describing changes.
+package gerrit
+
+import "encoding/json"
+import "strconv"
+import "strings"
+import "time"
+
+// Event is implemented by the struct for each type of stream event. Switch on
+// the concrete type to get at the details, e.g.
+//
+//	switch e := event.(type) {
+//	case *gerrit.CommentAdded:
+//		fmt.Println(e.Author.Name, e.Comment)
+//	}
+type Event interface {
+	// The type of the event, as Gerrit calls it, e.g. comment-added.
+	EventType() string
+
+	// When Gerrit says the event happened. Zero if it didn't say.
+	CreatedOn() time.Time
+
+	// The JSON the event was decoded from. For replayed events, this is
+	// reconstructed from the struct.
+	Raw() []byte
+
+	// Whether the event was reconstructed after being missed while we
+	// weren't connected, rather than streamed.
+	IsReplayed() bool
+
+	base() *EventBase
+}
+
+// EventBase holds what all events have in common. It is embedded in each of
+// the event structs.
+type EventBase struct {
+	Type           string `json:"type"`
+	EventCreatedOn int64  `json:"eventCreatedOn,omitempty"`
+
+	raw      []byte
+	replayed bool
+}
+
+func (this *EventBase) EventType() string {
+	return this.Type
+}
+
+func (this *EventBase) CreatedOn() time.Time {
+	if this.EventCreatedOn == 0 {
+		return time.Time{}
+	}
+	return time.Unix(this.EventCreatedOn, 0)
+}
+
+func (this *EventBase) Raw() []byte {
+	return this.raw
+}
+
+func (this *EventBase) IsReplayed() bool {
+	return this.replayed
+}
+
+func (this *EventBase) base() *EventBase {
+	return this
+}
+
+// A Number is one that Gerrit sends either as a JSON number or a string,
+// depending on its version and where in the event it is.
+type Number int64
+
+func (this *Number) UnmarshalJSON(data []byte) error {
+	str := strings.Trim(string(data), `"`)
+	if len(str) == 0 || str == "null" {
+		*this = 0
+		return nil
+	}
+	value, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return err
+	}
+	*this = Number(value)
+	return nil
+}
+
+type Account struct {
+	Name     string `json:"name,omitempty"`     // J-P Nurmi
+	Email    string `json:"email,omitempty"`    // jpnurmi@theqtcompany.com
+	Username string `json:"username,omitempty"` // jpnurmi
+}
+
+// Same returns true if a and b are the same person, going by the most
+// specific detail that either has.
+func (a Account) Same(b Account) bool {
+	if len(a.Username) > 0 || len(b.Username) > 0 {
+		return a.Username == b.Username
+	}
+	if len(a.Email) > 0 || len(b.Email) > 0 {
+		return a.Email == b.Email
+	}
+	return a.Name == b.Name
+}
+
+type Change struct {
+	Project  string  `json:"project"` // qt/qtdeclarative
+	Branch   string  `json:"branch"`  // 5.6
+	Id       string  `json:"id"`      // Icefdec91b012b12728367fd54b4d16796233ee12
+	Number   Number  `json:"number"`  // 125617
+	Subject  string  `json:"subject"` // Make QML composite types inherit enums
+	Owner    Account `json:"owner"`
+	Url      string  `json:"url"`              // https://codereview.qt-project.org/125617
+	Status   string  `json:"status,omitempty"` // NEW, MERGED, ABANDONED
+	Topic    string  `json:"topic,omitempty"`
+	Wip      bool    `json:"wip,omitempty"`
+	Private  bool    `json:"private,omitempty"`
+	Assignee Account `json:"assignee"`
+}
+
+type PatchSet struct {
+	Number         Number   `json:"number"`   // 9
+	Revision       string   `json:"revision"` // 52c9ebcd78379b0eacc1476237720e06abf286b3
+	Parents        []string `json:"parents"`  // ["9688aa4fe3195147881dc0969bf000bfc8a65e5e"]
+	Ref            string   `json:"ref"`      // refs/changes/17/125617/9
+	Uploader       Account  `json:"uploader"`
+	CreatedOn      int64    `json:"createdOn"` // 1442413012
+	Author         Account  `json:"author"`
+	SizeInsertions int64    `json:"sizeInsertions"` // 80
+	SizeDeletions  int64    `json:"sizeDeletions"`  // -13
+	Kind           string   `json:"kind,omitempty"` // REWORK, TRIVIAL_REBASE, ...
+}
+
+type Approval struct {
+	Type        string `json:"type"`                  // Code-Review
+	Description string `json:"description,omitempty"` // Code-Review
+	Value       Number `json:"value"`                 // 2
+
+	// Only sent (in comment-added) by newer Gerrit, if the vote changed.
+	OldValue *Number `json:"oldValue,omitempty"` // 1
+}
+
+// Changed returns true if the approval is a new or changed vote. If Gerrit
+// didn't say what the vote was before, it's assumed to have changed.
+func (this *Approval) Changed() bool {
+	return this.OldValue == nil || *this.OldValue != this.Value
+}
+
+// ChangedApprovals returns the votes that changed. Gerrit also sends the
+// author's other, unchanged, votes in comment-added, marking the changed ones
+// with an oldValue; if none are marked (as with a plain comment), nothing
+// changed. Older Gerrit never marks them; see HasOldValues.
+func ChangedApprovals(approvals []Approval) []Approval {
+	var changed []Approval
+	for _, approval := range approvals {
+		if approval.OldValue != nil && approval.Changed() {
+			changed = append(changed, approval)
+		}
+	}
+	return changed
+}
+
+// HasOldValues returns true if any of the votes says what it was before,
+// which older Gerrit never does.
+func HasOldValues(approvals []Approval) bool {
+	for _, approval := range approvals {
+		if approval.OldValue != nil {
+			return true
+		}
+	}
+	return false
+}
+
+type RefUpdate struct {
+	OldRev  string `json:"oldRev"`
+	NewRev  string `json:"newRev"`
+	RefName string `json:"refName"`
+	Project string `json:"project"`
+}
+
+type PatchSetCreated struct {
+	EventBase
+	Change   Change   `json:"change"`
+	PatchSet PatchSet `json:"patchSet"`
+	Uploader Account  `json:"uploader"`
+}
+
+type CommentAdded struct {
+	EventBase
+	Change    Change     `json:"change"`
+	PatchSet  PatchSet   `json:"patchSet"`
+	Author    Account    `json:"author"`
+	Approvals []Approval `json:"approvals,omitempty"`
+	Comment   string     `json:"comment"`
+}
+
+type ChangeMerged struct {
+	EventBase
+	Change    Change   `json:"change"`
+	PatchSet  PatchSet `json:"patchSet"`
+	Submitter Account  `json:"submitter"`
+	NewRev    string   `json:"newRev,omitempty"`
+}
+
+type MergeFailed struct {
+	EventBase
+	Change    Change   `json:"change"`
+	PatchSet  PatchSet `json:"patchSet"`
+	Submitter Account  `json:"submitter"`
+	Reason    string   `json:"reason"`
+}
+
+type ChangeAbandoned struct {
+	EventBase
+	Change    Change   `json:"change"`
+	PatchSet  PatchSet `json:"patchSet"`
+	Abandoner Account  `json:"abandoner"`
+	Reason    string   `json:"reason"`
+}
+
+// ChangeDeferred is specific to Qt's Gerrit.
+type ChangeDeferred struct {
+	EventBase
+	Change   Change   `json:"change"`
+	PatchSet PatchSet `json:"patchSet"`
+	Deferrer Account  `json:"deferrer"`
+	Reason   string   `json:"reason"`
+}
+
+type ChangeRestored struct {
+	EventBase
+	Change   Change   `json:"change"`
+	PatchSet PatchSet `json:"patchSet"`
+	Restorer Account  `json:"restorer"`
+	Reason   string   `json:"reason"`
+}
+
+type RefUpdated struct {
+	EventBase
+	Submitter Account   `json:"submitter"`
+	RefUpdate RefUpdate `json:"refUpdate"`
+}
+
+type ReviewerAdded struct {
+	EventBase
+	Change   Change   `json:"change"`
+	PatchSet PatchSet `json:"patchSet"`
+	Reviewer Account  `json:"reviewer"`
+	Adder    Account  `json:"adder"`
+}
+
+type VoteDeleted struct {
+	EventBase
+	Change    Change     `json:"change"`
+	PatchSet  PatchSet   `json:"patchSet"`
+	Reviewer  Account    `json:"reviewer"`
+	Remover   Account    `json:"remover"`
+	Approvals []Approval `json:"approvals,omitempty"`
+	Comment   string     `json:"comment"`
+}
+
+type TopicChanged struct {
+	EventBase
+	Change   Change  `json:"change"`
+	Changer  Account `json:"changer"`
+	OldTopic string  `json:"oldTopic"`
+}
+
+type HashtagsChanged struct {
+	EventBase
+	Change   Change   `json:"change"`
+	Editor   Account  `json:"editor"`
+	Added    []string `json:"added,omitempty"`
+	Removed  []string `json:"removed,omitempty"`
+	Hashtags []string `json:"hashtags,omitempty"`
+}
+
+type WipStateChanged struct {
+	EventBase
+	Change   Change   `json:"change"`
+	PatchSet PatchSet `json:"patchSet"`
+	Changer  Account  `json:"changer"`
+}
+
+type PrivateStateChanged struct {
+	EventBase
+	Change   Change   `json:"change"`
+	PatchSet PatchSet `json:"patchSet"`
+	Changer  Account  `json:"changer"`
+}
+
+type AssigneeChanged struct {
+	EventBase
+	Change      Change  `json:"change"`
+	Changer     Account `json:"changer"`
+	OldAssignee Account `json:"oldAssignee"`
+}
+
+type ProjectCreated struct {
+	EventBase
+	ProjectName string `json:"projectName"`
+	ProjectHead string `json:"projectHead"`
+}
+
+// UnknownEvent is any event type not listed here. Only the common fields
+// are decoded; the rest is in Raw().
+type UnknownEvent struct {
+	EventBase
+}
+
+func newEvent(eventType string) Event {
+	switch eventType {
+	case "patchset-created":
+		return &PatchSetCreated{}
+	case "comment-added":
+		return &CommentAdded{}
+	case "change-merged":
+		return &ChangeMerged{}
+	case "merge-failed":
+		return &MergeFailed{}
+	case "change-abandoned":
+		return &ChangeAbandoned{}
+	case "change-deferred":
+		return &ChangeDeferred{}
+	case "change-restored":
+		return &ChangeRestored{}
+	case "ref-updated":
+		return &RefUpdated{}
+	case "reviewer-added":
+		return &ReviewerAdded{}
+	case "vote-deleted":
+		return &VoteDeleted{}
+	case "topic-changed":
+		return &TopicChanged{}
+	case "hashtags-changed":
+		return &HashtagsChanged{}
+	case "wip-state-changed":
+		return &WipStateChanged{}
+	case "private-state-changed":
+		return &PrivateStateChanged{}
+	case "assignee-changed":
+		return &AssigneeChanged{}
+	case "project-created":
+		return &ProjectCreated{}
+	}
+	return &UnknownEvent{}
+}
+
+// ParseEvent decodes a line of `gerrit stream-events` output.
+func ParseEvent(data []byte) (Event, error) {
+	var header EventBase
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+
+	event := newEvent(header.Type)
+	if err := json.Unmarshal(data, event); err != nil {
+		return nil, err
+	}
+	event.base().raw = append([]byte(nil), data...)
+	return event, nil
+}
+
+// Fill in the raw JSON of an event we built ourselves.
+func encodeEvent(event Event) Event {
+	data, err := json.Marshal(event)
+	if err == nil {
+		event.base().raw = data
+	}
+	return event
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package gerrit
+
+import "fmt"
+import "testing"
+
+func TestParseEvent(t *testing.T) {
+	blob := `{"type": "comment-added", "change": {"project": "qt/qtbase", "number": "1234"}, "patchSet": {"number": 2}, "author": {"name": "Bob"}, "approvals": [{"type": "Code-Review", "value": "2", "oldValue": "1"}, {"type": "Verified", "value": 1}], "comment": "LGTM", "eventCreatedOn": 1700000000}`
+	event := testEvent(t, blob)
+
+	comment, ok := event.(*CommentAdded)
+	if !ok {
+		t.Fatalf("Expected a *CommentAdded, got %#v", event)
+	}
+	if comment.EventType() != "comment-added" || comment.Change.Number != 1234 || comment.PatchSet.Number != 2 || comment.Author.Name != "Bob" || comment.Comment != "LGTM" {
+		t.Errorf("Unexpected event: %#v", comment)
+	}
+	if comment.CreatedOn().Unix() != 1700000000 {
+		t.Errorf("Expected: %#v, got %#v", 1700000000, comment.CreatedOn().Unix())
+	}
+	if string(event.Raw()) != blob {
+		t.Errorf("Expected: %#v, got %#v", blob, string(event.Raw()))
+	}
+	if event.IsReplayed() {
+		t.Errorf("Expected a streamed event not to be replayed")
+	}
+
+	changed := ChangedApprovals(comment.Approvals)
+	if len(changed) != 1 || changed[0].Type != "Code-Review" || *changed[0].OldValue != 1 {
+		t.Errorf("Expected only the Code-Review vote to have changed, got %#v", changed)
+	}
+	if !HasOldValues(comment.Approvals) {
+		t.Errorf("Expected the votes to have old values")
+	}
+
+	// a plain comment repeats the author's votes, none of them changed
+	plain := testEvent(t, `{"type": "comment-added", "approvals": [{"type": "Code-Review", "value": "1"}, {"type": "Verified", "value": "1"}]}`).(*CommentAdded)
+	if changed := ChangedApprovals(plain.Approvals); len(changed) != 0 {
+		t.Errorf("Expected no changed votes, got %#v", changed)
+	}
+	if HasOldValues(plain.Approvals) {
+		t.Errorf("Expected the votes to have no old values")
+	}
+
+	tests := map[string]string{
+		`{"type": "patchset-created"}`:      "*gerrit.PatchSetCreated",
+		`{"type": "change-merged"}`:         "*gerrit.ChangeMerged",
+		`{"type": "ref-updated"}`:           "*gerrit.RefUpdated",
+		`{"type": "project-created"}`:       "*gerrit.ProjectCreated",
+		`{"type": "something-new"}`:         "*gerrit.UnknownEvent",
+		`{"type": "hashtags-changed"}`:      "*gerrit.HashtagsChanged",
+		`{"type": "private-state-changed"}`: "*gerrit.PrivateStateChanged",
+	}
+	for blob, expected := range tests {
+		if got := typeName(testEvent(t, blob)); got != expected {
+			t.Errorf("Expected: %#v, got %#v", expected, got)
+		}
+	}
+
+	if _, err := ParseEvent([]byte(`{"type": "comment-added", "change": {"number": "x"}}`)); err == nil {
+		t.Errorf("Expected an error for a bad number")
+	}
+	if _, err := ParseEvent([]byte(`not json`)); err == nil {
+		t.Errorf("Expected an error for bad JSON")
+	}
+}
+
+func typeName(v interface{}) string {
+	return fmt.Sprintf("%T", v)
+}
+
+func TestAccountSame(t *testing.T) {
+	tests := []struct {
+		A        Account
+		B        Account
+		Expected bool
+	}{
+		{Account{Name: "Bob", Username: "bob"}, Account{Name: "Robert", Username: "bob"}, true},
+		{Account{Name: "Bob", Username: "bob"}, Account{Name: "Bob"}, false},
+		{Account{Email: "bob@example.com"}, Account{Name: "Bob", Email: "bob@example.com"}, true},
+		{Account{Name: "Bob"}, Account{Name: "Bob"}, true},
+	}
+	for _, test := range tests {
+		if got := test.A.Same(test.B); got != test.Expected {
+			t.Errorf("%#v vs %#v: Expected: %#v, got %#v", test.A, test.B, test.Expected, got)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Package gerrittest provides a fake Gerrit SSH daemon for testing code that
+// uses the gerrit package, in the spirit of net/http/httptest.
+package gerrittest
+
+import "crypto/ed25519"
+import "crypto/rand"
+import "golang.org/x/crypto/ssh"
+import "net"
+import "sync"
+import "time"
+
+// NewKey generates a key, for the server or a client.
+func NewKey() ssh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic("gerrittest: failed to generate a key: " + err.Error())
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		panic("gerrittest: failed to generate a key: " + err.Error())
+	}
+	return signer
+}
+
+// A Server accepts any client key, streams the events given to Send to every
+// `gerrit stream-events` session, and answers `gerrit query` with Query.
+type Server struct {
+	// The host key. Set by NewUnstartedServer; may be replaced before Start.
+	HostKey ssh.Signer
+
+	// The server config, which may be changed before Start, e.g. to limit
+	// the ciphers. The host key is added to it on Start.
+	Config *ssh.ServerConfig
+
+	// Answers any other command; its output is sent, followed by exit
+	// status 0. If nil, or it returns false, the command fails.
+	Query func(command string) (string, bool)
+
+	listener net.Listener
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	conns    map[*ssh.ServerConn]bool
+	streams  map[ssh.Channel]bool
+	started  int
+	commands []string
+}
+
+// NewServer starts a server on a local port.
+func NewServer() *Server {
+	server := NewUnstartedServer()
+	server.Start()
+	return server
+}
+
+// NewUnstartedServer creates a server that can be configured before Start.
+func NewUnstartedServer() *Server {
+	server := &Server{
+		HostKey: NewKey(),
+		Config: &ssh.ServerConfig{
+			PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+				return nil, nil
+			},
+		},
+		conns:   map[*ssh.ServerConn]bool{},
+		streams: map[ssh.Channel]bool{},
+	}
+	server.cond = sync.NewCond(&server.mutex)
+	return server
+}
+
+// Start listens on a local port and serves connections.
+func (this *Server) Start() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("gerrittest: failed to listen: " + err.Error())
+	}
+	this.listener = listener
+	this.Config.AddHostKey(this.HostKey)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go this.serve(conn)
+		}
+	}()
+}
+
+// Addr returns the host:port the server listens on.
+func (this *Server) Addr() string {
+	return this.listener.Addr().String()
+}
+
+// Fingerprint returns the SHA256 fingerprint of the host key.
+func (this *Server) Fingerprint() string {
+	return ssh.FingerprintSHA256(this.HostKey.PublicKey())
+}
+
+// Close stops listening, and drops all connections.
+func (this *Server) Close() {
+	this.listener.Close()
+	this.DropConnections()
+}
+
+// DropConnections closes all current connections, as if the network went
+// away, but keeps listening.
+func (this *Server) DropConnections() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for conn := range this.conns {
+		conn.Close()
+	}
+}
+
+// Send sends an event (a line of JSON) to every stream-events session, and
+// returns how many there were.
+func (this *Server) Send(event string) int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for stream := range this.streams {
+		stream.Write([]byte(event + "\n"))
+	}
+	return len(this.streams)
+}
+
+// WaitForStreams waits until n stream-events sessions have been started in
+// total, returning false if that doesn't happen within timeout.
+func (this *Server) WaitForStreams(n int, timeout time.Duration) bool {
+	timer := time.AfterFunc(timeout, func() {
+		this.mutex.Lock()
+		defer this.mutex.Unlock()
+		this.cond.Broadcast()
+	})
+	defer timer.Stop()
+
+	deadline := time.Now().Add(timeout)
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for this.started < n {
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		this.cond.Wait()
+	}
+	return true
+}
+
+// Commands returns the commands that were run, other than stream-events.
+func (this *Server) Commands() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return append([]string(nil), this.commands...)
+}
+
+func (this *Server) serve(conn net.Conn) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, this.Config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	this.mutex.Lock()
+	this.conns[sconn] = true
+	this.mutex.Unlock()
+	defer func() {
+		this.mutex.Lock()
+		delete(this.conns, sconn)
+		this.mutex.Unlock()
+	}()
+
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go this.serveSession(channel, requests)
+	}
+}
+
+func (this *Server) serveSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer func() {
+		this.mutex.Lock()
+		delete(this.streams, channel)
+		this.mutex.Unlock()
+	}()
+
+	for req := range requests {
+		var exec struct{ Command string }
+		if req.Type != "exec" || ssh.Unmarshal(req.Payload, &exec) != nil {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+
+		if exec.Command == "gerrit stream-events" {
+			// keep streaming until the connection goes, as Gerrit would
+			this.mutex.Lock()
+			this.streams[channel] = true
+			this.started++
+			this.cond.Broadcast()
+			this.mutex.Unlock()
+			continue
+		}
+
+		this.mutex.Lock()
+		this.commands = append(this.commands, exec.Command)
+		query := this.Query
+		this.mutex.Unlock()
+
+		status := uint32(1)
+		if query != nil {
+			if out, ok := query(exec.Command); ok {
+				channel.Write([]byte(out))
+				status = 0
+			}
+		}
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+		channel.Close()
+	}
+}
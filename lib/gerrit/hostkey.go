@@ -0,0 +1,137 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package gerrit
+
+import "errors"
+import "fmt"
+import "golang.org/x/crypto/ssh"
+import "golang.org/x/crypto/ssh/knownhosts"
+import "net"
+import "os"
+import "strings"
+
+// HostKeyConfig says how to verify Gerrit's host key.
+type HostKeyConfig struct {
+	// A known_hosts file to check the key against.
+	KnownHosts string
+
+	// The fingerprint of the key, as shown by ssh-keygen -l
+	// (SHA256:<base64>), or a legacy MD5 one. If set, KnownHosts is not used.
+	Fingerprint string
+
+	// If the host isn't in KnownHosts, trust whatever key it presents, and
+	// add it to the file.
+	TrustOnFirstUse bool
+}
+
+// A HostKeyChangedError means Gerrit presented a host key other than the one
+// we know for it. Either the key was changed on purpose, or someone is in the
+// middle of the connection, so we refuse to connect until it's looked into.
+type HostKeyChangedError struct {
+	Host        string
+	Fingerprint string   // of the key that was presented
+	Expected    []string // fingerprints of the keys we know
+}
+
+func (this *HostKeyChangedError) Error() string {
+	return fmt.Sprintf("host key for %s has CHANGED: it is now %s, but expected %s",
+		this.Host, this.Fingerprint, strings.Join(this.Expected, " or "))
+}
+
+// Check a fingerprint from the config against key. SHA256 fingerprints are
+// written SHA256:<base64>, as by ssh-keygen -l; anything else is taken to be
+// a legacy MD5 one.
+func fingerprintMatches(fingerprint string, key ssh.PublicKey) bool {
+	if strings.HasPrefix(fingerprint, "SHA256:") {
+		return fingerprint == ssh.FingerprintSHA256(key)
+	}
+	return strings.EqualFold(strings.TrimPrefix(fingerprint, "MD5:"), ssh.FingerprintLegacyMD5(key))
+}
+
+// HostKeyCallback builds the callback that verifies Gerrit's host key, as
+// configured: either against a fingerprint, or against a known_hosts file.
+// With trust on first use, a host missing from known_hosts is added to it;
+// notify is told when that happens.
+func HostKeyCallback(config HostKeyConfig, notify func(string)) (ssh.HostKeyCallback, error) {
+	if len(config.Fingerprint) > 0 {
+		return func(host string, remote net.Addr, key ssh.PublicKey) error {
+			if !fingerprintMatches(config.Fingerprint, key) {
+				return &HostKeyChangedError{
+					Host:        host,
+					Fingerprint: ssh.FingerprintSHA256(key),
+					Expected:    []string{config.Fingerprint},
+				}
+			}
+			return nil
+		}, nil
+	}
+
+	if config.TrustOnFirstUse {
+		// make sure there's a file to add to
+		f, err := os.OpenFile(config.KnownHosts, os.O_CREATE|os.O_RDONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+	}
+
+	known, err := knownhosts.New(config.KnownHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(host string, remote net.Addr, key ssh.PublicKey) error {
+		err := known(host, remote, key)
+		var keyErr *knownhosts.KeyError
+		if err == nil || !errors.As(err, &keyErr) {
+			return err
+		}
+
+		if len(keyErr.Want) > 0 {
+			changed := &HostKeyChangedError{Host: host, Fingerprint: ssh.FingerprintSHA256(key)}
+			for _, want := range keyErr.Want {
+				changed.Expected = append(changed.Expected, ssh.FingerprintSHA256(want.Key))
+			}
+			return changed
+		}
+
+		if !config.TrustOnFirstUse {
+			return fmt.Errorf("host key for %s (%s %s) is not in %s", host, key.Type(), ssh.FingerprintSHA256(key), config.KnownHosts)
+		}
+
+		f, err := os.OpenFile(config.KnownHosts, os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := f.WriteString(knownhosts.Line([]string{knownhosts.Normalize(host)}, key) + "\n"); err != nil {
+			return err
+		}
+		if notify != nil {
+			notify(fmt.Sprintf("Trusting the host key for %s (%s %s) on first use, and adding it to %s", host, key.Type(), ssh.FingerprintSHA256(key), config.KnownHosts))
+		}
+		return nil
+	}, nil
+}
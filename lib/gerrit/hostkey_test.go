@@ -0,0 +1,195 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package gerrit
+
+import "context"
+import "errors"
+import "github.com/rburchell/gobo/lib/gerrit/gerrittest"
+import "golang.org/x/crypto/ssh"
+import "golang.org/x/crypto/ssh/knownhosts"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "strings"
+import "testing"
+import "time"
+
+func dialTestServer(t *testing.T, server *gerrittest.Server, config Config) (string, error) {
+	var notices []string
+	callback, err := HostKeyCallback(config.HostKeys, func(msg string) {
+		notices = append(notices, msg)
+	})
+	if err != nil {
+		return "", err
+	}
+	clientConfig := &ssh.ClientConfig{
+		User:            "bot",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(gerrittest.NewKey())},
+		Config:          ssh.Config{Ciphers: config.Ciphers, KeyExchanges: config.KeyExchanges},
+		HostKeyCallback: callback,
+	}
+	client, err := dialSSH(context.Background(), server.Addr(), clientConfig, Config{}.withDefaults())
+	if err != nil {
+		return "", err
+	}
+	client.Close()
+	return strings.Join(notices, "\n"), nil
+}
+
+func TestHostKeyFingerprint(t *testing.T) {
+	server := gerrittest.NewServer()
+	defer server.Close()
+	otherKey := gerrittest.NewKey()
+
+	config := Config{HostKeys: HostKeyConfig{Fingerprint: server.Fingerprint()}}
+	if _, err := dialTestServer(t, server, config); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	config.HostKeys.Fingerprint = ssh.FingerprintLegacyMD5(server.HostKey.PublicKey())
+	if _, err := dialTestServer(t, server, config); err != nil {
+		t.Errorf("Unexpected error with an MD5 fingerprint: %s", err)
+	}
+
+	config.HostKeys.Fingerprint = ssh.FingerprintSHA256(otherKey.PublicKey())
+	_, err := dialTestServer(t, server, config)
+	var changed *HostKeyChangedError
+	if !errors.As(err, &changed) {
+		t.Fatalf("Expected a HostKeyChangedError, got %#v", err)
+	}
+	if changed.Fingerprint != server.Fingerprint() {
+		t.Errorf("Expected: %#v, got %#v", server.Fingerprint(), changed.Fingerprint)
+	}
+}
+
+func TestHostKeyKnownHosts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gerrit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := gerrittest.NewServer()
+	defer server.Close()
+	otherKey := gerrittest.NewKey()
+
+	knownHosts := filepath.Join(dir, "known_hosts")
+	config := Config{HostKeys: HostKeyConfig{KnownHosts: knownHosts}}
+
+	// unknown host, without trust on first use
+	ioutil.WriteFile(knownHosts, nil, 0600)
+	if _, err := dialTestServer(t, server, config); err == nil || !strings.Contains(err.Error(), "is not in "+knownHosts) {
+		t.Errorf("Expected an unknown host error, got %#v", err)
+	}
+
+	// a known host
+	line := knownhosts.Line([]string{knownhosts.Normalize(server.Addr())}, server.HostKey.PublicKey())
+	ioutil.WriteFile(knownHosts, []byte(line+"\n"), 0600)
+	if _, err := dialTestServer(t, server, config); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	// a changed key
+	line = knownhosts.Line([]string{knownhosts.Normalize(server.Addr())}, otherKey.PublicKey())
+	ioutil.WriteFile(knownHosts, []byte(line+"\n"), 0600)
+	_, err = dialTestServer(t, server, config)
+	var changed *HostKeyChangedError
+	if !errors.As(err, &changed) {
+		t.Fatalf("Expected a HostKeyChangedError, got %#v", err)
+	}
+	if len(changed.Expected) != 1 || changed.Expected[0] != ssh.FingerprintSHA256(otherKey.PublicKey()) {
+		t.Errorf("Expected: %#v, got %#v", ssh.FingerprintSHA256(otherKey.PublicKey()), changed.Expected)
+	}
+}
+
+func TestHostKeyTrustOnFirstUse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gerrit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := gerrittest.NewServer()
+	defer server.Close()
+
+	config := Config{HostKeys: HostKeyConfig{KnownHosts: filepath.Join(dir, "known_hosts"), TrustOnFirstUse: true}}
+	notices, err := dialTestServer(t, server, config)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !strings.HasPrefix(notices, "Trusting the host key for "+server.Addr()) {
+		t.Errorf("Expected a notice about trusting the key, got %#v", notices)
+	}
+
+	data, _ := ioutil.ReadFile(config.HostKeys.KnownHosts)
+	expected := knownhosts.Line([]string{knownhosts.Normalize(server.Addr())}, server.HostKey.PublicKey()) + "\n"
+	if string(data) != expected {
+		t.Errorf("Expected: %#v, got %#v", expected, string(data))
+	}
+
+	// the second time, it's known; and a new key is a change, not a first use.
+	if notices, err := dialTestServer(t, server, config); err != nil || len(notices) > 0 {
+		t.Errorf("Expected no error or notices, got %v, %#v", err, notices)
+	}
+
+	oldKey := server.HostKey
+	server.Close()
+	server = gerrittest.NewServer()
+	defer server.Close()
+	ioutil.WriteFile(config.HostKeys.KnownHosts, []byte(knownhosts.Line([]string{knownhosts.Normalize(server.Addr())}, oldKey.PublicKey())+"\n"), 0600)
+	_, err = dialTestServer(t, server, config)
+	var changed *HostKeyChangedError
+	if !errors.As(err, &changed) {
+		t.Errorf("Expected a HostKeyChangedError, got %#v", err)
+	}
+}
+
+func TestSSHCiphers(t *testing.T) {
+	server := gerrittest.NewUnstartedServer()
+	server.Config.Ciphers = []string{"aes256-ctr"}
+	server.Start()
+	defer server.Close()
+
+	config := Config{HostKeys: HostKeyConfig{Fingerprint: server.Fingerprint()}}
+	config.Ciphers = []string{"aes128-ctr"}
+	if _, err := dialTestServer(t, server, config); err == nil || !strings.Contains(err.Error(), "no common algorithm for client to server cipher") {
+		t.Errorf("Expected no common cipher, got %#v", err)
+	}
+
+	config.Ciphers = []string{"aes128-ctr", "aes256-ctr"}
+	config.KeyExchanges = []string{"curve25519-sha256"}
+	if _, err := dialTestServer(t, server, config); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestDialCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	config := &ssh.ClientConfig{User: "bot", HostKeyCallback: ssh.InsecureIgnoreHostKey()}
+	if _, err := dialSSH(ctx, "127.0.0.1:1", config, Config{DialTimeout: time.Second}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected: %#v, got %#v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,326 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package gerrit
+
+import "bufio"
+import "encoding/json"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "os"
+import "sort"
+import "strconv"
+import "strings"
+import "sync"
+import "time"
+
+// The result of `gerrit query --format=JSON --patch-sets --all-approvals
+// --comments`, one per changed change.
+type queryChange struct {
+	Type        string  `json:"type"` // only set for the stats line
+	Project     string  `json:"project"`
+	Branch      string  `json:"branch"`
+	Id          string  `json:"id"`
+	Number      Number  `json:"number"`
+	Subject     string  `json:"subject"`
+	Owner       Account `json:"owner"`
+	Url         string  `json:"url"`
+	Topic       string  `json:"topic"`
+	LastUpdated int64   `json:"lastUpdated"`
+	Status      string  `json:"status"` // NEW, MERGED, ABANDONED
+	PatchSets   []struct {
+		Number    Number   `json:"number"`
+		Revision  string   `json:"revision"`
+		Parents   []string `json:"parents"`
+		Ref       string   `json:"ref"`
+		Uploader  Account  `json:"uploader"`
+		Author    Account  `json:"author"`
+		CreatedOn int64    `json:"createdOn"`
+		Approvals []struct {
+			Type        string  `json:"type"`
+			Description string  `json:"description"`
+			Value       Number  `json:"value"`
+			GrantedOn   int64   `json:"grantedOn"`
+			By          Account `json:"by"`
+		} `json:"approvals"`
+	} `json:"patchSets"`
+	Comments []struct {
+		Timestamp int64   `json:"timestamp"`
+		Reviewer  Account `json:"reviewer"`
+		Message   string  `json:"message"`
+	} `json:"comments"`
+
+	// Only in the stats line
+	RowCount    int  `json:"rowCount"`
+	MoreChanges bool `json:"moreChanges"`
+}
+
+// Build the query for changes updated since the given time.
+func queryCommand(since time.Time, start int) string {
+	return fmt.Sprintf(`gerrit query --format=JSON --patch-sets --all-approvals --comments --start %d 'since:"%s"'`,
+		start, since.UTC().Format("2006-01-02 15:04:05 -0700"))
+}
+
+// Parse the output of a query, returning the changes and whether there are
+// more to fetch.
+func parseQueryResults(r io.Reader) ([]*queryChange, bool, error) {
+	var changes []*queryChange
+	more := false
+
+	bio := bufio.NewReader(r)
+	for {
+		line, err := bio.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			var change queryChange
+			if jerr := json.Unmarshal(line, &change); jerr != nil {
+				return nil, false, fmt.Errorf("bad query result: %s", jerr.Error())
+			}
+			if change.Type == "stats" {
+				more = change.MoreChanges
+			} else if change.Type == "error" {
+				return nil, false, fmt.Errorf("query failed: %s", strings.TrimSpace(string(line)))
+			} else {
+				changes = append(changes, &change)
+			}
+		}
+		if err == io.EOF {
+			return changes, more, nil
+		} else if err != nil {
+			return nil, false, err
+		}
+	}
+}
+
+// Reconstruct the stream events that the changes went through from since on,
+// as best we can, in the order they happened. Gerrit only has timestamps to
+// the second, so that includes the second of since itself, and so likely
+// events already seen, which the deduplicator drops.
+func synthesizeEvents(changes []*queryChange, since time.Time) []Event {
+	var events []Event
+	after := since.Unix()
+
+	for _, change := range changes {
+		base := func(eventType string, createdOn int64) EventBase {
+			return EventBase{Type: eventType, EventCreatedOn: createdOn, replayed: true}
+		}
+		info := Change{
+			Project: change.Project,
+			Branch:  change.Branch,
+			Id:      change.Id,
+			Number:  change.Number,
+			Subject: change.Subject,
+			Owner:   change.Owner,
+			Url:     change.Url,
+			Topic:   change.Topic,
+		}
+		patchSet := func(idx int) PatchSet {
+			ps := change.PatchSets[idx]
+			return PatchSet{
+				Number:    ps.Number,
+				Revision:  ps.Revision,
+				Parents:   ps.Parents,
+				Ref:       ps.Ref,
+				Uploader:  ps.Uploader,
+				Author:    ps.Author,
+				CreatedOn: ps.CreatedOn,
+			}
+		}
+		patchSetIndex := func(number Number) int {
+			for idx, ps := range change.PatchSets {
+				if ps.Number == number {
+					return idx
+				}
+			}
+			return len(change.PatchSets) - 1
+		}
+		lastPatchSet := func() PatchSet {
+			if len(change.PatchSets) == 0 {
+				return PatchSet{}
+			}
+			return patchSet(len(change.PatchSets) - 1)
+		}
+
+		for idx, ps := range change.PatchSets {
+			if ps.CreatedOn >= after {
+				events = append(events, &PatchSetCreated{
+					EventBase: base("patchset-created", ps.CreatedOn),
+					Change:    info,
+					PatchSet:  patchSet(idx),
+					Uploader:  ps.Uploader,
+				})
+			}
+		}
+
+		// the person who last commented is the best guess at who merged or
+		// abandoned it, since both leave a comment behind.
+		lastCommenter := change.Owner
+		for _, comment := range change.Comments {
+			lastCommenter = comment.Reviewer
+
+			// only reviews cause comment-added, not e.g. the messages Gerrit
+			// leaves when uploading, merging or abandoning.
+			if comment.Timestamp < after || !strings.HasPrefix(comment.Message, "Patch Set ") {
+				continue
+			}
+
+			event := &CommentAdded{
+				EventBase: base("comment-added", comment.Timestamp),
+				Change:    info,
+				Author:    comment.Reviewer,
+				Comment:   comment.Message,
+			}
+
+			var number Number
+			fmt.Sscanf(comment.Message, "Patch Set %d", &number)
+			if len(change.PatchSets) > 0 {
+				idx := patchSetIndex(number)
+				event.PatchSet = patchSet(idx)
+				for _, approval := range change.PatchSets[idx].Approvals {
+					if approval.GrantedOn == comment.Timestamp && approval.By.Same(comment.Reviewer) {
+						event.Approvals = append(event.Approvals, Approval{
+							Type:        approval.Type,
+							Description: approval.Description,
+							Value:       approval.Value,
+						})
+					}
+				}
+			}
+			events = append(events, event)
+		}
+
+		if change.LastUpdated >= after {
+			switch change.Status {
+			case "MERGED":
+				events = append(events, &ChangeMerged{
+					EventBase: base("change-merged", change.LastUpdated),
+					Change:    info,
+					PatchSet:  lastPatchSet(),
+					Submitter: lastCommenter,
+				})
+			case "ABANDONED":
+				events = append(events, &ChangeAbandoned{
+					EventBase: base("change-abandoned", change.LastUpdated),
+					Change:    info,
+					PatchSet:  lastPatchSet(),
+					Abandoner: lastCommenter,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].base().EventCreatedOn < events[j].base().EventCreatedOn
+	})
+	for _, event := range events {
+		encodeEvent(event)
+	}
+	return events
+}
+
+// Return a key identifying event, so the same event arriving both live and
+// from a replay can be recognised.
+func eventKey(event Event) string {
+	switch e := event.(type) {
+	case *PatchSetCreated:
+		return fmt.Sprintf("%s|%d|%d", e.Type, e.Change.Number, e.PatchSet.Number)
+	case *CommentAdded:
+		who := e.Author.Username
+		if len(who) == 0 {
+			who = e.Author.Email
+		}
+		return fmt.Sprintf("%s|%d|%s|%s", e.Type, e.Change.Number, who, strings.TrimSpace(e.Comment))
+	case *ChangeMerged:
+		return fmt.Sprintf("%s|%d", e.Type, e.Change.Number)
+	case *ChangeAbandoned:
+		return fmt.Sprintf("%s|%d", e.Type, e.Change.Number)
+	case *RefUpdated:
+		return fmt.Sprintf("%s|%s|%s|%s", e.Type, e.RefUpdate.Project, e.RefUpdate.RefName, e.RefUpdate.NewRev)
+	}
+	// nothing better to go on than the exact event
+	return string(event.Raw())
+}
+
+// Remembers the events seen recently, to drop duplicates.
+type eventDeduplicator struct {
+	mutex  sync.Mutex
+	maxAge time.Duration
+	seen   map[string]bool
+	order  []seenEvent // what's in seen, oldest first
+}
+
+// An event remembered by an eventDeduplicator.
+type seenEvent struct {
+	key  string
+	when time.Time
+}
+
+func newEventDeduplicator(maxAge time.Duration) *eventDeduplicator {
+	return &eventDeduplicator{maxAge: maxAge, seen: map[string]bool{}}
+}
+
+// Returns true if event was seen before, and remembers it otherwise.
+func (this *eventDeduplicator) Seen(event Event, now time.Time) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	expired := 0
+	for expired < len(this.order) && now.Sub(this.order[expired].when) > this.maxAge {
+		delete(this.seen, this.order[expired].key)
+		expired++
+	}
+	this.order = this.order[expired:]
+
+	key := eventKey(event)
+	if this.seen[key] {
+		return true
+	}
+	this.seen[key] = true
+	this.order = append(this.order, seenEvent{key: key, when: now})
+	return false
+}
+
+// Read the time of the last processed event from path. A missing file isn't
+// an error, it just means there's nothing to catch up on.
+func loadLastEvent(path string) (time.Time, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	seconds, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+func saveLastEvent(path string, when time.Time) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(fmt.Sprintf("%d\n", when.Unix())), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
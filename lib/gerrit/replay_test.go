@@ -0,0 +1,175 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package gerrit
+
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "strings"
+import "testing"
+import "time"
+
+const testQueryResults = `{"project":"qt/qtbase","branch":"dev","id":"I0123","number":1234,"subject":"Fix it","owner":{"name":"Alice","email":"alice@example.com","username":"alice"},"url":"https://codereview.qt-project.org/c/qt/qtbase/+/1234","lastUpdated":1700000500,"status":"MERGED","patchSets":[{"number":1,"revision":"aaaa","ref":"refs/changes/34/1234/1","uploader":{"name":"Alice","username":"alice"},"createdOn":1699990000},{"number":2,"revision":"bbbb","ref":"refs/changes/34/1234/2","uploader":{"name":"Alice","username":"alice"},"createdOn":1700000100,"approvals":[{"type":"Code-Review","description":"Code-Review","value":"2","grantedOn":1700000200,"by":{"name":"Bob","username":"bob"}},{"type":"Sanity-Review","value":"1","grantedOn":1700000150,"by":{"name":"Qt Sanity Bot","username":"qt_sanitybot"}}]}],"comments":[{"timestamp":1699990000,"reviewer":{"name":"Alice","username":"alice"},"message":"Uploaded patch set 1."},{"timestamp":1700000100,"reviewer":{"name":"Alice","username":"alice"},"message":"Uploaded patch set 2."},{"timestamp":1700000200,"reviewer":{"name":"Bob","username":"bob"},"message":"Patch Set 2: Code-Review+2\n\nLooks good"},{"timestamp":1700000500,"reviewer":{"name":"Qt CI Bot","username":"qt_ci_bot"},"message":"Change has been successfully cherry-picked as cccc"}]}
+{"project":"qt/qtdeclarative","branch":"6.5","id":"I4567","number":"4567","subject":"Drop it","owner":{"name":"Carol","username":"carol"},"url":"https://codereview.qt-project.org/c/qt/qtdeclarative/+/4567","lastUpdated":1700000300,"status":"ABANDONED","patchSets":[{"number":"1","revision":"dddd","uploader":{"name":"Carol","username":"carol"},"createdOn":1699000000}],"comments":[{"timestamp":1700000300,"reviewer":{"name":"Carol","username":"carol"},"message":"Abandoned"}]}
+{"type":"stats","rowCount":2,"runTimeMilliseconds":5,"moreChanges":true}
+`
+
+func testEvent(t *testing.T, blob string) Event {
+	event, err := ParseEvent([]byte(blob))
+	if err != nil {
+		t.Fatalf("Failed to parse %s: %s", blob, err)
+	}
+	return event
+}
+
+func TestParseQueryResults(t *testing.T) {
+	changes, more, err := parseQueryResults(strings.NewReader(testQueryResults))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !more {
+		t.Errorf("Expected more changes")
+	}
+	if len(changes) != 2 || changes[0].Number != 1234 || changes[1].Number != 4567 {
+		t.Fatalf("Expected changes 1234 and 4567, got %#v", changes)
+	}
+
+	_, _, err = parseQueryResults(strings.NewReader(`{"type":"error","message":"bad query"}` + "\n"))
+	if err == nil {
+		t.Errorf("Expected an error")
+	}
+}
+
+func TestSynthesizeEvents(t *testing.T) {
+	changes, _, err := parseQueryResults(strings.NewReader(testQueryResults))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	events := synthesizeEvents(changes, time.Unix(1700000000, 0))
+	var got []string
+	for _, event := range events {
+		if !event.IsReplayed() {
+			t.Errorf("Expected %s to be marked as replayed", event.EventType())
+		}
+		actor := ""
+		switch e := event.(type) {
+		case *PatchSetCreated:
+			actor = e.Uploader.Name
+		case *CommentAdded:
+			actor = e.Author.Name
+			for _, approval := range e.Approvals {
+				actor += "|" + approval.Type
+			}
+		case *ChangeMerged:
+			actor = e.Submitter.Name
+		case *ChangeAbandoned:
+			actor = e.Abandoner.Name
+		}
+		got = append(got, eventKey(event)+"|"+actor)
+
+		// the raw JSON decodes back to the same event
+		if again := testEvent(t, string(event.Raw())); eventKey(again) != eventKey(event) {
+			t.Errorf("Expected: %#v, got %#v", eventKey(event), eventKey(again))
+		}
+	}
+
+	expected := []string{
+		"patchset-created|1234|2|Alice",
+		"comment-added|1234|bob|Patch Set 2: Code-Review+2\n\nLooks good|Bob|Code-Review",
+		"change-abandoned|4567|Carol",
+		"change-merged|1234|Qt CI Bot",
+	}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected: %#v, got %#v", expected, got)
+	}
+
+	// what happened in the same second as since may not have been seen yet
+	if events := synthesizeEvents(changes, time.Unix(1700000500, 0)); len(events) != 1 || eventKey(events[0]) != "change-merged|1234" {
+		t.Errorf("Expected only change-merged, got %#v", events)
+	}
+	if events := synthesizeEvents(changes, time.Unix(1700000501, 0)); len(events) != 0 {
+		t.Errorf("Expected no events, got %#v", events)
+	}
+}
+
+func TestQueryCommand(t *testing.T) {
+	since := time.Date(2023, 11, 14, 23, 13, 20, 0, time.FixedZone("CET", 3600))
+	expected := `gerrit query --format=JSON --patch-sets --all-approvals --comments --start 10 'since:"2023-11-14 22:13:20 +0000"'`
+	if cmd := queryCommand(since, 10); cmd != expected {
+		t.Errorf("Expected: %#v, got %#v", expected, cmd)
+	}
+}
+
+func TestEventDeduplicator(t *testing.T) {
+	dedup := newEventDeduplicator(time.Hour)
+	now := time.Now()
+
+	live := testEvent(t, `{"type": "comment-added", "author": {"name": "Bob", "username": "bob"}, "comment": "Patch Set 2: Code-Review+2\n\nLooks good", "change": {"number": "1234"}, "eventCreatedOn": 1700000201}`)
+	changes, _, _ := parseQueryResults(strings.NewReader(testQueryResults))
+	replayed := synthesizeEvents(changes, time.Unix(1700000000, 0))[1]
+
+	if dedup.Seen(replayed, now) {
+		t.Errorf("Expected the replayed event to be new")
+	}
+	if !dedup.Seen(live, now) {
+		t.Errorf("Expected the live event to be a duplicate of the replayed one")
+	}
+
+	merged := testEvent(t, `{"type": "change-merged", "change": {"number": "1"}}`)
+	if dedup.Seen(merged, now) || !dedup.Seen(merged, now.Add(time.Minute)) {
+		t.Errorf("Expected only the second change-merged to be a duplicate")
+	}
+	abandoned := testEvent(t, `{"type": "change-abandoned", "change": {"number": "2"}}`)
+	dedup.Seen(abandoned, now.Add(90*time.Minute))
+	if dedup.Seen(merged, now.Add(2*time.Hour)) {
+		t.Errorf("Expected change-merged to be forgotten after an hour")
+	}
+	if !dedup.Seen(abandoned, now.Add(2*time.Hour)) {
+		t.Errorf("Expected change-abandoned to be remembered for an hour")
+	}
+}
+
+func TestLastEventState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gerrit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state")
+
+	when, err := loadLastEvent(path)
+	if err != nil || !when.IsZero() {
+		t.Errorf("Expected no time and no error, got %v, %v", when, err)
+	}
+
+	if err := saveLastEvent(path, time.Unix(1700000000, 0)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	when, err = loadLastEvent(path)
+	if err != nil || when.Unix() != 1700000000 {
+		t.Errorf("Expected: %#v, got %v, %v", 1700000000, when, err)
+	}
+}
@@ -0,0 +1,402 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package gerrit
+
+import "bytes"
+import "context"
+import "encoding/json"
+import "errors"
+import "fmt"
+import "io/ioutil"
+import "net/http"
+import "net/url"
+import "strconv"
+import "strings"
+import "time"
+
+// Options for change queries (o=), asking Gerrit to include more detail.
+const (
+	OptionLabels           = "LABELS"
+	OptionDetailedLabels   = "DETAILED_LABELS"
+	OptionCurrentRevision  = "CURRENT_REVISION"
+	OptionAllRevisions     = "ALL_REVISIONS"
+	OptionCurrentCommit    = "CURRENT_COMMIT"
+	OptionCurrentFiles     = "CURRENT_FILES"
+	OptionMessages         = "MESSAGES"
+	OptionDetailedAccounts = "DETAILED_ACCOUNTS"
+	OptionReviewerUpdates  = "REVIEWER_UPDATES"
+)
+
+// RestConfig controls the behaviour of a RestClient.
+type RestConfig struct {
+	// Where Gerrit is, e.g. https://codereview.qt-project.org.
+	Url string
+
+	// Credentials, if any: a username and HTTP password (as generated in
+	// Gerrit's settings) for basic auth, or a token for bearer auth. With
+	// either, requests go to the authenticated (/a/) endpoints.
+	Username string
+	Password string
+	Token    string
+
+	// How long a request may take (default 10s).
+	Timeout time.Duration
+
+	// How many times to retry a request that failed in a way that might
+	// not fail again, i.e. a network error or a 5xx or 429 status (default
+	// 2), and how long to wait before the first retry (default 1s, doubling
+	// each time).
+	Retries    int
+	RetryDelay time.Duration
+
+	// The HTTP client to use. If nil, one is created.
+	HTTPClient *http.Client
+}
+
+// A RestClient talks to Gerrit's REST API.
+type RestClient struct {
+	config RestConfig
+	client *http.Client
+}
+
+// NewRestClient creates a client for the Gerrit described by config.
+func NewRestClient(config RestConfig) *RestClient {
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.Retries == 0 {
+		config.Retries = 2
+	} else if config.Retries < 0 {
+		config.Retries = 0
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = time.Second
+	}
+	config.Url = strings.TrimSuffix(config.Url, "/")
+
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: config.Timeout}
+	}
+	return &RestClient{config: config, client: client}
+}
+
+// A RestError is a reply from Gerrit with an unsuccessful status.
+type RestError struct {
+	Url        string
+	StatusCode int
+	Body       string
+}
+
+func (this *RestError) Error() string {
+	msg := strings.TrimSpace(this.Body)
+	if len(msg) == 0 {
+		msg = http.StatusText(this.StatusCode)
+	}
+	return fmt.Sprintf("%s: %d %s", this.Url, this.StatusCode, msg)
+}
+
+// IsNotFound returns true if err says the thing asked for doesn't exist (or
+// isn't visible to us).
+func IsNotFound(err error) bool {
+	var restErr *RestError
+	return errors.As(err, &restErr) && restErr.StatusCode == http.StatusNotFound
+}
+
+// A Timestamp as Gerrit writes it: "2006-01-02 15:04:05.000000000", in UTC.
+type Timestamp struct {
+	time.Time
+}
+
+func (this *Timestamp) UnmarshalJSON(data []byte) error {
+	str := strings.Trim(string(data), `"`)
+	if len(str) == 0 || str == "null" {
+		this.Time = time.Time{}
+		return nil
+	}
+	t, err := time.ParseInLocation("2006-01-02 15:04:05.999999999", str, time.UTC)
+	if err != nil {
+		return err
+	}
+	this.Time = t
+	return nil
+}
+
+type AccountInfo struct {
+	AccountId   int64  `json:"_account_id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Email       string `json:"email"`
+	Username    string `json:"username"`
+	Inactive    bool   `json:"inactive"`
+}
+
+type ApprovalInfo struct {
+	AccountInfo
+	Value int64     `json:"value"`
+	Date  Timestamp `json:"date"`
+}
+
+type LabelInfo struct {
+	Optional     bool              `json:"optional"`
+	Approved     *AccountInfo      `json:"approved"`
+	Rejected     *AccountInfo      `json:"rejected"`
+	Recommended  *AccountInfo      `json:"recommended"`
+	Disliked     *AccountInfo      `json:"disliked"`
+	Blocking     bool              `json:"blocking"`
+	Value        int64             `json:"value"`
+	All          []ApprovalInfo    `json:"all"`    // with DETAILED_LABELS
+	Values       map[string]string `json:"values"` // with DETAILED_LABELS
+	DefaultValue int64             `json:"default_value"`
+}
+
+type GitPersonInfo struct {
+	Name  string    `json:"name"`
+	Email string    `json:"email"`
+	Date  Timestamp `json:"date"`
+}
+
+type CommitInfo struct {
+	Commit  string `json:"commit"`
+	Parents []struct {
+		Commit  string `json:"commit"`
+		Subject string `json:"subject"`
+	} `json:"parents"`
+	Author    GitPersonInfo `json:"author"`
+	Committer GitPersonInfo `json:"committer"`
+	Subject   string        `json:"subject"`
+	Message   string        `json:"message"`
+}
+
+type FileInfo struct {
+	Status        string `json:"status"` // A, D, R, C, W; empty for modified
+	Binary        bool   `json:"binary"`
+	OldPath       string `json:"old_path"`
+	LinesInserted int64  `json:"lines_inserted"`
+	LinesDeleted  int64  `json:"lines_deleted"`
+	SizeDelta     int64  `json:"size_delta"`
+	Size          int64  `json:"size"`
+}
+
+type RevisionInfo struct {
+	Kind     string               `json:"kind"`
+	Number   int64                `json:"_number"`
+	Created  Timestamp            `json:"created"`
+	Uploader AccountInfo          `json:"uploader"`
+	Ref      string               `json:"ref"`
+	Commit   *CommitInfo          `json:"commit"` // with CURRENT_COMMIT
+	Files    map[string]*FileInfo `json:"files"`  // with CURRENT_FILES
+}
+
+type ChangeMessageInfo struct {
+	Id             string      `json:"id"`
+	Author         AccountInfo `json:"author"`
+	Date           Timestamp   `json:"date"`
+	Message        string      `json:"message"`
+	Tag            string      `json:"tag"`
+	RevisionNumber int64       `json:"_revision_number"`
+}
+
+type ChangeInfo struct {
+	Id              string                   `json:"id"` // qt%2Fqtbase~dev~I0123...
+	Project         string                   `json:"project"`
+	Branch          string                   `json:"branch"`
+	Topic           string                   `json:"topic"`
+	Hashtags        []string                 `json:"hashtags"`
+	ChangeId        string                   `json:"change_id"`
+	Subject         string                   `json:"subject"`
+	Status          string                   `json:"status"` // NEW, MERGED, ABANDONED
+	Created         Timestamp                `json:"created"`
+	Updated         Timestamp                `json:"updated"`
+	Submitted       Timestamp                `json:"submitted"`
+	Mergeable       bool                     `json:"mergeable"`
+	Insertions      int64                    `json:"insertions"`
+	Deletions       int64                    `json:"deletions"`
+	Number          int64                    `json:"_number"`
+	Owner           AccountInfo              `json:"owner"`
+	WorkInProgress  bool                     `json:"work_in_progress"`
+	IsPrivate       bool                     `json:"is_private"`
+	Labels          map[string]*LabelInfo    `json:"labels"`           // with LABELS or DETAILED_LABELS
+	Messages        []ChangeMessageInfo      `json:"messages"`         // with MESSAGES
+	CurrentRevision string                   `json:"current_revision"` // with CURRENT_REVISION or ALL_REVISIONS
+	Revisions       map[string]*RevisionInfo `json:"revisions"`
+
+	// Set on the last change of a page of results, if there are more.
+	MoreChanges bool `json:"_more_changes"`
+}
+
+type ReviewerInfo struct {
+	AccountInfo
+	Approvals map[string]string `json:"approvals"` // label name to vote, e.g. " 0", "+2"
+}
+
+// The prefix Gerrit puts in front of JSON, to prevent cross site script
+// inclusion.
+var xssiPrefix = []byte(")]}'")
+
+// Do a GET of path (relative to the API's root), with the given query
+// parameters, and decode the JSON reply into out.
+func (this *RestClient) get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	u := this.config.Url
+	if len(this.config.Username) > 0 || len(this.config.Token) > 0 {
+		u += "/a"
+	}
+	u += path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	delay := this.config.RetryDelay
+	for attempt := 0; ; attempt++ {
+		body, err := this.fetch(ctx, u)
+		if err == nil {
+			body = bytes.TrimPrefix(body, xssiPrefix)
+			if err := json.Unmarshal(body, out); err != nil {
+				return fmt.Errorf("%s: %s", u, err.Error())
+			}
+			return nil
+		}
+
+		var restErr *RestError
+		retry := !errors.As(err, &restErr) || restErr.StatusCode >= 500 || restErr.StatusCode == http.StatusTooManyRequests
+		if !retry || attempt >= this.config.Retries || ctx.Err() != nil {
+			return err
+		}
+		if !sleep(ctx, delay) {
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+func (this *RestClient) fetch(ctx context.Context, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if len(this.config.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+this.config.Token)
+	} else if len(this.config.Username) > 0 {
+		req.SetBasicAuth(this.config.Username, this.config.Password)
+	}
+
+	res, err := this.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, &RestError{Url: u, StatusCode: res.StatusCode, Body: string(body)}
+	}
+	return body, nil
+}
+
+func optionValues(options []string) url.Values {
+	query := url.Values{}
+	for _, option := range options {
+		query.Add("o", option)
+	}
+	return query
+}
+
+// GetChange fetches a change, by number, Change-Id, or project~branch~Change-Id.
+func (this *RestClient) GetChange(ctx context.Context, id string, options ...string) (*ChangeInfo, error) {
+	var change ChangeInfo
+	if err := this.get(ctx, "/changes/"+url.PathEscape(id), optionValues(options), &change); err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+// QueryChanges returns the changes matching query (e.g. "status:open
+// project:qt/qtbase"), fetching as many pages as it takes to get limit of
+// them, or all of them if limit is zero.
+func (this *RestClient) QueryChanges(ctx context.Context, query string, limit int, options ...string) ([]*ChangeInfo, error) {
+	var changes []*ChangeInfo
+	for {
+		values := optionValues(options)
+		values.Set("q", query)
+		if limit > 0 {
+			values.Set("n", strconv.Itoa(limit-len(changes)))
+		}
+		if len(changes) > 0 {
+			values.Set("S", strconv.Itoa(len(changes)))
+		}
+
+		var page []*ChangeInfo
+		if err := this.get(ctx, "/changes/", values, &page); err != nil {
+			return nil, err
+		}
+		changes = append(changes, page...)
+
+		more := len(page) > 0 && page[len(page)-1].MoreChanges
+		if !more || (limit > 0 && len(changes) >= limit) {
+			break
+		}
+	}
+	if limit > 0 && len(changes) > limit {
+		changes = changes[:limit]
+	}
+	return changes, nil
+}
+
+// GetAccount looks up an account, by id, username, email or name; "self"
+// is the account we're authenticated as.
+func (this *RestClient) GetAccount(ctx context.Context, id string) (*AccountInfo, error) {
+	var account AccountInfo
+	if err := this.get(ctx, "/accounts/"+url.PathEscape(id), url.Values{"o": {"DETAILS"}}, &account); err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// ListReviewers returns the reviewers of a change, and their votes.
+func (this *RestClient) ListReviewers(ctx context.Context, changeId string) ([]*ReviewerInfo, error) {
+	var reviewers []*ReviewerInfo
+	if err := this.get(ctx, "/changes/"+url.PathEscape(changeId)+"/reviewers/", nil, &reviewers); err != nil {
+		return nil, err
+	}
+	return reviewers, nil
+}
+
+// ListFiles returns the files a revision of a change modifies, by path,
+// including Gerrit's magic /COMMIT_MSG. An empty revision means the current
+// one.
+func (this *RestClient) ListFiles(ctx context.Context, changeId string, revision string) (map[string]*FileInfo, error) {
+	if len(revision) == 0 {
+		revision = "current"
+	}
+	var files map[string]*FileInfo
+	if err := this.get(ctx, "/changes/"+url.PathEscape(changeId)+"/revisions/"+url.PathEscape(revision)+"/files/", nil, &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
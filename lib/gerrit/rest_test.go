@@ -0,0 +1,270 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package gerrit
+
+import "context"
+import "fmt"
+import "net/http"
+import "net/http/httptest"
+import "strconv"
+import "strings"
+import "sync"
+import "testing"
+import "time"
+
+// A stand-in for Gerrit's REST API, recording the requests it gets.
+type testRestServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests []string
+	failures int // how many requests to fail with 503 first
+}
+
+func startTestRestServer(t *testing.T) *testRestServer {
+	server := &testRestServer{}
+	mux := http.NewServeMux()
+	reply := func(w http.ResponseWriter, body string) {
+		fmt.Fprintf(w, ")]}'\n%s", body)
+	}
+
+	mux.HandleFunc("/a/changes/", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if r.Header.Get("Authorization") != "Bearer secret" && (!ok || user != "bot" || pass != "hunter2") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		server.serveChanges(w, r, reply)
+	})
+	mux.HandleFunc("/changes/", func(w http.ResponseWriter, r *http.Request) {
+		server.serveChanges(w, r, reply)
+	})
+	mux.HandleFunc("/accounts/", func(w http.ResponseWriter, r *http.Request) {
+		server.record(r)
+		if r.URL.Path != "/accounts/alice" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "Account Not Found: %s", strings.TrimPrefix(r.URL.Path, "/accounts/"))
+			return
+		}
+		reply(w, `{"_account_id": 1000, "name": "Alice", "email": "alice@example.com", "username": "alice"}`)
+	})
+
+	server.Server = httptest.NewServer(mux)
+	return server
+}
+
+func (this *testRestServer) record(r *http.Request) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.requests = append(this.requests, r.URL.RequestURI())
+}
+
+func (this *testRestServer) serveChanges(w http.ResponseWriter, r *http.Request, reply func(http.ResponseWriter, string)) {
+	this.record(r)
+
+	this.mutex.Lock()
+	fail := this.failures > 0
+	if fail {
+		this.failures--
+	}
+	this.mutex.Unlock()
+	if fail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	path := strings.TrimPrefix(strings.TrimPrefix(r.URL.EscapedPath(), "/a"), "/changes/")
+	switch {
+	case path == "":
+		// 5 changes, 2 to a page
+		start, _ := strconv.Atoi(r.URL.Query().Get("S"))
+		var page []string
+		for idx := start; idx < 5 && idx < start+2; idx++ {
+			change := fmt.Sprintf(`{"_number": %d, "project": "qt/qtbase", "subject": "Change %d"`, idx+1, idx+1)
+			if idx == start+1 && idx < 4 {
+				change += `, "_more_changes": true`
+			}
+			page = append(page, change+"}")
+		}
+		reply(w, "["+strings.Join(page, ",")+"]")
+	case path == "qt%2Fqtbase~dev~I0123":
+		reply(w, `{"id": "qt%2Fqtbase~dev~I0123", "project": "qt/qtbase", "branch": "dev", "change_id": "I0123", "subject": "Fix it", "status": "NEW", "created": "2023-11-14 22:13:20.000000000", "_number": 1234, "owner": {"_account_id": 1000, "name": "Alice"}, "labels": {"Code-Review": {"approved": {"name": "Bob"}, "all": [{"name": "Bob", "value": 2}]}}, "current_revision": "bbbb", "revisions": {"bbbb": {"_number": 2, "ref": "refs/changes/34/1234/2"}}, "messages": [{"author": {"name": "Bob"}, "message": "Patch Set 2: Code-Review+2", "_revision_number": 2}]}`)
+	case path == "1234/reviewers/":
+		reply(w, `[{"_account_id": 1001, "name": "Bob", "approvals": {"Code-Review": "+2", "Verified": " 0"}}]`)
+	case path == "1234/revisions/current/files/":
+		reply(w, `{"/COMMIT_MSG": {"status": "A", "lines_inserted": 7}, "src/corelib/qfoo.cpp": {"lines_inserted": 10, "lines_deleted": 2}}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Not found: %s", path)
+	}
+}
+
+func (this *testRestServer) takeRequests() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	requests := this.requests
+	this.requests = nil
+	return requests
+}
+
+func TestRestGetChange(t *testing.T) {
+	server := startTestRestServer(t)
+	defer server.Close()
+
+	client := NewRestClient(RestConfig{Url: server.URL + "/", Username: "bot", Password: "hunter2"})
+	change, err := client.GetChange(context.Background(), "qt/qtbase~dev~I0123", OptionDetailedLabels, OptionCurrentRevision, OptionMessages)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if change.Number != 1234 || change.Owner.Name != "Alice" || change.Labels["Code-Review"].Approved.Name != "Bob" || change.Labels["Code-Review"].All[0].Value != 2 {
+		t.Errorf("Unexpected change: %#v", change)
+	}
+	if change.Revisions[change.CurrentRevision].Number != 2 || len(change.Messages) != 1 || change.Messages[0].RevisionNumber != 2 {
+		t.Errorf("Unexpected revisions or messages: %#v", change)
+	}
+	if expected := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC); !change.Created.Equal(expected) {
+		t.Errorf("Expected: %v, got %v", expected, change.Created)
+	}
+
+	expected := []string{"/a/changes/qt%2Fqtbase~dev~I0123?o=DETAILED_LABELS&o=CURRENT_REVISION&o=MESSAGES"}
+	if requests := server.takeRequests(); strings.Join(requests, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected: %#v, got %#v", expected, requests)
+	}
+
+	// a bearer token works too, and without credentials, the anonymous API is used
+	client = NewRestClient(RestConfig{Url: server.URL, Token: "secret"})
+	if _, err := client.GetChange(context.Background(), "qt/qtbase~dev~I0123"); err != nil {
+		t.Errorf("Unexpected error with a token: %s", err)
+	}
+	client = NewRestClient(RestConfig{Url: server.URL})
+	if _, err := client.GetChange(context.Background(), "qt/qtbase~dev~I0123"); err != nil {
+		t.Errorf("Unexpected error without credentials: %s", err)
+	}
+	expected = []string{"/a/changes/qt%2Fqtbase~dev~I0123", "/changes/qt%2Fqtbase~dev~I0123"}
+	if requests := server.takeRequests(); strings.Join(requests, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected: %#v, got %#v", expected, requests)
+	}
+
+	// bad credentials aren't retried
+	client = NewRestClient(RestConfig{Url: server.URL, Username: "bot", Password: "wrong"})
+	if _, err := client.GetChange(context.Background(), "1234"); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Expected a 401, got %#v", err)
+	}
+	if requests := server.takeRequests(); len(requests) != 0 {
+		t.Errorf("Expected no requests to get past authentication, got %#v", requests)
+	}
+}
+
+func TestRestQueryChanges(t *testing.T) {
+	server := startTestRestServer(t)
+	defer server.Close()
+	client := NewRestClient(RestConfig{Url: server.URL})
+
+	changes, err := client.QueryChanges(context.Background(), "status:open", 0, OptionLabels)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(changes) != 5 || changes[0].Number != 1 || changes[4].Number != 5 {
+		t.Errorf("Expected 5 changes, got %#v", changes)
+	}
+	expected := []string{
+		"/changes/?o=LABELS&q=status%3Aopen",
+		"/changes/?S=2&o=LABELS&q=status%3Aopen",
+		"/changes/?S=4&o=LABELS&q=status%3Aopen",
+	}
+	if requests := server.takeRequests(); strings.Join(requests, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected: %#v, got %#v", expected, requests)
+	}
+
+	changes, err = client.QueryChanges(context.Background(), "status:open", 3)
+	if err != nil || len(changes) != 3 {
+		t.Errorf("Expected 3 changes, got %#v, %v", changes, err)
+	}
+	expected = []string{"/changes/?n=3&q=status%3Aopen", "/changes/?S=2&n=1&q=status%3Aopen"}
+	if requests := server.takeRequests(); strings.Join(requests, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected: %#v, got %#v", expected, requests)
+	}
+}
+
+func TestRestRetries(t *testing.T) {
+	server := startTestRestServer(t)
+	defer server.Close()
+	client := NewRestClient(RestConfig{Url: server.URL, Retries: 2, RetryDelay: time.Millisecond})
+
+	server.failures = 2
+	if _, err := client.GetChange(context.Background(), "qt/qtbase~dev~I0123"); err != nil {
+		t.Errorf("Unexpected error after retrying: %s", err)
+	}
+	if requests := server.takeRequests(); len(requests) != 3 {
+		t.Errorf("Expected 3 requests, got %#v", requests)
+	}
+
+	server.failures = 3
+	_, err := client.GetChange(context.Background(), "qt/qtbase~dev~I0123")
+	if restErr, ok := err.(*RestError); !ok || restErr.StatusCode != 503 {
+		t.Errorf("Expected a 503, got %#v", err)
+	}
+	server.takeRequests()
+	server.failures = 0
+
+	// not found isn't worth retrying
+	_, err = client.GetChange(context.Background(), "999")
+	if !IsNotFound(err) || !strings.HasSuffix(err.Error(), ": 404 Not found: 999") {
+		t.Errorf("Expected not found, got %#v", err)
+	}
+	if requests := server.takeRequests(); len(requests) != 1 {
+		t.Errorf("Expected 1 request, got %#v", requests)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.GetChange(ctx, "1234"); err == nil {
+		t.Errorf("Expected an error when cancelled")
+	}
+}
+
+func TestRestAccountsReviewersFiles(t *testing.T) {
+	server := startTestRestServer(t)
+	defer server.Close()
+	client := NewRestClient(RestConfig{Url: server.URL})
+	ctx := context.Background()
+
+	account, err := client.GetAccount(ctx, "alice")
+	if err != nil || account.AccountId != 1000 || account.Email != "alice@example.com" {
+		t.Errorf("Unexpected account: %#v, %v", account, err)
+	}
+	if _, err := client.GetAccount(ctx, "nobody"); !IsNotFound(err) {
+		t.Errorf("Expected not found, got %#v", err)
+	}
+
+	reviewers, err := client.ListReviewers(ctx, "1234")
+	if err != nil || len(reviewers) != 1 || reviewers[0].Name != "Bob" || reviewers[0].Approvals["Code-Review"] != "+2" {
+		t.Errorf("Unexpected reviewers: %#v, %v", reviewers, err)
+	}
+
+	files, err := client.ListFiles(ctx, "1234", "")
+	if err != nil || len(files) != 2 || files["src/corelib/qfoo.cpp"].LinesInserted != 10 || files["/COMMIT_MSG"].Status != "A" {
+		t.Errorf("Unexpected files: %#v, %v", files, err)
+	}
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package gerrit
+
+import "context"
+import "golang.org/x/crypto/ssh"
+import "net"
+import "time"
+
+// A net.Conn that gives up on reads and writes that take too long, so a
+// connection that silently died doesn't hang us forever.
+type timeoutConn struct {
+	net.Conn
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+}
+
+func (this *timeoutConn) Read(b []byte) (int, error) {
+	if err := this.Conn.SetReadDeadline(time.Now().Add(this.readTimeout)); err != nil {
+		return 0, err
+	}
+	return this.Conn.Read(b)
+}
+
+func (this *timeoutConn) Write(b []byte) (int, error) {
+	if err := this.Conn.SetWriteDeadline(time.Now().Add(this.writeTimeout)); err != nil {
+		return 0, err
+	}
+	return this.Conn.Write(b)
+}
+
+// Connect to addr, and keep the connection alive until it's closed.
+func dialSSH(ctx context.Context, addr string, config *ssh.ClientConfig, timeouts Config) (*ssh.Client, error) {
+	dialer := net.Dialer{Timeout: timeouts.DialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// the handshake has no context of its own
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	c, chans, reqs, err := ssh.NewClientConn(&timeoutConn{conn, timeouts.ReadTimeout, timeouts.WriteTimeout}, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	client := ssh.NewClient(c, chans, reqs)
+
+	go func() {
+		t := time.NewTicker(timeouts.KeepAlive)
+		defer t.Stop()
+		for {
+			<-t.C
+			_, _, err := client.Conn.SendRequest("keepalive@gobo", true, nil)
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return client, nil
+}
@@ -0,0 +1,475 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Package bouncer lets local IRC clients share the connection of an
+// IrcClient, as an IRC bouncer would.
+//
+// Downstream clients connect to a listener and register as they would with
+// any IRC server. They are then sent the registration burst and the state of
+// the channels the upstream connection is in, and from then on see everything
+// the upstream connection receives. Commands they send are forwarded upstream.
+// While no downstream client is attached, messages are buffered, and played
+// back to the next client that attaches.
+package bouncer
+
+import "github.com/rburchell/gobo/lib/irc/client"
+import "github.com/rburchell/gobo/lib/irc/parser"
+import "bufio"
+import "crypto/subtle"
+import "net"
+import "sort"
+import "strings"
+import "sync"
+import "time"
+
+// The name the bouncer uses as a prefix for messages it generates itself.
+const serverName = "gobo.bouncer"
+
+// Config controls the behaviour of a Bouncer.
+type Config struct {
+	// The password downstream clients must send (with PASS) to attach. If
+	// empty, no password is required, so anyone who can connect may speak as
+	// the client.
+	Password string
+
+	// How many messages to buffer while no downstream client is attached.
+	// Defaults to 500. Older messages are dropped first.
+	BufferSize int
+}
+
+// Commands worth buffering while nobody is attached.
+var bufferedCommands = map[string]bool{
+	"PRIVMSG": true, "NOTICE": true, "JOIN": true, "PART": true, "KICK": true,
+	"QUIT": true, "NICK": true, "TOPIC": true, "MODE": true, "INVITE": true,
+}
+
+// Commands from upstream that are handled by the IrcClient, and so are not
+// of interest to downstream clients.
+var upstreamOnly = map[string]bool{
+	"PING": true, "PONG": true, "CAP": true, "ERROR": true,
+}
+
+// Bouncer serves downstream clients from an IrcClient.
+type Bouncer struct {
+	client *client.IrcClient
+	config Config
+
+	mutex       sync.Mutex
+	downstreams map[*downstream]bool
+	buffer      []*parser.IrcMessage
+}
+
+// New creates a Bouncer for the given client, and registers the callbacks it
+// needs to see what the client receives. The client must have callbacks
+// processed as usual (see IrcClient.ProcessCallbacks).
+func New(c *client.IrcClient, config Config) *Bouncer {
+	if config.BufferSize == 0 {
+		config.BufferSize = 500
+	}
+
+	this := &Bouncer{
+		client:      c,
+		config:      config,
+		downstreams: make(map[*downstream]bool),
+	}
+
+	c.RequestCap("server-time")
+	c.AddCallback(client.OnAny, func(c *client.IrcClient, command *parser.IrcMessage) {
+		this.handleUpstream(command)
+	})
+	return this
+}
+
+// ListenAndServe listens on the given TCP address and serves downstream
+// clients connecting to it.
+func (this *Bouncer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return this.Serve(l)
+}
+
+// Serve accepts downstream clients on a listener until it is closed.
+func (this *Bouncer) Serve(l net.Listener) error {
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go this.serveDownstream(conn)
+	}
+}
+
+// Attached returns how many downstream clients are attached.
+func (this *Bouncer) Attached() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return len(this.downstreams)
+}
+
+// Forwards a message received upstream to all attached downstream clients,
+// or buffers it if there are none.
+func (this *Bouncer) handleUpstream(command *parser.IrcMessage) {
+	if upstreamOnly[command.Command] {
+		return
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if len(this.downstreams) == 0 {
+		if !bufferedCommands[command.Command] {
+			return
+		}
+		// make sure the message shows up with the time it was received,
+		// rather than the time it is played back.
+		if _, ok := command.Tag("time"); !ok {
+			command = copyMessage(command)
+			command.Tags = append(command.Tags, parser.IrcTag{Key: "time", Value: time.Now().UTC().Format("2006-01-02T15:04:05.000Z")})
+		}
+		this.buffer = append(this.buffer, command)
+		if len(this.buffer) > this.config.BufferSize {
+			this.buffer = this.buffer[len(this.buffer)-this.config.BufferSize:]
+		}
+		return
+	}
+
+	for d := range this.downstreams {
+		d.send(command)
+	}
+}
+
+// Adds a downstream client, having first queued the state of the upstream
+// connection and the messages buffered for it, so that nothing received
+// meanwhile gets ahead of them.
+func (this *Bouncer) attach(d *downstream) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for _, command := range this.state(d.nick) {
+		d.send(command)
+	}
+	for _, command := range this.buffer {
+		d.send(command)
+	}
+	this.buffer = nil
+	this.downstreams[d] = true
+}
+
+func (this *Bouncer) detach(d *downstream) {
+	this.mutex.Lock()
+	delete(this.downstreams, d)
+	this.mutex.Unlock()
+}
+
+// Sends a message to all downstream clients except one, e.g. to let the others
+// see what one of them said.
+func (this *Bouncer) broadcast(except *downstream, command *parser.IrcMessage) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for d := range this.downstreams {
+		if d != except {
+			d.send(command)
+		}
+	}
+}
+
+func copyMessage(c *parser.IrcMessage) *parser.IrcMessage {
+	n := *c
+	n.Tags = append([]parser.IrcTag(nil), c.Tags...)
+	n.Parameters = append([]string(nil), c.Parameters...)
+	return &n
+}
+
+// A downstream client connection.
+type downstream struct {
+	conn     net.Conn
+	outgoing chan string
+	nick     string
+	closed   chan struct{}
+	flush    chan struct{} // closed to have the writer close once it's done
+	once     sync.Once
+}
+
+// Queues a message to be sent to the client. If the client can't keep up, it
+// is disconnected rather than holding up everyone else.
+func (this *downstream) send(command *parser.IrcMessage) {
+	this.sendLine(command.Serialize())
+}
+
+func (this *downstream) sendLine(line string) {
+	select {
+	case this.outgoing <- line:
+	case <-this.closed:
+	default:
+		this.close()
+	}
+}
+
+func (this *downstream) close() {
+	this.once.Do(func() {
+		close(this.closed)
+		this.conn.Close()
+	})
+}
+
+// Closes the connection once what's queued has been sent, e.g. so that an
+// ERROR gets through, and waits for that.
+func (this *downstream) closeWhenSent() {
+	close(this.flush)
+	<-this.closed
+}
+
+func (this *downstream) write(line string) bool {
+	this.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	if _, err := this.conn.Write([]byte(line + "\r\n")); err != nil {
+		this.close()
+		return false
+	}
+	return true
+}
+
+func (this *downstream) writer() {
+	for {
+		select {
+		case line := <-this.outgoing:
+			if !this.write(line) {
+				return
+			}
+		case <-this.flush:
+			for {
+				select {
+				case line := <-this.outgoing:
+					if !this.write(line) {
+						return
+					}
+				default:
+					this.close()
+					return
+				}
+			}
+		case <-this.closed:
+			return
+		}
+	}
+}
+
+// A numeric (or other message) from the bouncer itself.
+func bouncerMessage(command string, params ...string) *parser.IrcMessage {
+	return &parser.IrcMessage{Prefix: parser.IrcPrefix{Server: serverName}, Command: command, Parameters: params}
+}
+
+// Sends a numeric (or other message) from the bouncer itself.
+func (this *downstream) reply(command string, params ...string) {
+	this.send(bouncerMessage(command, params...))
+}
+
+func (this *Bouncer) serveDownstream(conn net.Conn) {
+	d := &downstream{
+		conn:     conn,
+		outgoing: make(chan string, 1024),
+		closed:   make(chan struct{}),
+		flush:    make(chan struct{}),
+	}
+	defer d.close()
+	go d.writer()
+
+	scanner := bufio.NewScanner(conn)
+	if !this.register(d, scanner) {
+		return
+	}
+
+	this.attach(d)
+	defer this.detach(d)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) == 0 {
+			continue
+		}
+		if !validLine(line) {
+			// else it would be more than one line upstream, and the rest
+			// would get by what's handled here (e.g. JOIN and PART).
+			d.reply("NOTICE", d.nick, "Not forwarding a line with a CR or NUL in it")
+			continue
+		}
+
+		command := parser.ParseClientLine(line)
+		switch command.Command {
+		case "PING":
+			d.reply("PONG", append([]string{serverName}, command.Parameters...)...)
+		case "QUIT":
+			// the client is going away, but we aren't.
+			return
+		case "PASS", "USER", "CAP", "PONG":
+			// not meaningful once registered.
+		case "JOIN", "PART":
+			// through the client, so that it knows which channels to
+			// rejoin when it reconnects.
+			if len(command.Parameters) == 0 {
+				continue
+			}
+			for _, channel := range strings.Split(command.Parameters[0], ",") {
+				if command.Command == "JOIN" {
+					this.client.Join(channel)
+				} else {
+					this.client.Part(channel)
+				}
+			}
+		case "PRIVMSG", "NOTICE":
+			// strip any prefix the client might have sent
+			command.Prefix = parser.IrcPrefix{}
+			this.client.WriteLine(command.String())
+			command.Prefix = parser.IrcPrefix{Nick: this.client.CurrentNick()}
+			this.broadcast(d, command)
+		default:
+			command.Prefix = parser.IrcPrefix{}
+			this.client.WriteLine(command.String())
+		}
+	}
+}
+
+// Handles registration of a downstream client. Returns false if the client
+// went away or failed to authenticate.
+func (this *Bouncer) register(d *downstream, scanner *bufio.Scanner) bool {
+	var pass string
+	var gotUser bool
+
+	d.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	defer d.conn.SetReadDeadline(time.Time{})
+
+	for len(d.nick) == 0 || !gotUser {
+		if !scanner.Scan() {
+			return false
+		}
+
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if !validLine(line) {
+			continue
+		}
+
+		command := parser.ParseClientLine(line)
+		switch command.Command {
+		case "PASS":
+			if len(command.Parameters) > 0 {
+				pass = command.Parameters[0]
+			}
+		case "NICK":
+			if len(command.Parameters) > 0 {
+				d.nick = command.Parameters[0]
+			}
+		case "USER":
+			gotUser = true
+		case "CAP":
+			// we don't offer any capabilities, but clients that ask
+			// will wait for an answer.
+			if len(command.Parameters) > 0 && strings.ToUpper(command.Parameters[0]) == "LS" {
+				d.reply("CAP", "*", "LS", "")
+			}
+		case "PING":
+			d.reply("PONG", append([]string{serverName}, command.Parameters...)...)
+		case "QUIT":
+			return false
+		}
+	}
+
+	if len(this.config.Password) > 0 && subtle.ConstantTimeCompare([]byte(pass), []byte(this.config.Password)) != 1 {
+		d.reply("464", d.nick, "Password incorrect")
+		d.sendLine("ERROR :Closing link (password incorrect)")
+		d.closeWhenSent()
+		return false
+	}
+	return true
+}
+
+// Whether a line from a downstream client is one line, and so safe to pass
+// on: a CR would end it early upstream, and NUL isn't allowed at all.
+func validLine(line string) bool {
+	return !strings.ContainsAny(line, "\r\x00")
+}
+
+// Returns the registration burst, followed by the state of each channel, to
+// bring a client (registered as clientNick) to where the upstream connection
+// is.
+func (this *Bouncer) state(clientNick string) []*parser.IrcMessage {
+	nick := this.client.CurrentNick()
+	burst := this.client.Burst()
+
+	if len(nick) == 0 || len(burst) == 0 {
+		// not (yet) connected upstream. let the client register anyway, so
+		// that it sees the connection once it is established.
+		return []*parser.IrcMessage{
+			bouncerMessage("001", clientNick, "Welcome to gobo. Not connected to the server yet."),
+			bouncerMessage("422", clientNick, "MOTD File is missing"),
+		}
+	}
+
+	var state []*parser.IrcMessage
+
+	for _, command := range burst {
+		command = copyMessage(command)
+		// the messages were addressed to us upstream, so they carry the
+		// upstream nick already. that is also the nick the client should
+		// take on.
+		if len(command.Parameters) > 0 {
+			command.Parameters[0] = nick
+		}
+		state = append(state, command)
+	}
+
+	channels := this.client.Channels()
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].Name < channels[j].Name
+	})
+
+	for _, channel := range channels {
+		state = append(state, &parser.IrcMessage{Prefix: parser.IrcPrefix{Nick: nick}, Command: "JOIN", Parameters: []string{channel.Name}})
+		if len(channel.Topic) > 0 {
+			state = append(state, bouncerMessage("332", nick, channel.Name, channel.Topic))
+		}
+
+		var names []string
+		for _, member := range channel.Members {
+			names = append(names, member.Prefix+member.Nick)
+		}
+		sort.Strings(names)
+
+		// keep each line comfortably within the 512 byte limit.
+		for len(names) > 0 {
+			line := ""
+			for len(names) > 0 && len(line)+len(names[0]) < 400 {
+				line += names[0] + " "
+				names = names[1:]
+			}
+			if len(line) == 0 {
+				line = names[0] + " "
+				names = names[1:]
+			}
+			state = append(state, bouncerMessage("353", nick, "=", channel.Name, strings.TrimSpace(line)))
+		}
+		state = append(state, bouncerMessage("366", nick, channel.Name, "End of /NAMES list."))
+	}
+	return state
+}
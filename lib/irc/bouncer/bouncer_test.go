@@ -0,0 +1,245 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package bouncer
+
+import "github.com/rburchell/gobo/lib/irc/client"
+import "github.com/rburchell/gobo/lib/irc/irctest"
+import "github.com/rburchell/gobo/lib/irc/parser"
+import "bufio"
+import "net"
+import "strings"
+import "testing"
+import "time"
+
+// Checks the next thing the client sent upstream, ignoring what it sends by
+// itself.
+func expect(t *testing.T, s *irctest.Server, line string) {
+	for {
+		c, ok := s.Next(5 * time.Second)
+		if !ok {
+			t.Fatalf("Expected: %#v upstream, got nothing", line)
+		}
+		if c.Command == "PASS" || c.Command == "PONG" || c.Command == "CAP" {
+			continue
+		}
+		if c.String() != line {
+			t.Errorf("Expected: %#v upstream, got %#v", line, c.String())
+		}
+		return
+	}
+}
+
+// A downstream client connected to the bouncer.
+type testDownstream struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+}
+
+func connect(t *testing.T, addr string, lines ...string) *testDownstream {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %s", err)
+	}
+	for _, line := range lines {
+		conn.Write([]byte(line + "\r\n"))
+	}
+	return &testDownstream{conn: conn, scanner: bufio.NewScanner(conn)}
+}
+
+// Reads lines until one matches, returning those seen along the way.
+func (this *testDownstream) readUntil(t *testing.T, line string) []string {
+	var seen []string
+	this.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for this.scanner.Scan() {
+		seen = append(seen, this.scanner.Text())
+		if strings.HasSuffix(this.scanner.Text(), line) {
+			return seen
+		}
+	}
+	t.Fatalf("Expected: %#v, got %#v", line, seen)
+	return nil
+}
+
+func setup(t *testing.T) (*irctest.Server, *client.IrcClient, *Bouncer, string) {
+	s := irctest.NewServer()
+
+	c := client.NewClient("gobo", "gobo", "gobo", "", "")
+	c.Join("#gobo")
+	joined := make(chan bool, 1)
+	c.AddCallback("366", func(c *client.IrcClient, command *parser.IrcMessage) {
+		select {
+		case joined <- true:
+		default:
+		}
+	})
+
+	b := New(c, Config{Password: "secret", BufferSize: 2})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	go b.Serve(l)
+
+	if !s.Connect(c, 5*time.Second) {
+		t.Fatalf("Failed to register upstream")
+	}
+	select {
+	case <-joined:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Failed to join upstream")
+	}
+	return s, c, b, l.Addr().String()
+}
+
+func waitAttached(t *testing.T, b *Bouncer, count int) {
+	for i := 0; i < 500 && b.Attached() != count; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if b.Attached() != count {
+		t.Fatalf("Expected: %d attached, got %d", count, b.Attached())
+	}
+}
+
+func TestBouncer(t *testing.T) {
+	s, _, b, addr := setup(t)
+
+	// buffered while nobody is attached; only the last two are kept.
+	s.Send(":friend!user@host PRIVMSG #gobo :one")
+	s.Send(":friend!user@host PRIVMSG #gobo :two")
+	s.Send(":friend!user@host PRIVMSG #gobo :three")
+	s.Send(":fake.server 999 gobo :not buffered")
+	time.Sleep(100 * time.Millisecond)
+
+	d := connect(t, addr, "PASS secret", "NICK someone", "USER a b c :d")
+	seen := d.readUntil(t, "three")
+
+	expected := []string{
+		":fake.server 001 gobo :Welcome to the fake network",
+		":fake.server 005 gobo PREFIX=(ov)@+ CASEMAPPING=ascii :are supported by this server",
+		":fake.server 376 gobo :End of MOTD",
+		":gobo JOIN #gobo",
+		":gobo.bouncer 332 gobo #gobo :Welcome to #gobo",
+		":gobo.bouncer 353 gobo = #gobo :@friend gobo",
+		":gobo.bouncer 366 gobo #gobo :End of /NAMES list.",
+		":friend!user@host PRIVMSG #gobo two",
+		":friend!user@host PRIVMSG #gobo three",
+	}
+	if len(seen) != len(expected) {
+		t.Fatalf("Expected: %#v, got %#v", expected, seen)
+	}
+	for idx := range expected {
+		// buffered messages carry the time they were received
+		line := seen[idx]
+		if strings.HasPrefix(line, "@time=") {
+			line = line[strings.Index(line, " ")+1:]
+		} else if idx >= 7 {
+			t.Errorf("Expected a time tag on %#v", seen[idx])
+		}
+		if line != expected[idx] {
+			t.Errorf("Expected: %#v, got %#v", expected[idx], line)
+		}
+	}
+
+	// commands are forwarded upstream, and others downstream see what we said
+	d2 := connect(t, addr, "PASS secret", "NICK other", "USER a b c :d")
+	d2.readUntil(t, "End of /NAMES list.")
+	waitAttached(t, b, 2)
+
+	d.conn.Write([]byte("PRIVMSG #gobo :hello\r\n"))
+	expect(t, s, "PRIVMSG #gobo hello")
+	d2.readUntil(t, ":gobo PRIVMSG #gobo hello")
+
+	d.conn.Write([]byte("TOPIC #gobo :new topic\r\n"))
+	expect(t, s, "TOPIC #gobo :new topic")
+
+	// and everything received upstream goes to everyone
+	s.Send(":friend!user@host PRIVMSG #gobo :hi all")
+	d.readUntil(t, "hi all")
+	d2.readUntil(t, "hi all")
+
+	// PING is answered locally, QUIT only detaches
+	d.conn.Write([]byte("PING :are you there\r\n"))
+	d.readUntil(t, ":gobo.bouncer PONG gobo.bouncer :are you there")
+	d.conn.Write([]byte("QUIT :bye\r\n"))
+	d2.conn.Write([]byte("QUIT :bye\r\n"))
+	waitAttached(t, b, 0)
+
+	s.Send(":friend!user@host PRIVMSG #gobo :still buffering")
+	time.Sleep(100 * time.Millisecond)
+	d3 := connect(t, addr, "PASS secret", "NICK gobo", "USER a b c :d")
+	d3.readUntil(t, "still buffering")
+}
+
+func TestPassword(t *testing.T) {
+	_, _, b, addr := setup(t)
+
+	d := connect(t, addr, "PASS wrong", "NICK someone", "USER a b c :d")
+	d.readUntil(t, "Password incorrect")
+	if b.Attached() != 0 {
+		t.Errorf("Expected: nobody attached, got %d", b.Attached())
+	}
+
+	// and hung up on, once told why
+	d.readUntil(t, "ERROR :Closing link (password incorrect)")
+	if d.scanner.Scan() {
+		t.Errorf("Expected the connection to be closed, got %#v", d.scanner.Text())
+	}
+}
+
+func TestEmbeddedLineBreak(t *testing.T) {
+	s, _, _, addr := setup(t)
+
+	d := connect(t, addr, "PASS secret", "NICK someone", "USER a b c :d")
+	d.readUntil(t, "End of /NAMES list.")
+
+	// what follows the CR isn't sent upstream as a command of its own
+	d.conn.Write([]byte("PRIVMSG #gobo :hi\rJOIN #secret\r\n"))
+	d.readUntil(t, "Not forwarding a line with a CR or NUL in it")
+	d.conn.Write([]byte("PRIVMSG #gobo :hello\r\n"))
+	expect(t, s, "PRIVMSG #gobo hello")
+}
+
+func TestJoinPart(t *testing.T) {
+	s, _, _, addr := setup(t)
+
+	d := connect(t, addr, "PASS secret", "NICK someone", "USER a b c :d")
+	d.readUntil(t, "End of /NAMES list.")
+
+	d.conn.Write([]byte("JOIN #new,#other\r\n"))
+	d.readUntil(t, ":gobo!gobo@127.0.0.1 JOIN #new")
+	d.readUntil(t, "#other :End of /NAMES list.")
+	d.conn.Write([]byte("PART #other\r\n"))
+	expect(t, s, "PART #other")
+
+	// the client rejoins what was joined downstream when it reconnects, but
+	// not what was parted.
+	s.DropConnections()
+	seen := d.readUntil(t, ":gobo!gobo@127.0.0.1 JOIN #new")
+	for _, line := range seen {
+		if strings.HasSuffix(line, "JOIN #other") {
+			t.Errorf("Expected: #other not rejoined, got %#v", seen)
+		}
+	}
+}
@@ -27,6 +27,7 @@ package client
 import "github.com/rburchell/gobo/lib/irc/parser"
 import "bufio"
 import "net"
+import "strings"
 import "fmt"
 import "sync"
 import "time"
@@ -35,6 +36,7 @@ type IrcClient struct {
 	conn            net.Conn
 	CommandChannel  chan *parser.IrcMessage
 	callbacks       map[string][]CommandFunc
+	sent_callbacks  []CommandFunc
 	callbacks_mutex sync.Mutex
 	nick            string
 	user            string
@@ -43,6 +45,14 @@ type IrcClient struct {
 	nsPass          string
 	irc_channels    []string
 	connected       bool
+	closed          bool
+	channels_mutex  sync.Mutex // guards irc_channels, connected, closed and setting conn
+	caps            []string
+	caps_pending    int
+	caps_acked      map[string]bool
+	caps_mutex      sync.Mutex
+	state           state
+	lineParser      parser.Parser
 }
 
 // A CommandFunc is a callback function to handle a received command from a
@@ -88,6 +98,16 @@ func (this *IrcClient) AddCallback(command string, callback CommandFunc) {
 	this.callbacks_mutex.Unlock()
 }
 
+// AddSentCallback registers a callback to be run for each line the client
+// sends, as the server would pass it on, i.e. prefixed with our nickname.
+// Callbacks are run from whichever goroutine sent the line, and see every
+// line (including PASS), so they must filter what they are interested in.
+func (this *IrcClient) AddSentCallback(callback CommandFunc) {
+	this.callbacks_mutex.Lock()
+	this.sent_callbacks = append(this.sent_callbacks, callback)
+	this.callbacks_mutex.Unlock()
+}
+
 func (this *IrcClient) Run(host string) {
 	var scanner *bufio.Scanner
 	var reconnDelay int
@@ -103,8 +123,12 @@ func (this *IrcClient) Run(host string) {
 	for {
 		for this.conn == nil {
 			var err error
+			if this.isClosed() {
+				close(this.CommandChannel)
+				return
+			}
 			if this.conn == nil {
-				this.connected = false
+				this.setConnected(false)
 				if reconnDelay > 0 {
 					if reconnDelay > 60 {
 						reconnDelay = 60
@@ -115,9 +139,13 @@ func (this *IrcClient) Run(host string) {
 					<-timer.C
 				}
 
-				this.conn, err = net.DialTimeout("tcp", host, time.Second*5)
+				var conn net.Conn
+				conn, err = net.DialTimeout("tcp", host, time.Second*5)
 
-				if err != nil {
+				if err == nil && !this.setConn(conn) {
+					// closed while connecting
+					conn.Close()
+				} else if err != nil {
 					// if we're having trouble connecting at all, the problem is
 					// likely not a transient one (e.g. catestrophic server
 					// failure, DNS failure, ...) so increase the timeout
@@ -127,6 +155,7 @@ func (this *IrcClient) Run(host string) {
 				} else {
 					// TODO: handle 443:
 					// :weber.freenode.net 433 * qt_gerrit :Nickname is already in use.
+					this.requestCaps()
 					this.WriteLine(fmt.Sprintf("PASS %s:%s", this.nsUser, this.nsPass))
 					this.WriteLine(fmt.Sprintf("NICK %s", this.nick))
 					this.WriteLine(fmt.Sprintf("USER %s * * :%s", this.user, this.realname))
@@ -145,7 +174,7 @@ func (this *IrcClient) Run(host string) {
 			}
 			reconnDelay += 1
 			scanner = nil
-			this.conn = nil
+			this.setConn(nil)
 			continue
 		}
 
@@ -153,7 +182,8 @@ func (this *IrcClient) Run(host string) {
 		bufstring := string(buffer)
 		//TODO: enable logging somehow
 		//println("IN: ", bufstring)
-		command := parser.ParseLine(bufstring)
+		command := this.lineParser.ParseLine(bufstring)
+		this.state.update(command)
 
 		switch command.Command {
 		case "PING":
@@ -162,6 +192,15 @@ func (this *IrcClient) Run(host string) {
 			// something is probably very wrong (e.g. a ban/kill)
 			// wait a while longer to reconnect because of this
 			reconnDelay += 8
+		case "CAP":
+			this.handleCap(command)
+		case "004":
+			// :server 004 nick servername version usermodes chanmodes
+			// knowing the server's name lets us tell it apart from
+			// users with a dot in their nick.
+			if len(command.Parameters) > 1 {
+				this.lineParser.AddServer(command.Parameters[1])
+			}
 		case OnConnected:
 			// only reset delay on a full, successful connection. if we're
 			// banned, we'll successfully establish a socket connection, but
@@ -169,7 +208,7 @@ func (this *IrcClient) Run(host string) {
 			reconnDelay = 0
 			this.handleConnected()
 		case OnKick:
-			for _, channel := range this.irc_channels {
+			for _, channel := range this.joinedChannels() {
 				if channel == command.Parameters[0] {
 					if command.Parameters[1] == this.nick {
 						this.WriteLine(fmt.Sprintf("JOIN %s", channel))
@@ -183,8 +222,152 @@ func (this *IrcClient) Run(host string) {
 	}
 }
 
+// Nick returns the nickname the client registers with.
+func (this *IrcClient) Nick() string {
+	return this.nick
+}
+
+// RequestCap asks for an IRCv3 capability (e.g. server-time) to be enabled
+// when connecting. It must be called before Run.
+func (this *IrcClient) RequestCap(capability string) {
+	this.caps_mutex.Lock()
+	this.caps = append(this.caps, capability)
+	this.caps_mutex.Unlock()
+}
+
+// HasCap returns true if the server enabled a capability requested by
+// RequestCap.
+func (this *IrcClient) HasCap(capability string) bool {
+	this.caps_mutex.Lock()
+	defer this.caps_mutex.Unlock()
+	return this.caps_acked[capability]
+}
+
+// Each capability is requested separately, so that a server which doesn't
+// know about one of them doesn't reject them all.
+func (this *IrcClient) requestCaps() {
+	this.caps_mutex.Lock()
+	this.caps_acked = make(map[string]bool)
+	this.caps_pending = len(this.caps)
+	caps := this.caps
+	this.caps_mutex.Unlock()
+
+	for _, capability := range caps {
+		this.WriteLine("CAP REQ :" + capability)
+	}
+}
+
+// :server CAP * ACK :server-time
+func (this *IrcClient) handleCap(command *parser.IrcMessage) {
+	if len(command.Parameters) < 3 {
+		return
+	}
+
+	subcommand := command.Parameters[1]
+	if subcommand != "ACK" && subcommand != "NAK" {
+		return
+	}
+
+	this.caps_mutex.Lock()
+	if subcommand == "ACK" {
+		for _, capability := range strings.Fields(command.Parameters[2]) {
+			this.caps_acked[capability] = true
+		}
+	}
+	this.caps_pending--
+	done := this.caps_pending == 0
+	this.caps_mutex.Unlock()
+
+	if done {
+		this.WriteLine("CAP END")
+	}
+}
+
+// Join adds a channel to those the client is in. The channel is joined right
+// away if the client is connected, and (re)joined whenever it connects.
 func (this *IrcClient) Join(channel string) {
-	this.irc_channels = append(this.irc_channels, channel)
+	this.channels_mutex.Lock()
+	known := false
+	for _, existing := range this.irc_channels {
+		if strings.EqualFold(existing, channel) {
+			known = true
+			break
+		}
+	}
+	if !known {
+		this.irc_channels = append(this.irc_channels, channel)
+	}
+	connected := this.connected
+	this.channels_mutex.Unlock()
+	if connected {
+		this.WriteLine(fmt.Sprintf("JOIN %s", channel))
+	}
+}
+
+// Part removes a channel from those the client is in, leaving it if the
+// client is connected.
+func (this *IrcClient) Part(channel string) {
+	this.channels_mutex.Lock()
+	for idx, existing := range this.irc_channels {
+		if strings.EqualFold(existing, channel) {
+			this.irc_channels = append(this.irc_channels[:idx], this.irc_channels[idx+1:]...)
+			break
+		}
+	}
+	connected := this.connected
+	this.channels_mutex.Unlock()
+	if connected {
+		this.WriteLine(fmt.Sprintf("PART %s", channel))
+	}
+}
+
+func (this *IrcClient) setConnected(connected bool) {
+	this.channels_mutex.Lock()
+	this.connected = connected
+	this.channels_mutex.Unlock()
+}
+
+// Close disconnects from the server for good: rather than reconnect, Run
+// returns, closing CommandChannel.
+func (this *IrcClient) Close() {
+	this.channels_mutex.Lock()
+	defer this.channels_mutex.Unlock()
+	this.closed = true
+	if this.conn != nil {
+		this.conn.Close()
+	}
+}
+
+func (this *IrcClient) isClosed() bool {
+	this.channels_mutex.Lock()
+	defer this.channels_mutex.Unlock()
+	return this.closed
+}
+
+// Sets the connection, unless the client was closed, returning whether it
+// was set.
+func (this *IrcClient) setConn(conn net.Conn) bool {
+	this.channels_mutex.Lock()
+	defer this.channels_mutex.Unlock()
+	if this.closed && conn != nil {
+		return false
+	}
+	this.conn = conn
+	return true
+}
+
+// Registered returns true if the client is connected, and the server has
+// accepted its registration.
+func (this *IrcClient) Registered() bool {
+	this.channels_mutex.Lock()
+	defer this.channels_mutex.Unlock()
+	return this.connected
+}
+
+func (this *IrcClient) joinedChannels() []string {
+	this.channels_mutex.Lock()
+	defer this.channels_mutex.Unlock()
+	return append([]string(nil), this.irc_channels...)
 }
 
 var OnConnected string = "001"
@@ -194,19 +377,49 @@ var OnNotice string = "NOTICE"
 var OnJoin string = "JOIN"
 var OnPart string = "PART"
 
+// OnAny is a callback key matching every command. Callbacks registered for it
+// are run after those registered for the specific command.
+var OnAny string = "*"
+
 func (this *IrcClient) ProcessCallbacks(c *parser.IrcMessage) {
 	this.callbacks_mutex.Lock()
 	callbacks := this.callbacks[c.Command]
+	if any := this.callbacks[OnAny]; len(any) > 0 {
+		callbacks = append(callbacks[:len(callbacks):len(callbacks)], any...)
+	}
+	if _, ok := ctcpPayload(c); ok {
+		if c.Command == OnMessage {
+			callbacks = append(callbacks[:len(callbacks):len(callbacks)], this.callbacks[OnCTCP]...)
+		} else {
+			callbacks = append(callbacks[:len(callbacks):len(callbacks)], this.callbacks[OnCTCPReply]...)
+		}
+	}
 	this.callbacks_mutex.Unlock()
 	for _, callback := range callbacks {
 		callback(this, c)
 	}
 }
 
+// LocalAddr returns the local address of the connection to the IRC server,
+// or nil if there is no connection.
+func (this *IrcClient) LocalAddr() net.Addr {
+	conn := this.conn
+	if conn == nil {
+		return nil
+	}
+	return conn.LocalAddr()
+}
+
 func (this *IrcClient) WriteMessage(target string, message string) {
 	this.WriteLine("PRIVMSG " + target + " :" + message)
 }
 
+// WriteNotice sends a NOTICE, which (unlike a PRIVMSG) is never answered
+// automatically, to the given target.
+func (this *IrcClient) WriteNotice(target string, message string) {
+	this.WriteLine("NOTICE " + target + " :" + message)
+}
+
 func (this *IrcClient) WriteLine(bytes string) {
 	if this.conn == nil {
 		return
@@ -214,17 +427,36 @@ func (this *IrcClient) WriteLine(bytes string) {
 
 	//TODO: enable logging somehow
 	//println("OUT: ", bytes)
-	_, err := this.conn.Write([]byte(bytes))
-	_, err2 := this.conn.Write([]byte("\r\n"))
 
-	if err != nil || err2 != nil {
+	// write the line in one go, so that lines written from several goroutines
+	// (e.g. DCC negotiation) don't interleave.
+	_, err := this.conn.Write([]byte(bytes + "\r\n"))
+
+	if err != nil {
 		this.conn.Close()
+		return
+	}
+
+	this.callbacks_mutex.Lock()
+	callbacks := this.sent_callbacks
+	this.callbacks_mutex.Unlock()
+	if len(callbacks) == 0 {
+		return
+	}
+
+	c := parser.ParseClientLine(bytes)
+	c.Prefix = parser.IrcPrefix{Nick: this.CurrentNick()}
+	if len(c.Prefix.Nick) == 0 {
+		c.Prefix.Nick = this.nick
+	}
+	for _, callback := range callbacks {
+		callback(this, c)
 	}
 }
 
 func (this *IrcClient) handleConnected() {
-	this.connected = true
-	for _, channel := range this.irc_channels {
+	this.setConnected(true)
+	for _, channel := range this.joinedChannels() {
 		this.WriteLine(fmt.Sprintf("JOIN %s", channel))
 	}
 }
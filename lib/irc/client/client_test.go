@@ -25,13 +25,18 @@
 package client
 
 // import "net"
+import "github.com/rburchell/gobo/lib/irc/parser"
+import "io/ioutil"
+import "net"
+import "reflect"
 import "testing"
+import "time"
 
 func TestConstruct(t *testing.T) {
 	nick := "testnick"
 	user := "testuser"
 	realname := "test real name"
-	c := NewClient(nick, user, realname)
+	c := NewClient(nick, user, realname, "", "")
 
 	if c.nick != nick {
 		t.Errorf("Expected: %#v, got %#v", nick, c.nick)
@@ -45,3 +50,179 @@ func TestConstruct(t *testing.T) {
 		t.Errorf("Expected: %#v, got %#v", realname, c.realname)
 	}
 }
+
+func TestClose(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	c := NewClient("gobo", "gobo", "gobo", "", "")
+	go c.Run(l.Addr().String())
+	select {
+	case conn := <-accepted:
+		defer conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Failed to connect")
+	}
+
+	// Run returns, rather than reconnecting
+	c.Close()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-c.CommandChannel:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Expected CommandChannel to be closed")
+		}
+	}
+}
+
+func TestSentCallback(t *testing.T) {
+	c := NewClient("gobo", "gobo", "gobo", "", "")
+	server, conn := net.Pipe()
+	defer server.Close()
+	go ioutil.ReadAll(server)
+	c.conn = conn
+
+	var sent []string
+	c.AddSentCallback(func(c *IrcClient, command *parser.IrcMessage) {
+		sent = append(sent, command.String())
+	})
+	c.WriteMessage("#gobo", "hello there")
+	c.WriteNotice("alice", "psst")
+
+	expected := []string{":gobo PRIVMSG #gobo :hello there", ":gobo NOTICE alice psst"}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %#v, got %#v", expected, sent)
+	}
+}
+
+func TestParseCTCP(t *testing.T) {
+	tests := []struct {
+		Input   string
+		Command string
+		Args    string
+		Ok      bool
+	}{
+		{"\x01VERSION\x01", "VERSION", "", true},
+		{"\x01ping 1234\x01", "PING", "1234", true},
+		{"\x01DCC SEND file.txt 2130706433 1024 42\x01", "DCC", "SEND file.txt 2130706433 1024 42", true},
+		{"\x01ACTION waves", "ACTION", "waves", true},
+		{"hello world", "", "", false},
+		{"\x01\x01", "", "", false},
+	}
+
+	for _, test := range tests {
+		command, args, ok := ParseCTCP(test.Input)
+		if command != test.Command || args != test.Args || ok != test.Ok {
+			t.Errorf("Expected: %#v %#v %v, got %#v %#v %v", test.Command, test.Args, test.Ok, command, args, ok)
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	c := NewClient("gobo", "gobo", "gobo", "", "")
+	for _, line := range []string{
+		":server 001 gobo_ :Welcome",
+		":server 005 gobo_ PREFIX=(qov)~@+ CHANMODES=b,k,l,imnt :are supported by this server",
+		":server 376 gobo_ :End of MOTD",
+		":gobo_!gobo@host JOIN #gobo",
+		":server 332 gobo_ #gobo :the topic",
+		":server 353 gobo_ = #gobo :gobo_ ~founder @op +voice other",
+		":other!user@host JOIN #gobo",
+		":op!user@host MODE #gobo +o-v+bk voice voice *!*@bad key",
+		":op!user@host MODE #gobo -o+v op op",
+		":other!user@host NICK renamed",
+		":founder!user@host QUIT :bye",
+		":op!user@host TOPIC #gobo :new topic",
+		":gobo_!gobo@host JOIN #other",
+		":op!user@host KICK #other gobo_ :out",
+	} {
+		c.state.update(parser.ParseLine(line))
+	}
+
+	if nick := c.CurrentNick(); nick != "gobo_" {
+		t.Errorf("Expected: %#v, got %#v", "gobo_", nick)
+	}
+	if burst := c.Burst(); len(burst) != 3 {
+		t.Errorf("Expected: 3 burst messages, got %d", len(burst))
+	}
+	if prefix, _ := c.ISupport("PREFIX"); prefix != "(qov)~@+" {
+		t.Errorf("Expected: %#v, got %#v", "(qov)~@+", prefix)
+	}
+	if _, ok := c.Channel("#other"); ok {
+		t.Errorf("Expected to no longer be in #other")
+	}
+
+	channel, ok := c.Channel("#GOBO")
+	if !ok {
+		t.Fatalf("Expected to be in #gobo")
+	}
+	if channel.Topic != "new topic" {
+		t.Errorf("Expected: %#v, got %#v", "new topic", channel.Topic)
+	}
+
+	expected := map[string]Member{
+		"gobo_":   {Nick: "gobo_"},
+		"op":      {Nick: "op", Prefix: "+"},
+		"voice":   {Nick: "voice", Prefix: "@"},
+		"renamed": {Nick: "renamed"},
+	}
+	if !reflect.DeepEqual(channel.Members, expected) {
+		t.Errorf("Expected: %#v, got %#v", expected, channel.Members)
+	}
+}
+
+func TestAccountNick(t *testing.T) {
+	c := NewClient("gobo", "gobo", "gobo", "", "")
+	for _, line := range []string{
+		":server 001 gobo :Welcome",
+		":gobo!gobo@host JOIN #gobo * :gobo",
+		":server 353 gobo = #gobo :gobo tagged notify",
+		":alice!user@host JOIN #gobo alice :Alice",
+		":anon!user@host JOIN #gobo * :Anonymous",
+		"@account=bob :tagged!user@host PRIVMSG #gobo :hi",
+		":notify!user@host ACCOUNT carol",
+		":alice!user@host NICK alice_",
+		":anon!user@host ACCOUNT dave",
+		":anon!user@host ACCOUNT *",
+	} {
+		c.state.update(parser.ParseLine(line))
+	}
+
+	for account, expected := range map[string]string{
+		"alice": "alice_",
+		"BOB":   "tagged",
+		"carol": "notify",
+		"dave":  "",
+		"":      "",
+	} {
+		nick, ok := c.AccountNick(account)
+		if nick != expected || ok != (expected != "") {
+			t.Errorf("Expected: %#v, got %#v (for %s)", expected, nick, account)
+		}
+	}
+
+	// names replies don't forget what we know
+	c.state.update(parser.ParseLine(":server 353 gobo = #gobo :gobo @tagged notify"))
+	if nick, _ := c.AccountNick("bob"); nick != "tagged" {
+		t.Errorf("Expected: %#v, got %#v", "tagged", nick)
+	}
+
+	c.state.update(parser.ParseLine(":tagged!user@host QUIT :bye"))
+	if nick, ok := c.AccountNick("bob"); ok {
+		t.Errorf("Expected: %#v, got %#v", "", nick)
+	}
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package client
+
+import "github.com/rburchell/gobo/lib/irc/parser"
+import "strings"
+
+// CTCP (Client-To-Client Protocol) messages are PRIVMSG or NOTICE messages
+// whose text is wrapped in a pair of \x01 delimiters. A PRIVMSG carries a
+// request, a NOTICE carries the reply.
+const ctcpDelim = "\x01"
+
+// OnCTCP is the callback key used for CTCP requests. Callbacks registered
+// for it are run (in addition to any OnMessage callbacks) for every PRIVMSG
+// that contains a CTCP request. Use ParseCTCP to extract the request.
+var OnCTCP string = "CTCP"
+
+// OnCTCPReply is the callback key used for CTCP replies (i.e. NOTICEs
+// containing a CTCP payload).
+var OnCTCPReply string = "CTCPREPLY"
+
+// ParseCTCP extracts a CTCP command and its arguments from the text of a
+// PRIVMSG or NOTICE. The command is uppercased. If the text is not a CTCP
+// message, ok is false.
+func ParseCTCP(text string) (command string, args string, ok bool) {
+	if len(text) < 2 || !strings.HasPrefix(text, ctcpDelim) {
+		return "", "", false
+	}
+
+	text = text[1:]
+	// the trailing delimiter is technically mandatory, but some clients
+	// forget it.
+	text = strings.TrimSuffix(text, ctcpDelim)
+	command, args = splitArg(text)
+	if len(command) == 0 {
+		return "", "", false
+	}
+	return strings.ToUpper(command), args, true
+}
+
+// ctcpPayload returns the CTCP payload of a PRIVMSG or NOTICE, if any.
+func ctcpPayload(c *parser.IrcMessage) (string, bool) {
+	if c.Command != OnMessage && c.Command != OnNotice {
+		return "", false
+	}
+	if len(c.Parameters) < 2 || !strings.HasPrefix(c.Parameters[1], ctcpDelim) {
+		return "", false
+	}
+	return c.Parameters[1], true
+}
+
+func splitArg(line string) (string, string) {
+	parts := strings.SplitN(line, " ", 2)
+	if len(parts) > 1 {
+		return parts[0], parts[1]
+	}
+	return parts[0], ""
+}
+
+func formatCTCP(command, args string) string {
+	if len(args) > 0 {
+		return ctcpDelim + command + " " + args + ctcpDelim
+	}
+	return ctcpDelim + command + ctcpDelim
+}
+
+// WriteCTCP sends a CTCP request to the given target.
+func (this *IrcClient) WriteCTCP(target, command, args string) {
+	this.WriteMessage(target, formatCTCP(command, args))
+}
+
+// WriteCTCPReply sends a CTCP reply to the given target.
+func (this *IrcClient) WriteCTCPReply(target, command, args string) {
+	this.WriteNotice(target, formatCTCP(command, args))
+}
@@ -0,0 +1,395 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package client
+
+import "github.com/rburchell/gobo/lib/irc/parser"
+import "strings"
+import "sync"
+
+// Member is a user in a channel.
+type Member struct {
+	Nick string
+
+	// The status prefixes the user has in the channel, e.g. "@" for an
+	// operator, or "@+" if they are also voiced (and the server told us so).
+	Prefix string
+
+	// The account the user is logged in to, as told by extended-join,
+	// account-notify or the account-tag capability. Empty if they aren't
+	// logged in, or we don't know.
+	Account string
+}
+
+// Channel is the state of a channel the client is in.
+type Channel struct {
+	Name  string
+	Topic string
+
+	// Keyed by lowercased nickname.
+	Members map[string]Member
+}
+
+// The state of the connection, as tracked from what the server sends us.
+type state struct {
+	mutex sync.Mutex
+
+	nick     string
+	burst    []*parser.IrcMessage
+	isupport map[string]string
+	channels map[string]*Channel
+}
+
+// Numerics sent as part of the registration burst, which are worth keeping to
+// describe the connection (e.g. to replay them to a bouncer client).
+var burstNumerics = map[string]bool{
+	"001": true, "002": true, "003": true, "004": true, "005": true,
+	"251": true, "252": true, "253": true, "254": true, "255": true,
+	"265": true, "266": true,
+	"375": true, "372": true, "376": true, "422": true,
+}
+
+// CurrentNick returns the nickname the server knows us by, which may differ
+// from the one we asked for. Empty if we aren't registered.
+func (this *IrcClient) CurrentNick() string {
+	this.state.mutex.Lock()
+	defer this.state.mutex.Unlock()
+	return this.state.nick
+}
+
+// Burst returns the messages the server sent when we registered (the welcome
+// numerics, ISUPPORT, LUSERS and MOTD).
+func (this *IrcClient) Burst() []*parser.IrcMessage {
+	this.state.mutex.Lock()
+	defer this.state.mutex.Unlock()
+	return append([]*parser.IrcMessage(nil), this.state.burst...)
+}
+
+// ISupport returns the value of an ISUPPORT (005) token sent by the server,
+// e.g. "CHANTYPES", and whether it was sent at all.
+func (this *IrcClient) ISupport(key string) (string, bool) {
+	this.state.mutex.Lock()
+	defer this.state.mutex.Unlock()
+	value, ok := this.state.isupport[key]
+	return value, ok
+}
+
+// CaseMapping returns the case mapping the server uses to compare nicknames
+// and channel names.
+func (this *IrcClient) CaseMapping() parser.CaseMapping {
+	value, _ := this.ISupport("CASEMAPPING")
+	return parser.ParseCaseMapping(value)
+}
+
+// Channels returns a copy of the state of all the channels the client is in.
+func (this *IrcClient) Channels() []Channel {
+	this.state.mutex.Lock()
+	defer this.state.mutex.Unlock()
+
+	var channels []Channel
+	for _, channel := range this.state.channels {
+		channels = append(channels, copyChannel(channel))
+	}
+	return channels
+}
+
+// Channel returns a copy of the state of a channel, and whether the client is
+// in it.
+func (this *IrcClient) Channel(name string) (Channel, bool) {
+	this.state.mutex.Lock()
+	defer this.state.mutex.Unlock()
+
+	channel, ok := this.state.channels[strings.ToLower(name)]
+	if !ok {
+		return Channel{}, false
+	}
+	return copyChannel(channel), true
+}
+
+// AccountNick returns the nickname of a user logged in to an account, and
+// whether one is in any of the channels the client is in. This relies on the
+// account-notify and extended-join (or account-tag) capabilities.
+func (this *IrcClient) AccountNick(account string) (string, bool) {
+	this.state.mutex.Lock()
+	defer this.state.mutex.Unlock()
+
+	if len(account) == 0 {
+		return "", false
+	}
+	for _, channel := range this.state.channels {
+		for _, member := range channel.Members {
+			if strings.EqualFold(member.Account, account) {
+				return member.Nick, true
+			}
+		}
+	}
+	return "", false
+}
+
+func copyChannel(channel *Channel) Channel {
+	c := *channel
+	c.Members = make(map[string]Member, len(channel.Members))
+	for k, v := range channel.Members {
+		c.Members[k] = v
+	}
+	return c
+}
+
+// Splits PREFIX=(ov)@+ into the modes, and the matching prefixes.
+func (this *state) prefixes() (string, string) {
+	value, ok := this.isupport["PREFIX"]
+	if !ok || !strings.HasPrefix(value, "(") {
+		return "ov", "@+"
+	}
+	end := strings.Index(value, ")")
+	if end == -1 || len(value)-end-1 != end-1 {
+		return "ov", "@+"
+	}
+	return value[1:end], value[end+1:]
+}
+
+// Returns the channel modes that take a parameter when being set, and when
+// being unset.
+func (this *state) paramModes() (string, string) {
+	modes, _ := this.prefixes()
+	chanmodes, ok := this.isupport["CHANMODES"]
+	if !ok {
+		chanmodes = "beI,k,l,imnpst"
+	}
+	parts := strings.Split(chanmodes, ",")
+	for len(parts) < 4 {
+		parts = append(parts, "")
+	}
+	return modes + parts[0] + parts[1] + parts[2], modes + parts[0] + parts[1]
+}
+
+func (this *state) isSelf(nick string) bool {
+	return strings.EqualFold(nick, this.nick)
+}
+
+// Updates the state from a message received from the server.
+func (this *state) update(c *parser.IrcMessage) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.channels == nil {
+		this.isupport = make(map[string]string)
+		this.channels = make(map[string]*Channel)
+	}
+
+	param := func(idx int) string {
+		if idx < len(c.Parameters) {
+			return c.Parameters[idx]
+		}
+		return ""
+	}
+
+	if burstNumerics[c.Command] {
+		if c.Command == "001" {
+			this.nick = param(0)
+			this.burst = nil
+			this.isupport = make(map[string]string)
+			this.channels = make(map[string]*Channel)
+		}
+		this.burst = append(this.burst, c)
+	}
+
+	switch c.Command {
+	case "005":
+		// :server 005 nick TOKEN=value TOKEN -TOKEN :are supported by this server
+		if len(c.Parameters) < 3 {
+			return
+		}
+		for _, token := range c.Parameters[1 : len(c.Parameters)-1] {
+			if strings.HasPrefix(token, "-") {
+				delete(this.isupport, token[1:])
+				continue
+			}
+			kv := strings.SplitN(token, "=", 2)
+			if len(kv) == 2 {
+				this.isupport[kv[0]] = kv[1]
+			} else {
+				this.isupport[kv[0]] = ""
+			}
+		}
+	case "NICK":
+		if this.isSelf(c.Prefix.Nick) {
+			this.nick = param(0)
+		}
+		for _, channel := range this.channels {
+			if member, ok := channel.Members[strings.ToLower(c.Prefix.Nick)]; ok {
+				delete(channel.Members, strings.ToLower(c.Prefix.Nick))
+				member.Nick = param(0)
+				channel.Members[strings.ToLower(param(0))] = member
+			}
+		}
+	case "JOIN":
+		name := strings.ToLower(param(0))
+		if this.isSelf(c.Prefix.Nick) {
+			this.channels[name] = &Channel{Name: param(0), Members: make(map[string]Member)}
+		}
+		if channel, ok := this.channels[name]; ok {
+			member := Member{Nick: c.Prefix.Nick}
+			// with extended-join: JOIN #channel account :realname
+			if len(c.Parameters) == 3 && param(1) != "*" {
+				member.Account = param(1)
+			}
+			channel.Members[strings.ToLower(c.Prefix.Nick)] = member
+		}
+	case "PART", "KICK":
+		who := c.Prefix.Nick
+		if c.Command == "KICK" {
+			who = param(1)
+		}
+		name := strings.ToLower(param(0))
+		if this.isSelf(who) {
+			delete(this.channels, name)
+		} else if channel, ok := this.channels[name]; ok {
+			delete(channel.Members, strings.ToLower(who))
+		}
+	case "QUIT":
+		for _, channel := range this.channels {
+			delete(channel.Members, strings.ToLower(c.Prefix.Nick))
+		}
+	case "TOPIC":
+		if channel, ok := this.channels[strings.ToLower(param(0))]; ok {
+			channel.Topic = param(1)
+		}
+	case "332":
+		// :server 332 nick #channel :topic
+		if channel, ok := this.channels[strings.ToLower(param(1))]; ok {
+			channel.Topic = param(2)
+		}
+	case "353":
+		// :server 353 nick = #channel :@op +voice nick
+		channel, ok := this.channels[strings.ToLower(param(2))]
+		if !ok {
+			return
+		}
+		_, symbols := this.prefixes()
+		for _, name := range strings.Fields(param(3)) {
+			nick := strings.TrimLeft(name, symbols)
+			// with userhost-in-names, the entries are full prefixes
+			if bang := strings.Index(nick, "!"); bang != -1 {
+				nick = nick[:bang]
+			}
+			member := channel.Members[strings.ToLower(nick)]
+			member.Nick = nick
+			member.Prefix = name[:len(name)-len(strings.TrimLeft(name, symbols))]
+			channel.Members[strings.ToLower(nick)] = member
+		}
+	case "MODE":
+		channel, ok := this.channels[strings.ToLower(param(0))]
+		if !ok {
+			return
+		}
+		this.updateModes(channel, c.Parameters[1:])
+	case "ACCOUNT":
+		// with account-notify: ACCOUNT account, or ACCOUNT * when logging out
+		account := param(0)
+		if account == "*" {
+			account = ""
+		}
+		this.setAccount(c.Prefix.Nick, account)
+	}
+
+	// with account-tag, anything a user sends says who they are
+	if account, ok := c.Tag("account"); ok && len(c.Prefix.Nick) > 0 {
+		if account == "*" {
+			account = ""
+		}
+		this.setAccount(c.Prefix.Nick, account)
+	}
+}
+
+// Records the account a user is logged in to, in every channel they're in.
+func (this *state) setAccount(nick string, account string) {
+	for _, channel := range this.channels {
+		if member, ok := channel.Members[strings.ToLower(nick)]; ok {
+			member.Account = account
+			channel.Members[strings.ToLower(nick)] = member
+		}
+	}
+}
+
+// Tracks changes to channel membership prefixes, e.g. MODE #channel +o-v a b
+func (this *state) updateModes(channel *Channel, params []string) {
+	if len(params) == 0 {
+		return
+	}
+
+	modes, symbols := this.prefixes()
+	setParams, unsetParams := this.paramModes()
+	args := params[1:]
+	adding := true
+
+	for _, mode := range params[0] {
+		switch {
+		case mode == '+':
+			adding = true
+			continue
+		case mode == '-':
+			adding = false
+			continue
+		}
+
+		takesParam := strings.ContainsRune(unsetParams, mode)
+		if adding {
+			takesParam = strings.ContainsRune(setParams, mode)
+		}
+		if !takesParam {
+			continue
+		}
+		if len(args) == 0 {
+			return
+		}
+		arg := args[0]
+		args = args[1:]
+
+		idx := strings.IndexRune(modes, mode)
+		if idx == -1 {
+			continue
+		}
+		member, ok := channel.Members[strings.ToLower(arg)]
+		if !ok {
+			continue
+		}
+
+		symbol := symbols[idx : idx+1]
+		prefix := strings.Replace(member.Prefix, symbol, "", -1)
+		if adding {
+			prefix += symbol
+		}
+
+		// keep prefixes in order of rank, highest first
+		sorted := ""
+		for _, s := range symbols {
+			if strings.ContainsRune(prefix, s) {
+				sorted += string(s)
+			}
+		}
+		member.Prefix = sorted
+		channel.Members[strings.ToLower(arg)] = member
+	}
+}
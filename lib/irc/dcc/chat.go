@@ -0,0 +1,146 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package dcc
+
+import "bufio"
+import "errors"
+import "net"
+
+// Chat is an established DCC CHAT connection. Lines are exchanged directly
+// with the peer, without going through the IRC server.
+type Chat struct {
+	// The nickname of the peer.
+	Peer string
+
+	conn    net.Conn
+	scanner *bufio.Scanner
+}
+
+func newChat(peer string, conn net.Conn) *Chat {
+	return &Chat{Peer: peer, conn: conn, scanner: bufio.NewScanner(conn)}
+}
+
+// ReadLine blocks until a line is received from the peer.
+func (this *Chat) ReadLine() (string, error) {
+	if !this.scanner.Scan() {
+		if err := this.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", errors.New("dcc: chat closed")
+	}
+	line := this.scanner.Text()
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+// WriteLine sends a line to the peer.
+func (this *Chat) WriteLine(line string) error {
+	_, err := this.conn.Write([]byte(line + "\n"))
+	return err
+}
+
+// Close closes the connection.
+func (this *Chat) Close() error {
+	return this.conn.Close()
+}
+
+// Chat offers a DCC CHAT to the given nickname, and blocks until the peer
+// connects (or the timeout expires).
+//
+// If passive is true, the peer is asked to listen for us instead, which is
+// useful when we are behind NAT.
+func (this *Manager) Chat(nick string, passive bool) (*Chat, error) {
+	if passive {
+		token := this.newToken()
+		key := "chat:" + token
+		p := this.expect(key, nick, "")
+		defer this.forget(key)
+
+		this.sendDcc(nick, "CHAT", "chat", encodeAddr(this.publicHost()), "0", token)
+		r, err := this.wait(p)
+		if err != nil {
+			return nil, err
+		}
+
+		conn, err := this.dial(r.addr)
+		if err != nil {
+			return nil, err
+		}
+		return newChat(nick, conn), nil
+	}
+
+	from, err := this.peerAddrs(nick)
+	if err != nil {
+		return nil, err
+	}
+
+	l, host, port, err := this.listen()
+	if err != nil {
+		return nil, err
+	}
+
+	this.sendDcc(nick, "CHAT", "chat", host, port)
+	conn, err := this.accept(l, from)
+	if err != nil {
+		return nil, err
+	}
+	return newChat(nick, conn), nil
+}
+
+// AcceptChat accepts a DCC CHAT offer, blocking until the connection is
+// established. It must not be called from an OfferFunc directly.
+func (this *Offer) AcceptChat() (*Chat, error) {
+	if this.Type != ChatOffer {
+		return nil, errors.New("dcc: not a chat offer")
+	}
+
+	m := this.manager
+	if !this.Passive() {
+		conn, err := m.dial(this.addr)
+		if err != nil {
+			return nil, err
+		}
+		return newChat(this.From.Nick, conn), nil
+	}
+
+	from, err := this.peerAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	l, host, port, err := m.listen()
+	if err != nil {
+		return nil, err
+	}
+
+	m.sendDcc(this.From.Nick, "CHAT", "chat", host, port, this.token)
+	conn, err := m.accept(l, from)
+	if err != nil {
+		return nil, err
+	}
+	return newChat(this.From.Nick, conn), nil
+}
@@ -0,0 +1,595 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Package dcc implements DCC CHAT and DCC SEND on top of the CTCP support in
+// the IRC client.
+//
+// Both the active form (the offering side listens, the other side connects)
+// and the passive, or reverse, form (the offering side advertises port 0 and
+// a token, and the other side listens) are supported, as is resuming partial
+// transfers using DCC RESUME and DCC ACCEPT.
+//
+// Whenever we listen for a connection, only one from the peer's address is
+// accepted: that of its host as the IRC server shows it (looked up with
+// USERHOST when we make the offer), or the address it advertised in its
+// offer. Peers whose host doesn't resolve (e.g. because it is cloaked) can't
+// connect to us, but we can still connect to them.
+package dcc
+
+import "github.com/rburchell/gobo/lib/irc/client"
+import "github.com/rburchell/gobo/lib/irc/parser"
+import "context"
+import "errors"
+import "fmt"
+import "net"
+import "strconv"
+import "strings"
+import "sync"
+import "time"
+
+// Config controls the behaviour of a Manager.
+type Config struct {
+	// The address advertised to peers when we listen for a connection. If
+	// empty, the local address of the connection to the IRC server is used.
+	PublicAddress string
+
+	// The local address to listen on when we listen for a connection. If
+	// empty, all interfaces are used. The port is always chosen by the
+	// system.
+	ListenAddress string
+
+	// The largest file (in bytes) we are willing to send or receive. Zero
+	// means no limit.
+	MaxSize int64
+
+	// The peers we accept DCC offers from. Each entry is a nickname or a
+	// hostmask, e.g. *!*@trusted.example.org (see parser.Mask). Offers from
+	// anyone else are ignored. An empty list means no offers are accepted.
+	AllowedPeers []string
+
+	// How long to wait for the other side to connect, or to answer a passive
+	// offer or resume request. Defaults to two minutes.
+	Timeout time.Duration
+
+	// If set, called as transfers make progress.
+	Progress ProgressFunc
+}
+
+// An OfferFunc is a callback to handle an incoming DCC offer. It is run from
+// ProcessCallbacks, so it must not block: accept the offer from a separate
+// goroutine.
+type OfferFunc func(manager *Manager, offer *Offer)
+
+// A ProgressFunc is a callback reporting transfer progress.
+type ProgressFunc func(transfer *Transfer)
+
+// The type of a DCC offer.
+type OfferType int
+
+const (
+	ChatOffer OfferType = iota
+	SendOffer
+)
+
+var ErrTimeout = errors.New("dcc: timed out waiting for peer")
+var ErrTooLarge = errors.New("dcc: file exceeds the maximum size")
+var ErrRejected = errors.New("dcc: peer is not allowed")
+var ErrUnknownPeer = errors.New("dcc: the peer's address is not known")
+
+// Offer is an incoming DCC CHAT or DCC SEND request from a peer.
+type Offer struct {
+	Type OfferType
+
+	// The peer that made the offer.
+	From parser.IrcPrefix
+
+	// For DCC SEND, the name (without any directory components) and size of
+	// the offered file.
+	Filename string
+	Size     int64
+
+	// The address to connect to. Empty for passive offers.
+	addr string
+
+	// The host the peer advertised, even for passive offers, where it is
+	// where we expect the connection from.
+	host string
+
+	// The port of an active offer, as sent by the peer. Used to identify the
+	// offer in DCC RESUME.
+	port string
+
+	// The token of a passive offer.
+	token string
+
+	manager *Manager
+}
+
+// Passive returns true if the peer asked us to listen for the connection,
+// rather than connecting to them.
+func (this *Offer) Passive() bool {
+	return len(this.addr) == 0
+}
+
+// A reply to a request we made, e.g. the answer to a passive offer.
+type reply struct {
+	from parser.IrcPrefix
+	addr string
+	pos  int64
+}
+
+// Something we are waiting on an answer from a peer for.
+type pending struct {
+	nick     string
+	filename string
+	replies  chan reply
+}
+
+// Manager handles DCC negotiation for an IrcClient.
+type Manager struct {
+	client *client.IrcClient
+	config Config
+
+	allowed   *parser.MaskSet
+	mutex     sync.Mutex
+	callbacks []OfferFunc
+	pending   map[string]*pending
+	nextToken int
+
+	// Those waiting on a USERHOST reply, by nickname.
+	userhosts map[string][]chan string
+}
+
+// NewManager creates a Manager for the given client, and registers the
+// callbacks it needs to see DCC requests.
+func NewManager(c *client.IrcClient, config Config) *Manager {
+	if config.Timeout == 0 {
+		config.Timeout = 2 * time.Minute
+	}
+
+	m := &Manager{
+		client:    c,
+		config:    config,
+		allowed:   parser.NewMaskSet(c.CaseMapping(), config.AllowedPeers...),
+		pending:   make(map[string]*pending),
+		userhosts: make(map[string][]chan string),
+	}
+
+	c.AddCallback(client.OnCTCP, func(c *client.IrcClient, command *parser.IrcMessage) {
+		m.handleCTCP(command)
+	})
+	c.AddCallback("302", func(c *client.IrcClient, command *parser.IrcMessage) {
+		m.handleUserhost(command)
+	})
+	c.AddCallback("005", func(c *client.IrcClient, command *parser.IrcMessage) {
+		// the server may compare nicknames differently from what we assumed
+		// before it said so.
+		allowed := parser.NewMaskSet(c.CaseMapping(), config.AllowedPeers...)
+		m.mutex.Lock()
+		m.allowed = allowed
+		m.mutex.Unlock()
+	})
+	return m
+}
+
+// AddOfferCallback registers a callback to be run for each incoming offer
+// from an allowed peer.
+func (this *Manager) AddOfferCallback(callback OfferFunc) {
+	this.mutex.Lock()
+	this.callbacks = append(this.callbacks, callback)
+	this.mutex.Unlock()
+}
+
+// IsAllowed returns true if offers from the given peer are accepted.
+func (this *Manager) IsAllowed(peer parser.IrcPrefix) bool {
+	this.mutex.Lock()
+	allowed := this.allowed
+	this.mutex.Unlock()
+	_, ok := allowed.Match(peer, "")
+	return ok
+}
+
+func (this *Manager) handleCTCP(command *parser.IrcMessage) {
+	ctcp, args, ok := client.ParseCTCP(command.Parameters[1])
+	if !ok || ctcp != "DCC" {
+		return
+	}
+
+	fields := splitDccArgs(args)
+	if len(fields) < 4 {
+		return
+	}
+
+	from := command.Prefix
+	switch strings.ToUpper(fields[0]) {
+	case "CHAT":
+		// DCC CHAT chat <ip> <port> [token]
+		addr, port, ok := decodeAddr(fields[2], fields[3])
+		if !ok {
+			return
+		}
+		token := field(fields, 4)
+		if len(token) > 0 && port != "0" && this.answer("chat:"+token, from, reply{from: from, addr: addr}) {
+			return
+		}
+		this.offer(&Offer{Type: ChatOffer, From: from, addr: addr, host: decodeHost(fields[2]), port: port, token: token})
+	case "SEND":
+		// DCC SEND <filename> <ip> <port> [<size> [token]]
+		addr, port, ok := decodeAddr(fields[2], fields[3])
+		if !ok {
+			return
+		}
+		size, _ := strconv.ParseInt(field(fields, 4), 10, 64)
+		token := field(fields, 5)
+		if len(token) > 0 && port != "0" && this.answer("send:"+token, from, reply{from: from, addr: addr}) {
+			return
+		}
+		this.offer(&Offer{Type: SendOffer, From: from, Filename: sanitizeFilename(fields[1]), Size: size, addr: addr, host: decodeHost(fields[2]), port: port, token: token})
+	case "RESUME", "ACCEPT":
+		// DCC RESUME <filename> <port> <position> [token]
+		// DCC ACCEPT <filename> <port> <position> [token]
+		pos, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil || pos < 0 {
+			return
+		}
+		key := strings.ToLower(fields[0]) + ":" + fields[2]
+		if token := field(fields, 4); fields[2] == "0" && len(token) > 0 {
+			key = strings.ToLower(fields[0]) + ":" + token
+		}
+		this.answer(key, from, reply{from: from, pos: pos})
+	}
+}
+
+// Delivers a reply to whoever is waiting for it, if the reply came from the
+// peer they are waiting on.
+func (this *Manager) answer(key string, from parser.IrcPrefix, r reply) bool {
+	this.mutex.Lock()
+	p := this.pending[key]
+	if p != nil && !strings.EqualFold(p.nick, from.Nick) {
+		p = nil
+	}
+	this.mutex.Unlock()
+
+	if p == nil {
+		return false
+	}
+
+	select {
+	case p.replies <- r:
+	default:
+		// nobody is listening (anymore), or we already have an answer.
+	}
+	return true
+}
+
+func (this *Manager) offer(offer *Offer) {
+	if !this.IsAllowed(offer.From) {
+		return
+	}
+	if offer.Type == SendOffer && this.config.MaxSize > 0 && offer.Size > this.config.MaxSize {
+		return
+	}
+
+	offer.manager = this
+	this.mutex.Lock()
+	callbacks := this.callbacks
+	this.mutex.Unlock()
+	for _, callback := range callbacks {
+		callback(this, offer)
+	}
+}
+
+// Registers interest in a reply under the given key.
+func (this *Manager) expect(key, nick, filename string) *pending {
+	p := &pending{nick: nick, filename: filename, replies: make(chan reply, 1)}
+	this.mutex.Lock()
+	this.pending[key] = p
+	this.mutex.Unlock()
+	return p
+}
+
+func (this *Manager) forget(key string) {
+	this.mutex.Lock()
+	delete(this.pending, key)
+	this.mutex.Unlock()
+}
+
+// Waits for a reply to arrive for something we expect.
+func (this *Manager) wait(p *pending) (reply, error) {
+	timer := time.NewTimer(this.config.Timeout)
+	defer timer.Stop()
+	select {
+	case r := <-p.replies:
+		return r, nil
+	case <-timer.C:
+		return reply{}, ErrTimeout
+	}
+}
+
+func (this *Manager) newToken() string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.nextToken++
+	return strconv.Itoa(this.nextToken)
+}
+
+// The host we tell peers to connect to.
+func (this *Manager) publicHost() string {
+	if len(this.config.PublicAddress) > 0 {
+		return this.config.PublicAddress
+	}
+	if addr, ok := this.client.LocalAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	return ""
+}
+
+// Opens a listener for an incoming connection, returning it along with the
+// address and port to advertise to the peer.
+func (this *Manager) listen() (net.Listener, string, string, error) {
+	host := this.publicHost()
+	if len(host) == 0 {
+		return nil, "", "", errors.New("dcc: no address to advertise")
+	}
+
+	l, err := net.Listen("tcp", net.JoinHostPort(this.config.ListenAddress, "0"))
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	return l, encodeAddr(host), port, nil
+}
+
+// Accepts a single connection from one of the given addresses on the
+// listener, giving up after the timeout. Connections from anywhere else are
+// closed.
+func (this *Manager) accept(l net.Listener, from []net.IP) (net.Conn, error) {
+	defer l.Close()
+	if tl, ok := l.(*net.TCPListener); ok {
+		tl.SetDeadline(time.Now().Add(this.config.Timeout))
+	}
+	for {
+		conn, err := l.Accept()
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil, ErrTimeout
+		} else if err != nil {
+			return nil, err
+		}
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+			for _, ip := range from {
+				if ip.Equal(addr.IP) {
+					return conn, nil
+				}
+			}
+		}
+		conn.Close()
+	}
+}
+
+// Asks the server for the host of the given nickname.
+func (this *Manager) lookupHost(nick string) (string, error) {
+	key := this.client.CaseMapping().Fold(nick)
+	reply := make(chan string, 1)
+	this.mutex.Lock()
+	this.userhosts[key] = append(this.userhosts[key], reply)
+	this.mutex.Unlock()
+	defer func() {
+		this.mutex.Lock()
+		waiting := this.userhosts[key]
+		for idx, other := range waiting {
+			if other == reply {
+				waiting = append(waiting[:idx], waiting[idx+1:]...)
+				break
+			}
+		}
+		if len(waiting) == 0 {
+			delete(this.userhosts, key)
+		} else {
+			this.userhosts[key] = waiting
+		}
+		this.mutex.Unlock()
+	}()
+
+	this.client.WriteLine("USERHOST " + nick)
+	timer := time.NewTimer(this.config.Timeout)
+	defer timer.Stop()
+	select {
+	case host := <-reply:
+		return host, nil
+	case <-timer.C:
+		return "", ErrTimeout
+	}
+}
+
+func (this *Manager) handleUserhost(command *parser.IrcMessage) {
+	// :server 302 me :nick=+user@host nick*=-user@host
+	if len(command.Parameters) < 2 {
+		return
+	}
+	cm := this.client.CaseMapping()
+	for _, entry := range strings.Fields(command.Parameters[len(command.Parameters)-1]) {
+		eq := strings.Index(entry, "=")
+		at := strings.LastIndex(entry, "@")
+		if eq < 0 || at < eq {
+			continue
+		}
+		key := cm.Fold(strings.TrimSuffix(entry[:eq], "*"))
+		this.mutex.Lock()
+		for _, reply := range this.userhosts[key] {
+			select {
+			case reply <- entry[at+1:]:
+			default:
+			}
+		}
+		this.mutex.Unlock()
+	}
+}
+
+// Returns the addresses the given hosts resolve to. Empty hosts are skipped.
+func (this *Manager) resolve(hosts ...string) []net.IP {
+	var ips []net.IP
+	for _, host := range hosts {
+		if len(host) == 0 {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			ips = append(ips, ip)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), this.config.Timeout)
+		addrs, _ := net.DefaultResolver.LookupIPAddr(ctx, host)
+		cancel()
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	return ips
+}
+
+// Returns the addresses the peer we are making an offer to may connect from.
+func (this *Manager) peerAddrs(nick string) ([]net.IP, error) {
+	host, err := this.lookupHost(nick)
+	if err != nil {
+		return nil, err
+	}
+	ips := this.resolve(host)
+	if len(ips) == 0 {
+		return nil, ErrUnknownPeer
+	}
+	return ips, nil
+}
+
+// Returns the addresses the peer that made an offer may connect from.
+func (this *Offer) peerAddrs() ([]net.IP, error) {
+	ips := this.manager.resolve(this.From.Host, this.host)
+	if len(ips) == 0 {
+		return nil, ErrUnknownPeer
+	}
+	return ips, nil
+}
+
+func (this *Manager) dial(addr string) (net.Conn, error) {
+	return net.DialTimeout("tcp", addr, this.config.Timeout)
+}
+
+func (this *Manager) sendDcc(nick string, args ...string) {
+	this.client.WriteCTCP(nick, "DCC", strings.Join(args, " "))
+}
+
+func field(fields []string, idx int) string {
+	if idx < len(fields) {
+		return fields[idx]
+	}
+	return ""
+}
+
+// Splits the arguments to a DCC request. Filenames containing spaces are
+// quoted by most clients, so a quoted argument is kept as a single field.
+func splitDccArgs(args string) []string {
+	var fields []string
+	for len(args) > 0 {
+		args = strings.TrimLeft(args, " ")
+		if strings.HasPrefix(args, "\"") {
+			end := strings.Index(args[1:], "\"")
+			if end != -1 {
+				fields = append(fields, args[1:end+1])
+				args = args[end+2:]
+				continue
+			}
+		}
+		idx := strings.Index(args, " ")
+		if idx == -1 {
+			if len(args) > 0 {
+				fields = append(fields, args)
+			}
+			break
+		}
+		fields = append(fields, args[:idx])
+		args = args[idx+1:]
+	}
+	return fields
+}
+
+func quoteFilename(name string) string {
+	if strings.Contains(name, " ") {
+		return "\"" + name + "\""
+	}
+	return name
+}
+
+// Strips any directory components from a filename offered by a peer, so that
+// it can't be used to write outside of where the caller intends.
+func sanitizeFilename(name string) string {
+	name = name[strings.LastIndexAny(name, "/\\")+1:]
+	if name == "" || name == "." || name == ".." {
+		return "file"
+	}
+	return name
+}
+
+// DCC addresses are sent as an unsigned 32 bit integer for IPv4, and as a
+// plain string for IPv6.
+func encodeAddr(host string) string {
+	if len(host) == 0 {
+		return "0"
+	}
+	ip := net.ParseIP(host)
+	if ip4 := ip.To4(); ip4 != nil {
+		return strconv.FormatUint(uint64(ip4[0])<<24|uint64(ip4[1])<<16|uint64(ip4[2])<<8|uint64(ip4[3]), 10)
+	}
+	return host
+}
+
+// Decodes a DCC address and port into something we can dial. If the port is
+// zero (a passive offer), the returned address is empty.
+func decodeAddr(host, port string) (string, string, bool) {
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return "", "", false
+	}
+	if p == 0 {
+		return "", port, true
+	}
+
+	host = decodeHost(host)
+	if len(host) == 0 {
+		return "", "", false
+	}
+	return net.JoinHostPort(host, port), port, true
+}
+
+// Decodes a DCC address into an IP address, or returns an empty string if it
+// isn't one.
+func decodeHost(host string) string {
+	if n, err := strconv.ParseUint(host, 10, 32); err == nil {
+		if n == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%d.%d.%d.%d", byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	} else if net.ParseIP(host) == nil {
+		return ""
+	}
+	return host
+}
@@ -0,0 +1,333 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package dcc
+
+import "github.com/rburchell/gobo/lib/irc/client"
+import "github.com/rburchell/gobo/lib/irc/irctest"
+import "github.com/rburchell/gobo/lib/irc/parser"
+import "bytes"
+import "io/ioutil"
+import "net"
+import "os"
+import "path/filepath"
+import "sync/atomic"
+import "testing"
+import "time"
+
+// Connects a client with the given nickname to the fake server, and waits for
+// it to register.
+func connect(t *testing.T, s *irctest.Server, nick string) *client.IrcClient {
+	c := client.NewClient(nick, nick, nick, "", "")
+	if !s.Connect(c, 5*time.Second) {
+		c.Close()
+		t.Fatalf("%s failed to register", nick)
+	}
+	return c
+}
+
+// Sets up two clients, alice and bob, with a DCC manager each. bob accepts
+// offers from alice.
+func setup(t *testing.T, config Config) (*Manager, *Manager, chan *Offer) {
+	s := irctest.NewServer()
+	config.Timeout = 5 * time.Second
+	alice := NewManager(connect(t, s, "alice"), config)
+	config.AllowedPeers = []string{"alice"}
+	bob := NewManager(connect(t, s, "bob"), config)
+	t.Cleanup(func() {
+		alice.client.Close()
+		bob.client.Close()
+		s.Close()
+	})
+
+	offers := make(chan *Offer, 1)
+	bob.AddOfferCallback(func(m *Manager, offer *Offer) {
+		offers <- offer
+	})
+	return alice, bob, offers
+}
+
+func waitOffer(t *testing.T, offers chan *Offer) *Offer {
+	select {
+	case offer := <-offers:
+		return offer
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No offer received")
+	}
+	return nil
+}
+
+func testChat(t *testing.T, passive bool) {
+	alice, _, offers := setup(t, Config{})
+
+	chats := make(chan *Chat, 1)
+	go func() {
+		chat, err := alice.Chat("bob", passive)
+		if err != nil {
+			t.Errorf("Chat failed: %s", err)
+		}
+		chats <- chat
+	}()
+
+	offer := waitOffer(t, offers)
+	if offer.Type != ChatOffer || offer.From.Nick != "alice" || offer.Passive() != passive {
+		t.Fatalf("Unexpected offer: %#v", offer)
+	}
+
+	bobChat, err := offer.AcceptChat()
+	if err != nil {
+		t.Fatalf("AcceptChat failed: %s", err)
+	}
+	defer bobChat.Close()
+
+	aliceChat := <-chats
+	if aliceChat == nil {
+		return
+	}
+	defer aliceChat.Close()
+
+	aliceChat.WriteLine("hello bob")
+	if line, err := bobChat.ReadLine(); line != "hello bob" || err != nil {
+		t.Errorf("Expected: %#v, got %#v (%v)", "hello bob", line, err)
+	}
+
+	bobChat.WriteLine("hello alice")
+	if line, err := aliceChat.ReadLine(); line != "hello alice" || err != nil {
+		t.Errorf("Expected: %#v, got %#v (%v)", "hello alice", line, err)
+	}
+}
+
+func TestChat(t *testing.T) {
+	testChat(t, false)
+}
+
+func TestChatPassive(t *testing.T) {
+	testChat(t, true)
+}
+
+func TestChatStranger(t *testing.T) {
+	alice, _, offers := setup(t, Config{})
+
+	chats := make(chan *Chat, 1)
+	go func() {
+		chat, err := alice.Chat("bob", false)
+		if err != nil {
+			t.Errorf("Chat failed: %s", err)
+		}
+		chats <- chat
+	}()
+	offer := waitOffer(t, offers)
+
+	// someone other than bob connecting first is turned away
+	d := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.2")}}
+	stranger, err := d.Dial("tcp", offer.addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %s", err)
+	}
+	stranger.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := stranger.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Expected the stranger to be disconnected")
+	}
+	stranger.Close()
+
+	bobChat, err := offer.AcceptChat()
+	if err != nil {
+		t.Fatalf("AcceptChat failed: %s", err)
+	}
+	defer bobChat.Close()
+	if aliceChat := <-chats; aliceChat != nil {
+		aliceChat.Close()
+	}
+}
+
+func testData() []byte {
+	return bytes.Repeat([]byte("0123456789abcdef"), 10000)
+}
+
+func testSend(t *testing.T, data []byte, passive bool, existing int) {
+	var progress int64
+	alice, _, offers := setup(t, Config{
+		Progress: func(transfer *Transfer) {
+			// only track bob's side of things
+			if transfer.Peer == "alice" {
+				atomic.StoreInt64(&progress, transfer.Position())
+			}
+		},
+	})
+
+	sent, err := alice.Send("bob", "build log.txt", bytes.NewReader(data), int64(len(data)), passive)
+	if err != nil {
+		t.Fatalf("Send failed: %s", err)
+	}
+
+	offer := waitOffer(t, offers)
+	if offer.Type != SendOffer || offer.Filename != "build log.txt" || offer.Size != int64(len(data)) || offer.Passive() != passive {
+		t.Fatalf("Unexpected offer: %#v", offer)
+	}
+
+	dir, err := ioutil.TempDir("", "dcc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, offer.Filename)
+	if existing > 0 {
+		ioutil.WriteFile(path, data[:existing], 0644)
+	}
+
+	received, err := offer.ReceiveFile(path)
+	if err != nil {
+		t.Fatalf("ReceiveFile failed: %s", err)
+	}
+
+	if err := received.Wait(); err != nil {
+		t.Errorf("Receiving failed: %s", err)
+	}
+	if err := sent.Wait(); err != nil {
+		t.Errorf("Sending failed: %s", err)
+	}
+
+	if received.Offset != int64(existing) || sent.Offset != int64(existing) {
+		t.Errorf("Expected: offset %d, got %d/%d", existing, sent.Offset, received.Offset)
+	}
+	if p := atomic.LoadInt64(&progress); p != int64(len(data)) {
+		t.Errorf("Expected: progress %d, got %d", len(data), p)
+	}
+
+	got, _ := ioutil.ReadFile(path)
+	if !bytes.Equal(got, data) {
+		t.Errorf("Received file differs (%d bytes vs %d)", len(got), len(data))
+	}
+}
+
+func TestSend(t *testing.T) {
+	testSend(t, testData(), false, 0)
+}
+
+func TestSendPassive(t *testing.T) {
+	testSend(t, testData(), true, 0)
+}
+
+func TestSendResume(t *testing.T) {
+	testSend(t, testData(), false, 12345)
+}
+
+func TestSendResumePassive(t *testing.T) {
+	testSend(t, testData(), true, 12345)
+}
+
+func TestSendEmpty(t *testing.T) {
+	testSend(t, []byte{}, false, 0)
+}
+
+func TestAck(t *testing.T) {
+	tests := []struct {
+		prev     int64
+		pos      int64
+		expected int64
+	}{
+		{0, 1024, 1024},
+		{1024, 1024, 1024},
+		// past 4GiB, acks wrap around, and must not be mistaken for the end of
+		// a file of (4GiB + 1024) bytes until they get there.
+		{1 << 32, 1<<32 + 1024, 1<<32 + 1024},
+		{1<<32 - 1024, 1<<32 + 1024, 1<<32 + 1024},
+		{5<<32 - 1024, 5<<32 + 1024, 5<<32 + 1024},
+	}
+	for _, test := range tests {
+		if got := ackedPosition(test.prev, ackFor(test.pos)); got != test.expected {
+			t.Errorf("Expected: %#v, got %#v", test.expected, got)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	alice, bob, offers := setup(t, Config{MaxSize: 1000})
+
+	if _, err := alice.Send("bob", "big", bytes.NewReader(testData()), 1001, false); err != ErrTooLarge {
+		t.Errorf("Expected: %v, got %v", ErrTooLarge, err)
+	}
+
+	// offers that are too large, or from someone not on the allow-list, are
+	// never seen.
+	alice.client.WriteCTCP("bob", "DCC", "SEND big 2130706433 1234 1001")
+	bob.client.WriteCTCP("alice", "DCC", "SEND small 2130706433 1234 10")
+	alice.client.WriteCTCP("bob", "DCC", "SEND small 2130706433 1234 10")
+
+	offer := waitOffer(t, offers)
+	if offer.Filename != "small" || offer.From.Nick != "alice" {
+		t.Errorf("Unexpected offer: %#v", offer)
+	}
+}
+
+func TestCaseMapping(t *testing.T) {
+	s := irctest.NewServer()
+	defer s.Close()
+	c := client.NewClient("bob", "bob", "bob", "", "")
+	defer c.Close()
+	m := NewManager(c, Config{AllowedPeers: []string{"alice{"}})
+
+	// until the server says otherwise, [ and { are the same
+	if !m.IsAllowed(parser.IrcPrefix{Nick: "ALICE["}) {
+		t.Errorf("Expected ALICE[ to be allowed")
+	}
+
+	if !s.Connect(c, 5*time.Second) {
+		t.Fatalf("Failed to register")
+	}
+
+	if m.IsAllowed(parser.IrcPrefix{Nick: "ALICE["}) {
+		t.Errorf("Expected ALICE[ not to be allowed with CASEMAPPING=ascii")
+	}
+	if !m.IsAllowed(parser.IrcPrefix{Nick: "ALICE{"}) {
+		t.Errorf("Expected ALICE{ to be allowed")
+	}
+}
+
+func TestAddr(t *testing.T) {
+	if a := encodeAddr("127.0.0.1"); a != "2130706433" {
+		t.Errorf("Expected: %#v, got %#v", "2130706433", a)
+	}
+	if a := encodeAddr("::1"); a != "::1" {
+		t.Errorf("Expected: %#v, got %#v", "::1", a)
+	}
+	if a, _, ok := decodeAddr("2130706433", "1024"); a != "127.0.0.1:1024" || !ok {
+		t.Errorf("Expected: %#v, got %#v", "127.0.0.1:1024", a)
+	}
+	if a, _, ok := decodeAddr("::1", "1024"); a != "[::1]:1024" || !ok {
+		t.Errorf("Expected: %#v, got %#v", "[::1]:1024", a)
+	}
+	if _, _, ok := decodeAddr("bogus", "1024"); ok {
+		t.Errorf("Expected bogus address to fail")
+	}
+
+	fields := splitDccArgs(`SEND "build log.txt" 2130706433 1024 42`)
+	if len(fields) != 5 || fields[1] != "build log.txt" {
+		t.Errorf("Unexpected fields: %#v", fields)
+	}
+	if name := sanitizeFilename("../../etc/passwd"); name != "passwd" {
+		t.Errorf("Expected: %#v, got %#v", "passwd", name)
+	}
+}
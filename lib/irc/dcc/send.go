@@ -0,0 +1,433 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package dcc
+
+import "encoding/binary"
+import "errors"
+import "io"
+import "net"
+import "os"
+import "path/filepath"
+import "strconv"
+import "sync/atomic"
+import "time"
+
+// Transfer is a DCC SEND in progress, in either direction.
+type Transfer struct {
+	// The nickname of the peer.
+	Peer string
+
+	// The name and total size of the file.
+	Filename string
+	Size     int64
+
+	// The position the transfer started from. Non-zero if it was resumed.
+	Offset int64
+
+	position int64
+	done     chan struct{}
+	err      error
+	progress ProgressFunc
+}
+
+func newTransfer(m *Manager, peer, filename string, size int64) *Transfer {
+	return &Transfer{
+		Peer:     peer,
+		Filename: filename,
+		Size:     size,
+		done:     make(chan struct{}),
+		progress: m.config.Progress,
+	}
+}
+
+// Position returns how much of the file has been transferred so far,
+// including any part transferred before resuming.
+func (this *Transfer) Position() int64 {
+	return atomic.LoadInt64(&this.position)
+}
+
+// Done returns a channel that is closed once the transfer is over.
+func (this *Transfer) Done() <-chan struct{} {
+	return this.done
+}
+
+// Wait blocks until the transfer is over, returning an error if it did not
+// complete successfully.
+func (this *Transfer) Wait() error {
+	<-this.done
+	return this.err
+}
+
+func (this *Transfer) advance(n int64) {
+	atomic.AddInt64(&this.position, n)
+	if this.progress != nil {
+		this.progress(this)
+	}
+}
+
+func (this *Transfer) finish(err error) {
+	this.err = err
+	close(this.done)
+}
+
+// The acknowledgement a receiver sends after each chunk: the position in the
+// file it has reached, as a 32 bit big endian integer. Positions are absolute,
+// also when resuming.
+func ackFor(pos int64) []byte {
+	var ack [4]byte
+	binary.BigEndian.PutUint32(ack[:], uint32(pos))
+	return ack[:]
+}
+
+// Follows the position a receiver has reached from its acknowledgements,
+// which wrap around for files over 4GiB. They only move forward, by far less
+// than that, so the position can be worked out from the previous one.
+func ackedPosition(prev int64, ack []byte) int64 {
+	return prev + int64(binary.BigEndian.Uint32(ack)-uint32(prev))
+}
+
+// SendFile offers the file at path to the given nickname. See Send.
+func (this *Manager) SendFile(nick, path string, passive bool) (*Transfer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	t, err := this.Send(nick, filepath.Base(path), f, fi.Size(), passive)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	go func() {
+		<-t.Done()
+		f.Close()
+	}()
+	return t, nil
+}
+
+// Send offers a file of the given size to the given nickname, and returns
+// once the offer is made. The transfer happens in the background once the peer accepts;
+// use Wait to find out how it went. The peer may ask to resume the transfer
+// part of the way through the file.
+//
+// If passive is true, the peer is asked to listen for us instead.
+func (this *Manager) Send(nick, name string, r io.ReaderAt, size int64, passive bool) (*Transfer, error) {
+	if this.config.MaxSize > 0 && size > this.config.MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	t := newTransfer(this, nick, name, size)
+	sizeStr := strconv.FormatInt(size, 10)
+
+	if passive {
+		token := this.newToken()
+		p := this.expect("send:"+token, nick, name)
+		resume := this.expect("resume:"+token, nick, name)
+		this.sendDcc(nick, "SEND", quoteFilename(name), encodeAddr(this.publicHost()), "0", sizeStr, token)
+
+		go func() {
+			defer this.forget("send:" + token)
+			defer this.forget("resume:" + token)
+
+			timer := time.NewTimer(this.config.Timeout)
+			defer timer.Stop()
+			for {
+				select {
+				case r := <-resume.replies:
+					if r.pos <= size {
+						t.Offset = r.pos
+						this.sendDcc(nick, "ACCEPT", quoteFilename(name), "0", strconv.FormatInt(r.pos, 10), token)
+					}
+				case reply := <-p.replies:
+					conn, err := this.dial(reply.addr)
+					if err != nil {
+						t.finish(err)
+						return
+					}
+					t.finish(this.sendData(t, conn, r))
+					return
+				case <-timer.C:
+					t.finish(ErrTimeout)
+					return
+				}
+			}
+		}()
+		return t, nil
+	}
+
+	from, err := this.peerAddrs(nick)
+	if err != nil {
+		return nil, err
+	}
+
+	l, host, port, err := this.listen()
+	if err != nil {
+		return nil, err
+	}
+
+	resume := this.expect("resume:"+port, nick, name)
+	this.sendDcc(nick, "SEND", quoteFilename(name), host, port, sizeStr)
+
+	go func() {
+		defer this.forget("resume:" + port)
+
+		type result struct {
+			conn net.Conn
+			err  error
+		}
+		accepted := make(chan result, 1)
+		go func() {
+			conn, err := this.accept(l, from)
+			accepted <- result{conn, err}
+		}()
+
+		for {
+			select {
+			case r := <-resume.replies:
+				if r.pos <= size {
+					t.Offset = r.pos
+					this.sendDcc(nick, "ACCEPT", quoteFilename(name), port, strconv.FormatInt(r.pos, 10))
+				}
+			case res := <-accepted:
+				if res.err != nil {
+					t.finish(res.err)
+					return
+				}
+				t.finish(this.sendData(t, res.conn, r))
+				return
+			}
+		}
+	}()
+	return t, nil
+}
+
+// Writes the file to the peer, starting from the transfer offset, and waits
+// for the peer to acknowledge all of it.
+func (this *Manager) sendData(t *Transfer, conn net.Conn, r io.ReaderAt) error {
+	defer conn.Close()
+	atomic.StoreInt64(&t.position, t.Offset)
+
+	// the peer acknowledges each chunk. these must be read as we go, otherwise
+	// they fill the socket buffers and the transfer stalls. if there is
+	// nothing left to send, there is nothing to wait for either.
+	acked := make(chan error, 1)
+	go func() {
+		var ack [4]byte
+		pos := t.Offset
+		for pos < t.Size {
+			if _, err := io.ReadFull(conn, ack[:]); err != nil {
+				acked <- err
+				return
+			}
+			pos = ackedPosition(pos, ack[:])
+		}
+		if pos != t.Size {
+			acked <- errors.New("dcc: peer acknowledged more than was sent")
+			return
+		}
+		acked <- nil
+	}()
+
+	buf := make([]byte, 32*1024)
+	pos := t.Offset
+	for pos < t.Size {
+		n, err := r.ReadAt(buf, pos)
+		if n > 0 {
+			conn.SetWriteDeadline(time.Now().Add(this.config.Timeout))
+			if _, werr := conn.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			pos += int64(n)
+			t.advance(int64(n))
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if pos != t.Size {
+		return errors.New("dcc: file is shorter than offered")
+	}
+
+	timer := time.NewTimer(this.config.Timeout)
+	defer timer.Stop()
+	select {
+	case err := <-acked:
+		return err
+	case <-timer.C:
+		return ErrTimeout
+	}
+}
+
+// ReceiveFile accepts a DCC SEND offer, writing the file to path. If path
+// already holds part of the file, the transfer is resumed from where it left
+// off. It blocks until the transfer starts, which then continues in the
+// background. It must not be called from an OfferFunc directly.
+func (this *Offer) ReceiveFile(path string) (*Transfer, error) {
+	var offset int64
+	if fi, err := os.Stat(path); err == nil && fi.Size() < this.Size {
+		offset = fi.Size()
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := this.Receive(f, offset)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	go func() {
+		<-t.Done()
+		f.Close()
+	}()
+	return t, nil
+}
+
+// Receive accepts a DCC SEND offer, writing the file to w. If offset is
+// non-zero, the peer is asked to resume the transfer from that position, and
+// w is expected to already hold that much of the file. It blocks until the
+// transfer starts, which then continues in the background. It must not be
+// called from an OfferFunc directly.
+func (this *Offer) Receive(w io.Writer, offset int64) (*Transfer, error) {
+	if this.Type != SendOffer {
+		return nil, errors.New("dcc: not a send offer")
+	}
+
+	m := this.manager
+	if m.config.MaxSize > 0 && this.Size > m.config.MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	nick := this.From.Nick
+	name := quoteFilename(this.Filename)
+	t := newTransfer(m, nick, this.Filename, this.Size)
+
+	if offset > 0 {
+		key := "accept:" + this.port
+		args := []string{"RESUME", name, this.port, strconv.FormatInt(offset, 10)}
+		if this.Passive() {
+			key = "accept:" + this.token
+			args = append(args, this.token)
+		}
+
+		p := m.expect(key, nick, this.Filename)
+		m.sendDcc(nick, args...)
+		r, err := m.wait(p)
+		m.forget(key)
+		if err != nil {
+			return nil, err
+		}
+		if r.pos != offset {
+			return nil, errors.New("dcc: peer accepted resume at the wrong position")
+		}
+		t.Offset = offset
+	}
+
+	var conn net.Conn
+	var err error
+	if this.Passive() {
+		var from []net.IP
+		from, err = this.peerAddrs()
+		if err != nil {
+			return nil, err
+		}
+
+		var l net.Listener
+		var host, port string
+		l, host, port, err = m.listen()
+		if err != nil {
+			return nil, err
+		}
+		m.sendDcc(nick, "SEND", name, host, port, strconv.FormatInt(this.Size, 10), this.token)
+		conn, err = m.accept(l, from)
+	} else {
+		conn, err = m.dial(this.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		t.finish(m.receiveData(t, conn, w))
+	}()
+	return t, nil
+}
+
+// Reads the file from the peer, acknowledging each chunk.
+func (this *Manager) receiveData(t *Transfer, conn net.Conn, w io.Writer) error {
+	defer conn.Close()
+	atomic.StoreInt64(&t.position, t.Offset)
+
+	buf := make([]byte, 32*1024)
+	pos := t.Offset
+	for t.Size == 0 || pos < t.Size {
+		conn.SetReadDeadline(time.Now().Add(this.config.Timeout))
+		n, err := conn.Read(buf)
+		if n > 0 {
+			if (t.Size > 0 && pos+int64(n) > t.Size) || (this.config.MaxSize > 0 && pos+int64(n) > this.config.MaxSize) {
+				return ErrTooLarge
+			}
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			pos += int64(n)
+			t.advance(int64(n))
+			if _, werr := conn.Write(ackFor(pos)); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			if t.Size == 0 {
+				return nil
+			}
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if pos != t.Size {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
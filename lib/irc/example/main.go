@@ -31,7 +31,7 @@ import (
 )
 
 func main() {
-	c := client.NewClient("testbot", "testuser", "Test thing")
+	c := client.NewClient("testbot", "testuser", "Test thing", "", "")
 
 	c.AddCallback(client.OnMessage, func(c *client.IrcClient, command *parser.IrcMessage) {
 		fmt.Printf("In CONNECTED callback: %v\n", command)
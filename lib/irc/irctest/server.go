@@ -0,0 +1,227 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Package irctest provides a fake IRC server for testing code that uses the
+// client package, in the spirit of net/http/httptest.
+//
+// The server is just capable enough to register clients, let them join
+// channels (with a friend already there), relay messages between them, and
+// tell them where the others are. Anything else a client sends is kept, to be
+// checked with Next.
+package irctest
+
+import "github.com/rburchell/gobo/lib/irc/client"
+import "github.com/rburchell/gobo/lib/irc/parser"
+import "bufio"
+import "net"
+import "strings"
+import "sync"
+import "time"
+
+// The host every client appears to connect from.
+const clientHost = "127.0.0.1"
+
+// A Server is a fake IRC network of one server.
+type Server struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	clients  map[net.Conn]string // by connection, their nickname
+	received []*parser.IrcMessage
+	next     int // the first of received that Next hasn't returned
+}
+
+// NewServer starts a server on a local port.
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("irctest: failed to listen: " + err.Error())
+	}
+
+	server := &Server{listener: listener, clients: map[net.Conn]string{}}
+	server.cond = sync.NewCond(&server.mutex)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+// Addr returns the host:port the server listens on.
+func (this *Server) Addr() string {
+	return this.listener.Addr().String()
+}
+
+// Close stops listening, and drops all connections.
+func (this *Server) Close() {
+	this.listener.Close()
+	this.DropConnections()
+}
+
+// DropConnections closes all current connections, as if the network went
+// away, but keeps listening.
+func (this *Server) DropConnections() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for conn := range this.clients {
+		conn.Close()
+	}
+}
+
+// Connect connects c to the server, processing what it receives in the
+// background, and waits until it has registered (and had the MOTD), returning
+// false if that doesn't happen within timeout.
+func (this *Server) Connect(c *client.IrcClient, timeout time.Duration) bool {
+	registered := make(chan bool, 1)
+	c.AddCallback("376", func(c *client.IrcClient, command *parser.IrcMessage) {
+		select {
+		case registered <- true:
+		default:
+		}
+	})
+
+	go c.Run(this.Addr())
+	go func() {
+		for command := range c.CommandChannel {
+			c.ProcessCallbacks(command)
+		}
+	}()
+
+	select {
+	case <-registered:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Send sends a line to every client, and returns how many there were.
+func (this *Server) Send(line string) int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for conn := range this.clients {
+		conn.Write([]byte(line + "\r\n"))
+	}
+	return len(this.clients)
+}
+
+// Next returns the next message a client sent that the server didn't handle
+// itself, returning false if there isn't one within timeout.
+func (this *Server) Next(timeout time.Duration) (*parser.IrcMessage, bool) {
+	timer := time.AfterFunc(timeout, func() {
+		this.mutex.Lock()
+		defer this.mutex.Unlock()
+		this.cond.Broadcast()
+	})
+	defer timer.Stop()
+
+	deadline := time.Now().Add(timeout)
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for this.next == len(this.received) {
+		if !time.Now().Before(deadline) {
+			return nil, false
+		}
+		this.cond.Wait()
+	}
+	this.next++
+	return this.received[this.next-1], true
+}
+
+// Finds the connection of the client using nick. Called with the mutex held.
+func (this *Server) find(nick string) net.Conn {
+	for conn, n := range this.clients {
+		if strings.EqualFold(n, nick) {
+			return conn
+		}
+	}
+	return nil
+}
+
+func (this *Server) serve(conn net.Conn) {
+	this.mutex.Lock()
+	this.clients[conn] = ""
+	this.mutex.Unlock()
+	defer func() {
+		this.mutex.Lock()
+		delete(this.clients, conn)
+		this.mutex.Unlock()
+		conn.Close()
+	}()
+
+	var nick string
+	write := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		c := parser.ParseClientLine(scanner.Text())
+		switch c.Command {
+		case "NICK":
+			nick = c.Parameters[0]
+			this.mutex.Lock()
+			this.clients[conn] = nick
+			this.mutex.Unlock()
+		case "USER":
+			write(":fake.server 001 " + nick + " :Welcome to the fake network")
+			write(":fake.server 005 " + nick + " PREFIX=(ov)@+ CASEMAPPING=ascii :are supported by this server")
+			write(":fake.server 376 " + nick + " :End of MOTD")
+		case "JOIN":
+			for _, channel := range strings.Split(c.Parameters[0], ",") {
+				write(":" + nick + "!" + nick + "@" + clientHost + " JOIN " + channel)
+				write(":fake.server 332 " + nick + " " + channel + " :Welcome to " + channel)
+				write(":fake.server 353 " + nick + " = " + channel + " :" + nick + " @friend")
+				write(":fake.server 366 " + nick + " " + channel + " :End of /NAMES list.")
+			}
+		case "USERHOST":
+			this.mutex.Lock()
+			target := this.find(c.Parameters[0])
+			this.mutex.Unlock()
+			if target != nil {
+				write(":fake.server 302 " + nick + " :" + c.Parameters[0] + "=+" + c.Parameters[0] + "@" + clientHost)
+			}
+		case "PRIVMSG", "NOTICE":
+			this.mutex.Lock()
+			target := this.find(c.Parameters[0])
+			this.mutex.Unlock()
+			if target != nil {
+				c.Prefix = parser.IrcPrefix{Nick: nick, User: nick, Host: clientHost}
+				target.Write([]byte(c.String() + "\r\n"))
+				continue
+			}
+			fallthrough
+		default:
+			this.mutex.Lock()
+			this.received = append(this.received, c)
+			this.cond.Broadcast()
+			this.mutex.Unlock()
+		}
+	}
+}
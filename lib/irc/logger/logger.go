@@ -0,0 +1,447 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Package logger records what an IrcClient sees to disk, one log per channel
+// (or query), and reads those logs back.
+//
+// Logs are written in a plain text format similar to that of irssi and ZNC,
+// and/or as JSON lines, which can be replayed exactly. Files are rotated daily,
+// and optionally once they grow beyond a given size.
+package logger
+
+import "github.com/rburchell/gobo/lib/irc/client"
+import "github.com/rburchell/gobo/lib/irc/parser"
+import "encoding/json"
+import "fmt"
+import "os"
+import "path/filepath"
+import "sort"
+import "strconv"
+import "strings"
+import "sync"
+import "time"
+
+// Config controls where and how logs are written.
+type Config struct {
+	// The directory logs are written to. Each channel or query gets a
+	// subdirectory of its own.
+	Directory string
+
+	// Which formats to write. If neither is set, plain text is written.
+	Text bool
+	JSON bool
+
+	// If non-zero, a log is rotated once it grows beyond this many bytes, in
+	// addition to the daily rotation.
+	MaxSize int64
+
+	// The timezone used for daily rotation and for timestamps in the plain
+	// text format. Defaults to local time.
+	Location *time.Location
+}
+
+// The format of server-time tags, see
+// http://ircv3.net/specs/extensions/server-time-3.2.html
+const ServerTimeFormat = "2006-01-02T15:04:05.000Z"
+
+const textExt = ".log"
+const jsonExt = ".jsonl"
+
+// An entry in a JSON lines log.
+type jsonEntry struct {
+	Time    time.Time `json:"time"`
+	Target  string    `json:"target"`
+	Command string    `json:"command"`
+	Nick    string    `json:"nick,omitempty"`
+	Text    string    `json:"text,omitempty"`
+
+	// The full protocol line, without tags.
+	Line string `json:"line"`
+}
+
+// An open log file.
+type logFile struct {
+	file *os.File
+	size int64
+	day  string
+	seq  int
+}
+
+// Logger writes logs for the messages it is given.
+type Logger struct {
+	config Config
+	nick   string
+
+	mutex sync.Mutex
+	files map[string]*logFile
+
+	// channel -> nicknames (lowercased) in it. needed to know which channels
+	// to log QUIT and NICK to.
+	members map[string]map[string]bool
+}
+
+// New creates a Logger. If c is non-nil, the logger subscribes to everything
+// the client receives, and the messages and notices it sends, and asks the
+// server for server-time, so that messages are logged with the time the
+// server saw them rather than when we did.
+//
+// A Logger created without a client can still be used to read logs back, or
+// be fed with Log.
+func New(c *client.IrcClient, config Config) *Logger {
+	if !config.Text && !config.JSON {
+		config.Text = true
+	}
+	if config.Location == nil {
+		config.Location = time.Local
+	}
+
+	this := &Logger{
+		config:  config,
+		files:   make(map[string]*logFile),
+		members: make(map[string]map[string]bool),
+	}
+
+	if c != nil {
+		this.nick = c.Nick()
+		c.RequestCap("server-time")
+		c.AddCallback(client.OnAny, func(c *client.IrcClient, command *parser.IrcMessage) {
+			if err := this.Log(command); err != nil {
+				println("Error writing log: " + err.Error())
+			}
+		})
+		c.AddSentCallback(func(c *client.IrcClient, command *parser.IrcMessage) {
+			// the server tells us about anything else we do, but not this.
+			if command.Command != "PRIVMSG" && command.Command != "NOTICE" {
+				return
+			}
+			if err := this.Log(command); err != nil {
+				println("Error writing log: " + err.Error())
+			}
+		})
+	}
+	return this
+}
+
+// Close closes all open log files.
+func (this *Logger) Close() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	var err error
+	for key, lf := range this.files {
+		if cerr := lf.file.Close(); cerr != nil {
+			err = cerr
+		}
+		delete(this.files, key)
+	}
+	return err
+}
+
+// Time returns the time a message was sent according to its server-time tag,
+// if it has one.
+func Time(msg *parser.IrcMessage) (time.Time, bool) {
+	value, ok := msg.Tag("time")
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func isChannel(target string) bool {
+	return len(target) > 0 && strings.ContainsRune("#&+!", rune(target[0]))
+}
+
+// Log writes a message to the log(s) it belongs in. Messages that aren't
+// interesting to log (numerics, PINGs, ...) are ignored.
+func (this *Logger) Log(msg *parser.IrcMessage) error {
+	when, ok := Time(msg)
+	if !ok {
+		when = time.Now()
+	}
+
+	var err error
+	for _, target := range this.targets(msg) {
+		if werr := this.write(when, target, msg); werr != nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+// Works out which logs a message belongs in, keeping track of who is in which
+// channel as it goes.
+func (this *Logger) targets(msg *parser.IrcMessage) []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	nick := strings.ToLower(msg.Prefix.Nick)
+	param := func(idx int) string {
+		if idx < len(msg.Parameters) {
+			return msg.Parameters[idx]
+		}
+		return ""
+	}
+
+	switch msg.Command {
+	case "PRIVMSG", "NOTICE":
+		target := param(0)
+		if isChannel(target) {
+			return []string{target}
+		}
+		if len(msg.Prefix.Nick) == 0 {
+			// server notices
+			return nil
+		}
+		if strings.EqualFold(msg.Prefix.Nick, this.nick) {
+			return []string{target}
+		}
+		return []string{msg.Prefix.Nick}
+	case "JOIN":
+		channel := strings.ToLower(param(0))
+		if this.members[channel] == nil || strings.EqualFold(msg.Prefix.Nick, this.nick) {
+			this.members[channel] = make(map[string]bool)
+		}
+		this.members[channel][nick] = true
+		return []string{param(0)}
+	case "PART":
+		channel := strings.ToLower(param(0))
+		if strings.EqualFold(msg.Prefix.Nick, this.nick) {
+			delete(this.members, channel)
+		} else if this.members[channel] != nil {
+			delete(this.members[channel], nick)
+		}
+		return []string{param(0)}
+	case "KICK":
+		channel := strings.ToLower(param(0))
+		if strings.EqualFold(param(1), this.nick) {
+			delete(this.members, channel)
+		} else if this.members[channel] != nil {
+			delete(this.members[channel], strings.ToLower(param(1)))
+		}
+		return []string{param(0)}
+	case "TOPIC":
+		return []string{param(0)}
+	case "MODE":
+		if isChannel(param(0)) {
+			return []string{param(0)}
+		}
+	case "QUIT", "NICK":
+		var targets []string
+		for channel, members := range this.members {
+			if members[nick] {
+				targets = append(targets, channel)
+				delete(members, nick)
+				if msg.Command == "NICK" {
+					members[strings.ToLower(param(0))] = true
+				}
+			}
+		}
+		sort.Strings(targets)
+		return targets
+	case "353":
+		// :server 353 me = #channel :@op +voice nick
+		channel := strings.ToLower(param(2))
+		if this.members[channel] == nil {
+			this.members[channel] = make(map[string]bool)
+		}
+		for _, name := range strings.Fields(param(3)) {
+			this.members[channel][strings.ToLower(strings.TrimLeft(name, "~&@%+!"))] = true
+		}
+	}
+	return nil
+}
+
+func (this *Logger) write(when time.Time, target string, msg *parser.IrcMessage) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.config.Text {
+		if line := formatText(when.In(this.config.Location), msg); len(line) > 0 {
+			if err := this.append(when, target, textExt, []byte(line+"\n")); err != nil {
+				return err
+			}
+		}
+	}
+
+	if this.config.JSON {
+		entry := jsonEntry{
+			Time:    when.UTC(),
+			Target:  target,
+			Command: msg.Command,
+			Nick:    msg.Prefix.Nick,
+			Line:    msg.String(),
+		}
+		if (msg.Command == "PRIVMSG" || msg.Command == "NOTICE") && len(msg.Parameters) > 0 {
+			entry.Text = msg.Parameters[len(msg.Parameters)-1]
+		}
+		blob, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if err := this.append(when, target, jsonExt, append(blob, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Turns a channel or nickname into something safe to use as a directory name.
+func targetDirectory(target string) string {
+	target = strings.ToLower(target)
+	target = strings.NewReplacer("/", "_", "\\", "_", "\x00", "_").Replace(target)
+	if strings.HasPrefix(target, ".") {
+		target = "_" + target[1:]
+	}
+	return target
+}
+
+// The path to a log file for a day. The first file of a day is named after
+// the day alone, those rotated because of their size get a sequence number.
+func (this *Logger) logPath(target, day string, seq int, ext string) string {
+	name := day
+	if seq > 0 {
+		name += "." + strconv.Itoa(seq)
+	}
+	return filepath.Join(this.config.Directory, targetDirectory(target), name+ext)
+}
+
+// Appends data to the current log file for target, rotating it as necessary.
+func (this *Logger) append(when time.Time, target, ext string, data []byte) error {
+	key := targetDirectory(target) + ext
+	day := when.In(this.config.Location).Format("2006-01-02")
+	lf := this.files[key]
+
+	if lf != nil && lf.day != day {
+		lf.file.Close()
+		lf = nil
+	}
+
+	if lf == nil {
+		var err error
+		lf, err = this.open(target, day, this.lastSeq(target, day, ext), ext)
+		if err != nil {
+			return err
+		}
+		this.files[key] = lf
+	}
+
+	if this.config.MaxSize > 0 && lf.size > 0 && lf.size+int64(len(data)) > this.config.MaxSize {
+		lf.file.Close()
+		var err error
+		lf, err = this.open(target, day, lf.seq+1, ext)
+		if err != nil {
+			delete(this.files, key)
+			return err
+		}
+		this.files[key] = lf
+	}
+
+	n, err := lf.file.Write(data)
+	lf.size += int64(n)
+	return err
+}
+
+func (this *Logger) open(target, day string, seq int, ext string) (*logFile, error) {
+	path := this.logPath(target, day, seq, ext)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &logFile{file: f, size: fi.Size(), day: day, seq: seq}, nil
+}
+
+// Finds the most recent file for a day, so that we carry on where we left off
+// after a restart.
+func (this *Logger) lastSeq(target, day, ext string) int {
+	seq := 0
+	for {
+		if _, err := os.Stat(this.logPath(target, day, seq+1, ext)); err != nil {
+			return seq
+		}
+		seq++
+	}
+}
+
+// Formats a message in the plain text format. Returns an empty string for
+// messages that have no plain text form.
+func formatText(when time.Time, msg *parser.IrcMessage) string {
+	param := func(idx int) string {
+		if idx < len(msg.Parameters) {
+			return msg.Parameters[idx]
+		}
+		return ""
+	}
+
+	nick := msg.Prefix.Nick
+	who := nick
+	if len(msg.Prefix.User) > 0 {
+		who = fmt.Sprintf("%s [%s@%s]", nick, msg.Prefix.User, msg.Prefix.Host)
+	}
+
+	var line string
+	switch msg.Command {
+	case "PRIVMSG":
+		if ctcp, args, ok := client.ParseCTCP(param(1)); ok {
+			if ctcp != "ACTION" {
+				return ""
+			}
+			line = fmt.Sprintf("* %s %s", nick, args)
+		} else {
+			line = fmt.Sprintf("<%s> %s", nick, param(1))
+		}
+	case "NOTICE":
+		line = fmt.Sprintf("-%s- %s", nick, param(1))
+	case "JOIN":
+		line = fmt.Sprintf("-!- %s has joined %s", who, param(0))
+	case "PART":
+		line = fmt.Sprintf("-!- %s has left %s [%s]", who, param(0), param(1))
+	case "QUIT":
+		line = fmt.Sprintf("-!- %s has quit [%s]", who, param(0))
+	case "KICK":
+		line = fmt.Sprintf("-!- %s was kicked from %s by %s [%s]", param(1), param(0), nick, param(2))
+	case "NICK":
+		line = fmt.Sprintf("-!- %s is now known as %s", nick, param(0))
+	case "TOPIC":
+		line = fmt.Sprintf("-!- %s changed the topic of %s to: %s", nick, param(0), param(1))
+	case "MODE":
+		line = fmt.Sprintf("-!- mode/%s [%s] by %s", param(0), strings.Join(msg.Parameters[1:], " "), nick)
+	default:
+		return ""
+	}
+	return when.Format("15:04:05") + " " + line
+}
@@ -0,0 +1,214 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package logger
+
+import "github.com/rburchell/gobo/lib/irc/client"
+import "github.com/rburchell/gobo/lib/irc/irctest"
+import "github.com/rburchell/gobo/lib/irc/parser"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "reflect"
+import "strings"
+import "testing"
+import "time"
+
+var testLines = []string{
+	"@time=2026-10-19T09:00:00.000Z :w00t!toot@moo.cows JOIN #gobo",
+	"@time=2026-10-19T09:00:01.000Z :w00t!toot@moo.cows PRIVMSG #gobo :hello world",
+	"@time=2026-10-19T09:00:02.000Z :w00t!toot@moo.cows PRIVMSG #gobo :\x01ACTION waves\x01",
+	"@time=2026-10-19T09:00:03.000Z :w00t!toot@moo.cows NOTICE #gobo ::)",
+	"@time=2026-10-19T09:00:04.000Z :w00t!toot@moo.cows TOPIC #gobo :all about gobo",
+	"@time=2026-10-19T09:00:05.000Z :w00t!toot@moo.cows MODE #gobo +o gobo",
+	"@time=2026-10-19T09:00:06.000Z :w00t!toot@moo.cows KICK #gobo other :bye",
+	"@time=2026-10-19T09:00:07.000Z :w00t!toot@moo.cows NICK w00t_",
+	"@time=2026-10-19T09:00:08.000Z :w00t_!toot@moo.cows QUIT :gone",
+	"@time=2026-10-19T09:00:09.000Z :someone!else@where PRIVMSG gobo :psst",
+	"@time=2026-10-19T09:00:10.000Z :server.example 001 gobo :Welcome",
+}
+
+const expectedText = `09:00:00 -!- w00t [toot@moo.cows] has joined #gobo
+09:00:01 <w00t> hello world
+09:00:02 * w00t waves
+09:00:03 -w00t- :)
+09:00:04 -!- w00t changed the topic of #gobo to: all about gobo
+09:00:05 -!- mode/#gobo [+o gobo] by w00t
+09:00:06 -!- other was kicked from #gobo by w00t [bye]
+09:00:07 -!- w00t is now known as w00t_
+09:00:08 -!- w00t_ [toot@moo.cows] has quit [gone]
+`
+
+func newTestLogger(t *testing.T, config Config) (*Logger, func()) {
+	dir, err := ioutil.TempDir("", "logger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Directory = dir
+	config.Location = time.UTC
+	l := New(nil, config)
+	l.nick = "gobo"
+	return l, func() {
+		l.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func logAll(t *testing.T, l *Logger, lines []string) {
+	for _, line := range lines {
+		if err := l.Log(parser.ParseLine(line)); err != nil {
+			t.Fatalf("Log failed: %s", err)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	l, cleanup := newTestLogger(t, Config{Text: true})
+	defer cleanup()
+	logAll(t, l, testLines)
+
+	got, _ := ioutil.ReadFile(filepath.Join(l.config.Directory, "#gobo", "2026-10-19.log"))
+	if string(got) != expectedText {
+		t.Errorf("Expected: %#v, got %#v", expectedText, string(got))
+	}
+
+	query, _ := ioutil.ReadFile(filepath.Join(l.config.Directory, "someone", "2026-10-19.log"))
+	if string(query) != "09:00:09 <someone> psst\n" {
+		t.Errorf("Unexpected query log: %#v", string(query))
+	}
+}
+
+func TestSent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := irctest.NewServer()
+	defer server.Close()
+
+	c := client.NewClient("gobo", "gobo", "gobo", "", "")
+	l := New(c, Config{Directory: dir, Location: time.UTC})
+	defer l.Close()
+	if !server.Connect(c, 5*time.Second) {
+		t.Fatalf("Failed to register")
+	}
+
+	// what we say is logged, but not the rest of what we send
+	c.WriteMessage("#gobo", "hello from me")
+	c.WriteLine("TOPIC #gobo :a new topic")
+
+	got, _ := ioutil.ReadFile(filepath.Join(dir, "#gobo", time.Now().UTC().Format("2006-01-02")+".log"))
+	if !strings.HasSuffix(string(got), " <gobo> hello from me\n") || strings.Count(string(got), "\n") != 1 {
+		t.Errorf("Expected what we said to be logged, got %#v", string(got))
+	}
+}
+
+func testReplay(t *testing.T, config Config) {
+	l, cleanup := newTestLogger(t, config)
+	defer cleanup()
+	logAll(t, l, testLines)
+
+	var got []string
+	err := l.Replay("#gobo", time.Time{}, time.Time{}, func(msg *parser.IrcMessage) bool {
+		when, _ := Time(msg)
+		got = append(got, when.Format(time.RFC3339)+" "+msg.String())
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
+
+	expected := []string{
+		"2026-10-19T09:00:00Z :w00t!toot@moo.cows JOIN #gobo",
+		"2026-10-19T09:00:01Z :w00t PRIVMSG #gobo :hello world",
+		"2026-10-19T09:00:02Z :w00t PRIVMSG #gobo :\x01ACTION waves\x01",
+		"2026-10-19T09:00:03Z :w00t NOTICE #gobo ::)",
+		"2026-10-19T09:00:04Z :w00t TOPIC #gobo :all about gobo",
+		"2026-10-19T09:00:05Z :w00t MODE #gobo +o gobo",
+		"2026-10-19T09:00:06Z :w00t KICK #gobo other bye",
+		"2026-10-19T09:00:07Z :w00t NICK w00t_",
+		"2026-10-19T09:00:08Z :w00t_!toot@moo.cows QUIT gone",
+	}
+	if config.JSON {
+		// JSON logs keep the full prefix
+		for idx := range expected {
+			expected[idx] = strings.Replace(expected[idx], ":w00t ", ":w00t!toot@moo.cows ", 1)
+		}
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected: %#v, got %#v", expected, got)
+	}
+
+	found, err := l.Search("#gobo", time.Date(2026, 10, 19, 9, 0, 1, 0, time.UTC), time.Time{}, "HELLO")
+	if err != nil || len(found) != 1 || found[0].Parameters[1] != "hello world" {
+		t.Errorf("Unexpected search result: %#v (%v)", found, err)
+	}
+}
+
+func TestReplayText(t *testing.T) {
+	testReplay(t, Config{Text: true})
+}
+
+func TestReplayJSON(t *testing.T) {
+	testReplay(t, Config{Text: true, JSON: true})
+}
+
+func TestRotation(t *testing.T) {
+	l, cleanup := newTestLogger(t, Config{Text: true, MaxSize: 64})
+	defer cleanup()
+	logAll(t, l, []string{
+		"@time=2026-10-18T23:59:59.000Z :w00t!toot@moo.cows PRIVMSG #gobo :yesterday",
+		"@time=2026-10-19T00:00:00.000Z :w00t!toot@moo.cows PRIVMSG #gobo :one two three four five",
+		"@time=2026-10-19T00:00:01.000Z :w00t!toot@moo.cows PRIVMSG #gobo :six seven eight nine ten",
+		"@time=2026-10-19T00:00:02.000Z :w00t!toot@moo.cows PRIVMSG #gobo :eleven",
+	})
+
+	infos, _ := ioutil.ReadDir(filepath.Join(l.config.Directory, "#gobo"))
+	var names []string
+	for _, fi := range infos {
+		names = append(names, fi.Name())
+	}
+	expected := []string{"2026-10-18.log", "2026-10-19.1.log", "2026-10-19.log"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected: %#v, got %#v", expected, names)
+	}
+
+	// a new logger carries on in the latest file
+	l.Close()
+	l2 := New(nil, l.config)
+	logAll(t, l2, []string{"@time=2026-10-19T00:00:03.000Z :w00t!toot@moo.cows PRIVMSG #gobo :twelve"})
+	l2.Close()
+
+	var got []string
+	l.Replay("#gobo", time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), time.Time{}, func(msg *parser.IrcMessage) bool {
+		got = append(got, msg.Parameters[1])
+		return true
+	})
+	expected = []string{"one two three four five", "six seven eight nine ten", "eleven", "twelve"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected: %#v, got %#v", expected, got)
+	}
+}
@@ -0,0 +1,268 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package logger
+
+import "github.com/rburchell/gobo/lib/irc/parser"
+import "bufio"
+import "encoding/json"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "regexp"
+import "sort"
+import "strconv"
+import "strings"
+import "time"
+
+// A log file found on disk.
+type logName struct {
+	path string
+	day  time.Time
+	seq  int
+}
+
+// Lists the log files for a target, oldest first. Where a day has been logged
+// in both formats, only the JSON log is used, as it replays exactly.
+func (this *Logger) logFiles(target string) ([]logName, error) {
+	dir := filepath.Join(this.config.Directory, targetDirectory(target))
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	haveJSON := make(map[string]bool)
+	for _, fi := range infos {
+		if strings.HasSuffix(fi.Name(), jsonExt) {
+			haveJSON[strings.TrimSuffix(fi.Name(), jsonExt)] = true
+		}
+	}
+
+	var names []logName
+	for _, fi := range infos {
+		base := fi.Name()
+		if strings.HasSuffix(base, textExt) {
+			base = strings.TrimSuffix(base, textExt)
+			if haveJSON[base] {
+				continue
+			}
+		} else if strings.HasSuffix(base, jsonExt) {
+			base = strings.TrimSuffix(base, jsonExt)
+		} else {
+			continue
+		}
+
+		name := logName{path: filepath.Join(dir, fi.Name())}
+		parts := strings.SplitN(base, ".", 2)
+		name.day, err = time.ParseInLocation("2006-01-02", parts[0], this.config.Location)
+		if err != nil {
+			continue
+		}
+		if len(parts) > 1 {
+			name.seq, _ = strconv.Atoi(parts[1])
+		}
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		if !names[i].day.Equal(names[j].day) {
+			return names[i].day.Before(names[j].day)
+		}
+		return names[i].seq < names[j].seq
+	})
+	return names, nil
+}
+
+// Replay reads back the logs for a channel or query, calling fn for every
+// message logged between since and until (inclusive, either may be zero to
+// leave that end open), oldest first. Each message carries a server-time tag
+// with the time it was logged. Replay stops early if fn returns false.
+func (this *Logger) Replay(target string, since, until time.Time, fn func(msg *parser.IrcMessage) bool) error {
+	names, err := this.logFiles(target)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		// skip files that can't contain anything in range
+		if !since.IsZero() && name.day.AddDate(0, 0, 1).Before(since) {
+			continue
+		}
+		if !until.IsZero() && name.day.After(until) {
+			break
+		}
+
+		msgs, err := ReadFile(name.path, this.config.Location)
+		if err != nil {
+			return err
+		}
+
+		for _, msg := range msgs {
+			when, _ := Time(msg)
+			if !since.IsZero() && when.Before(since) {
+				continue
+			}
+			if !until.IsZero() && when.After(until) {
+				return nil
+			}
+			if !fn(msg) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// Search returns the PRIVMSGs and NOTICEs logged for a target between since
+// and until whose text contains the given string, ignoring case.
+func (this *Logger) Search(target string, since, until time.Time, text string) ([]*parser.IrcMessage, error) {
+	var found []*parser.IrcMessage
+	text = strings.ToLower(text)
+	err := this.Replay(target, since, until, func(msg *parser.IrcMessage) bool {
+		if (msg.Command == "PRIVMSG" || msg.Command == "NOTICE") && len(msg.Parameters) > 1 {
+			if strings.Contains(strings.ToLower(msg.Parameters[1]), text) {
+				found = append(found, msg)
+			}
+		}
+		return true
+	})
+	return found, err
+}
+
+// Adds a server-time tag to a replayed message.
+func withTime(msg *parser.IrcMessage, when time.Time) *parser.IrcMessage {
+	msg.Tags = append(msg.Tags, parser.IrcTag{Key: "time", Value: when.UTC().Format(ServerTimeFormat)})
+	return msg
+}
+
+// ReadFile reads a single log file, in either format, into messages. The
+// timezone is used to interpret timestamps in plain text logs; JSON logs are
+// unambiguous. Lines that can't be understood are skipped.
+func ReadFile(path string, location *time.Location) ([]*parser.IrcMessage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if location == nil {
+		location = time.Local
+	}
+
+	var day time.Time
+	var target string
+	text := strings.HasSuffix(path, textExt)
+	if text {
+		base := strings.SplitN(filepath.Base(path), ".", 2)[0]
+		day, err = time.ParseInLocation("2006-01-02", base, location)
+		if err != nil {
+			return nil, err
+		}
+		target = filepath.Base(filepath.Dir(path))
+	}
+
+	var msgs []*parser.IrcMessage
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var msg *parser.IrcMessage
+		if text {
+			msg = parseText(day, target, scanner.Text())
+		} else {
+			var entry jsonEntry
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil && len(entry.Line) > 0 {
+				msg = withTime(parser.ParseLine(entry.Line), entry.Time)
+			}
+		}
+		if msg != nil {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, scanner.Err()
+}
+
+var textPatterns = []struct {
+	re    *regexp.Regexp
+	build func(target string, m []string) string
+}{
+	{regexp.MustCompile(`^<([^>]+)> (.*)$`), func(target string, m []string) string {
+		return ":" + m[1] + " PRIVMSG " + target + " :" + m[2]
+	}},
+	{regexp.MustCompile(`^\* (\S+) (.*)$`), func(target string, m []string) string {
+		return ":" + m[1] + " PRIVMSG " + target + " :\x01ACTION " + m[2] + "\x01"
+	}},
+	{regexp.MustCompile(`^-!- (\S+)(?: \[(\S*)\])? has joined (\S+)$`), func(target string, m []string) string {
+		return ":" + userPrefix(m[1], m[2]) + " JOIN " + m[3]
+	}},
+	{regexp.MustCompile(`^-!- (\S+)(?: \[(\S*)\])? has left (\S+) \[(.*)\]$`), func(target string, m []string) string {
+		return ":" + userPrefix(m[1], m[2]) + " PART " + m[3] + " :" + m[4]
+	}},
+	{regexp.MustCompile(`^-!- (\S+)(?: \[(\S*)\])? has quit \[(.*)\]$`), func(target string, m []string) string {
+		return ":" + userPrefix(m[1], m[2]) + " QUIT :" + m[3]
+	}},
+	{regexp.MustCompile(`^-!- (\S+) was kicked from (\S+) by (\S+) \[(.*)\]$`), func(target string, m []string) string {
+		return ":" + m[3] + " KICK " + m[2] + " " + m[1] + " :" + m[4]
+	}},
+	{regexp.MustCompile(`^-!- (\S+) is now known as (\S+)$`), func(target string, m []string) string {
+		return ":" + m[1] + " NICK " + m[2]
+	}},
+	{regexp.MustCompile(`^-!- (\S+) changed the topic of (\S+) to: (.*)$`), func(target string, m []string) string {
+		return ":" + m[1] + " TOPIC " + m[2] + " :" + m[3]
+	}},
+	{regexp.MustCompile(`^-!- mode/(\S+) \[(.*)\] by (\S+)$`), func(target string, m []string) string {
+		return ":" + m[3] + " MODE " + m[1] + " " + m[2]
+	}},
+	// this must come after the -!- forms
+	{regexp.MustCompile(`^-(\S+)- (.*)$`), func(target string, m []string) string {
+		return ":" + m[1] + " NOTICE " + target + " :" + m[2]
+	}},
+}
+
+func userPrefix(nick, userhost string) string {
+	if len(userhost) == 0 {
+		return nick
+	}
+	return nick + "!" + userhost
+}
+
+// Parses a line of a plain text log back into a message.
+func parseText(day time.Time, target string, line string) *parser.IrcMessage {
+	// 12:34:56 <nick> text
+	if len(line) < 10 || line[8] != ' ' {
+		return nil
+	}
+	clock, err := time.Parse("15:04:05", line[:8])
+	if err != nil {
+		return nil
+	}
+	when := time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, day.Location())
+
+	rest := line[9:]
+	for _, pattern := range textPatterns {
+		if m := pattern.re.FindStringSubmatch(rest); m != nil {
+			return withTime(parser.ParseLine(pattern.build(target, m)), when)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,415 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package parser
+
+import "net"
+import "strings"
+import "sync"
+
+// CaseMapping defines which characters an IRC server considers to be the
+// same when comparing nicknames and channel names, as advertised in the
+// CASEMAPPING ISUPPORT token.
+type CaseMapping int
+
+const (
+	// A-Z are equivalent to a-z, and []\~ are equivalent to {}|^. This is
+	// the default.
+	CaseMappingRFC1459 CaseMapping = iota
+
+	// As CaseMappingRFC1459, but ~ and ^ are distinct.
+	CaseMappingStrictRFC1459
+
+	// Only A-Z are equivalent to a-z.
+	CaseMappingASCII
+)
+
+// ParseCaseMapping returns the CaseMapping for the value of a CASEMAPPING
+// ISUPPORT token. Unknown values are treated as rfc1459.
+func ParseCaseMapping(name string) CaseMapping {
+	switch strings.ToLower(name) {
+	case "ascii":
+		return CaseMappingASCII
+	case "strict-rfc1459":
+		return CaseMappingStrictRFC1459
+	}
+	return CaseMappingRFC1459
+}
+
+func (this CaseMapping) foldRune(r rune) rune {
+	switch {
+	case r >= 'A' && r <= 'Z':
+		return r + ('a' - 'A')
+	case this == CaseMappingASCII:
+		return r
+	case r == '[':
+		return '{'
+	case r == ']':
+		return '}'
+	case r == '\\':
+		return '|'
+	case r == '~' && this == CaseMappingRFC1459:
+		return '^'
+	}
+	return r
+}
+
+// Fold returns s with every character mapped to its lowercase equivalent, so
+// that folded strings can be compared directly.
+func (this CaseMapping) Fold(s string) string {
+	return strings.Map(this.foldRune, s)
+}
+
+// Equal returns true if a and b are the same under the case mapping.
+func (this CaseMapping) Equal(a, b string) bool {
+	return this.Fold(a) == this.Fold(b)
+}
+
+// Glob returns true if s matches the IRC glob pattern, where * matches any
+// number of characters, ? matches exactly one, and a backslash makes the
+// following character match literally. Comparison uses the case mapping.
+func (this CaseMapping) Glob(pattern, s string) bool {
+	p := []rune(pattern)
+	str := []rune(this.Fold(s))
+
+	// the position to return to after a mismatch following a *
+	starP, starS := -1, 0
+	pi, si := 0, 0
+	for si < len(str) {
+		if pi < len(p) {
+			switch p[pi] {
+			case '*':
+				starP, starS = pi, si
+				pi++
+				continue
+			case '?':
+				pi++
+				si++
+				continue
+			case '\\':
+				if pi+1 < len(p) && this.foldRune(p[pi+1]) == str[si] {
+					pi += 2
+					si++
+					continue
+				}
+			default:
+				if this.foldRune(p[pi]) == str[si] {
+					pi++
+					si++
+					continue
+				}
+			}
+		}
+		if starP == -1 {
+			return false
+		}
+		// let the last * swallow one more character and try again
+		starS++
+		pi, si = starP+1, starS
+	}
+
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
+
+// Returns true if a pattern contains no wildcards, i.e. it can only match one
+// string. The unescaped form of the pattern is also returned.
+func literal(pattern string) (string, bool) {
+	if !strings.ContainsAny(pattern, "*?\\") {
+		return pattern, true
+	}
+	var unescaped []rune
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+			continue
+		case r == '*' || r == '?':
+			return "", false
+		}
+		unescaped = append(unescaped, r)
+	}
+	return string(unescaped), true
+}
+
+// Mask is a parsed hostmask, e.g. nick!user@host, for matching against
+// prefixes.
+//
+// Each part may contain IRC glob wildcards (see CaseMapping.Glob). The host
+// may also be given in CIDR notation (e.g. 192.0.2.0/24), which matches users
+// whose host is an IP address within that range.
+//
+// Masks of the form $a:account (an extban) match users logged in to the given
+// account instead, and $a alone matches anyone logged in.
+type Mask struct {
+	// The mask as it was given.
+	Raw string
+
+	Nick string
+	User string
+	Host string
+
+	// For $a masks, the account (or glob) to match. Empty for $a alone.
+	Account   string
+	IsAccount bool
+
+	network *net.IPNet
+}
+
+// ParseMask parses a hostmask. Missing parts are filled in with wildcards, so
+// that "nick" is the same as "nick!*@*", and "*@host" the same as "*!*@host".
+func ParseMask(mask string) Mask {
+	m := Mask{Raw: mask}
+
+	if strings.HasPrefix(mask, "$a") && (len(mask) == 2 || mask[2] == ':') {
+		m.IsAccount = true
+		if len(mask) > 3 {
+			m.Account = mask[3:]
+		}
+		return m
+	}
+
+	m.Nick, m.User, m.Host = "*", "*", "*"
+	rest := mask
+	if at := strings.LastIndex(rest, "@"); at != -1 {
+		m.Host = rest[at+1:]
+		rest = rest[:at]
+	}
+	if bang := strings.Index(rest, "!"); bang != -1 {
+		m.User = rest[bang+1:]
+		rest = rest[:bang]
+	}
+	if len(rest) > 0 {
+		m.Nick = rest
+	}
+	if len(m.User) == 0 {
+		m.User = "*"
+	}
+	if len(m.Host) == 0 {
+		m.Host = "*"
+	}
+
+	if strings.Contains(m.Host, "/") {
+		if _, network, err := net.ParseCIDR(m.Host); err == nil {
+			m.network = network
+		}
+	}
+	return m
+}
+
+// Match returns true if the mask matches the given prefix, using the given
+// case mapping. The account is that of the user, if known (e.g. from the
+// account-tag of a message), and is only used by $a masks.
+func (this *Mask) Match(prefix IrcPrefix, account string, cm CaseMapping) bool {
+	if this.IsAccount {
+		if len(account) == 0 || account == "*" {
+			return false
+		}
+		return len(this.Account) == 0 || cm.Glob(this.Account, account)
+	}
+
+	if prefix.Kind == PrefixServer || len(prefix.Nick) == 0 {
+		return false
+	}
+	if !cm.Glob(this.Nick, prefix.Nick) || !cm.Glob(this.User, prefix.User) {
+		return false
+	}
+	if this.network != nil {
+		ip := net.ParseIP(prefix.Host)
+		return ip != nil && this.network.Contains(ip)
+	}
+	return cm.Glob(this.Host, prefix.Host)
+}
+
+// Matches returns true if the prefix matches a hostmask (see Mask), using the
+// rfc1459 case mapping.
+func (this *IrcPrefix) Matches(mask string) bool {
+	m := ParseMask(mask)
+	return m.Match(*this, "", CaseMappingRFC1459)
+}
+
+// Account returns the account the sender of the message is logged in to, as
+// given by the account-tag capability, or an empty string if they aren't
+// logged in or the server didn't say.
+func (this *IrcMessage) Account() string {
+	account, ok := this.Tag("account")
+	if !ok || account == "*" {
+		return ""
+	}
+	return account
+}
+
+// MaskSet is a set of hostmasks that can be efficiently matched against,
+// e.g. for ignore lists or access control. It is safe for concurrent use.
+//
+// Masks with a literal nickname, a literal host, or a literal account are
+// indexed, so only a handful of masks need to be checked for any given
+// prefix, however many there are in the set.
+type MaskSet struct {
+	caseMapping CaseMapping
+
+	mutex     sync.RWMutex
+	masks     map[string]*Mask
+	byNick    map[string][]*Mask
+	byHost    map[string][]*Mask
+	byAcct    map[string][]*Mask
+	unindexed []*Mask
+}
+
+// NewMaskSet creates a MaskSet using the given case mapping, containing the
+// given masks.
+func NewMaskSet(cm CaseMapping, masks ...string) *MaskSet {
+	this := &MaskSet{
+		caseMapping: cm,
+		masks:       make(map[string]*Mask),
+		byNick:      make(map[string][]*Mask),
+		byHost:      make(map[string][]*Mask),
+		byAcct:      make(map[string][]*Mask),
+	}
+	for _, mask := range masks {
+		this.Add(mask)
+	}
+	return this
+}
+
+// Returns the index (and key within it) a mask belongs in, or nil if it can't
+// be indexed.
+func (this *MaskSet) indexFor(m *Mask) (map[string][]*Mask, string) {
+	if m.IsAccount {
+		if account, ok := literal(m.Account); ok && len(account) > 0 {
+			return this.byAcct, this.caseMapping.Fold(account)
+		}
+		return nil, ""
+	}
+	if nick, ok := literal(m.Nick); ok {
+		return this.byNick, this.caseMapping.Fold(nick)
+	}
+	if host, ok := literal(m.Host); ok && m.network == nil {
+		return this.byHost, strings.ToLower(host)
+	}
+	return nil, ""
+}
+
+// Add adds a mask to the set. Adding a mask that is already present does
+// nothing.
+func (this *MaskSet) Add(mask string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	key := this.caseMapping.Fold(mask)
+	if _, ok := this.masks[key]; ok {
+		return
+	}
+
+	m := ParseMask(mask)
+	this.masks[key] = &m
+	if index, k := this.indexFor(&m); index != nil {
+		index[k] = append(index[k], &m)
+	} else {
+		this.unindexed = append(this.unindexed, &m)
+	}
+}
+
+func without(masks []*Mask, m *Mask) []*Mask {
+	for idx, candidate := range masks {
+		if candidate == m {
+			return append(masks[:idx:idx], masks[idx+1:]...)
+		}
+	}
+	return masks
+}
+
+// Remove removes a mask from the set, returning false if it wasn't there.
+func (this *MaskSet) Remove(mask string) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	key := this.caseMapping.Fold(mask)
+	m, ok := this.masks[key]
+	if !ok {
+		return false
+	}
+
+	delete(this.masks, key)
+	if index, k := this.indexFor(m); index != nil {
+		index[k] = without(index[k], m)
+		if len(index[k]) == 0 {
+			delete(index, k)
+		}
+	} else {
+		this.unindexed = without(this.unindexed, m)
+	}
+	return true
+}
+
+// Len returns the number of masks in the set.
+func (this *MaskSet) Len() int {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	return len(this.masks)
+}
+
+// Masks returns the masks in the set, as they were added.
+func (this *MaskSet) Masks() []string {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	masks := make([]string, 0, len(this.masks))
+	for _, m := range this.masks {
+		masks = append(masks, m.Raw)
+	}
+	return masks
+}
+
+// Match returns the first mask in the set matching the given prefix, and
+// whether there was one. The account is that of the user, if known, for $a
+// masks.
+func (this *MaskSet) Match(prefix IrcPrefix, account string) (string, bool) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	cm := this.caseMapping
+	candidates := [][]*Mask{
+		this.byNick[cm.Fold(prefix.Nick)],
+		this.byHost[strings.ToLower(prefix.Host)],
+		this.unindexed,
+	}
+	if len(account) > 0 {
+		candidates = append(candidates, this.byAcct[cm.Fold(account)])
+	}
+
+	for _, masks := range candidates {
+		for _, m := range masks {
+			if m.Match(prefix, account, cm) {
+				return m.Raw, true
+			}
+		}
+	}
+	return "", false
+}
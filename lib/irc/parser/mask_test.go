@@ -0,0 +1,186 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package parser
+
+import "fmt"
+import "reflect"
+import "sort"
+import "testing"
+
+func TestGlob(t *testing.T) {
+	tests := []struct {
+		Pattern string
+		Input   string
+		Match   bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"w00t", "W00T", true},
+		{"w00t", "w00t_", false},
+		{"w?0t", "w00t", true},
+		{"w*t", "w00t", true},
+		{"w*t", "w00", false},
+		{"*.cows", "moo.cows", true},
+		{"*.cows", "moo.cowsx", false},
+		{"a*b*c", "aXbYbZc", true},
+		{"a*b*c", "aXbYbZ", false},
+		{"\\*", "*", true},
+		{"\\*", "x", false},
+		{"what\\?", "what?", true},
+		{"what\\?", "whatx", false},
+		{"[foo]", "{FOO}", true},
+		{"foo~", "FOO^", true},
+	}
+
+	for _, test := range tests {
+		if m := CaseMappingRFC1459.Glob(test.Pattern, test.Input); m != test.Match {
+			t.Errorf("Expected: %v for %#v against %#v, got %v", test.Match, test.Pattern, test.Input, m)
+		}
+	}
+
+	if CaseMappingASCII.Glob("[foo]", "{foo}") {
+		t.Errorf("Expected: [ and { to differ in ascii")
+	}
+	if CaseMappingStrictRFC1459.Glob("foo~", "foo^") {
+		t.Errorf("Expected: ~ and ^ to differ in strict-rfc1459")
+	}
+}
+
+func TestMatches(t *testing.T) {
+	prefix := ParsePrefix("W00t!toot@moo.cows")
+	ipv4 := ParsePrefix("w00t!toot@192.0.2.55")
+	ipv6 := ParsePrefix("w00t!toot@2001:db8::1")
+
+	tests := []struct {
+		Prefix IrcPrefix
+		Mask   string
+		Match  bool
+	}{
+		{prefix, "w00t", true},
+		{prefix, "w00t!*@*", true},
+		{prefix, "*!toot@*.cows", true},
+		{prefix, "*@moo.cows", true},
+		{prefix, "*!*@*.sheep", false},
+		{prefix, "other", false},
+		{ipv4, "*!*@192.0.2.0/24", true},
+		{ipv4, "*!*@192.0.3.0/24", false},
+		{ipv4, "*!*@192.0.2.*", true},
+		{ipv6, "*!*@2001:db8::/32", true},
+		{ipv6, "*!*@2001:db9::/32", false},
+		{prefix, "*!*@192.0.2.0/24", false},
+		{ParsePrefix("irc.example.org"), "*", false},
+		{prefix, "$a:w00t", false},
+	}
+
+	for _, test := range tests {
+		if m := test.Prefix.Matches(test.Mask); m != test.Match {
+			t.Errorf("Expected: %v for %#v against %#v, got %v", test.Match, test.Prefix.String(), test.Mask, m)
+		}
+	}
+}
+
+func TestAccountMask(t *testing.T) {
+	c := ParseLine("@account=W00t :someone!toot@moo.cows PRIVMSG #gobo :hi")
+	if c.Account() != "W00t" {
+		t.Errorf("Expected: %#v, got %#v", "W00t", c.Account())
+	}
+	if ParseLine("@account=* :someone!toot@moo.cows PRIVMSG #gobo :hi").Account() != "" {
+		t.Errorf("Expected: no account when logged out")
+	}
+
+	for _, test := range []struct {
+		Mask    string
+		Account string
+		Match   bool
+	}{
+		{"$a:w00t", "W00t", true},
+		{"$a:w00t", "other", false},
+		{"$a:w0*", "W00t", true},
+		{"$a", "W00t", true},
+		{"$a", "", false},
+	} {
+		m := ParseMask(test.Mask)
+		if match := m.Match(c.Prefix, test.Account, CaseMappingRFC1459); match != test.Match {
+			t.Errorf("Expected: %v for %#v against %#v, got %v", test.Match, test.Account, test.Mask, match)
+		}
+	}
+}
+
+func TestMaskSet(t *testing.T) {
+	set := NewMaskSet(CaseMappingRFC1459, "admin", "*!*@trusted.host", "*!*@192.0.2.0/24", "$a:root", "bad*!*@*")
+	set.Add("ADMIN") // duplicate
+
+	if set.Len() != 5 {
+		t.Errorf("Expected: 5 masks, got %d", set.Len())
+	}
+
+	tests := []struct {
+		Prefix  string
+		Account string
+		Mask    string
+	}{
+		{"Admin!x@y", "", "admin"},
+		{"someone!x@trusted.host", "", "*!*@trusted.host"},
+		{"someone!x@192.0.2.1", "", "*!*@192.0.2.0/24"},
+		{"someone!x@y", "ROOT", "$a:root"},
+		{"badguy!x@y", "", "bad*!*@*"},
+		{"someone!x@y", "", ""},
+	}
+
+	for _, test := range tests {
+		mask, ok := set.Match(ParsePrefix(test.Prefix), test.Account)
+		if mask != test.Mask || ok != (len(test.Mask) > 0) {
+			t.Errorf("Expected: %#v for %#v, got %#v (%v)", test.Mask, test.Prefix, mask, ok)
+		}
+	}
+
+	if !set.Remove("Admin") || set.Remove("admin") {
+		t.Errorf("Expected: admin to be removed exactly once")
+	}
+	if _, ok := set.Match(ParsePrefix("admin!x@y"), ""); ok {
+		t.Errorf("Expected: admin to no longer match")
+	}
+
+	masks := set.Masks()
+	sort.Strings(masks)
+	expected := []string{"$a:root", "*!*@192.0.2.0/24", "*!*@trusted.host", "bad*!*@*"}
+	if !reflect.DeepEqual(masks, expected) {
+		t.Errorf("Expected: %#v, got %#v", expected, masks)
+	}
+}
+
+func BenchmarkMaskSet(b *testing.B) {
+	set := NewMaskSet(CaseMappingRFC1459)
+	for i := 0; i < 10000; i++ {
+		set.Add(fmt.Sprintf("nick%d!*@*", i))
+		set.Add(fmt.Sprintf("*!*@host%d.example.org", i))
+	}
+	set.Add("*!*@*.wild.example.org")
+
+	prefix := ParsePrefix("someone!user@host.wild.example.org")
+	for i := 0; i < b.N; i++ {
+		set.Match(prefix, "")
+	}
+}
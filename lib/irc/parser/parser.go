@@ -29,12 +29,40 @@ package parser
 import "fmt"
 import "strings"
 
+// PrefixKind describes what an IrcPrefix refers to.
+type PrefixKind int
+
+const (
+	// There was no prefix, or it was invalid.
+	PrefixNone PrefixKind = iota
+
+	// A server, e.g. irc.example.org
+	PrefixServer
+
+	// A nickname only, e.g. w00t
+	PrefixNick
+
+	// A nickname and username, e.g. w00t!toot
+	PrefixNickUser
+
+	// A nickname and hostname, e.g. w00t@moo.cows
+	PrefixNickHost
+
+	// A full user prefix, e.g. w00t!toot@moo.cows
+	PrefixFullUser
+)
+
 // IrcPrefix represents the sender of an IrcMessage.
 // A prefix may either be a server, or a user. If the prefix is representing a
 // server, the Server member will be a non-empty string representing the
 // server name. If the prefix is representing a user, the Nick, User and Host
 // fields will be filled (as much as is possible from the given message).
+//
+// Kind says which of these forms the prefix was parsed from.
 type IrcPrefix struct {
+	// What form the prefix takes.
+	Kind PrefixKind
+
 	// The name of the server this prefix represents.
 	Server string
 
@@ -70,14 +98,85 @@ func (this *IrcPrefix) String() string {
 		return this.Server
 	}
 
-	// we have two possible forms that are valid:
+	// we have four possible forms that are valid:
 	// nick
+	// nick!user
+	// nick@host
 	// nick!user@host
+	str := this.Nick
 	if len(this.User) > 0 {
-		return fmt.Sprintf("%s!%s@%s", this.Nick, this.User, this.Host)
+		str += "!" + this.User
+	}
+	if len(this.Host) > 0 {
+		str += "@" + this.Host
+	}
+	return str
+}
+
+// ParsePrefix parses a prefix (without the leading colon), e.g.
+// "nick!user@host".
+//
+// A prefix without a ! or @ may be either a server or a nickname, and this
+// can't be told from the prefix alone: one containing a dot is taken to be a
+// server. Parser.ParseLine is able to make a better decision.
+func ParsePrefix(pfx string) IrcPrefix {
+	return defaultParser.ParsePrefix(pfx)
+}
+
+// Parses the user forms of a prefix: nick!user@host, nick!user and nick@host.
+// A bare prefix is returned as a nickname.
+func parseUserPrefix(pfx string) IrcPrefix {
+	var prefix IrcPrefix
+
+	// the nick ends at the first ! or @. the user, if any, ends at the
+	// first @ after that. everything after that is the host, which may
+	// contain colons (IPv6) but never an @.
+	end := strings.IndexAny(pfx, "!@")
+	if end == -1 {
+		if len(pfx) > 0 {
+			prefix.Kind = PrefixNick
+			prefix.Nick = pfx
+		}
+		return prefix
+	}
+
+	nick := pfx[:end]
+	if len(nick) == 0 {
+		return prefix
 	}
 
-	return this.Nick
+	var user, host string
+	rest := pfx[end:]
+	if rest[0] == '!' {
+		rest = rest[1:]
+		at := strings.Index(rest, "@")
+		if at == -1 {
+			user = rest
+			if len(user) == 0 || strings.Contains(user, "!") {
+				return prefix
+			}
+			prefix.Kind = PrefixNickUser
+		} else {
+			user = rest[:at]
+			host = rest[at+1:]
+			if len(user) == 0 || len(host) == 0 || strings.Contains(user, "!") || strings.ContainsAny(host, "!@") {
+				return prefix
+			}
+			prefix.Kind = PrefixFullUser
+		}
+	} else {
+		// nick@host. a ! after the @ (nick@user!host) is invalid.
+		host = rest[1:]
+		if len(host) == 0 || strings.ContainsAny(host, "!@") {
+			return prefix
+		}
+		prefix.Kind = PrefixNickHost
+	}
+
+	prefix.Nick = nick
+	prefix.User = user
+	prefix.Host = host
+	return prefix
 }
 
 // IrcMessage is the primary interface for interaction with the parser. The
@@ -117,7 +216,8 @@ func (this *IrcMessage) String() string {
 
 	if len(this.Parameters) > 0 {
 		pcount := len(this.Parameters)
-		if strings.Contains(this.Parameters[pcount-1], " ") {
+		last := this.Parameters[pcount-1]
+		if strings.Contains(last, " ") || len(last) == 0 || strings.HasPrefix(last, ":") {
 			if pcount > 1 {
 				parameters = strings.Join(this.Parameters[0:pcount-1], " ")
 				parameters = fmt.Sprintf(" %s :%s", parameters, this.Parameters[pcount-1])
@@ -133,27 +233,165 @@ func (this *IrcMessage) String() string {
 	return fmt.Sprintf("%s%s%s", prefix, this.Command, parameters)
 }
 
+// Serialize converts an IrcMessage to its string representation, like String,
+// but also includes any message tags.
+func (this *IrcMessage) Serialize() string {
+	if len(this.Tags) == 0 {
+		return this.String()
+	}
+
+	tags := make([]string, 0, len(this.Tags))
+	for _, tag := range this.Tags {
+		key := tag.Key
+		if len(tag.VendorPrefix) > 0 {
+			key = tag.VendorPrefix + "/" + key
+		}
+		if len(tag.Value) > 0 {
+			key += "=" + tagEscaper.Replace(tag.Value)
+		}
+		tags = append(tags, key)
+	}
+
+	return "@" + strings.Join(tags, ";") + " " + this.String()
+}
+
+var tagEscaper = strings.NewReplacer("\\", "\\\\", ";", "\\:", " ", "\\s", "\r", "\\r", "\n", "\\n")
+
+// Tag returns the value of the tag with the given key, and whether the tag
+// was present at all. Vendor-prefixed tags are looked up by their full name,
+// e.g. "znc.in/server-time-iso".
+func (this *IrcMessage) Tag(key string) (string, bool) {
+	for _, tag := range this.Tags {
+		if tag.Key == key && len(tag.VendorPrefix) == 0 {
+			return tag.Value, true
+		}
+		if len(tag.VendorPrefix) > 0 && tag.VendorPrefix+"/"+tag.Key == key {
+			return tag.Value, true
+		}
+	}
+	return "", false
+}
+
 // Given a string line, splits by a space delimiter and returns the first word
-// in arg, and the rest of the string for further processing.
+// in arg, and the rest of the string for further processing. Runs of spaces
+// are treated as a single delimiter.
 func splitArg(line string) (arg string, rest string) {
+	line = strings.TrimLeft(line, " ")
 	parts := strings.SplitN(line, " ", 2)
 	if len(parts) > 0 {
 		arg = parts[0]
 	}
 	if len(parts) > 1 {
-		rest = parts[1]
+		rest = strings.TrimLeft(parts[1], " ")
 	}
 	return
 }
 
+// Mode says which direction the lines given to a Parser are flowing in.
+type Mode int
+
+const (
+	// Lines sent by a server to a client. This is the default.
+	ServerToClient Mode = iota
+
+	// Lines sent by a client to a server. These normally have no prefix, and
+	// if one is present it can only name the client itself.
+	ClientToServer
+)
+
+// Commands that are only ever sent by users, so a bare prefix on them is a
+// nickname, even if it looks like a server name.
+var userCommands = map[string]bool{
+	"PRIVMSG": true, "JOIN": true, "PART": true, "QUIT": true, "NICK": true,
+	"INVITE": true, "AWAY": true, "ACCOUNT": true, "CHGHOST": true,
+	"SETNAME": true,
+}
+
+// Parser holds what is known about the connection that lines are being parsed
+// for. This is used to interpret a bare prefix (one without a ! or @), which
+// may be either a server or a nickname.
+//
+// The zero value is ready to use, and parses lines sent by a server.
+type Parser struct {
+	// The direction the lines being parsed are flowing in.
+	Mode Mode
+
+	// Names known to belong to servers, e.g. from RPL_MYINFO (004).
+	servers map[string]bool
+}
+
+var defaultParser Parser
+
+// AddServer records the name of a server, so that a bare prefix matching it is
+// always taken to be a server, and one that doesn't is more likely to be taken
+// as a nickname.
+func (this *Parser) AddServer(name string) {
+	if this.servers == nil {
+		this.servers = make(map[string]bool)
+	}
+	this.servers[strings.ToLower(name)] = true
+}
+
+// ParsePrefix parses a prefix (without the leading colon). See also the
+// package level ParsePrefix.
+func (this *Parser) ParsePrefix(pfx string) IrcPrefix {
+	return this.parsePrefix(pfx, "")
+}
+
+func (this *Parser) parsePrefix(pfx string, command string) IrcPrefix {
+	if strings.ContainsAny(pfx, "!@") || this.Mode == ClientToServer {
+		return parseUserPrefix(pfx)
+	}
+	if len(pfx) == 0 {
+		return IrcPrefix{}
+	}
+
+	// a bare prefix: either a server, or a nickname.
+	isServer := false
+	switch {
+	case this.servers[strings.ToLower(pfx)]:
+		isServer = true
+	case len(command) == 3 && strings.Trim(command, "0123456789") == "":
+		// numerics are only sent by servers
+		isServer = true
+	case userCommands[command]:
+		isServer = false
+	default:
+		// nicknames can't normally contain a dot, and server names
+		// normally do.
+		isServer = strings.Contains(pfx, ".")
+	}
+
+	if isServer {
+		return IrcPrefix{Kind: PrefixServer, Server: pfx}
+	}
+	return parseUserPrefix(pfx)
+}
+
 // ParseLine takes the given IRC protocol message in line and processes it.
 //
 // It returns a usable IrcMessage struct instance.
 func ParseLine(line string) *IrcMessage {
+	return defaultParser.ParseLine(line)
+}
+
+// ParseClientLine is like ParseLine, but parses a line sent by a client to a
+// server, such as a bouncer or a server implementation would receive.
+func ParseClientLine(line string) *IrcMessage {
+	p := Parser{Mode: ClientToServer}
+	return p.ParseLine(line)
+}
+
+// ParseLine takes the given IRC protocol message in line and processes it.
+//
+// It returns a usable IrcMessage struct instance.
+func (this *Parser) ParseLine(line string) *IrcMessage {
 	// BUG(w00t): ParseLine does not currently have a way of reporting errors.
 	args := []string{}
 	command := new(IrcMessage)
 
+	line = strings.TrimRight(line, "\r\n")
+
 	// ircv3 message tags extension
 	if strings.HasPrefix(line, "@") {
 		var tagstr string
@@ -200,37 +438,12 @@ func ParseLine(line string) *IrcMessage {
 		}
 	}
 
+	var pfx string
 	if strings.HasPrefix(line, ":") {
-		var pfx string
 		pfx, line = splitArg(line)
 		pfx = pfx[1:]
-
-		// foo!bar@moo is nick!user@host
-		// foo is nick
-		// foo.bar.moo is a server
-		//
-		// therefore: if we see a ! OR we see no ., we enter this branch...
-		if strings.Contains(pfx, "!") || !strings.Contains(pfx, ".") {
-			bang := strings.Index(pfx, "!")
-			at := strings.Index(pfx, "@") //  TODO: would LastIndex be faster? maybe not, host is usually long.
-			if bang == -1 && at == -1 {
-				command.Prefix.Nick = pfx
-			} else if bang == -1 {
-				// nick@host? invalid case, we empty the prefix
-			} else if at == -1 {
-				// nick!user? invalid case, we empty the prefix
-			} else if bang > at {
-				// nick@user!host or similar => automatically invalid
-			} else {
-				command.Prefix.Nick = pfx[0:bang]
-				command.Prefix.User = pfx[bang+1 : at]
-				command.Prefix.Host = pfx[at+1:]
-			}
-		} else {
-			// message from a server
-			command.Prefix.Server = pfx
-		}
 	}
+
 	arg, line := splitArg(line)
 	command.Command = strings.ToUpper(arg)
 	for len(line) > 0 {
@@ -242,5 +455,9 @@ func ParseLine(line string) *IrcMessage {
 		args = append(args, arg)
 	}
 	command.Parameters = args
+
+	// the prefix is interpreted last, as the command helps to tell whether
+	// it's a server or a user.
+	command.Prefix = this.parsePrefix(pfx, command.Command)
 	return command
 }
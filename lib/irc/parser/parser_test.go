@@ -41,7 +41,7 @@ func TestParse(t *testing.T) {
 		{
 			":w00t TEST",
 			[]IrcTag{},
-			IrcPrefix{Nick: "w00t"},
+			IrcPrefix{Kind: PrefixNick, Nick: "w00t"},
 			"TEST",
 			[]string{},
 			":w00t TEST",
@@ -49,7 +49,7 @@ func TestParse(t *testing.T) {
 		{
 			":w00t TEST hello",
 			[]IrcTag{},
-			IrcPrefix{Nick: "w00t"},
+			IrcPrefix{Kind: PrefixNick, Nick: "w00t"},
 			"TEST",
 			[]string{"hello"},
 			":w00t TEST hello",
@@ -57,7 +57,7 @@ func TestParse(t *testing.T) {
 		{
 			":w00t TEST hello world",
 			[]IrcTag{},
-			IrcPrefix{Nick: "w00t"},
+			IrcPrefix{Kind: PrefixNick, Nick: "w00t"},
 			"TEST",
 			[]string{"hello", "world"},
 			":w00t TEST hello world",
@@ -65,7 +65,7 @@ func TestParse(t *testing.T) {
 		{
 			":w00t TEST :hello world",
 			[]IrcTag{},
-			IrcPrefix{Nick: "w00t"},
+			IrcPrefix{Kind: PrefixNick, Nick: "w00t"},
 			"TEST",
 			[]string{"hello world"},
 			":w00t TEST :hello world",
@@ -73,7 +73,7 @@ func TestParse(t *testing.T) {
 		{
 			":w00t TEST hello world :how are you today",
 			[]IrcTag{},
-			IrcPrefix{Nick: "w00t"},
+			IrcPrefix{Kind: PrefixNick, Nick: "w00t"},
 			"TEST",
 			[]string{"hello", "world", "how are you today"},
 			":w00t TEST hello world :how are you today",
@@ -119,12 +119,28 @@ func TestParse(t *testing.T) {
 			[]string{"hello", "world", "how are you today"},
 			"TEST hello world :how are you today",
 		},
+		{
+			"TEST hello ::)",
+			[]IrcTag{},
+			IrcPrefix{},
+			"TEST",
+			[]string{"hello", ":)"},
+			"TEST hello ::)",
+		},
+		{
+			"TEST hello :",
+			[]IrcTag{},
+			IrcPrefix{},
+			"TEST",
+			[]string{"hello", ""},
+			"TEST hello :",
+		},
 
 		// test prefix parsing
 		{
 			":w00t TEST",
 			[]IrcTag{},
-			IrcPrefix{Nick: "w00t"},
+			IrcPrefix{Kind: PrefixNick, Nick: "w00t"},
 			"TEST",
 			[]string{},
 			":w00t TEST",
@@ -132,7 +148,7 @@ func TestParse(t *testing.T) {
 		{
 			":w00t!toot@moo TEST",
 			[]IrcTag{},
-			IrcPrefix{Nick: "w00t", User: "toot", Host: "moo"},
+			IrcPrefix{Kind: PrefixFullUser, Nick: "w00t", User: "toot", Host: "moo"},
 			"TEST",
 			[]string{},
 			":w00t!toot@moo TEST",
@@ -140,7 +156,7 @@ func TestParse(t *testing.T) {
 		{
 			":w00t!toot@moo.cows TEST",
 			[]IrcTag{},
-			IrcPrefix{Nick: "w00t", User: "toot", Host: "moo.cows"},
+			IrcPrefix{Kind: PrefixFullUser, Nick: "w00t", User: "toot", Host: "moo.cows"},
 			"TEST",
 			[]string{},
 			":w00t!toot@moo.cows TEST",
@@ -148,7 +164,7 @@ func TestParse(t *testing.T) {
 		{
 			":w00t.toot.moo.cows TEST",
 			[]IrcTag{},
-			IrcPrefix{Server: "w00t.toot.moo.cows"},
+			IrcPrefix{Kind: PrefixServer, Server: "w00t.toot.moo.cows"},
 			"TEST",
 			[]string{},
 			":w00t.toot.moo.cows TEST",
@@ -156,19 +172,69 @@ func TestParse(t *testing.T) {
 		{
 			":w00t!toot TEST",
 			[]IrcTag{},
+			IrcPrefix{Kind: PrefixNickUser, Nick: "w00t", User: "toot"},
+			"TEST",
+			[]string{},
+			":w00t!toot TEST",
+		},
+		{
+			":w00t@toot TEST",
+			[]IrcTag{},
+			IrcPrefix{Kind: PrefixNickHost, Nick: "w00t", Host: "toot"},
+			"TEST",
+			[]string{},
+			":w00t@toot TEST",
+		},
+		{
+			":w00t!toot@2001:db8::1 TEST",
+			[]IrcTag{},
+			IrcPrefix{Kind: PrefixFullUser, Nick: "w00t", User: "toot", Host: "2001:db8::1"},
+			"TEST",
+			[]string{},
+			":w00t!toot@2001:db8::1 TEST",
+		},
+		{
+			":w00t@toot!moo TEST",
+			[]IrcTag{},
 			IrcPrefix{}, // invalid
 			"TEST",
 			[]string{},
 			"TEST",
 		},
 		{
-			":w00t@toot TEST",
+			":w00t! TEST",
 			[]IrcTag{},
 			IrcPrefix{}, // invalid
 			"TEST",
 			[]string{},
 			"TEST",
 		},
+		{
+			// a dot doesn't make a server if the command is only sent by users
+			":w00t.toot PRIVMSG #gobo :hello",
+			[]IrcTag{},
+			IrcPrefix{Kind: PrefixNick, Nick: "w00t.toot"},
+			"PRIVMSG",
+			[]string{"#gobo", "hello"},
+			":w00t.toot PRIVMSG #gobo hello",
+		},
+		{
+			// and numerics are only ever sent by servers
+			":localhost 001 w00t :Welcome",
+			[]IrcTag{},
+			IrcPrefix{Kind: PrefixServer, Server: "localhost"},
+			"001",
+			[]string{"w00t", "Welcome"},
+			":localhost 001 w00t Welcome",
+		},
+		{
+			"TEST   lots  of   :spaces  here  ",
+			[]IrcTag{},
+			IrcPrefix{},
+			"TEST",
+			[]string{"lots", "of", "spaces  here  "},
+			"TEST lots of :spaces  here  ",
+		},
 		{
 			":@! TEST",
 			[]IrcTag{},
@@ -182,7 +248,7 @@ func TestParse(t *testing.T) {
 		{
 			"@aaaa :w00t TEST",
 			[]IrcTag{{Key: "aaaa"}},
-			IrcPrefix{Nick: "w00t"},
+			IrcPrefix{Kind: PrefixNick, Nick: "w00t"},
 			"TEST",
 			[]string{},
 			":w00t TEST",
@@ -190,7 +256,7 @@ func TestParse(t *testing.T) {
 		{
 			"@aaaa;bbb;cccc :w00t TEST",
 			[]IrcTag{{Key: "aaaa"}, {Key: "bbb"}, {Key: "cccc"}},
-			IrcPrefix{Nick: "w00t"},
+			IrcPrefix{Kind: PrefixNick, Nick: "w00t"},
 			"TEST",
 			[]string{},
 			":w00t TEST",
@@ -198,7 +264,7 @@ func TestParse(t *testing.T) {
 		{
 			"@aaaa=test;bbb :w00t TEST",
 			[]IrcTag{{Key: "aaaa", Value: "test"}, {Key: "bbb"}},
-			IrcPrefix{Nick: "w00t"},
+			IrcPrefix{Kind: PrefixNick, Nick: "w00t"},
 			"TEST",
 			[]string{},
 			":w00t TEST",
@@ -206,7 +272,7 @@ func TestParse(t *testing.T) {
 		{
 			"@example.org/aaaa=test;bbb :w00t TEST",
 			[]IrcTag{{VendorPrefix: "example.org", Key: "aaaa", Value: "test"}, {Key: "bbb"}},
-			IrcPrefix{Nick: "w00t"},
+			IrcPrefix{Kind: PrefixNick, Nick: "w00t"},
 			"TEST",
 			[]string{},
 			":w00t TEST",
@@ -214,7 +280,7 @@ func TestParse(t *testing.T) {
 		{
 			"@example.org/aaaa=test;another.example.org/bbb :w00t TEST",
 			[]IrcTag{{VendorPrefix: "example.org", Key: "aaaa", Value: "test"}, {VendorPrefix: "another.example.org", Key: "bbb"}},
-			IrcPrefix{Nick: "w00t"},
+			IrcPrefix{Kind: PrefixNick, Nick: "w00t"},
 			"TEST",
 			[]string{},
 			":w00t TEST",
@@ -222,7 +288,7 @@ func TestParse(t *testing.T) {
 		{
 			"@aaaa=test;bbb=another :w00t TEST",
 			[]IrcTag{{Key: "aaaa", Value: "test"}, {Key: "bbb", Value: "another"}},
-			IrcPrefix{Nick: "w00t"},
+			IrcPrefix{Kind: PrefixNick, Nick: "w00t"},
 			"TEST",
 			[]string{},
 			":w00t TEST",
@@ -231,7 +297,7 @@ func TestParse(t *testing.T) {
 			// test escaping of tag values
 			"@aaaa=magic\\:things\\s\\\\happen\\rhere\\nsometimes :w00t TEST",
 			[]IrcTag{{Key: "aaaa", Value: "magic;things \\happen\rhere\nsometimes"}},
-			IrcPrefix{Nick: "w00t"},
+			IrcPrefix{Kind: PrefixNick, Nick: "w00t"},
 			"TEST",
 			[]string{},
 			":w00t TEST",
@@ -280,10 +346,78 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestParser(t *testing.T) {
+	var p Parser
+	if prefix := p.ParsePrefix("irc"); prefix.Kind != PrefixNick {
+		t.Errorf("Expected: nick, got %#v", prefix)
+	}
+	if prefix := p.ParsePrefix("foo.bar"); prefix.Kind != PrefixServer {
+		t.Errorf("Expected: server, got %#v", prefix)
+	}
+
+	// once the server is known, a bare prefix matching it is always a server.
+	p.AddServer("irc")
+	if c := p.ParseLine(":irc MODE w00t +i"); c.Prefix.Kind != PrefixServer || c.Prefix.Server != "irc" {
+		t.Errorf("Expected: server, got %#v", c.Prefix)
+	}
+}
+
+func TestParseClientLine(t *testing.T) {
+	c := ParseClientLine("privmsg #gobo :hello world\r\n")
+	if c.Command != "PRIVMSG" || c.Prefix.Kind != PrefixNone || !reflect.DeepEqual(c.Parameters, []string{"#gobo", "hello world"}) {
+		t.Errorf("Unexpected message: %#v", c)
+	}
+
+	// clients may only name themselves in a prefix
+	c = ParseClientLine(":w00t.toot NICK moo")
+	if c.Prefix != (IrcPrefix{Kind: PrefixNick, Nick: "w00t.toot"}) {
+		t.Errorf("Unexpected prefix: %#v", c.Prefix)
+	}
+}
+
+func TestTag(t *testing.T) {
+	c := ParseLine("@time=2015-09-16T14:16:52.000Z;example.org/aaaa=test;bbb :w00t TEST")
+
+	tests := []struct {
+		Key   string
+		Value string
+		Ok    bool
+	}{
+		{"time", "2015-09-16T14:16:52.000Z", true},
+		{"example.org/aaaa", "test", true},
+		{"aaaa", "", false},
+		{"bbb", "", true},
+		{"ccc", "", false},
+	}
+
+	for _, test := range tests {
+		value, ok := c.Tag(test.Key)
+		if value != test.Value || ok != test.Ok {
+			t.Errorf("Expected: %#v (%v) for %s, got %#v (%v)", test.Value, test.Ok, test.Key, value, ok)
+		}
+	}
+}
+
+func TestSerialize(t *testing.T) {
+	tests := []string{
+		":w00t TEST :hello world",
+		"@aaaa :w00t TEST",
+		"@example.org/aaaa=test;bbb :w00t TEST hello",
+		"@aaaa=magic\\:things\\s\\\\happen :w00t TEST",
+	}
+
+	for _, test := range tests {
+		c := ParseLine(test)
+		if c.Serialize() != test {
+			t.Errorf("Expected: %#v, got %#v", test, c.Serialize())
+		}
+	}
+}
+
 func BenchmarkString(b *testing.B) {
 	c := ParseLine(":w00t TEST :hello world")
 	for i := 0; i < b.N; i++ {
-		c.String()
+		_ = c.String()
 	}
 }
 
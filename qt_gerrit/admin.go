@@ -0,0 +1,375 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"github.com/rburchell/gobo/lib/irc/parser"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AdminConfig says where the bot talks about itself, and who may control
+// it.
+type AdminConfig struct {
+	// Where diagnostics (e.g. losing the connection to Gerrit) are posted,
+	// besides the log. If empty, they're only logged.
+	Channel string `toml:"channel"`
+
+	// Who may use admin commands, by private message: anyone logged in to
+	// one of Accounts, or matching one of Masks (e.g. *!*@qt/staff/*).
+	Accounts []string `toml:"accounts"`
+	Masks    []string `toml:"masks"`
+	admins   *parser.MaskSet
+}
+
+func (this *AdminConfig) validate(config *Config) []error {
+	var errs []error
+	if len(this.Channel) > 0 && !config.IRC.HasChannel(this.Channel) {
+		errs = append(errs, fmt.Errorf("admin.channel: %s is not in irc.channels", this.Channel))
+	}
+
+	this.admins = parser.NewMaskSet(parser.CaseMappingRFC1459)
+	for idx, account := range this.Accounts {
+		if len(account) == 0 || strings.ContainsAny(account, " *?") {
+			errs = append(errs, fmt.Errorf("admin.accounts[%d]: %q is not an account name", idx, account))
+			continue
+		}
+		this.admins.Add("$a:" + account)
+	}
+	for idx, mask := range this.Masks {
+		parsed := parser.ParseMask(mask)
+		if len(mask) == 0 || strings.Contains(mask, " ") {
+			errs = append(errs, fmt.Errorf("admin.masks[%d]: %q is not a hostmask", idx, mask))
+		} else if (parsed.IsAccount && len(parsed.Account) == 0) ||
+			(!parsed.IsAccount && parsed.Nick == "*" && parsed.User == "*" && parsed.Host == "*") {
+			errs = append(errs, fmt.Errorf("admin.masks[%d]: %q would match anyone", idx, mask))
+		} else {
+			this.admins.Add(mask)
+		}
+	}
+	return errs
+}
+
+// IsAdmin returns whether the sender of a message (logged in as account, if
+// known) is an admin, and the mask that says so.
+func (this *AdminConfig) IsAdmin(prefix parser.IrcPrefix, account string) (string, bool) {
+	if this.admins == nil {
+		return "", false
+	}
+	return this.admins.Match(prefix, account)
+}
+
+// A mute stops events of a type (or all, for "*") being announced in a
+// channel, until a time.
+type mute struct {
+	channel   string
+	eventType string
+	until     time.Time
+}
+
+func (this *mute) String() string {
+	eventType := this.eventType
+	if eventType == "*" {
+		eventType = "all events"
+	}
+	return fmt.Sprintf("%s in %s until %s", eventType, this.channel, this.until.UTC().Format("Jan 2 15:04 MST"))
+}
+
+// A muteList holds the mutes set up with !mute. They're forgotten on
+// restart.
+type muteList struct {
+	now func() time.Time
+
+	mutex sync.Mutex
+	mutes map[string]*mute // keyed by lowercased channel, and event type
+}
+
+// The mutes of the running bot.
+var mutes = newMuteList(time.Now)
+
+func newMuteList(now func() time.Time) *muteList {
+	return &muteList{now: now, mutes: map[string]*mute{}}
+}
+
+func muteKey(channel string, eventType string) string {
+	return strings.ToLower(channel) + " " + eventType
+}
+
+// Mute eventType (or all events, for "*") in channel until the given time.
+func (this *muteList) Mute(channel string, eventType string, until time.Time) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.mutes[muteKey(channel, eventType)] = &mute{channel: channel, eventType: eventType, until: until}
+}
+
+// Unmute eventType in channel, or for "*", everything muted there. Returns
+// how many mutes were undone.
+func (this *muteList) Unmute(channel string, eventType string) int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	undone := 0
+	for key, m := range this.mutes {
+		if strings.EqualFold(m.channel, channel) && (eventType == "*" || m.eventType == eventType) {
+			delete(this.mutes, key)
+			undone++
+		}
+	}
+	return undone
+}
+
+// Muted returns true if events of eventType are muted in channel.
+func (this *muteList) Muted(channel string, eventType string) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	now := this.now()
+	for _, key := range []string{muteKey(channel, eventType), muteKey(channel, "*")} {
+		if m, ok := this.mutes[key]; ok {
+			if now.Before(m.until) {
+				return true
+			}
+			delete(this.mutes, key)
+		}
+	}
+	return false
+}
+
+// List describes the mutes in effect, sorted.
+func (this *muteList) List() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	now := this.now()
+	var list []string
+	for key, m := range this.mutes {
+		if !now.Before(m.until) {
+			delete(this.mutes, key)
+			continue
+		}
+		list = append(list, m.String())
+	}
+	sort.Strings(list)
+	return list
+}
+
+// adminCommands carries out the commands admins give the bot privately.
+type adminCommands struct {
+	getConfig func() *Config
+	setConfig func(config *Config)
+	join      func(channel string)
+	part      func(channel string)
+	reload    func() error
+	reconnect func() bool // reconnect to Gerrit
+	mutes     *muteList
+	monitor   *botMonitor
+	now       func() time.Time
+}
+
+var adminCommandRegex = regexp.MustCompile(`^!(join|part|mute|unmute|mutes|reload|status|reconnect|admin)(?:\s+(.*))?$`)
+var channelNameRegex = regexp.MustCompile(`^[#&][^\s,\x07]+$`)
+
+var adminHelp = []string{
+	"!join <channel>, !part <channel>: join or leave a channel, until the config is reloaded",
+	"!mute <channel> <event type|all> [duration]: stop announcing events in a channel, for an hour unless told otherwise (e.g. 30m, 4h)",
+	"!unmute <channel> [event type|all]: undo !mute; !mutes: list what's muted",
+	"!reload: reload the config",
+	"!status: say how the bot is doing",
+	"!reconnect: reconnect to Gerrit",
+}
+
+// Command carries out text, if it's an admin command, sent privately by
+// prefix (logged in as account, if known), returning the replies. Returns
+// false if it isn't an admin command.
+func (this *adminCommands) Command(prefix parser.IrcPrefix, account string, text string) ([]string, bool) {
+	match := adminCommandRegex.FindStringSubmatch(strings.TrimSpace(text))
+	if match == nil {
+		return nil, false
+	}
+
+	config := this.getConfig()
+	mask, ok := config.Admin.IsAdmin(prefix, account)
+	if !ok {
+		fmt.Printf("Refused admin command from %s (account %q): %s\n", prefix.String(), account, text)
+		return []string{"Only admins can do that"}, true
+	}
+	fmt.Printf("Admin command from %s (as %s): %s\n", prefix.String(), mask, text)
+
+	args := strings.Fields(match[2])
+	switch match[1] {
+	case "join":
+		return this.joinChannel(config, args), true
+	case "part":
+		return this.partChannel(config, args), true
+	case "mute":
+		return this.mute(config, args), true
+	case "unmute":
+		return this.unmute(args), true
+	case "mutes":
+		if list := this.mutes.List(); len(list) > 0 {
+			return append([]string{"Muted:"}, list...), true
+		}
+		return []string{"Nothing is muted"}, true
+	case "reload":
+		if err := this.reload(); err != nil {
+			return append([]string{"Couldn't reload the config:"}, strings.Split(err.Error(), "\n")...), true
+		}
+		return []string{"Reloaded the config"}, true
+	case "status":
+		return this.status(), true
+	case "reconnect":
+		if this.reconnect() {
+			return []string{"Reconnecting to Gerrit"}, true
+		}
+		return []string{"I'm not connected to Gerrit, but I'm trying to be"}, true
+	}
+	return adminHelp, true
+}
+
+// Replace the channels the bot is in, in a copy of config.
+func withChannels(config *Config, channels []string) *Config {
+	updated := *config
+	updated.IRC.Channels = channels
+	return &updated
+}
+
+func (this *adminCommands) joinChannel(config *Config, args []string) []string {
+	if len(args) != 1 {
+		return []string{"Usage: !join <channel>"}
+	}
+	channel := args[0]
+	if !channelNameRegex.MatchString(channel) {
+		return []string{fmt.Sprintf("%s isn't a channel", channel)}
+	}
+	if config.IRC.HasChannel(channel) {
+		return []string{fmt.Sprintf("I'm already in %s", channel)}
+	}
+
+	channels := append(append([]string(nil), config.IRC.Channels...), channel)
+	this.setConfig(withChannels(config, channels))
+	this.join(channel)
+	return []string{fmt.Sprintf("Joining %s", channel)}
+}
+
+func (this *adminCommands) partChannel(config *Config, args []string) []string {
+	if len(args) != 1 {
+		return []string{"Usage: !part <channel>"}
+	}
+	channel := args[0]
+	if !config.IRC.HasChannel(channel) {
+		return []string{fmt.Sprintf("I'm not in %s", channel)}
+	}
+	if uses := config.ChannelUses(channel); len(uses) > 0 {
+		return []string{fmt.Sprintf("%s is used by %s; change the config to leave it", channel, strings.Join(uses, ", "))}
+	}
+
+	var channels []string
+	for _, existing := range config.IRC.Channels {
+		if !strings.EqualFold(existing, channel) {
+			channels = append(channels, existing)
+		}
+	}
+	this.setConfig(withChannels(config, channels))
+	this.part(channel)
+	return []string{fmt.Sprintf("Leaving %s", channel)}
+}
+
+func (this *adminCommands) mute(config *Config, args []string) []string {
+	if len(args) < 2 || len(args) > 3 {
+		return []string{"Usage: !mute <channel> <event type|all> [duration]"}
+	}
+	channel, eventType := args[0], args[1]
+	if !config.IRC.HasChannel(channel) {
+		return []string{fmt.Sprintf("I'm not in %s", channel)}
+	}
+	if eventType == "all" {
+		eventType = "*"
+	} else if _, ok := eventHandlers[eventType]; !ok {
+		return []string{fmt.Sprintf("%s isn't an event type (known: %s)", eventType, strings.Join(handledEventTypes(), ", "))}
+	}
+
+	duration := time.Hour
+	if len(args) == 3 {
+		var err error
+		if duration, err = time.ParseDuration(args[2]); err != nil || duration <= 0 {
+			return []string{fmt.Sprintf("%s isn't a duration (e.g. 30m, 4h)", args[2])}
+		}
+	}
+
+	m := mute{channel: channel, eventType: eventType, until: this.now().Add(duration)}
+	this.mutes.Mute(m.channel, m.eventType, m.until)
+	return []string{"Muted " + m.String()}
+}
+
+func (this *adminCommands) unmute(args []string) []string {
+	if len(args) < 1 || len(args) > 2 {
+		return []string{"Usage: !unmute <channel> [event type|all]"}
+	}
+	channel, eventType := args[0], "*"
+	if len(args) == 2 && args[1] != "all" {
+		eventType = args[1]
+	}
+	if this.mutes.Unmute(channel, eventType) == 0 {
+		if eventType == "*" {
+			return []string{fmt.Sprintf("Nothing is muted in %s", channel)}
+		}
+		return []string{fmt.Sprintf("%s isn't muted in %s", eventType, channel)}
+	}
+	if eventType == "*" {
+		return []string{fmt.Sprintf("Unmuted everything in %s", channel)}
+	}
+	return []string{fmt.Sprintf("Unmuted %s in %s", eventType, channel)}
+}
+
+func (this *adminCommands) status() []string {
+	state := this.monitor.State()
+	lines := []string{fmt.Sprintf("Up for %s, since %s", state.Uptime, state.Started)}
+
+	if state.IRC.Registered {
+		lines = append(lines, fmt.Sprintf("IRC: registered as %s, in %s", state.IRC.Nick, strings.Join(state.IRC.Channels, ", ")))
+	} else {
+		lines = append(lines, "IRC: not registered")
+	}
+
+	gerrit := "Gerrit: not connected"
+	if state.Gerrit.Connected {
+		gerrit = "Gerrit: connected"
+	}
+	if len(state.Gerrit.Since) > 0 {
+		gerrit += " since " + state.Gerrit.Since
+	}
+	if len(state.Gerrit.LastEvent) > 0 {
+		gerrit += ", last event at " + state.Gerrit.LastEvent
+	}
+	lines = append(lines, gerrit)
+
+	lines = append(lines, fmt.Sprintf("Queue: %d events held back; reconnects: IRC %d, Gerrit %d",
+		state.QueueDepth, state.Reconnects["irc"], state.Reconnects["gerrit"]))
+	if list := this.mutes.List(); len(list) > 0 {
+		lines = append(lines, fmt.Sprintf("Muted: %s", strings.Join(list, "; ")))
+	}
+	return lines
+}
@@ -0,0 +1,239 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"errors"
+	"github.com/rburchell/gobo/lib/irc/parser"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminConfig(t *testing.T) {
+	config := defaultConfig()
+	config.Admin.Accounts = []string{"alice", "", "b*"}
+	config.Admin.Masks = []string{"*!*@qt/staff/bob", "*", "*!*@*", "$a", "a b"}
+
+	expected := []string{
+		`admin.accounts[1]: "" is not an account name`,
+		`admin.accounts[2]: "b*" is not an account name`,
+		`admin.masks[1]: "*" would match anyone`,
+		`admin.masks[2]: "*!*@*" would match anyone`,
+		`admin.masks[3]: "$a" would match anyone`,
+		`admin.masks[4]: "a b" is not a hostmask`,
+	}
+	var got []string
+	for _, err := range config.validate() {
+		if strings.HasPrefix(err.Error(), "admin.") {
+			got = append(got, err.Error())
+		}
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected: %#v, got %#v", expected, got)
+	}
+
+	tests := []struct {
+		Prefix   string
+		Account  string
+		Expected bool
+	}{
+		{"alice!a@example.com", "alice", true},
+		{"alice!a@example.com", "", false},
+		{"mallory!m@example.com", "ALICE", true},
+		{"bob!b@qt/staff/bob", "", true},
+		{"bob!b@example.com", "bob", false},
+	}
+	for _, test := range tests {
+		if _, got := config.Admin.IsAdmin(parser.ParsePrefix(test.Prefix), test.Account); got != test.Expected {
+			t.Errorf("Expected %s (%s) to be an admin: %#v, got %#v", test.Prefix, test.Account, test.Expected, got)
+		}
+	}
+
+	if _, ok := defaultConfig().Admin.IsAdmin(parser.ParsePrefix("alice!a@example.com"), "alice"); ok {
+		t.Errorf("Expected no admins by default")
+	}
+}
+
+func TestMuteList(t *testing.T) {
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	list := newMuteList(clock.Now)
+	list.Mute("#qt", "comment-added", clock.Now().Add(time.Hour))
+	list.Mute("#Qt-Labs", "*", clock.Now().Add(2*time.Hour))
+
+	tests := []struct {
+		Channel   string
+		EventType string
+		Expected  bool
+	}{
+		{"#qt", "comment-added", true},
+		{"#QT", "comment-added", true},
+		{"#qt", "change-merged", false},
+		{"#qt-labs", "change-merged", true},
+		{"#elsewhere", "comment-added", false},
+	}
+	for _, test := range tests {
+		if got := list.Muted(test.Channel, test.EventType); got != test.Expected {
+			t.Errorf("Expected %s muted in %s: %#v, got %#v", test.EventType, test.Channel, test.Expected, got)
+		}
+	}
+
+	expected := "all events in #Qt-Labs until Nov 15 00:13 UTC, comment-added in #qt until Nov 14 23:13 UTC"
+	if got := strings.Join(list.List(), ", "); got != expected {
+		t.Errorf("Expected: %#v, got %#v", expected, got)
+	}
+
+	clock.Advance(time.Hour)
+	if list.Muted("#qt", "comment-added") {
+		t.Errorf("Expected the mute to have run out")
+	}
+	if undone := list.Unmute("#qt-labs", "comment-added"); undone != 0 {
+		t.Errorf("Expected: 0, got %d", undone)
+	}
+	if undone := list.Unmute("#qt-labs", "*"); undone != 1 {
+		t.Errorf("Expected: 1, got %d", undone)
+	}
+	if got := list.List(); len(got) != 0 {
+		t.Errorf("Expected nothing muted, got %#v", got)
+	}
+}
+
+func TestAdminCommands(t *testing.T) {
+	config := defaultConfig()
+	config.IRC.Channels = []string{"#qt-gerrit", "#qt-labs"}
+	config.Gerrit.Channel = "#qt-gerrit"
+	config.Admin.Accounts = []string{"alice"}
+	config.validate()
+
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	var actions []string
+	reloadErr := errors.New("gerrit.user: must be set (or GERRIT_USER)\nirc.server: must be set (or IRC_SERVER)")
+	connected := true
+	monitor := newBotMonitor(clock.Now)
+	monitor.ircRegistered = func() bool { return true }
+	monitor.ircNick = func() string { return "qt_gerrit" }
+	monitor.ircChannels = func() []string { return []string{"#qt-gerrit", "#qt-labs"} }
+	admin := &adminCommands{
+		getConfig: func() *Config { return config },
+		setConfig: func(updated *Config) { config = updated },
+		join:      func(channel string) { actions = append(actions, "join "+channel) },
+		part:      func(channel string) { actions = append(actions, "part "+channel) },
+		reload:    func() error { return reloadErr },
+		reconnect: func() bool { return connected },
+		mutes:     newMuteList(clock.Now),
+		monitor:   monitor,
+		now:       clock.Now,
+	}
+
+	alice := parser.ParsePrefix("alice!a@example.com")
+	tests := []struct {
+		Text     string
+		Expected string
+	}{
+		{"!join #qt", "Joining #qt"},
+		{"!join #QT", "I'm already in #QT"},
+		{"!join qt", "qt isn't a channel"},
+		{"!join", "Usage: !join <channel>"},
+		{"!part #qt-gerrit", "#qt-gerrit is used by gerrit.channel; change the config to leave it"},
+		{"!part #qt-labs", "Leaving #qt-labs"},
+		{"!part #qt-labs", "I'm not in #qt-labs"},
+		{"!mute #qt comment-added", "Muted comment-added in #qt until Nov 14 23:13 UTC"},
+		{"!mute #qt all 30m", "Muted all events in #qt until Nov 14 22:43 UTC"},
+		{"!mute #qt comment-added soon", "soon isn't a duration (e.g. 30m, 4h)"},
+		{"!mute #qt lunch", "lunch isn't an event type (known: " + strings.Join(handledEventTypes(), ", ") + ")"},
+		{"!mute #qt-labs all", "I'm not in #qt-labs"},
+		{"!mutes", "Muted:\nall events in #qt until Nov 14 22:43 UTC\ncomment-added in #qt until Nov 14 23:13 UTC"},
+		{"!unmute #qt comment-added", "Unmuted comment-added in #qt"},
+		{"!unmute #qt comment-added", "comment-added isn't muted in #qt"},
+		{"!unmute #qt", "Unmuted everything in #qt"},
+		{"!unmute #qt all", "Nothing is muted in #qt"},
+		{"!mutes", "Nothing is muted"},
+		{"!reload", "Couldn't reload the config:\ngerrit.user: must be set (or GERRIT_USER)\nirc.server: must be set (or IRC_SERVER)"},
+		{"!reconnect", "Reconnecting to Gerrit"},
+		{"!status", "Up for 0s, since 2023-11-14T22:13:20Z\nIRC: registered as qt_gerrit, in #qt-gerrit, #qt-labs\nGerrit: not connected\nQueue: 0 events held back; reconnects: IRC 0, Gerrit 0"},
+		{"!admin", strings.Join(adminHelp, "\n")},
+	}
+	for _, test := range tests {
+		replies, ok := admin.Command(alice, "alice", test.Text)
+		if got := strings.Join(replies, "\n"); !ok || got != test.Expected {
+			t.Errorf("Expected %s to give: %#v, got %#v", test.Text, test.Expected, got)
+		}
+	}
+
+	expected := "join #qt, part #qt-labs"
+	if got := strings.Join(actions, ", "); got != expected {
+		t.Errorf("Expected: %#v, got %#v", expected, got)
+	}
+	expected = "#qt-gerrit,#qt"
+	if got := strings.Join(config.IRC.Channels, ","); got != expected {
+		t.Errorf("Expected: %#v, got %#v", expected, got)
+	}
+
+	connected = false
+	if replies, _ := admin.Command(alice, "alice", "!reconnect"); strings.Join(replies, "\n") != "I'm not connected to Gerrit, but I'm trying to be" {
+		t.Errorf("Expected to be told Gerrit isn't connected, got %#v", replies)
+	}
+
+	// only admins may, and other things aren't admin commands
+	if replies, ok := admin.Command(parser.ParsePrefix("mallory!m@example.com"), "", "!join #evil"); !ok || strings.Join(replies, "\n") != "Only admins can do that" {
+		t.Errorf("Expected mallory to be refused, got %#v", replies)
+	}
+	for _, text := range []string{"!watch project qt/qtbase", "!joined", "hello"} {
+		if _, ok := admin.Command(alice, "alice", text); ok {
+			t.Errorf("Expected %#v not to be an admin command", text)
+		}
+	}
+}
+
+func TestMutedEvents(t *testing.T) {
+	config := defaultConfig()
+	config.Gerrit.Channel = "#qt-gerrit"
+	watches, _ := loadWatchList("")
+	watches.Command(config, "bob", "!watch project qt/qtbase")
+	watches.online = testOnline(map[string]string{"bob": "bob"})
+
+	var announced, notices []string
+	co := newCoalescer(func(channel string, line string) {
+		announced = append(announced, channel+" "+line)
+	})
+	co.config = func() *Config { return config }
+	notify := func(nick string, line string) {
+		notices = append(notices, nick+" "+line)
+	}
+
+	mutes.Mute("#qt-gerrit", "comment-added", time.Now().Add(time.Hour))
+	defer mutes.Unmute("#qt-gerrit", "*")
+	dispatchGerritEvent(co, watches, notify, config, testEvent(t, `{"type": "comment-added", "author": {"name": "Bob"}, "patchSet": {"number": "2", "uploader": {"name": "Alice"}}, `+testChange+`}`))
+	dispatchGerritEvent(co, watches, notify, config, testEvent(t, `{"type": "change-merged", "submitter": {"name": "Bob"}, "patchSet": {"number": "2", "uploader": {"name": "Alice"}}, `+testChange+`}`))
+
+	expected := "#qt-gerrit [qt/qtbase/dev] Fix it authored by Alice was cherry-picked by Bob - https://codereview.qt-project.org/1234"
+	if got := strings.Join(announced, "\n"); got != expected {
+		t.Errorf("Expected: %#v, got %#v", expected, got)
+	}
+	// muting a channel doesn't affect watchers
+	if len(notices) != 2 {
+		t.Errorf("Expected two notices, got %#v", notices)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// A commit, as described by the header of cgit's patch view.
+type CgitCommit struct {
+	Repo    string // qt/qtbase
+	Sha     string
+	Author  string
+	Subject string
+	Url     string
+}
+
+// Expands links to commits on cgit, e.g.
+// https://code.qt.io/cgit/qt/qtbase.git/commit/?id=<sha>
+type cgitExpander struct{}
+
+func (this cgitExpander) Pattern(config *Config) *regexp.Regexp {
+	if len(config.Cgit.Url) == 0 {
+		return nil
+	}
+	cgitUrl := regexp.QuoteMeta(strings.TrimSuffix(config.Cgit.Url, "/"))
+	return regexp.MustCompile(cgitUrl + `/([A-Za-z0-9_./-]+?)\.git/commit/?\?(?:[^ #]*&)?id=([0-9a-f]{7,40})`)
+}
+
+func (this cgitExpander) Fetch(ctx context.Context, lookups *lookupService, config *Config, match []string) (interface{}, error) {
+	repo := match[1]
+	sha := match[2]
+	cgitUrl := strings.TrimSuffix(config.Cgit.Url, "/")
+
+	req, err := http.NewRequestWithContext(ctx, "GET", cgitUrl+"/"+repo+".git/patch/?id="+sha, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := lookups.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("while fetching HTTP: %s", err.Error())
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, userErrorf("%s doesn't exist", match[0])
+	} else if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP status %s", res.Status)
+	}
+
+	commit, err := parseCgitPatch(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	commit.Repo = repo
+	commit.Url = match[0]
+	return commit, nil
+}
+
+// Read the author and subject of a commit from its patch, as generated by
+// git format-patch.
+func parseCgitPatch(r io.Reader) (*CgitCommit, error) {
+	bio := bufio.NewReader(io.LimitReader(r, 64*1024))
+
+	// skip the mbox "From <sha> <date>" line, which isn't a header
+	if start, err := bio.Peek(5); err == nil && string(start) == "From " {
+		if _, err := bio.ReadString('\n'); err != nil {
+			return nil, errors.New("malformed reply")
+		}
+	}
+
+	// only the header is needed, which ends at the first empty line
+	msg, err := mail.ReadMessage(bio)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing the patch: %s", err.Error())
+	}
+
+	decoder := new(mime.WordDecoder)
+	commit := &CgitCommit{}
+	if from, err := mail.ParseAddress(msg.Header.Get("From")); err == nil {
+		commit.Author = from.Name
+	} else {
+		commit.Author = msg.Header.Get("From")
+	}
+	subject, err := decoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+	commit.Subject = strings.TrimSpace(strings.TrimPrefix(subject, "[PATCH]"))
+	if len(commit.Subject) == 0 {
+		return nil, errors.New("malformed reply")
+	}
+	return commit, nil
+}
+
+func (this cgitExpander) Format(config *Config, found interface{}) string {
+	commit := found.(*CgitCommit)
+	return fmt.Sprintf("[%s] %s from %s - %s", commit.Repo, commit.Subject, commit.Author, commit.Url)
+}
+
+func init() {
+	registerExpander("cgit", false, cgitExpander{})
+}
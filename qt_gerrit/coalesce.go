@@ -0,0 +1,357 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CoalesceConfig controls how bursts of Gerrit events are grouped into one
+// summary line, rather than announced one by one.
+type CoalesceConfig struct {
+	// How long to wait for more events after the first of a group. Zero
+	// disables coalescing.
+	Window time.Duration `toml:"window"`
+
+	// What events are grouped by: "change", "project" (the default, which
+	// also takes the branch into account) or "submitter".
+	By string `toml:"by"`
+
+	// The event types that are coalesced.
+	Events []string `toml:"events"`
+}
+
+func defaultCoalesceConfig() CoalesceConfig {
+	return CoalesceConfig{
+		By:     "project",
+		Events: []string{"change-merged", "ref-updated"},
+	}
+}
+
+func (this *CoalesceConfig) validate() []error {
+	var errs []error
+	if this.Window < 0 {
+		errs = append(errs, fmt.Errorf("gerrit.coalesce.window: must not be negative"))
+	}
+	switch this.By {
+	case "change", "project", "submitter":
+	default:
+		errs = append(errs, fmt.Errorf("gerrit.coalesce.by: %q is not one of change, project or submitter", this.By))
+	}
+	for _, eventType := range this.Events {
+		if _, ok := eventHandlers[eventType]; !ok {
+			errs = append(errs, fmt.Errorf("gerrit.coalesce.events: unknown event type %s", eventType))
+		}
+	}
+	return errs
+}
+
+func (this *CoalesceConfig) coalesces(eventType string) bool {
+	if this.Window <= 0 {
+		return false
+	}
+	for _, existing := range this.Events {
+		if existing == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the key of the group msg belongs in.
+func (this *CoalesceConfig) groupKey(msg *GerritMessage) string {
+	switch this.By {
+	case "change":
+		if msg.Type == "ref-updated" {
+			return msg.Type + "|" + msg.ProjectName() + "|" + msg.RefUpdate.RefName
+		}
+		return fmt.Sprintf("%s|%d", msg.Type, msg.Change.Number)
+	case "submitter":
+		return msg.Type + "|" + msg.Actor().Name
+	}
+	return msg.Type + "|" + msg.ProjectName() + "|" + msg.BranchName()
+}
+
+// An EventSummary describes a group of events of the same type. It's what
+// the "summary" template is executed with.
+type EventSummary struct {
+	Type        string
+	Description string // e.g. "changes merged into"
+	Count       int
+	Events      []*GerritMessage
+	Places      []string // e.g. "qt/qtbase/dev", in the order first seen
+	Actors      []string // e.g. "Qt CI Bot", in the order first seen
+}
+
+// How a group of events of each type is described.
+var summaryDescriptions = map[string]string{
+	"comment-added":    "reviews on",
+	"patchset-created": "patch sets uploaded to",
+	"change-merged":    "changes merged into",
+	"merge-failed":     "merges failed in",
+	"ref-updated":      "ref updates in",
+	"change-abandoned": "changes abandoned in",
+	"change-deferred":  "changes deferred in",
+	"change-restored":  "changes restored in",
+}
+
+func appendUnique(list []string, str string) []string {
+	for _, existing := range list {
+		if existing == str {
+			return list
+		}
+	}
+	return append(list, str)
+}
+
+func summarize(events []*GerritMessage) *EventSummary {
+	summary := &EventSummary{
+		Type:   events[0].Type,
+		Count:  len(events),
+		Events: events,
+	}
+	summary.Description = summaryDescriptions[summary.Type]
+	if len(summary.Description) == 0 {
+		summary.Description = summary.Type + " events in"
+	}
+	for _, msg := range events {
+		place := msg.ProjectName()
+		if branch := msg.BranchName(); len(branch) > 0 {
+			place += "/" + branch
+		}
+		summary.Places = appendUnique(summary.Places, place)
+		if actor := msg.Actor().Name; len(actor) > 0 {
+			summary.Actors = appendUnique(summary.Actors, actor)
+		}
+	}
+	return summary
+}
+
+// Join a list for display, e.g. "a, b, c and 2 more".
+func summarizeList(list []string) string {
+	if len(list) > 3 {
+		return fmt.Sprintf("%s and %d more", strings.Join(list[:3], ", "), len(list)-3)
+	}
+	return strings.Join(list, ", ")
+}
+
+type eventGroup struct {
+	channel string
+	events  []*GerritMessage
+}
+
+type digest struct {
+	channel string
+	started time.Time
+	events  []*GerritMessage
+}
+
+// A coalescer announces Gerrit events, holding back those that should be
+// coalesced or put in a digest until their time is up.
+type coalescer struct {
+	write  func(channel string, line string)
+	config func() *Config
+
+	mutex   sync.Mutex
+	groups  map[string]*eventGroup
+	digests map[string]*digest
+}
+
+func newCoalescer(write func(channel string, line string)) *coalescer {
+	return &coalescer{
+		write:   write,
+		config:  getConfig,
+		groups:  map[string]*eventGroup{},
+		digests: map[string]*digest{},
+	}
+}
+
+// Announce msg in channel, now or later.
+func (this *coalescer) announce(channel string, msg *GerritMessage) {
+	config := this.config()
+
+	if channelConfig, _ := config.Channel(channel); channelConfig.Digest > 0 {
+		this.mutex.Lock()
+		key := strings.ToLower(channel)
+		d, ok := this.digests[key]
+		if !ok {
+			d = &digest{channel: channel, started: time.Now()}
+			this.digests[key] = d
+			time.AfterFunc(channelConfig.Digest, func() { this.flushDigest(key, d) })
+		}
+		d.events = append(d.events, msg)
+		this.mutex.Unlock()
+		return
+	}
+
+	if config.Gerrit.Coalesce.coalesces(msg.Type) {
+		this.mutex.Lock()
+		key := strings.ToLower(channel) + "|" + config.Gerrit.Coalesce.groupKey(msg)
+		group, ok := this.groups[key]
+		if !ok {
+			group = &eventGroup{channel: channel}
+			this.groups[key] = group
+			time.AfterFunc(config.Gerrit.Coalesce.Window, func() { this.flushGroup(key, group) })
+		}
+		group.events = append(group.events, msg)
+		this.mutex.Unlock()
+		return
+	}
+
+	this.announceEvents(config, channel, []*GerritMessage{msg})
+}
+
+// Announce the group held back under key. If only is set, that's only done
+// if it's still the one held back, and not one started since it was flushed.
+func (this *coalescer) flushGroup(key string, only *eventGroup) {
+	this.mutex.Lock()
+	group := this.groups[key]
+	if only != nil && group != only {
+		group = nil
+	} else {
+		delete(this.groups, key)
+	}
+	this.mutex.Unlock()
+
+	if group != nil {
+		this.announceEvents(this.config(), group.channel, group.events)
+	}
+}
+
+// As flushGroup, for digests.
+func (this *coalescer) flushDigest(key string, only *digest) {
+	this.mutex.Lock()
+	d := this.digests[key]
+	if only != nil && d != only {
+		d = nil
+	} else {
+		delete(this.digests, key)
+	}
+	this.mutex.Unlock()
+
+	if d == nil {
+		return
+	}
+
+	config := this.config()
+	this.write(d.channel, fmt.Sprintf("Gerrit digest since %s (%d events):", d.started.Format("15:04"), len(d.events)))
+
+	// group them by type and place, in the order they were first seen.
+	var keys []string
+	groups := map[string][]*GerritMessage{}
+	for _, msg := range d.events {
+		key := msg.Type + "|" + msg.ProjectName() + "|" + msg.BranchName()
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], msg)
+	}
+	for _, key := range keys {
+		this.announceEvents(config, d.channel, groups[key])
+	}
+}
+
+// Pending returns how many events are being held back.
+func (this *coalescer) Pending() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	pending := 0
+	for _, group := range this.groups {
+		pending += len(group.events)
+	}
+	for _, d := range this.digests {
+		pending += len(d.events)
+	}
+	return pending
+}
+
+// Flush everything that's being held back.
+func (this *coalescer) flush() {
+	this.mutex.Lock()
+	var groupKeys, digestKeys []string
+	for key := range this.groups {
+		groupKeys = append(groupKeys, key)
+	}
+	for key := range this.digests {
+		digestKeys = append(digestKeys, key)
+	}
+	this.mutex.Unlock()
+
+	for _, key := range groupKeys {
+		this.flushGroup(key, nil)
+	}
+	for _, key := range digestKeys {
+		this.flushDigest(key, nil)
+	}
+}
+
+// Let go of everything held back if config holds events back differently
+// from old, rather than keep it to the old settings.
+func (this *coalescer) reconfigured(old *Config, config *Config) {
+	if holdsBackDifferently(old, config) {
+		this.flush()
+	}
+}
+
+// Returns true if config coalesces events, or puts them in digests,
+// differently from old.
+func holdsBackDifferently(old *Config, config *Config) bool {
+	a, b := old.Gerrit.Coalesce, config.Gerrit.Coalesce
+	if a.Window != b.Window || a.By != b.By || strings.Join(a.Events, ",") != strings.Join(b.Events, ",") {
+		return true
+	}
+	for _, channels := range []map[string]ChannelConfig{old.Channels, config.Channels} {
+		for channel := range channels {
+			oldChannel, _ := old.Channel(channel)
+			newChannel, _ := config.Channel(channel)
+			if oldChannel.Digest != newChannel.Digest {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Announce a group of events of the same type: one by one if there's only
+// one, or else as a summary.
+func (this *coalescer) announceEvents(config *Config, channel string, events []*GerritMessage) {
+	var lines []string
+	var err error
+	if len(events) == 1 {
+		lines, err = renderTemplate(config.Template(channel, events[0].Type), events[0])
+	} else {
+		lines, err = renderTemplate(config.Template(channel, "summary"), summarize(events))
+	}
+	if err != nil {
+		println(fmt.Sprintf("Gerrit: Failed to render %s for %s: %s", events[0].Type, channel, err.Error()))
+		return
+	}
+	for _, line := range lines {
+		this.write(channel, line)
+	}
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"github.com/rburchell/gobo/lib/gerrit"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordedLines struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+func (this *recordedLines) write(channel string, line string) {
+	this.mutex.Lock()
+	this.lines = append(this.lines, channel+" "+line)
+	this.mutex.Unlock()
+}
+
+func (this *recordedLines) get() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return append([]string(nil), this.lines...)
+}
+
+func mergedEvent(number int, project string, branch string, submitter string) *GerritMessage {
+	msg := &GerritMessage{Type: "change-merged"}
+	msg.Change.Number = gerrit.Number(number)
+	msg.Change.Project = project
+	msg.Change.Branch = branch
+	msg.Change.Subject = fmt.Sprintf("Change %d", number)
+	msg.Change.Url = fmt.Sprintf("https://codereview.qt-project.org/%d", number)
+	msg.PatchSet.Uploader.Name = "Alice"
+	msg.Submitter.Name = submitter
+	return msg
+}
+
+func TestCoalesce(t *testing.T) {
+	config := defaultConfig()
+	config.Gerrit.Coalesce.Window = time.Hour
+
+	var recorded recordedLines
+	co := newCoalescer(recorded.write)
+	co.config = func() *Config { return config }
+
+	for idx := 1; idx <= 12; idx++ {
+		co.announce("#qt-gerrit", mergedEvent(idx, "qt/qtbase", "dev", "Qt CI Bot"))
+	}
+	co.announce("#qt-gerrit", mergedEvent(13, "qt/qtdeclarative", "dev", "Qt CI Bot"))
+
+	// not coalesced, so announced right away
+	patchset := mergedEvent(14, "qt/qtbase", "dev", "")
+	patchset.Type = "patchset-created"
+	patchset.PatchSet.Number = 1
+	co.announce("#qt-gerrit", patchset)
+
+	expected := []string{"#qt-gerrit [qt/qtbase/dev] Change 14 pushed by Alice - https://codereview.qt-project.org/14"}
+	if lines := recorded.get(); strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected: %#v, got %#v", expected, lines)
+	}
+
+	co.flush()
+	lines := recorded.get()[1:]
+	expected = []string{
+		"#qt-gerrit 12 changes merged into qt/qtbase/dev by Qt CI Bot",
+		"#qt-gerrit [qt/qtdeclarative/dev] Change 13 authored by Alice was cherry-picked by Qt CI Bot - https://codereview.qt-project.org/13",
+	}
+	if len(lines) != 2 || !(lines[0] == expected[0] && lines[1] == expected[1] || lines[0] == expected[1] && lines[1] == expected[0]) {
+		t.Errorf("Expected: %#v, got %#v", expected, lines)
+	}
+}
+
+func TestCoalesceBySubmitter(t *testing.T) {
+	config := defaultConfig()
+	config.Gerrit.Coalesce.Window = 10 * time.Millisecond
+	config.Gerrit.Coalesce.By = "submitter"
+
+	var recorded recordedLines
+	co := newCoalescer(recorded.write)
+	co.config = func() *Config { return config }
+
+	projects := []string{"qt/qtbase", "qt/qtdeclarative", "qt/qtsvg", "qt/qttools", "qt/qtbase"}
+	for idx, project := range projects {
+		co.announce("#qt-gerrit", mergedEvent(idx, project, "dev", "Qt CI Bot"))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	expected := "#qt-gerrit 5 changes merged into qt/qtbase/dev, qt/qtdeclarative/dev, qt/qtsvg/dev and 1 more by Qt CI Bot"
+	if lines := recorded.get(); len(lines) != 1 || lines[0] != expected {
+		t.Errorf("Expected: %#v, got %#v", expected, lines)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	config := defaultConfig()
+	config.Channels = map[string]ChannelConfig{
+		"#qt-digest": {Digest: time.Hour},
+	}
+
+	var recorded recordedLines
+	co := newCoalescer(recorded.write)
+	co.config = func() *Config { return config }
+
+	comment := mergedEvent(1, "qt/qtbase", "dev", "")
+	comment.Type = "comment-added"
+	comment.Author.Name = "Bob"
+	co.announce("#QT-DIGEST", comment)
+	co.announce("#qt-digest", mergedEvent(2, "qt/qtbase", "dev", "Qt CI Bot"))
+	co.announce("#qt-digest", mergedEvent(3, "qt/qtbase", "dev", "Qt CI Bot"))
+	co.announce("#qt-gerrit", mergedEvent(4, "qt/qtbase", "dev", "Qt CI Bot"))
+
+	if lines := recorded.get(); len(lines) != 1 || !strings.HasPrefix(lines[0], "#qt-gerrit ") {
+		t.Errorf("Expected only #qt-gerrit to be announced to, got %#v", lines)
+	}
+
+	co.flush()
+	lines := recorded.get()[1:]
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 lines, got %#v", lines)
+	}
+	if !strings.HasPrefix(lines[0], "#QT-DIGEST Gerrit digest since ") || !strings.HasSuffix(lines[0], " (3 events):") {
+		t.Errorf("Expected a digest header, got %#v", lines[0])
+	}
+	expected := "#QT-DIGEST [qt/qtbase/dev] Change 1 from Alice commented by Bob - https://codereview.qt-project.org/1"
+	if lines[1] != expected {
+		t.Errorf("Expected: %#v, got %#v", expected, lines[1])
+	}
+	expected = "#QT-DIGEST 2 changes merged into qt/qtbase/dev by Qt CI Bot"
+	if lines[2] != expected {
+		t.Errorf("Expected: %#v, got %#v", expected, lines[2])
+	}
+}
+
+func TestReconfigured(t *testing.T) {
+	config := defaultConfig()
+	config.Gerrit.Coalesce.Window = time.Hour
+
+	var recorded recordedLines
+	co := newCoalescer(recorded.write)
+	co.config = func() *Config { return config }
+	co.announce("#qt-gerrit", mergedEvent(1, "qt/qtbase", "dev", "Qt CI Bot"))
+	co.announce("#qt-gerrit", mergedEvent(2, "qt/qtbase", "dev", "Qt CI Bot"))
+
+	// nothing changed, so they're still held back
+	same := defaultConfig()
+	same.Gerrit.Coalesce.Window = time.Hour
+	co.reconfigured(config, same)
+	if co.Pending() != 2 || len(recorded.get()) != 0 {
+		t.Errorf("Expected: 2 events held back, got %d (and %#v)", co.Pending(), recorded.get())
+	}
+
+	digest := defaultConfig()
+	digest.Gerrit.Coalesce.Window = time.Hour
+	digest.Channels = map[string]ChannelConfig{"#QT-GERRIT": {Digest: time.Hour}}
+	if !holdsBackDifferently(same, digest) || !holdsBackDifferently(digest, same) {
+		t.Errorf("Expected a new digest to hold events back differently")
+	}
+
+	shorter := defaultConfig()
+	shorter.Gerrit.Coalesce.Window = time.Minute
+	co.reconfigured(config, shorter)
+	expected := []string{"#qt-gerrit 2 changes merged into qt/qtbase/dev by Qt CI Bot"}
+	if lines := recorded.get(); strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected: %#v, got %#v", expected, lines)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	config := defaultConfig()
+	config.Channels = map[string]ChannelConfig{"#qt-digest": {Digest: time.Hour}}
+
+	var recorded recordedLines
+	co := newCoalescer(recorded.write)
+	co.config = func() *Config { return config }
+	co.announce("#qt-digest", mergedEvent(1, "qt/qtbase", "dev", "Qt CI Bot"))
+
+	shutdown(co, func(line string) { recorded.write("", line) })
+	lines := recorded.get()
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "#qt-digest Gerrit digest since ") || lines[2] != " QUIT :Shutting down" {
+		t.Errorf("Expected the digest to be announced before quitting, got %#v", lines)
+	}
+}
@@ -1,23 +1,678 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
 package main
 
-// Map a bare repository name to a Github one.
-// Used for the text triggers, e.g. "look at commit qtbase/<sha>"
-// This is based on a whitelist (for now). Feel free to add additional entries.
-var repoNameToGithubMap = map[string]string{
-	"qt5":           "qt/qt5",
-	"qtdoc":         "qt/qtdoc",
-	"qtbase":        "qt/qtbase",
-	"qtmultimedia":  "qt/qtmultimedia",
-	"qtdeclarative": "qt/qtdeclarative",
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Config is the configuration of the bot, as read from a TOML file (see
+// qt_gerrit.toml.example) and then overridden by the environment.
+type Config struct {
+	IRC    IRCConfig     `toml:"irc"`
+	Gerrit GerritConfig  `toml:"gerrit"`
+	Jira   JiraConfig    `toml:"jira"`
+	Github GithubConfig  `toml:"github"`
+	Cgit   CgitConfig    `toml:"cgit"`
+	Docs   DocsConfig    `toml:"docs"`
+	Lookup LookupConfig  `toml:"lookup"`
+	Watch  WatchConfig   `toml:"watch"`
+	HTTP   HTTPConfig    `toml:"http"`
+	Admin  AdminConfig   `toml:"admin"`
+	Routes []RouteConfig `toml:"route"`
+
+	// Reports posted to channels on a schedule.
+	Reports []ReportConfig `toml:"report"`
+
+	// Enables or disables expanders (e.g. jira), which describe what's
+	// mentioned in messages, in all channels.
+	Expanders map[string]bool `toml:"expanders"`
+
+	// Settings for particular channels, keyed by channel name.
+	Channels map[string]ChannelConfig `toml:"channel"`
+
+	// How votes are shown, keyed by Gerrit label name.
+	Labels map[string]LabelConfig `toml:"labels"`
+}
+
+type IRCConfig struct {
+	Server        string   `toml:"server"` // irc.libera.chat:6667
+	Nick          string   `toml:"nick"`
+	User          string   `toml:"user"`
+	RealName      string   `toml:"realname"`
+	Channels      []string `toml:"channels"`
+	NickServUser  string   `toml:"nickserv_user"`
+	NickServPass  string   `toml:"nickserv_pass"`
+	LogDir        string   `toml:"log_dir"`
+	BouncerListen string   `toml:"bouncer_listen"`
+	BouncerPass   string   `toml:"bouncer_pass"`
+}
+
+type GerritConfig struct {
+	Host       string `toml:"host"` // codereview.qt-project.org:29418
+	User       string `toml:"user"`
+	PrivateKey string `toml:"private_key"`
+	Url        string `toml:"url"` // https://codereview.qt-project.org
+
+	// Credentials for the REST API at Url, for when anonymous access isn't
+	// enough: a username and HTTP password, or a bearer token.
+	HttpUser     string `toml:"http_user"`
+	HttpPassword string `toml:"http_password"`
+	HttpToken    string `toml:"http_token"`
+
+	// Gerrit's host key is checked against HostKeyFingerprint (e.g.
+	// SHA256:...) if that is set, or else against the KnownHosts file. With
+	// TrustOnFirstUse, a host missing from KnownHosts is added to it.
+	KnownHosts         string `toml:"known_hosts"`
+	HostKeyFingerprint string `toml:"host_key_fingerprint"`
+	TrustOnFirstUse    bool   `toml:"trust_on_first_use"`
+
+	// The ciphers and key exchange algorithms to offer, in order of
+	// preference. If empty, the SSH library's defaults are used.
+	Ciphers      []string `toml:"ciphers"`
+	KeyExchanges []string `toml:"kex"`
+
+	// The channel Gerrit activity is published to.
+	Channel string `toml:"channel"`
+
+	// If not empty, only activity on these projects is published.
+	Projects []string `toml:"projects"`
+
+	// Enables or disables the handlers for Gerrit event types (e.g.
+	// reviewer-added) in all channels.
+	Events map[string]bool `toml:"events"`
+
+	// Templates overriding the defaults for Gerrit event types, in all
+	// channels.
+	Templates map[string]string `toml:"templates"`
+	templates map[string]*template.Template
+
+	// How bursts of events are grouped together.
+	Coalesce CoalesceConfig `toml:"coalesce"`
+
+	// Where the time of the last event is kept, so that events missed
+	// while the bot wasn't running can be replayed.
+	StateFile string `toml:"state_file"`
+
+	// How far back to replay missed events, at most.
+	MaxReplay time.Duration `toml:"max_replay"`
+
+	// Gerrit older than 2.12 doesn't say which votes in comment-added
+	// changed. With this set, votes in comments that don't say are all taken
+	// to have changed; otherwise, such comments are taken to be plain ones.
+	LegacyApprovals bool `toml:"legacy_approvals"`
+
+	// How many of the commits a ref update added are listed. They're looked
+	// up on Github, which is also how force pushes are noticed; zero turns
+	// that off.
+	RefCommits int `toml:"ref_commits"`
+}
+
+type ChannelConfig struct {
+	// If not zero, Gerrit events aren't announced in this channel as they
+	// happen, but in a digest this often (e.g. "1h").
+	Digest time.Duration `toml:"digest"`
+
+	// Enables or disables the handlers for Gerrit event types in this
+	// channel, overriding gerrit.events.
+	Events map[string]bool `toml:"events"`
+
+	// Enables or disables expanders in this channel, overriding expanders.
+	Expanders map[string]bool `toml:"expanders"`
+
+	// Templates for Gerrit event types in this channel, overriding
+	// gerrit.templates.
+	Templates map[string]string `toml:"templates"`
+	templates map[string]*template.Template
+}
+
+type CgitConfig struct {
+	Url string `toml:"url"` // https://code.qt.io/cgit
+}
+
+type DocsConfig struct {
+	Url string `toml:"url"` // https://doc.qt.io
+}
+
+type JiraConfig struct {
+	Url string `toml:"url"` // https://bugreports.qt.io
+
+	// An API token, for when anonymous access isn't enough. Without User,
+	// it's sent as a bearer token (a personal access token on Jira Server);
+	// with one, as the password (an API token on Jira Cloud).
+	User  string `toml:"user"`
+	Token string `toml:"token"`
+
+	// How many issues !jira search lists, at most.
+	SearchResults int `toml:"search_results"`
+
+	// Filters whose new and changed issues are announced.
+	Watches []JiraWatchConfig `toml:"watch"`
+}
+
+type JiraWatchConfig struct {
+	// The channel to announce to.
+	Channel string `toml:"channel"`
+
+	// What to watch, e.g. project = QTBUG AND priority = P0. It's combined
+	// with a condition on when issues were updated, so it can't have an
+	// ORDER BY.
+	Jql string `toml:"jql"`
+
+	// How often to ask Jira.
+	Interval time.Duration `toml:"interval"`
+}
+
+type GithubConfig struct {
+	Url string `toml:"url"` // https://api.github.com
+
+	// A token to authenticate with, which raises the rate limit.
+	Token string `toml:"token"`
+
+	// Map a bare repository name to a Github one.
+	// Used for the text triggers, e.g. "look at commit qtbase/<sha>"
+	Repos map[string]string `toml:"repos"`
+
+	// Where to look for bare repository names not in Repos. If empty, only
+	// those in Repos are recognised. Otherwise, anything of the form
+	// word/<hex> is looked for there, and nothing said if it isn't found.
+	Org string `toml:"org"`
+}
+
+// ConfigErrors holds every problem found in a configuration, so that they
+// can all be fixed in one go rather than one per restart.
+type ConfigErrors []error
+
+func (this ConfigErrors) Error() string {
+	lines := make([]string, len(this))
+	for idx, err := range this {
+		lines[idx] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		IRC: IRCConfig{
+			Nick:     "qt_gerrit",
+			User:     "qt_gerrit",
+			RealName: "Qt IRC Bot",
+		},
+		Gerrit: GerritConfig{
+			Host: "codereview.qt-project.org:29418",
+			Url:  "https://codereview.qt-project.org",
+
+			KnownHosts: defaultKnownHosts(),
+			// this should be rechecked whenever Gerrit is upgraded, and
+			// ideally done away with once a better cipher is available
+			// there.
+			Ciphers: []string{"aes128-cbc"},
+
+			Coalesce:   defaultCoalesceConfig(),
+			MaxReplay:  6 * time.Hour,
+			RefCommits: 3,
+		},
+		Jira: JiraConfig{
+			Url:           "https://bugreports.qt.io",
+			SearchResults: 5,
+		},
+		Cgit: CgitConfig{
+			Url: "https://code.qt.io/cgit",
+		},
+		Docs: DocsConfig{
+			Url: "https://doc.qt.io",
+		},
+		Labels: defaultLabels(),
+		Lookup: defaultLookupConfig(),
+		Watch:  defaultWatchConfig(),
+		Github: GithubConfig{
+			Url: "https://api.github.com",
+			// This is based on a whitelist (for now). Feel free to add additional entries.
+			Repos: map[string]string{
+				"qt5":           "qt/qt5",
+				"qtdoc":         "qt/qtdoc",
+				"qtbase":        "qt/qtbase",
+				"qtmultimedia":  "qt/qtmultimedia",
+				"qtdeclarative": "qt/qtdeclarative",
+			},
+		},
+	}
+}
+
+func defaultKnownHosts() string {
+	if home := os.Getenv("HOME"); len(home) > 0 {
+		return filepath.Join(home, ".ssh", "known_hosts")
+	}
+	return ""
+}
+
+// LoadConfig reads the configuration from path (if it isn't empty), applies
+// any environment overrides, and validates the result.
+func LoadConfig(path string) (*Config, error) {
+	return loadConfig(path, os.Getenv)
+}
+
+func loadConfig(path string, getenv func(string) string) (*Config, error) {
+	config := defaultConfig()
+	var errs ConfigErrors
+
+	if len(path) > 0 {
+		// a configured repository map replaces the default one, rather
+		// than adding to it.
+		defaultRepos := config.Github.Repos
+		config.Github.Repos = nil
+
+		md, err := toml.DecodeFile(path, config)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range md.Undecoded() {
+			errs = append(errs, fmt.Errorf("%s: unknown setting", key))
+		}
+
+		if config.Github.Repos == nil {
+			config.Github.Repos = defaultRepos
+		}
+	}
+
+	config.applyEnv(getenv)
+	errs = append(errs, config.validate()...)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return config, nil
+}
+
+// The environment variables that may override a setting.
+func (this *Config) envOverrides() map[string]*string {
+	return map[string]*string{
+		"IRC_SERVER":         &this.IRC.Server,
+		"IRC_NICK":           &this.IRC.Nick,
+		"NICKSERV_USER":      &this.IRC.NickServUser,
+		"NICKSERV_PASS":      &this.IRC.NickServPass,
+		"IRC_LOG_DIR":        &this.IRC.LogDir,
+		"BOUNCER_LISTEN":     &this.IRC.BouncerListen,
+		"BOUNCER_PASS":       &this.IRC.BouncerPass,
+		"GERRIT_HOST":        &this.Gerrit.Host,
+		"GERRIT_USER":        &this.Gerrit.User,
+		"GERRIT_PRIVATE_KEY": &this.Gerrit.PrivateKey,
+		"GERRIT_CHANNEL":     &this.Gerrit.Channel,
+		"GERRIT_STATE_FILE":  &this.Gerrit.StateFile,
+		"GERRIT_KNOWN_HOSTS": &this.Gerrit.KnownHosts,
+		"GERRIT_HOST_KEY":    &this.Gerrit.HostKeyFingerprint,
+		"GERRIT_HTTP_USER":   &this.Gerrit.HttpUser,
+		"GERRIT_HTTP_PASS":   &this.Gerrit.HttpPassword,
+		"GERRIT_HTTP_TOKEN":  &this.Gerrit.HttpToken,
+		"JIRA_USER":          &this.Jira.User,
+		"JIRA_TOKEN":         &this.Jira.Token,
+		"GITHUB_TOKEN":       &this.Github.Token,
+		"WATCH_FILE":         &this.Watch.File,
+		"HTTP_LISTEN":        &this.HTTP.Listen,
+		"ADMIN_CHANNEL":      &this.Admin.Channel,
+	}
+}
+
+func (this *Config) applyEnv(getenv func(string) string) {
+	for name, setting := range this.envOverrides() {
+		if value := getenv(name); len(value) > 0 {
+			*setting = value
+		}
+	}
+
+	if value := getenv("IRC_CHANNELS"); len(value) > 0 {
+		this.IRC.Channels = nil
+		for _, channel := range strings.Split(value, ",") {
+			if channel = strings.TrimSpace(channel); len(channel) > 0 {
+				this.IRC.Channels = append(this.IRC.Channels, channel)
+			}
+		}
+	}
 }
 
-var validBareRepoNames []string
+var jqlOrderByRegex = regexp.MustCompile(`(?i)\border\s+by\b`)
+
+var githubRepoRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+/[A-Za-z0-9_.-]+$`)
+var githubOrgRegex = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+func (this *Config) validate() ConfigErrors {
+	var errs ConfigErrors
+	required := func(name, env, value string) {
+		if len(value) == 0 {
+			errs = append(errs, fmt.Errorf("%s: must be set (or %s)", name, env))
+		}
+	}
+	hostPort := func(name, value string) {
+		if len(value) == 0 {
+			return
+		}
+		if _, _, err := net.SplitHostPort(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", name, err.Error()))
+		}
+	}
+
+	required("irc.server", "IRC_SERVER", this.IRC.Server)
+	hostPort("irc.server", this.IRC.Server)
+	required("irc.nick", "IRC_NICK", this.IRC.Nick)
+	required("irc.nickserv_user", "NICKSERV_USER", this.IRC.NickServUser)
+	required("irc.nickserv_pass", "NICKSERV_PASS", this.IRC.NickServPass)
+	if len(this.IRC.Channels) == 0 {
+		errs = append(errs, fmt.Errorf("irc.channels: must be set (or IRC_CHANNELS)"))
+	}
+	for _, channel := range this.IRC.Channels {
+		if !strings.HasPrefix(channel, "#") && !strings.HasPrefix(channel, "&") {
+			errs = append(errs, fmt.Errorf("irc.channels: %q is not a channel", channel))
+		}
+	}
+	hostPort("irc.bouncer_listen", this.IRC.BouncerListen)
+	if len(this.IRC.BouncerListen) > 0 {
+		// anyone who can reach the bouncer could otherwise speak as the bot.
+		required("irc.bouncer_pass", "BOUNCER_PASS", this.IRC.BouncerPass)
+	}
 
-func init() {
-	validBareRepoNames = make([]string, len(repoNameToGithubMap))
-	i := 0
-	for k := range repoNameToGithubMap {
-		validBareRepoNames[i] = k
-		i++
+	required("gerrit.host", "GERRIT_HOST", this.Gerrit.Host)
+	hostPort("gerrit.host", this.Gerrit.Host)
+	required("gerrit.user", "GERRIT_USER", this.Gerrit.User)
+	required("gerrit.private_key", "GERRIT_PRIVATE_KEY", this.Gerrit.PrivateKey)
+	if len(this.Gerrit.PrivateKey) > 0 {
+		if f, err := os.Open(this.Gerrit.PrivateKey); err != nil {
+			errs = append(errs, fmt.Errorf("gerrit.private_key: %s", err.Error()))
+		} else {
+			f.Close()
+		}
+	}
+	if len(this.Gerrit.HostKeyFingerprint) == 0 {
+		required("gerrit.known_hosts", "GERRIT_KNOWN_HOSTS", this.Gerrit.KnownHosts)
+		if len(this.Gerrit.KnownHosts) > 0 && !this.Gerrit.TrustOnFirstUse {
+			if f, err := os.Open(this.Gerrit.KnownHosts); err != nil {
+				errs = append(errs, fmt.Errorf("gerrit.known_hosts: %s (set gerrit.host_key_fingerprint, or enable gerrit.trust_on_first_use)", err.Error()))
+			} else {
+				f.Close()
+			}
+		}
+	}
+	required("gerrit.channel", "GERRIT_CHANNEL", this.Gerrit.Channel)
+	if len(this.Gerrit.Channel) > 0 && !this.IRC.HasChannel(this.Gerrit.Channel) {
+		errs = append(errs, fmt.Errorf("gerrit.channel: %s is not in irc.channels", this.Gerrit.Channel))
+	}
+	if !strings.HasPrefix(this.Gerrit.Url, "https://") && !strings.HasPrefix(this.Gerrit.Url, "http://") {
+		errs = append(errs, fmt.Errorf("gerrit.url: %q is not an http(s) URL", this.Gerrit.Url))
+	}
+	if len(this.Gerrit.HttpUser) > 0 && len(this.Gerrit.HttpToken) > 0 {
+		errs = append(errs, fmt.Errorf("gerrit.http_user and gerrit.http_token can't both be set"))
+	}
+	if !strings.HasPrefix(this.Jira.Url, "https://") && !strings.HasPrefix(this.Jira.Url, "http://") {
+		errs = append(errs, fmt.Errorf("jira.url: %q is not an http(s) URL", this.Jira.Url))
+	}
+	if len(this.Jira.User) > 0 && len(this.Jira.Token) == 0 {
+		errs = append(errs, fmt.Errorf("jira.user: needs jira.token (or JIRA_TOKEN)"))
+	}
+	if this.Jira.SearchResults < 1 {
+		errs = append(errs, fmt.Errorf("jira.search_results: must be at least 1"))
+	}
+	for idx := range this.Jira.Watches {
+		watch := &this.Jira.Watches[idx]
+		if len(watch.Channel) == 0 {
+			errs = append(errs, fmt.Errorf("jira.watch[%d].channel: must be set", idx))
+		} else if !this.IRC.HasChannel(watch.Channel) {
+			errs = append(errs, fmt.Errorf("jira.watch[%d].channel: %s is not in irc.channels", idx, watch.Channel))
+		}
+		if len(strings.TrimSpace(watch.Jql)) == 0 {
+			errs = append(errs, fmt.Errorf("jira.watch[%d].jql: must be set", idx))
+		} else if jqlOrderByRegex.MatchString(watch.Jql) {
+			errs = append(errs, fmt.Errorf("jira.watch[%d].jql: can't have an ORDER BY", idx))
+		}
+		if watch.Interval == 0 {
+			watch.Interval = 5 * time.Minute
+		} else if watch.Interval < time.Minute {
+			errs = append(errs, fmt.Errorf("jira.watch[%d].interval: must be at least a minute", idx))
+		}
+	}
+	if len(this.Cgit.Url) > 0 && !strings.HasPrefix(this.Cgit.Url, "https://") && !strings.HasPrefix(this.Cgit.Url, "http://") {
+		errs = append(errs, fmt.Errorf("cgit.url: %q is not an http(s) URL", this.Cgit.Url))
 	}
+	if len(this.Docs.Url) > 0 && !strings.HasPrefix(this.Docs.Url, "https://") && !strings.HasPrefix(this.Docs.Url, "http://") {
+		errs = append(errs, fmt.Errorf("docs.url: %q is not an http(s) URL", this.Docs.Url))
+	}
+
+	for idx := range this.Routes {
+		route := &this.Routes[idx]
+		for _, err := range route.compile() {
+			errs = append(errs, fmt.Errorf("route[%d].%s", idx, err.Error()))
+		}
+		if len(route.Channel) == 0 {
+			errs = append(errs, fmt.Errorf("route[%d].channel: must be set", idx))
+		} else if !this.IRC.HasChannel(route.Channel) {
+			errs = append(errs, fmt.Errorf("route[%d].channel: %s is not in irc.channels", idx, route.Channel))
+		}
+	}
+
+	checkEvents := func(name string, events map[string]bool) {
+		for eventType := range events {
+			if _, ok := eventHandlers[eventType]; !ok {
+				errs = append(errs, fmt.Errorf("%s.%s: unknown event type (known: %s)", name, eventType, strings.Join(handledEventTypes(), ", ")))
+			}
+		}
+	}
+	checkEvents("gerrit.events", this.Gerrit.Events)
+	checkExpanders := func(name string, enabled map[string]bool) {
+		for expander := range enabled {
+			if _, ok := expanders[expander]; !ok {
+				errs = append(errs, fmt.Errorf("%s.%s: unknown expander (known: %s)", name, expander, strings.Join(expanderNames(), ", ")))
+			}
+		}
+	}
+	checkExpanders("expanders", this.Expanders)
+	var templateErrs []error
+	this.Gerrit.templates, templateErrs = compileTemplates("gerrit.templates", this.Gerrit.Templates)
+	errs = append(errs, templateErrs...)
+	for _, channel := range this.channelNames() {
+		if !this.IRC.HasChannel(channel) {
+			errs = append(errs, fmt.Errorf("channel.%s: %s is not in irc.channels", channel, channel))
+		}
+		channelConfig := this.Channels[channel]
+		checkEvents("channel."+channel+".events", channelConfig.Events)
+		checkExpanders("channel."+channel+".expanders", channelConfig.Expanders)
+		if channelConfig.Digest < 0 {
+			errs = append(errs, fmt.Errorf("channel.%s.digest: must not be negative", channel))
+		}
+		channelConfig.templates, templateErrs = compileTemplates("channel."+channel+".templates", channelConfig.Templates)
+		errs = append(errs, templateErrs...)
+		this.Channels[channel] = channelConfig
+	}
+
+	errs = append(errs, this.validateLabels()...)
+	errs = append(errs, this.Gerrit.Coalesce.validate()...)
+	errs = append(errs, this.Lookup.validate()...)
+	errs = append(errs, this.Watch.validate()...)
+	errs = append(errs, this.HTTP.validate()...)
+	errs = append(errs, this.Admin.validate(this)...)
+	for idx := range this.Reports {
+		errs = append(errs, this.Reports[idx].validate(fmt.Sprintf("report[%d]", idx), this)...)
+	}
+	if this.Gerrit.MaxReplay < 0 {
+		errs = append(errs, fmt.Errorf("gerrit.max_replay: must not be negative"))
+	}
+	if this.Gerrit.RefCommits < 0 {
+		errs = append(errs, fmt.Errorf("gerrit.ref_commits: must not be negative"))
+	}
+
+	if !strings.HasPrefix(this.Github.Url, "https://") && !strings.HasPrefix(this.Github.Url, "http://") {
+		errs = append(errs, fmt.Errorf("github.url: %q is not an http(s) URL", this.Github.Url))
+	}
+	if len(this.Github.Org) > 0 && !githubOrgRegex.MatchString(this.Github.Org) {
+		errs = append(errs, fmt.Errorf("github.org: %q is not an organization name", this.Github.Org))
+	}
+	for _, name := range this.Github.RepoNames() {
+		if !githubRepoRegex.MatchString(this.Github.Repos[name]) {
+			errs = append(errs, fmt.Errorf("github.repos.%s: %q is not of the form owner/repo", name, this.Github.Repos[name]))
+		}
+	}
+
+	return errs
+}
+
+// HasChannel returns true if channel is one of the configured channels.
+func (this *IRCConfig) HasChannel(channel string) bool {
+	for _, existing := range this.Channels {
+		if strings.EqualFold(existing, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the names of the channels with settings, sorted.
+func (this *Config) channelNames() []string {
+	names := make([]string, 0, len(this.Channels))
+	for name := range this.Channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Channel returns the settings for channel, if it has any.
+func (this *Config) Channel(channel string) (ChannelConfig, bool) {
+	if config, ok := this.Channels[channel]; ok {
+		return config, true
+	}
+	for name, config := range this.Channels {
+		if strings.EqualFold(name, channel) {
+			return config, true
+		}
+	}
+	return ChannelConfig{}, false
+}
+
+// ChannelUses returns the settings that send things to channel, e.g.
+// gerrit.channel or route[2].
+func (this *Config) ChannelUses(channel string) []string {
+	var uses []string
+	if strings.EqualFold(this.Gerrit.Channel, channel) {
+		uses = append(uses, "gerrit.channel")
+	}
+	if strings.EqualFold(this.Admin.Channel, channel) {
+		uses = append(uses, "admin.channel")
+	}
+	for idx, route := range this.Routes {
+		if strings.EqualFold(route.Channel, channel) {
+			uses = append(uses, fmt.Sprintf("route[%d]", idx))
+		}
+	}
+	for idx, watch := range this.Jira.Watches {
+		if strings.EqualFold(watch.Channel, channel) {
+			uses = append(uses, fmt.Sprintf("jira.watch[%d]", idx))
+		}
+	}
+	for idx, report := range this.Reports {
+		if strings.EqualFold(report.Channel, channel) {
+			uses = append(uses, fmt.Sprintf("report[%d]", idx))
+		}
+	}
+	if _, ok := this.Channel(channel); ok {
+		uses = append(uses, "channel."+channel)
+	}
+	return uses
+}
+
+// HandlerEnabled returns true if Gerrit events of eventType should be
+// published in channel.
+func (this *Config) HandlerEnabled(channel string, eventType string) bool {
+	if config, ok := this.Channel(channel); ok {
+		if enabled, ok := config.Events[eventType]; ok {
+			return enabled
+		}
+	}
+	if enabled, ok := this.Gerrit.Events[eventType]; ok {
+		return enabled
+	}
+	return eventHandlers[eventType].enabled
+}
+
+// ExpanderEnabled returns true if the expander called name is to be used in
+// channel.
+func (this *Config) ExpanderEnabled(channel string, name string) bool {
+	if config, ok := this.Channel(channel); ok {
+		if enabled, ok := config.Expanders[name]; ok {
+			return enabled
+		}
+	}
+	if enabled, ok := this.Expanders[name]; ok {
+		return enabled
+	}
+	return expanders[name].enabled
+}
+
+// WantsProject returns true if activity on project should be published.
+func (this *GerritConfig) WantsProject(project string) bool {
+	if len(this.Projects) == 0 {
+		return true
+	}
+	for _, existing := range this.Projects {
+		if existing == project {
+			return true
+		}
+	}
+	return false
+}
+
+// RepoNames returns the bare repository names that can be looked up, sorted.
+func (this *GithubConfig) RepoNames() []string {
+	names := make([]string, 0, len(this.Repos))
+	for name := range this.Repos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var (
+	configMutex   sync.RWMutex
+	currentConfig = defaultConfig()
+)
+
+// getConfig returns the configuration currently in effect. It must be treated
+// as read only, as a reload replaces it wholesale.
+func getConfig() *Config {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return currentConfig
+}
+
+func setConfig(config *Config) {
+	configMutex.Lock()
+	currentConfig = config
+	configMutex.Unlock()
 }
@@ -0,0 +1,191 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, dir, name, contents string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("Failed to write %s: %s", path, err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qt_gerrit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := writeTestFile(t, dir, "id_rsa", "key")
+	path := writeTestFile(t, dir, "qt_gerrit.toml", `
+[irc]
+server = "irc.example.org:6667"
+channels = ["#qt-labs", "#qt-gerrit"]
+nickserv_user = "bot"
+nickserv_pass = "pass"
+
+[gerrit]
+user = "bot"
+private_key = "`+key+`"
+host_key_fingerprint = "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8"
+channel = "#qt-gerrit"
+projects = ["qt/qtbase"]
+
+[[jira.watch]]
+channel = "#qt-labs"
+jql = "project = QTBUG AND priority = P0"
+
+[github.repos]
+qtwayland = "qt/qtwayland"
+`)
+
+	env := map[string]string{
+		"IRC_CHANNELS":     "#qt-labs, #qt-gerrit,#qt",
+		"GERRIT_CHANNEL":   "#qt",
+		"GERRIT_HTTP_USER": "bot",
+		"GERRIT_HTTP_PASS": "hunter2",
+		"JIRA_TOKEN":       "secret",
+	}
+	config, err := loadConfig(path, func(name string) string { return env[name] })
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if config.IRC.Server != "irc.example.org:6667" {
+		t.Errorf("Expected: %#v, got %#v", "irc.example.org:6667", config.IRC.Server)
+	}
+	if config.IRC.Nick != "qt_gerrit" {
+		t.Errorf("Expected: %#v, got %#v", "qt_gerrit", config.IRC.Nick)
+	}
+	if strings.Join(config.IRC.Channels, ",") != "#qt-labs,#qt-gerrit,#qt" {
+		t.Errorf("Expected: %#v, got %#v", "#qt-labs,#qt-gerrit,#qt", config.IRC.Channels)
+	}
+	if config.Gerrit.Channel != "#qt" {
+		t.Errorf("Expected: %#v, got %#v", "#qt", config.Gerrit.Channel)
+	}
+	if config.Gerrit.Host != "codereview.qt-project.org:29418" {
+		t.Errorf("Expected: %#v, got %#v", "codereview.qt-project.org:29418", config.Gerrit.Host)
+	}
+	if !config.Gerrit.WantsProject("qt/qtbase") || config.Gerrit.WantsProject("qt/qtdoc") {
+		t.Errorf("Expected only qt/qtbase to be wanted, got %#v", config.Gerrit.Projects)
+	}
+	if config.Gerrit.HttpUser != "bot" || config.Gerrit.HttpPassword != "hunter2" {
+		t.Errorf("Expected: %#v, got %#v", "bot:hunter2", config.Gerrit.HttpUser+":"+config.Gerrit.HttpPassword)
+	}
+	if config.Jira.Token != "secret" {
+		t.Errorf("Expected: %#v, got %#v", "secret", config.Jira.Token)
+	}
+	if len(config.Jira.Watches) != 1 || config.Jira.Watches[0].Interval != 5*time.Minute {
+		t.Errorf("Expected one watch, polled every 5m, got %#v", config.Jira.Watches)
+	}
+	if names := strings.Join(config.Github.RepoNames(), ","); names != "qtwayland" {
+		t.Errorf("Expected: %#v, got %#v", "qtwayland", names)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qt_gerrit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeTestFile(t, dir, "qt_gerrit.toml", `
+[irc]
+server = "irc.example.org"
+channels = ["qt-labs"]
+colour = true
+bouncer_listen = "localhost:6667"
+
+[gerrit]
+private_key = "`+filepath.Join(dir, "missing")+`"
+known_hosts = "`+filepath.Join(dir, "known_hosts")+`"
+channel = "#qt-gerrit"
+http_user = "bot"
+http_token = "secret"
+
+[jira]
+user = "bot@example.org"
+
+[[jira.watch]]
+channel = "#qt-jira"
+jql = "project = QTBUG order  by created"
+interval = "10s"
+
+[github]
+org = "qt/qtbase"
+
+[watch]
+max = 0
+
+[github.repos]
+qtbase = "qtbase"
+`)
+
+	_, err = loadConfig(path, func(string) string { return "" })
+	errs, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatalf("Expected ConfigErrors, got %#v", err)
+	}
+
+	expected := []string{
+		"irc.colour: unknown setting",
+		"irc.server: address irc.example.org: missing port in address",
+		"irc.nickserv_user: must be set (or NICKSERV_USER)",
+		"irc.nickserv_pass: must be set (or NICKSERV_PASS)",
+		`irc.channels: "qt-labs" is not a channel`,
+		"irc.bouncer_pass: must be set (or BOUNCER_PASS)",
+		"gerrit.user: must be set (or GERRIT_USER)",
+		"gerrit.private_key: open " + filepath.Join(dir, "missing") + ": no such file or directory",
+		"gerrit.known_hosts: open " + filepath.Join(dir, "known_hosts") + ": no such file or directory (set gerrit.host_key_fingerprint, or enable gerrit.trust_on_first_use)",
+		"gerrit.channel: #qt-gerrit is not in irc.channels",
+		"gerrit.http_user and gerrit.http_token can't both be set",
+		"jira.user: needs jira.token (or JIRA_TOKEN)",
+		"jira.watch[0].channel: #qt-jira is not in irc.channels",
+		"jira.watch[0].jql: can't have an ORDER BY",
+		"jira.watch[0].interval: must be at least a minute",
+		"watch.max: must be at least 1",
+		`github.org: "qt/qtbase" is not an organization name`,
+		`github.repos.qtbase: "qtbase" is not of the form owner/repo`,
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %d:\n%s", len(expected), len(errs), errs)
+	}
+	for idx, err := range errs {
+		if err.Error() != expected[idx] {
+			t.Errorf("Expected: %#v, got %#v", expected[idx], err.Error())
+		}
+	}
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A cronSchedule is when something is to run, as in a crontab: minute, hour,
+// day of the month, month and day of the week (0 or 7 being Sunday), each a
+// *, or a list of numbers and ranges (e.g. 1-5), optionally with a step
+// (e.g. */15).
+type cronSchedule struct {
+	spec    string
+	minutes [60]bool
+	hours   [24]bool
+	days    [32]bool
+	months  [13]bool
+	weekday [8]bool
+
+	// As in cron, if both the day of the month and of the week are
+	// restricted, a day matching either will do.
+	anyDay     bool
+	anyWeekday bool
+}
+
+// Parse one field of a schedule, marking the values it allows in allowed,
+// which has room for values from min to max. Returns whether it was a *.
+func parseCronField(field string, allowed []bool, min int, max int) (bool, error) {
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			var err error
+			rangePart = part[:idx]
+			if step, err = strconv.Atoi(part[idx+1:]); err != nil || step < 1 {
+				return false, fmt.Errorf("bad step in %q", part)
+			}
+		}
+
+		from, to := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return false, fmt.Errorf("bad value %q", part)
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return false, fmt.Errorf("bad value %q", part)
+				}
+			} else if step > 1 {
+				to = max
+			}
+			if from < min || to > max || from > to {
+				return false, fmt.Errorf("%q is out of range (%d-%d)", part, min, max)
+			}
+		}
+
+		for value := from; value <= to; value += step {
+			allowed[value] = true
+		}
+	}
+	return field == "*", nil
+}
+
+// Parse a schedule, e.g. "0 9 * * 1-5" for 9:00 on weekdays.
+func parseCron(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%q should have 5 fields (minute hour day month weekday)", spec)
+	}
+
+	schedule := &cronSchedule{spec: spec}
+	var err error
+	if _, err = parseCronField(fields[0], schedule.minutes[:], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %s", err.Error())
+	}
+	if _, err = parseCronField(fields[1], schedule.hours[:], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %s", err.Error())
+	}
+	if schedule.anyDay, err = parseCronField(fields[2], schedule.days[:], 1, 31); err != nil {
+		return nil, fmt.Errorf("day: %s", err.Error())
+	}
+	if _, err = parseCronField(fields[3], schedule.months[:], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %s", err.Error())
+	}
+	if schedule.anyWeekday, err = parseCronField(fields[4], schedule.weekday[:], 0, 7); err != nil {
+		return nil, fmt.Errorf("weekday: %s", err.Error())
+	}
+	if schedule.weekday[7] {
+		schedule.weekday[0] = true
+	}
+	return schedule, nil
+}
+
+// Matches returns true if the schedule says to run in the minute of t.
+func (this *cronSchedule) Matches(t time.Time) bool {
+	if !this.minutes[t.Minute()] || !this.hours[t.Hour()] || !this.months[t.Month()] {
+		return false
+	}
+	day, weekday := this.days[t.Day()], this.weekday[t.Weekday()]
+	switch {
+	case this.anyDay && this.anyWeekday:
+		return true
+	case this.anyDay:
+		return weekday
+	case this.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+func (this *cronSchedule) String() string {
+	return this.spec
+}
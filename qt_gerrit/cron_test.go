@@ -0,0 +1,92 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	bad := []string{
+		"",
+		"0 9 * *",
+		"0 9 * * * *",
+		"60 9 * * *",
+		"0 24 * * *",
+		"0 9 0 * *",
+		"0 9 * 13 *",
+		"0 9 * * 8",
+		"0 9 * * 5-1",
+		"*/0 9 * * *",
+		"a 9 * * *",
+		"0 9-x * * *",
+	}
+	for _, spec := range bad {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("Expected an error parsing %#v", spec)
+		}
+	}
+}
+
+func TestCronMatches(t *testing.T) {
+	// 2023-11-14 was a Tuesday
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2023, 11, day, hour, minute, 30, 0, time.UTC)
+	}
+
+	tests := []struct {
+		Spec     string
+		Time     time.Time
+		Expected bool
+	}{
+		{"* * * * *", at(14, 22, 13), true},
+		{"0 9 * * 1-5", at(14, 9, 0), true},
+		{"0 9 * * 1-5", at(14, 9, 1), false},
+		{"0 9 * * 1-5", at(18, 9, 0), false},
+		{"0 9 * * 7", at(19, 9, 0), true},
+		{"0 9 * * 0", at(19, 9, 0), true},
+		{"*/15 * * * *", at(14, 3, 45), true},
+		{"*/15 * * * *", at(14, 3, 50), false},
+		{"10/20 * * * *", at(14, 3, 50), true},
+		{"0 8,12,16 * * *", at(14, 12, 0), true},
+		{"0 8,12,16 * * *", at(14, 13, 0), false},
+		{"0 9 * 12 *", at(14, 9, 0), false},
+		// either the day of the month or the weekday
+		{"0 9 1 * 2", at(14, 9, 0), true},
+		{"0 9 1 * 3", at(14, 9, 0), false},
+		{"0 9 14 * *", at(14, 9, 0), true},
+	}
+	for _, test := range tests {
+		schedule, err := parseCron(test.Spec)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %#v: %s", test.Spec, err)
+			continue
+		}
+		if got := schedule.Matches(test.Time); got != test.Expected {
+			t.Errorf("Expected %s at %s: %#v, got %#v", test.Spec, test.Time, test.Expected, got)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// A page of documentation.
+type DocPage struct {
+	Title string
+	Url   string
+}
+
+// Expands links to documentation pages, e.g.
+// https://doc.qt.io/qt-6/qstring.html
+type docsExpander struct{}
+
+var docTitleRegex = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+func (this docsExpander) Pattern(config *Config) *regexp.Regexp {
+	if len(config.Docs.Url) == 0 {
+		return nil
+	}
+	docsUrl := regexp.QuoteMeta(strings.TrimSuffix(config.Docs.Url, "/"))
+	return regexp.MustCompile(docsUrl + `/[A-Za-z0-9_./-]+\.html(?:#[A-Za-z0-9_-]+)?`)
+}
+
+func (this docsExpander) Fetch(ctx context.Context, lookups *lookupService, config *Config, match []string) (interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", match[0], nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := lookups.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("while fetching HTTP: %s", err.Error())
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, userErrorf("%s doesn't exist", match[0])
+	} else if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP status %s", res.Status)
+	}
+
+	// the title is near the top
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("while reading response: %s", err.Error())
+	}
+	title := docTitleRegex.FindSubmatch(body)
+	if title == nil {
+		return nil, errors.New("the page has no title")
+	}
+	return &DocPage{
+		Title: strings.Join(strings.Fields(html.UnescapeString(string(title[1]))), " "),
+		Url:   match[0],
+	}, nil
+}
+
+func (this docsExpander) Format(config *Config, found interface{}) string {
+	page := found.(*DocPage)
+	return fmt.Sprintf("%s - %s", page.Title, page.Url)
+}
+
+func init() {
+	registerExpander("docs", false, docsExpander{})
+}
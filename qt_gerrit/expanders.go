@@ -0,0 +1,203 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// An Expander recognises references to something (an issue, a change, a
+// commit, ...) in what's said in channels, looks them up, and describes
+// them.
+type Expander interface {
+	// The pattern references are recognised by, given the config, or nil if
+	// none can be. If it has a group named ref, that's the reference, and the
+	// rest only what has to come before it.
+	Pattern(config *Config) *regexp.Regexp
+
+	// Look up what a match of the pattern (with its groups, as returned by
+	// FindStringSubmatch) refers to.
+	Fetch(ctx context.Context, lookups *lookupService, config *Config, match []string) (interface{}, error)
+
+	// Describe what Fetch found, in one line.
+	Format(config *Config, found interface{}) string
+}
+
+type registeredExpander struct {
+	expander Expander
+	enabled  bool // whether it's enabled unless configured otherwise
+}
+
+var expanders = map[string]registeredExpander{}
+
+// Register expander under name. If enabled is false, it's only used in
+// channels whose config enables it.
+func registerExpander(name string, enabled bool, expander Expander) {
+	expanders[name] = registeredExpander{expander: expander, enabled: enabled}
+}
+
+// Return the names of the expanders, sorted.
+func expanderNames() []string {
+	names := make([]string, 0, len(expanders))
+	for name := range expanders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// A reference to something, found in a message.
+type reference struct {
+	expander string   // the name of the expander that recognised it
+	match    []string // the match of its pattern; match[0] is the whole of it
+	offset   int      // where in the message it starts
+}
+
+// Find the references in text that are to be expanded in channel, in the
+// order they appear.
+func (this *Config) findReferences(channel string, text string) []reference {
+	var refs []reference
+	for _, name := range expanderNames() {
+		if !this.ExpanderEnabled(channel, name) {
+			continue
+		}
+		pattern := expanders[name].expander.Pattern(this)
+		if pattern == nil {
+			continue
+		}
+		refGroup := pattern.SubexpIndex("ref")
+		for _, loc := range pattern.FindAllStringSubmatchIndex(text, -1) {
+			match := make([]string, len(loc)/2)
+			for idx := range match {
+				if loc[2*idx] >= 0 {
+					match[idx] = text[loc[2*idx]:loc[2*idx+1]]
+				}
+			}
+			offset := loc[0]
+			if refGroup > 0 {
+				match[0], offset = match[refGroup], loc[2*refGroup]
+			}
+			refs = append(refs, reference{expander: name, match: match, offset: offset})
+		}
+	}
+	sort.SliceStable(refs, func(i, j int) bool {
+		return refs[i].offset < refs[j].offset
+	})
+	return refs
+}
+
+// Look up and describe ref.
+func expandReference(ctx context.Context, lookups *lookupService, config *Config, ref reference) (string, error) {
+	expander := expanders[ref.expander].expander
+	found, err := expander.Fetch(ctx, lookups, config, ref.match)
+	if err != nil {
+		return "", err
+	}
+	return expander.Format(config, found), nil
+}
+
+// A userError is a failure to look something up that's worth telling the
+// channel about as it is, e.g. that it doesn't exist. Other errors are only
+// logged, and the channel just told the lookup failed.
+type userError struct {
+	message string
+}
+
+func (this *userError) Error() string {
+	return this.message
+}
+
+func userErrorf(format string, args ...interface{}) error {
+	return &userError{message: fmt.Sprintf(format, args...)}
+}
+
+// errNotAReference is returned by an expander when what its pattern matched
+// turns out not to be a reference after all. Nothing is said about it.
+var errNotAReference = errors.New("not a reference")
+
+// Look up and describe ref, or say why that couldn't be done. Returns an empty
+// string if ref isn't a reference after all.
+func describeReference(ctx context.Context, lookups *lookupService, config *Config, ref reference) string {
+	started := time.Now()
+	line, err := expandReference(ctx, lookups, config, ref)
+	var userErr *userError
+	isUserErr := errors.As(err, &userErr)
+	notAReference := errors.Is(err, errNotAReference)
+	monitor.LookedUp(ref.expander, time.Since(started), err != nil && !isUserErr && !notAReference)
+	if err == nil {
+		return line
+	}
+	if notAReference {
+		return ""
+	}
+	if isUserErr {
+		return userErr.message
+	}
+	fmt.Printf("Failed to look up %s: %s\n", ref.match[0], err.Error())
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Sprintf("Couldn't look up %s in time", ref.match[0])
+	}
+	return fmt.Sprintf("Couldn't look up %s", ref.match[0])
+}
+
+// Expand refs, all at once, sending the descriptions to resultsChannel,
+// prefixed with directTo, in the order of refs. Whatever isn't done by the
+// deadline is given up on.
+func runExpansions(lookups *lookupService, config *Config, resultsChannel chan string, directTo string, refs []reference) {
+	defer func() { close(resultsChannel) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Lookup.Deadline)
+	defer cancel()
+
+	results := make([]chan string, len(refs))
+	for idx, ref := range refs {
+		results[idx] = make(chan string, 1)
+		go func(ref reference, result chan string) {
+			result <- describeReference(ctx, lookups, config, ref)
+		}(ref, results[idx])
+	}
+
+	for idx, result := range results {
+		var line string
+		select {
+		case line = <-result:
+		case <-ctx.Done():
+			// in case an expander doesn't give up by itself
+			select {
+			case line = <-result:
+			default:
+				line = fmt.Sprintf("Couldn't look up %s in time", refs[idx].match[0])
+			}
+		}
+		if len(line) > 0 {
+			resultsChannel <- directTo + line
+		}
+	}
+}
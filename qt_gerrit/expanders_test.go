@@ -0,0 +1,160 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A stand-in for Jira, Gerrit, cgit and the docs, all in one.
+func startTestExpanderServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.RequestURI() {
+		case "/rest/api/2/issue/QTBUG-1":
+			fmt.Fprintf(w, `{"key": "QTBUG-1", "fields": {"summary": "It crashes", "status": {"name": "Open"}}}`)
+		case "/rest/api/2/issue/QTBUG-2":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"errorMessages": ["Issue Does Not Exist"]}`)
+		case "/rest/api/2/issue/QTBUG-3":
+			// never answers
+			<-r.Context().Done()
+		case "/rest/api/2/issue/QTBUG-4":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/changes/1234":
+			fmt.Fprintf(w, ")]}'\n"+`{"id": "qt%%2Fqtbase~dev~I0123", "project": "qt/qtbase", "branch": "dev", "subject": "Fix it", "status": "NEW", "_number": 1234, "owner": {"name": "Alice"}}`)
+		case "/cgit/qt/qtbase.git/patch/?id=abcdef1":
+			fmt.Fprintf(w, "From abcdef1234 Mon Sep 17 00:00:00 2001\nFrom: =?UTF-8?q?J=C3=B6rg?= <joerg@example.com>\nDate: Tue, 14 Nov 2023 22:13:20 +0100\nSubject: [PATCH] Fix the\n frobnicator\n\nIt was broken.\n---\n")
+		case "/qt-6/qstring.html":
+			fmt.Fprintf(w, "<html><head><title>QString Class | Qt Core &amp; friends</title></head></html>")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestFindReferences(t *testing.T) {
+	config := testConfig("https://example.org")
+	text := "See https://example.org/1234 for QTBUG-1, and qtbase/abcdef1 or https://example.org/cgit/qt/qtbase.git/commit/?h=dev&id=abcdef1 and https://example.org/qt-6/qstring.html#details"
+
+	describe := func(refs []reference) string {
+		var got []string
+		for _, ref := range refs {
+			got = append(got, ref.expander+" "+ref.match[0])
+		}
+		return strings.Join(got, ", ")
+	}
+
+	expected := "gerrit https://example.org/1234, jira QTBUG-1, github qtbase/abcdef1"
+	if got := describe(config.findReferences("#qt", text)); got != expected {
+		t.Errorf("Expected: %#v, got %#v", expected, got)
+	}
+
+	expected = "gerrit https://example.org/1234, github qtbase/abcdef1, cgit https://example.org/cgit/qt/qtbase.git/commit/?h=dev&id=abcdef1, docs https://example.org/qt-6/qstring.html#details"
+	if got := describe(config.findReferences("#qt-docs", text)); got != expected {
+		t.Errorf("Expected: %#v, got %#v", expected, got)
+	}
+
+	config.Expanders = map[string]bool{"gerrit": false}
+	expected = "jira QTBUG-1, github qtbase/abcdef1"
+	if got := describe(config.findReferences("#qt", text)); got != expected {
+		t.Errorf("Expected: %#v, got %#v", expected, got)
+	}
+}
+
+func TestExpanders(t *testing.T) {
+	server := startTestExpanderServer(t)
+	defer server.Close()
+	config := testConfig(server.URL)
+	lookups, _ := newTestLookupService(config)
+
+	tests := []struct {
+		Text     string
+		Expected string
+	}{
+		{"QTBUG-1", "It crashes - " + server.URL + "/browse/QTBUG-1 (Open)"},
+		{"QTBUG-2", "QTBUG-2: Issue Does Not Exist"},
+		{"QTBUG-4", "Couldn't look up QTBUG-4"},
+		{server.URL + "/1234", "[qt/qtbase/dev] Fix it from Alice - " + server.URL + "/1234 (NEW)"},
+		{server.URL + "/999", "Change 999 doesn't exist"},
+		{server.URL + "/cgit/qt/qtbase.git/commit/?id=abcdef1", "[qt/qtbase] Fix the frobnicator from Jörg - " + server.URL + "/cgit/qt/qtbase.git/commit/?id=abcdef1"},
+		{server.URL + "/qt-6/qstring.html", "QString Class | Qt Core & friends - " + server.URL + "/qt-6/qstring.html"},
+		{server.URL + "/qt-6/missing.html", server.URL + "/qt-6/missing.html doesn't exist"},
+	}
+	for _, test := range tests {
+		refs := config.findReferences("#qt-all", test.Text)
+		if len(refs) != 1 {
+			t.Errorf("Expected one reference in %s, got %#v", test.Text, refs)
+			continue
+		}
+		got := describeReference(context.Background(), lookups, config, refs[0])
+		if got != test.Expected {
+			t.Errorf("Expected: %#v, got %#v", test.Expected, got)
+		}
+	}
+}
+
+func TestRunExpansions(t *testing.T) {
+	server := startTestExpanderServer(t)
+	defer server.Close()
+	config := testConfig(server.URL)
+	config.Lookup.Deadline = 200 * time.Millisecond
+	lookups, _ := newTestLookupService(config)
+
+	refs := config.findReferences("#qt", "QTBUG-3 QTBUG-1, QTBUG-4 and "+server.URL+"/1234")
+	results := make(chan string)
+	go runExpansions(lookups, config, results, "alice: ", refs)
+
+	var got []string
+	for line := range results {
+		got = append(got, line)
+	}
+	expected := []string{
+		"alice: Couldn't look up QTBUG-3 in time",
+		"alice: It crashes - " + server.URL + "/browse/QTBUG-1 (Open)",
+		"alice: Couldn't look up QTBUG-4",
+		"alice: [qt/qtbase/dev] Fix it from Alice - " + server.URL + "/1234 (NEW)",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected: %#v, got %#v", expected, got)
+	}
+}
+
+func TestUnknownExpander(t *testing.T) {
+	config := defaultConfig()
+	config.Expanders = map[string]bool{"bugzilla": true}
+	expected := "expanders.bugzilla: unknown expander (known: cgit, docs, gerrit, github, jira)"
+	for _, err := range config.validate() {
+		if err.Error() == expected {
+			return
+		}
+	}
+	t.Errorf("Expected the error %#v", expected)
+}
@@ -25,81 +25,60 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"github.com/rburchell/gobo/lib/gerrit"
 	"net/http"
-	"time"
+	"regexp"
+	"strings"
 )
 
-type GerritChange struct {
-	Kind      string `json:"kind"`
-	Id        string `json:"id"`
-	Project   string `json:"project"`
-	Branch    string `json:"branch"`
-	ChangeId  string `json:"change_id"`
-	Subject   string `json:"subject"`
-	Status    string `json:"status"`
-	Created   string `json:"created"`
-	Updated   string `json:"updated"`
-	Mergeable bool   `json:"mergeable"`
-	SortKey   string `json:"_sortkey"`
-	Number    int    `json:"_number"`
-	Owner     struct {
-		Name string `json:"name"`
-	} `json:"owner"`
+// Create a client for Gerrit's REST API, as configured.
+func newGerritRestClient(config GerritConfig, hclient *http.Client) *gerrit.RestClient {
+	return gerrit.NewRestClient(gerrit.RestConfig{
+		Url:        config.Url,
+		Username:   config.HttpUser,
+		Password:   config.HttpPassword,
+		Token:      config.HttpToken,
+		HTTPClient: hclient,
+	})
 }
 
-func handleGerritWebApi(resultsChannel chan string, directTo string, changes []string) {
-	defer func() { close(resultsChannel) }()
+// Expands Gerrit Change-Ids, and links to changes.
+type gerritExpander struct{}
 
-	hclient := http.Client{
-		Timeout: time.Duration(10 * time.Second),
-	}
-
-	for _, changeId := range changes {
-		res, err := hclient.Get("https://codereview.qt-project.org/changes/" + changeId)
-		if err != nil {
-			resultsChannel <- fmt.Sprintf("Error retrieving change %s (while fetching HTTP): %s", changeId, err.Error())
-			continue
-		}
-
-		jsonBlob, err := ioutil.ReadAll(res.Body)
-		res.Body.Close()
-		if err != nil {
-			resultsChannel <- fmt.Sprintf("Error retrieving change %s (while reading response): %s", changeId, err.Error())
-			continue
-		}
+func (this gerritExpander) Pattern(config *Config) *regexp.Regexp {
+	gerritUrl := regexp.QuoteMeta(strings.TrimSuffix(config.Gerrit.Url, "/"))
+	return regexp.MustCompile(`(I[0-9a-f]{40})|` + gerritUrl + `\/(?:\#\/c\/)?([0-9]+)\/?`)
+}
 
-		// From the Gerrit documentation:
-		// To prevent against Cross Site Script Inclusion (XSSI) attacks, the JSON
-		// response body starts with a magic prefix line that must be stripped before
-		// feeding the rest of the response body to a JSON parser:
-		//    )]}'
-		//    [ ... valid JSON ... ]
-		if !bytes.HasPrefix(jsonBlob, []byte(")]}'\n")) {
-			resultsChannel <- fmt.Sprintf("Error retrieving change %s (couldn't find Gerrit magic)", changeId)
-			continue
-		}
+func (this gerritExpander) Fetch(ctx context.Context, lookups *lookupService, config *Config, match []string) (interface{}, error) {
+	changeId := match[1]
+	if len(changeId) == 0 {
+		changeId = match[2]
+	}
 
-		// strip off the gerrit magic
-		jsonBlob = jsonBlob[5:]
+	change, err := newGerritRestClient(config.Gerrit, lookups.client).GetChange(ctx, changeId)
+	if gerrit.IsNotFound(err) {
+		return nil, userErrorf("Change %s doesn't exist", changeId)
+	} else if err != nil {
+		return nil, err
+	}
 
-		var change GerritChange
-		err = json.Unmarshal(jsonBlob, &change)
-		if err != nil {
-			resultsChannel <- fmt.Sprintf("Error retrieving change %s (while parsing JSON): %s", changeId, err.Error())
-			continue
-		}
+	if len(change.Id) == 0 {
+		return nil, errors.New("malformed reply")
+	}
+	return change, nil
+}
 
-		if len(change.Id) == 0 {
-			resultsChannel <- fmt.Sprintf("Error retrieving change %s: malformed reply", changeId)
-			continue
-		}
+func (this gerritExpander) Format(config *Config, found interface{}) string {
+	change := found.(*gerrit.ChangeInfo)
+	return fmt.Sprintf("[%s/%s] %s from %s - %s (%s)",
+		change.Project, change.Branch, change.Subject, change.Owner.Name,
+		fmt.Sprintf("%s/%d", strings.TrimSuffix(config.Gerrit.Url, "/"), change.Number), change.Status)
+}
 
-		resultsChannel <- fmt.Sprintf("%s[%s/%s] %s from %s - %s (%s)",
-			directTo, change.Project, change.Branch, change.Subject, change.Owner.Name,
-			fmt.Sprintf("https://codereview.qt-project.org/%d", change.Number), change.Status)
-	}
+func init() {
+	registerExpander("gerrit", true, gerritExpander{})
 }
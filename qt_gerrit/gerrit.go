@@ -25,50 +25,28 @@
 package main
 
 import (
-	"bufio"
 	"encoding/json"
+	"errors"
+	"github.com/rburchell/gobo/lib/gerrit"
 	"golang.org/x/crypto/ssh"
 	"io/ioutil"
-	"net"
-	"os"
-	"time"
+	"strings"
 )
 
-type GerritPerson struct {
-	Name     string `json:"name"`     // J-P Nurmi
-	Email    string `json:"email"`    // jpnurmi@theqtcompany.com
-	Username string `json:"username"` // jpnurmi
-}
+type GerritPerson = gerrit.Account
+type GerritApproval = gerrit.Approval
+type GerritRefUpdate = gerrit.RefUpdate
 
+// GerritMessage flattens the events of the gerrit package into one struct,
+// so templates and handlers can treat them alike. Fields an event doesn't
+// have are left empty.
 type GerritMessage struct {
-	Type   string `json:"type"` // comment-added
-	Change struct {
-		Project string       `json:"project"`       // qt/qtdeclarative
-		Branch  string       `json:"branch"`        // 5.6
-		Id      string       `json:"id"`            // Icefdec91b012b12728367fd54b4d16796233ee12
-		Number  int64        `json:"number,string"` // 125617
-		Subject string       `json:"subject"`       // Make QML composite types inherit enums
-		Owner   GerritPerson `json:"owner"`
-		Url     string       `json:"url"` // https://codereview.qt-project.org/125617
-	} `json:"change"`
-	PatchSet struct {
-		Number         int64        `json:"number,string"` // 9
-		Revision       string       `json:"revision"`      // 52c9ebcd78379b0eacc1476237720e06abf286b3
-		Parents        []string     `json:"parents"`       // ["9688aa4fe3195147881dc0969bf000bfc8a65e5e"]
-		Ref            string       `json:"ref"`           // refs/changes/17/125617/9
-		Uploader       GerritPerson `json:"uploader"`
-		CreatedOn      int64        `json:"createdOn"` // 1442413012
-		Author         GerritPerson `json:"author"`
-		SizeInsertions int64        `json:"sizeInsertions"` // 80
-		SizeDeletions  int64        `json:"sizeDeletions"`  // -13
-	} `json:"patchSet"`
-	Author    GerritPerson `json:"author"`
-	Approvals []struct {
-		Type        string `json:"type"`
-		Description string `json:"description"`
-		Value       int64  `json:"value,string"`
-	} `json:"approvals"`
-	Comment string `json:"comment"`
+	Type      string           `json:"type"` // comment-added
+	Change    gerrit.Change    `json:"change"`
+	PatchSet  gerrit.PatchSet  `json:"patchSet"`
+	Author    GerritPerson     `json:"author"`
+	Approvals []GerritApproval `json:"approvals"`
+	Comment   string           `json:"comment"`
 
 	// Used in change-abandoned
 	Abandoner GerritPerson `json:"abandoner"`
@@ -79,133 +57,152 @@ type GerritMessage struct {
 	// used in ref-updated
 	Submitter GerritPerson    `json:"submitter"`
 	RefUpdate GerritRefUpdate `json:"refUpdate"`
+	RefChange RefChange       `json:"-"` // worked out by handleRefUpdate
 
-	// used in merge-failed
+	// used in merge-failed and change-restored
 	Reason string `json:"reason"`
 
-	OriginalJson []byte
-}
+	// Used in change-restored
+	Restorer GerritPerson `json:"restorer"`
 
-type GerritRefUpdate struct {
-	OldRev  string `json:"oldRev"`
-	NewRev  string `json:"newRev"`
-	RefName string `json:"refName"`
-	Project string `json:"project"`
-}
+	// Used in topic-changed, wip-state-changed, private-state-changed and
+	// assignee-changed
+	Changer     GerritPerson `json:"changer"`
+	OldTopic    string       `json:"oldTopic"`
+	OldAssignee GerritPerson `json:"oldAssignee"`
 
-// Connect to Gerrit (and keep trying until we succeed).
-func (this *GerritClient) connectToGerrit(signer *ssh.Signer) (*ssh.Client, *bufio.Reader) {
-	gerritUser := os.Getenv("GERRIT_USER")
-	if len(gerritUser) == 0 {
-		panic("Must provide GERRIT_USER environment variable.")
-	}
+	// Used in hashtags-changed
+	Editor   GerritPerson `json:"editor"`
+	Added    []string     `json:"added"`
+	Removed  []string     `json:"removed"`
+	Hashtags []string     `json:"hashtags"`
 
-	config := &ssh.ClientConfig{
-		User: gerritUser,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(*signer),
-		},
-		Config: ssh.Config{
-			// this should be rechecked whenever Gerrit is upgraded, and ideally
-			// done away with once a better cipher is available there.
-			Ciphers: []string{
-				"aes128-cbc",
-			},
-		},
-		HostKeyCallback: func(host string, remote net.Addr, key ssh.PublicKey) error {
-			return nil
-		},
+	// Used in reviewer-added and vote-deleted
+	Reviewer GerritPerson `json:"reviewer"`
+	Adder    GerritPerson `json:"adder"`
+	Remover  GerritPerson `json:"remover"`
+
+	// Used in project-created
+	CreatedProject string `json:"projectName"`
+	ProjectHead    string `json:"projectHead"`
+
+	// When Gerrit says the event happened, in seconds since the epoch.
+	EventCreatedOn int64 `json:"eventCreatedOn"`
+
+	// Set on events reconstructed after missing them while disconnected.
+	Replayed bool `json:"-"`
+
+	OriginalJson []byte
+}
+
+// ChangedApprovals returns the votes the event changed. Gerrit also sends the
+// author's other, unchanged, votes in comment-added, marking the changed ones
+// with an oldValue; if none are marked, it was a plain comment. Older Gerrit
+// never marks them, so with gerrit.legacy_approvals all of them are taken to
+// have changed instead.
+func (this *GerritMessage) ChangedApprovals() []GerritApproval {
+	if getConfig().Gerrit.LegacyApprovals && !gerrit.HasOldValues(this.Approvals) {
+		return this.Approvals
 	}
+	return gerrit.ChangedApprovals(this.Approvals)
+}
 
-	this.DiagnosticsChannel <- "Attempting to connect to Gerrit"
-
-	for {
-		client, err := SSHDialTimeout("tcp", "codereview.qt-project.org:29418", config, time.Second*10, time.Hour*5, time.Second*20)
-		if err != nil {
-			this.DiagnosticsChannel <- "Failed to dial: " + err.Error()
-			time.Sleep(10 * time.Second)
-			continue
-		}
-
-		session, err := client.NewSession()
-		if err != nil {
-			this.DiagnosticsChannel <- "Failed to create session:" + err.Error()
-			time.Sleep(10 * time.Second)
-			continue
-		}
-
-		stdout, err := session.StdoutPipe()
-		if err != nil {
-			this.DiagnosticsChannel <- "Failed to get stdout pipe: " + err.Error()
-			time.Sleep(10 * time.Second)
-			continue
-		}
-
-		bio := bufio.NewReader(stdout)
-		if err := session.Start("gerrit stream-events"); err != nil {
-			this.DiagnosticsChannel <- "Failed to stream: " + err.Error()
-			time.Sleep(10 * time.Second)
-			continue
-		} else {
-			this.DiagnosticsChannel <- "Gerrit connection reestablished."
-			return client, bio
-		}
+// Actor returns the person who caused the event, if there is one.
+func (this *GerritMessage) Actor() GerritPerson {
+	switch this.Type {
+	case "comment-added":
+		return this.Author
+	case "patchset-created":
+		return this.PatchSet.Uploader
+	case "change-merged", "merge-failed", "ref-updated":
+		return this.Submitter
+	case "change-abandoned":
+		return this.Abandoner
+	case "change-deferred":
+		return this.Deferrer
+	case "change-restored":
+		return this.Restorer
+	case "topic-changed", "wip-state-changed", "private-state-changed", "assignee-changed":
+		return this.Changer
+	case "hashtags-changed":
+		return this.Editor
+	case "reviewer-added":
+		return this.Adder
+	case "vote-deleted":
+		return this.Remover
 	}
+	return GerritPerson{}
 }
 
-type GerritClient struct {
-	MessageChannel     chan *GerritMessage
-	DiagnosticsChannel chan string
-	client             *ssh.Client
+// ProjectName returns the project the event is about.
+func (this *GerritMessage) ProjectName() string {
+	switch this.Type {
+	case "ref-updated":
+		return this.RefUpdate.Project
+	case "project-created":
+		return this.CreatedProject
+	}
+	return this.Change.Project
 }
 
-func NewClient() *GerritClient {
-	client := new(GerritClient)
-	client.MessageChannel = make(chan *GerritMessage)
-	client.DiagnosticsChannel = make(chan string)
-	return client
+// BranchName returns the branch the event is about.
+func (this *GerritMessage) BranchName() string {
+	switch this.Type {
+	case "ref-updated":
+		return strings.TrimPrefix(this.RefUpdate.RefName, "refs/heads/")
+	case "project-created":
+		return strings.TrimPrefix(this.ProjectHead, "refs/heads/")
+	}
+	return this.Change.Branch
 }
 
-func (this *GerritClient) Run() {
-	gerritKey := os.Getenv("GERRIT_PRIVATE_KEY")
-	if len(gerritKey) == 0 {
-		panic("Must provide GERRIT_PRIVATE_KEY environment variable.")
+// Flatten an event from the gerrit package into a GerritMessage.
+func newGerritMessage(event gerrit.Event) (*GerritMessage, error) {
+	var msg GerritMessage
+	if err := json.Unmarshal(event.Raw(), &msg); err != nil {
+		return nil, err
 	}
+	msg.Replayed = event.IsReplayed()
+	msg.OriginalJson = event.Raw()
+	return &msg, nil
+}
 
-	keybytes, err := ioutil.ReadFile(gerritKey)
+// Build the configuration of the gerrit package's client from ours.
+func gerritClientConfig(config GerritConfig) (gerrit.Config, error) {
+	keybytes, err := ioutil.ReadFile(config.PrivateKey)
 	if err != nil {
-		panic("Failed to read SSH key: " + err.Error())
+		return gerrit.Config{}, errors.New("Failed to read SSH key: " + err.Error())
 	}
 
 	signer, err := ssh.ParsePrivateKey(keybytes)
 	if err != nil {
-		panic("Failed to parse SSH key: " + err.Error())
+		return gerrit.Config{}, errors.New("Failed to parse SSH key: " + err.Error())
 	}
 
-	var bio *bufio.Reader
-
-	for {
-		if this.client == nil {
-			this.client, bio = this.connectToGerrit(&signer)
-		}
-
-		jsonBlob, err := bio.ReadBytes('\n')
-		if err != nil {
-			this.DiagnosticsChannel <- "Error reading line: " + err.Error()
-			this.client.Close()
-			this.client = nil
-			continue // go reconnect, via connectToGerrit
-		}
-
-		var message GerritMessage
-		err = json.Unmarshal(jsonBlob, &message)
-		message.OriginalJson = jsonBlob
-		if err != nil {
-			this.DiagnosticsChannel <- "Error processing JSON: " + err.Error()
-			println("BAD JSON: " + string(jsonBlob))
-			continue
-		}
-
-		this.MessageChannel <- &message
+	return gerrit.Config{
+		Host:   config.Host,
+		User:   config.User,
+		Signer: signer,
+		HostKeys: gerrit.HostKeyConfig{
+			KnownHosts:      config.KnownHosts,
+			Fingerprint:     config.HostKeyFingerprint,
+			TrustOnFirstUse: config.TrustOnFirstUse,
+		},
+		Ciphers:      config.Ciphers,
+		KeyExchanges: config.KeyExchanges,
+		StateFile:    config.StateFile,
+		MaxReplay:    config.MaxReplay,
+	}, nil
+}
+
+// Describe a diagnostic from the gerrit package for the channel.
+func describeDiagnostic(diag gerrit.Diagnostic) string {
+	switch diag.Kind {
+	case gerrit.HostKeyChanged:
+		return "WARNING: Refusing to connect to Gerrit, its " + diag.Err.Error() +
+			". Someone may be intercepting the connection. If the key was changed on purpose, update the known hosts or fingerprint in the config."
+	case gerrit.Disconnected:
+		return "Error reading line: " + diag.Err.Error()
 	}
+	return diag.String()
 }
@@ -26,141 +26,150 @@ package main
 
 import (
 	"fmt"
-	"github.com/rburchell/gobo/lib/irc/client"
-	"os"
+	"sort"
 	"strings"
 )
 
-var gerritChannel = os.Getenv("GERRIT_CHANNEL")
+// An Announcer publishes a Gerrit event, described using the template for its
+// type.
+type Announcer interface {
+	Announce(msg *GerritMessage)
+}
 
-func handleCommentAdded(c *client.IrcClient, msg *GerritMessage) {
-	reviewstring := ""
+// An EventHandler describes one type of Gerrit event to an Announcer.
+type EventHandler func(a Announcer, msg *GerritMessage)
 
-	for _, approval := range msg.Approvals {
-		if len(reviewstring) > 0 {
-			reviewstring += " "
-		}
+type eventHandler struct {
+	handle  EventHandler
+	enabled bool // whether it's enabled unless configured otherwise
+}
 
-		if approval.Value < 0 {
-			// dark red
-		} else if approval.Value > 0 {
-			// dark green
-		}
+var eventHandlers = map[string]eventHandler{}
 
-		atype := approval.Type
-
-		// newer Gerrit uses long form type strings
-		// canonicalize them into something useful
-		if approval.Type == "Code-Review" {
-			atype = "C"
-		} else if approval.Type == "Sanity-Review" {
-			atype = "S"
-		} else if approval.Type == "CRVW" {
-			atype = "C"
-		} else if approval.Type == "SRVW" {
-			atype = "S"
-		} else {
-			panic("Unknown approval type " + atype)
-		}
+// Register handler for the Gerrit event type eventType. If enabled is false,
+// the event is only published in channels whose config enables it.
+func registerHandler(eventType string, enabled bool, handler EventHandler) {
+	eventHandlers[eventType] = eventHandler{handle: handler, enabled: enabled}
+}
 
-		// TODO: color wrap
-		reviewstring += fmt.Sprintf("%s: %d", atype, approval.Value)
+// Return the event types there are handlers for, sorted.
+func handledEventTypes() []string {
+	types := make([]string, 0, len(eventHandlers))
+	for eventType := range eventHandlers {
+		types = append(types, eventType)
 	}
+	sort.Strings(types)
+	return types
+}
 
-	// TODO: handle color when we add it
-	if msg.Author.Email == "qt_sanitybot@qt-project.org" && reviewstring == "S: 1" {
-		// drop these, they're spammy
-	} else {
-		if len(msg.Approvals) > 0 {
-			msg := fmt.Sprintf("[%s/%s] %s from %s reviewed by %s: %s - %s",
-				msg.Change.Project, msg.Change.Branch,
-				msg.Change.Subject, msg.PatchSet.Uploader.Name,
-				msg.Author.Name, reviewstring, msg.Change.Url)
-			c.WriteMessage(gerritChannel, msg)
-		} else {
-			msg := fmt.Sprintf("[%s/%s] %s from %s commented by %s - %s",
-				msg.Change.Project, msg.Change.Branch,
-				msg.Change.Subject, msg.PatchSet.Uploader.Name,
-				msg.Author.Name, msg.Change.Url)
-			c.WriteMessage(gerritChannel, msg)
-		}
-	}
+// The handler for events that are always announced as they are.
+func announceEvent(a Announcer, msg *GerritMessage) {
+	a.Announce(msg)
 }
 
-func handlePatchSetCreated(c *client.IrcClient, msg *GerritMessage) {
-	if msg.PatchSet.Number == 1 {
-		msg := fmt.Sprintf("[%s/%s] %s pushed by %s - %s",
-			msg.Change.Project, msg.Change.Branch,
-			msg.Change.Subject, msg.PatchSet.Uploader.Name,
-			msg.Change.Url)
-		c.WriteMessage(gerritChannel, msg)
-	} else {
-		// TODO: msg.Owner.Name != msg.PatchSet.Uploader.Name, note
-		// separately since someone else updating a patch is
-		// significant
-		msg := fmt.Sprintf("[%s/%s] %s updated by %s - %s",
-			msg.Change.Project, msg.Change.Branch,
-			msg.Change.Subject, msg.PatchSet.Uploader.Name,
-			msg.Change.Url)
-		c.WriteMessage(gerritChannel, msg)
-	}
+func init() {
+	registerHandler("comment-added", true, handleCommentAdded)
+	registerHandler("patchset-created", true, announceEvent)
+	registerHandler("change-merged", true, announceEvent)
+	registerHandler("merge-failed", true, announceEvent)
+	registerHandler("ref-updated", true, handleRefUpdate)
+	registerHandler("change-abandoned", true, announceEvent)
+	registerHandler("change-deferred", true, announceEvent)
+	registerHandler("change-restored", true, announceEvent)
+	registerHandler("topic-changed", true, announceEvent)
+	registerHandler("hashtags-changed", true, handleHashtagsChanged)
+	registerHandler("wip-state-changed", true, announceEvent)
+	registerHandler("private-state-changed", true, announceEvent)
+	registerHandler("vote-deleted", true, announceEvent)
+	registerHandler("assignee-changed", true, announceEvent)
+	registerHandler("project-created", true, announceEvent)
+
+	// too spammy for most channels
+	registerHandler("reviewer-added", false, announceEvent)
+}
+
+// Announces to a set of IRC channels.
+type channelAnnouncer struct {
+	coalescer *coalescer
+	channels  []string
 }
 
-func handleChangeMerged(c *client.IrcClient, msg *GerritMessage) {
-	// TODO: msg.Owner.Name != msg.PatchSet.Uploader.Name, note
-	// separately since someone else updating a patch is
-	// significant
-	str := fmt.Sprintf("[%s/%s] %s authored by %s was cherry-picked by %s - %s",
-		msg.Change.Project, msg.Change.Branch,
-		msg.Change.Subject, msg.PatchSet.Uploader.Name,
-		msg.Submitter.Name,
-		msg.Change.Url)
-	c.WriteMessage(gerritChannel, str)
+func (this *channelAnnouncer) Announce(msg *GerritMessage) {
+	for _, channel := range this.channels {
+		this.coalescer.announce(channel, msg)
+	}
 }
 
-func handleChangeDeferred(c *client.IrcClient, msg *GerritMessage) {
-	// TODO: msg.Owner.Name != msg.Deferrer.Name, note
-	// separately since someone else updating a patch is
-	// significant
-	str := fmt.Sprintf("[%s/%s] %s owned by %s was deferred by %s - %s",
-		msg.Change.Project, msg.Change.Branch,
-		msg.Change.Subject, msg.Change.Owner.Name,
-		msg.Deferrer.Name,
-		msg.Change.Url)
-	c.WriteMessage(gerritChannel, str)
+// Announces to several Announcers at once.
+type multiAnnouncer []Announcer
+
+func (this multiAnnouncer) Announce(msg *GerritMessage) {
+	for _, a := range this {
+		a.Announce(msg)
+	}
 }
 
-func handleChangeAbandoned(c *client.IrcClient, msg *GerritMessage) {
-	// TODO: msg.Owner.Name != msg.Abandoner.Name, note
-	// separately since someone else updating a patch is
-	// significant
-	str := fmt.Sprintf("[%s/%s] %s owned by %s was abandoned by %s - %s",
-		msg.Change.Project, msg.Change.Branch,
-		msg.Change.Subject, msg.Change.Owner.Name,
-		msg.Abandoner.Name,
-		msg.Change.Url)
-	c.WriteMessage(gerritChannel, str)
+// Publish a Gerrit event in the channels that it's routed to, and that have
+// its handler enabled and haven't muted it, and to those watching it (unless
+// watches is nil), by passing notices to notify.
+func dispatchGerritEvent(co *coalescer, watches *watchList, notify func(nick string, line string), config *Config, msg *GerritMessage) {
+	handler, ok := eventHandlers[msg.Type]
+	if !ok {
+		println(fmt.Sprintf("Gerrit: No handler for event type %s", msg.Type))
+		return
+	}
+
+	var announcers multiAnnouncer
+	var channels []string
+	for _, channel := range config.RouteChannels(msg) {
+		if config.HandlerEnabled(channel, msg.Type) && !mutes.Muted(channel, msg.Type) {
+			channels = append(channels, channel)
+		}
+	}
+	if len(channels) > 0 {
+		announcers = append(announcers, &channelAnnouncer{coalescer: co, channels: channels})
+	}
+	if watches != nil && config.Gerrit.WantsProject(msg.ProjectName()) && config.HandlerEnabled("", msg.Type) {
+		if nicks := watches.Recipients(msg); len(nicks) > 0 {
+			announcers = append(announcers, &noticeAnnouncer{config: config, nicks: nicks, notify: notify})
+		}
+	}
+	if len(announcers) == 0 {
+		return
+	}
+
+	handler.handle(announcers, msg)
 }
 
-func handleMergeFailed(c *client.IrcClient, msg *GerritMessage) {
-	reasons := strings.Split(msg.Reason, "\n")
-	reason := reasons[0]
-	str := fmt.Sprintf("[%s/%s] %s tried to cherry-pick %s, but the merge failed because: %s - %s",
-		msg.Change.Project, msg.Change.Branch,
-		msg.Submitter.Name, msg.Change.Subject,
-		reason, msg.Change.Url)
-	c.WriteMessage(gerritChannel, str)
+func handleCommentAdded(a Announcer, msg *GerritMessage) {
+	// drop these, they're spammy
+	approvals := msg.ChangedApprovals()
+	if msg.Author.Email == "qt_sanitybot@qt-project.org" && len(approvals) == 1 &&
+		getConfig().LabelName(approvals[0].Type) == "Sanity-Review" && approvals[0].Value == 1 {
+		return
+	}
+	a.Announce(msg)
 }
 
-// ### It would be nice if we could actually describe *what* changed.
-func handleRefUpdate(resultsChannel chan string, m *GerritMessage) {
-	defer func() { close(resultsChannel) }()
+// The lookups used to find out what ref updates changed. Set by main; when
+// it's nil, only what the event itself says is described.
+var refLookups *lookupService
 
-	// These are handled by handleChangeMerged
-	if strings.HasPrefix(m.RefUpdate.RefName, "refs/staging/") {
+func handleRefUpdate(a Announcer, msg *GerritMessage) {
+	if strings.HasPrefix(msg.RefUpdate.RefName, "refs/staging/") {
 		return
 	}
 
-	url := "https://code.qt.io/cgit/" + m.RefUpdate.Project + ".git/log/?qt=range&q=" + m.RefUpdate.OldRev + "..." + m.RefUpdate.NewRev
-	resultsChannel <- fmt.Sprintf("[%s] %s updated %s from %s to %s - %s", m.RefUpdate.Project, m.Submitter.Name, m.RefUpdate.RefName, m.RefUpdate.OldRev, m.RefUpdate.NewRev, url)
+	// looking up what changed can take a while, but Gerrit events are
+	// dispatched on their own (see main), so this only holds up the events
+	// after this one, which keeps them in order.
+	msg.RefChange = describeRefUpdate(refLookups, getConfig(), msg.RefUpdate)
+	a.Announce(msg)
+}
+
+func handleHashtagsChanged(a Announcer, msg *GerritMessage) {
+	if len(msg.Added) == 0 && len(msg.Removed) == 0 {
+		return
+	}
+	a.Announce(msg)
 }
@@ -1,21 +1,198 @@
 package main
 
-/*
-func main() {
-	var m GerritMessage
-	m.Type = "ref-updated"
-	m.Submitter = GerritPerson{
-		Name:     "Qt CI Bot",
-		Email:    "qt_ci_bot@qt-project.org",
-		Username: "qt_ci_bot",
-	}
-	m.RefUpdate = GerritRefUpdate{
-		OldRev:  "c253d71a062a39927b0f54565db8230d76862c6a",
-		NewRev:  "3ea073ada141164ed2999c142439fe86a220615e",
-		RefName: "refs/staging/5.6",
-		Project: "qt/qt5",
-	}
-
-	handlePushFromGerrit(m)
+import (
+	"errors"
+	"github.com/rburchell/gobo/lib/gerrit"
+	"testing"
+)
+
+type recordingAnnouncer struct {
+	t       *testing.T
+	config  *Config
+	channel string
+	lines   []string
+}
+
+func (this *recordingAnnouncer) Announce(msg *GerritMessage) {
+	lines, err := renderTemplate(this.config.Template(this.channel, msg.Type), msg)
+	if err != nil {
+		this.t.Errorf("Failed to render %s: %s", msg.Type, err)
+	}
+	this.lines = append(this.lines, lines...)
+}
+
+func testEvent(t *testing.T, blob string) *GerritMessage {
+	event, err := gerrit.ParseEvent([]byte(blob))
+	if err != nil {
+		t.Fatalf("Failed to parse %s: %s", blob, err)
+	}
+	msg, err := newGerritMessage(event)
+	if err != nil {
+		t.Fatalf("Failed to flatten %s: %s", blob, err)
+	}
+	return msg
+}
+
+const testChange = `"change": {"project": "qt/qtbase", "branch": "dev", "subject": "Fix it", "owner": {"name": "Alice"}, "url": "https://codereview.qt-project.org/1234", "topic": "fixes", "wip": true, "assignee": {"name": "Carol"}}`
+
+func TestEventHandlers(t *testing.T) {
+	tests := []struct {
+		Json     string
+		Expected string
+	}{
+		{
+			`{"type": "comment-added", "author": {"name": "Bob"}, "patchSet": {"number": "2", "uploader": {"name": "Alice"}}, "approvals": [{"type": "Code-Review", "value": "2", "oldValue": "0"}, {"type": "SRVW", "value": "-1", "oldValue": "0"}], ` + testChange + `}`,
+			"[qt/qtbase/dev] Fix it from Alice reviewed by Bob: C: 2 S: -1 - https://codereview.qt-project.org/1234",
+		},
+		{
+			`{"type": "comment-added", "author": {"name": "Bob"}, "patchSet": {"number": "2", "uploader": {"name": "Alice"}}, ` + testChange + `}`,
+			"[qt/qtbase/dev] Fix it from Alice commented by Bob - https://codereview.qt-project.org/1234",
+		},
+		{
+			`{"type": "comment-added", "author": {"name": "Bob"}, "patchSet": {"number": "2", "uploader": {"name": "Alice"}}, "approvals": [{"type": "Code-Review", "value": "1", "oldValue": "1"}, {"type": "Verified", "value": "-1", "oldValue": "1"}], ` + testChange + `}`,
+			"[qt/qtbase/dev] Fix it from Alice reviewed by Bob: V: -1 (was 1) - https://codereview.qt-project.org/1234",
+		},
+		{
+			`{"type": "comment-added", "author": {"name": "Bob"}, "patchSet": {"number": "2", "uploader": {"name": "Alice"}}, "approvals": [{"type": "Code-Review", "value": "1", "oldValue": "1"}], ` + testChange + `}`,
+			"[qt/qtbase/dev] Fix it from Alice commented by Bob - https://codereview.qt-project.org/1234",
+		},
+		{
+			// a plain comment repeats the author's votes, without oldValue
+			`{"type": "comment-added", "author": {"name": "Bob"}, "patchSet": {"number": "2", "uploader": {"name": "Alice"}}, "approvals": [{"type": "Code-Review", "value": "1"}, {"type": "Verified", "value": "1"}], ` + testChange + `}`,
+			"[qt/qtbase/dev] Fix it from Alice commented by Bob - https://codereview.qt-project.org/1234",
+		},
+		{
+			`{"type": "comment-added", "author": {"name": "Qt Sanity Bot", "email": "qt_sanitybot@qt-project.org"}, "approvals": [{"type": "Sanity-Review", "value": "1", "oldValue": "0"}], ` + testChange + `}`,
+			"",
+		},
+		{
+			`{"type": "patchset-created", "patchSet": {"number": "1", "uploader": {"name": "Alice"}}, ` + testChange + `}`,
+			"[qt/qtbase/dev] Fix it pushed by Alice - https://codereview.qt-project.org/1234",
+		},
+		{
+			`{"type": "patchset-created", "patchSet": {"number": "3", "uploader": {"name": "Bob"}}, ` + testChange + `}`,
+			"[qt/qtbase/dev] Fix it updated by Bob - https://codereview.qt-project.org/1234",
+		},
+		{
+			`{"type": "merge-failed", "submitter": {"name": "Bob"}, "reason": "Conflict\nin file", ` + testChange + `}`,
+			"[qt/qtbase/dev] Bob tried to cherry-pick Fix it, but the merge failed because: Conflict - https://codereview.qt-project.org/1234",
+		},
+		{
+			`{"type": "ref-updated", "submitter": {"name": "Qt CI Bot"}, "refUpdate": {"oldRev": "c253d71a", "newRev": "3ea073ad", "refName": "refs/heads/5.6", "project": "qt/qt5"}}`,
+			"[qt/qt5] Qt CI Bot updated refs/heads/5.6 from c253d71a to 3ea073ad - https://code.qt.io/cgit/qt/qt5.git/log/?qt=range&q=c253d71a...3ea073ad",
+		},
+		{
+			`{"type": "ref-updated", "refUpdate": {"refName": "refs/staging/5.6", "project": "qt/qt5"}}`,
+			"",
+		},
+		{
+			`{"type": "topic-changed", "changer": {"name": "Bob"}, "oldTopic": "", ` + testChange + `}`,
+			`[qt/qtbase/dev] Fix it owned by Alice had its topic set to "fixes" by Bob - https://codereview.qt-project.org/1234`,
+		},
+		{
+			`{"type": "hashtags-changed", "editor": {"name": "Bob"}, "added": ["a", "b"], "removed": ["c"], ` + testChange + `}`,
+			"[qt/qtbase/dev] Fix it owned by Alice had its hashtags changed by Bob: +a +b -c - https://codereview.qt-project.org/1234",
+		},
+		{
+			`{"type": "change-restored", "restorer": {"name": "Bob"}, ` + testChange + `}`,
+			"[qt/qtbase/dev] Fix it owned by Alice was restored by Bob - https://codereview.qt-project.org/1234",
+		},
+		{
+			`{"type": "wip-state-changed", "changer": {"name": "Bob"}, ` + testChange + `}`,
+			"[qt/qtbase/dev] Fix it owned by Alice was marked as work in progress by Bob - https://codereview.qt-project.org/1234",
+		},
+		{
+			`{"type": "private-state-changed", "changer": {"name": "Bob"}, ` + testChange + `}`,
+			"[qt/qtbase/dev] Fix it owned by Alice was made public by Bob - https://codereview.qt-project.org/1234",
+		},
+		{
+			`{"type": "vote-deleted", "remover": {"name": "Bob"}, "reviewer": {"name": "Dave"}, ` + testChange + `}`,
+			"[qt/qtbase/dev] Fix it owned by Alice had a vote by Dave removed by Bob - https://codereview.qt-project.org/1234",
+		},
+		{
+			`{"type": "assignee-changed", "changer": {"name": "Bob"}, ` + testChange + `}`,
+			"[qt/qtbase/dev] Fix it owned by Alice was assigned to Carol by Bob - https://codereview.qt-project.org/1234",
+		},
+		{
+			`{"type": "reviewer-added", "adder": {"name": "Bob"}, "reviewer": {"name": "Dave"}, ` + testChange + `}`,
+			"[qt/qtbase/dev] Fix it from Alice: Dave was added as a reviewer by Bob - https://codereview.qt-project.org/1234",
+		},
+		{
+			`{"type": "project-created", "projectName": "qt/qtnew", "projectHead": "refs/heads/dev"}`,
+			"[qt/qtnew] Project created, with HEAD refs/heads/dev",
+		},
+	}
+
+	for _, test := range tests {
+		msg := testEvent(t, test.Json)
+		handler, ok := eventHandlers[msg.Type]
+		if !ok {
+			t.Errorf("No handler for %s", msg.Type)
+			continue
+		}
+
+		a := &recordingAnnouncer{t: t, config: defaultConfig(), channel: "#qt-gerrit"}
+		handler.handle(a, msg)
+		got := ""
+		if len(a.lines) > 0 {
+			got = a.lines[0]
+		}
+		if got != test.Expected || len(a.lines) > 1 {
+			t.Errorf("%s: Expected: %#v, got %#v", msg.Type, test.Expected, a.lines)
+		}
+	}
+}
+
+func TestHandlerEnabled(t *testing.T) {
+	config := defaultConfig()
+	config.Gerrit.Events = map[string]bool{"topic-changed": false}
+	config.Channels = map[string]ChannelConfig{
+		"#qt-qml":  {Events: map[string]bool{"reviewer-added": true, "comment-added": false}},
+		"#qt-labs": {Events: map[string]bool{"topic-changed": true}},
+	}
+
+	tests := []struct {
+		Channel   string
+		EventType string
+		Expected  bool
+	}{
+		{"#qt-gerrit", "comment-added", true},
+		{"#qt-gerrit", "reviewer-added", false},
+		{"#qt-gerrit", "topic-changed", false},
+		{"#QT-QML", "reviewer-added", true},
+		{"#qt-qml", "comment-added", false},
+		{"#qt-qml", "patchset-created", true},
+		{"#qt-labs", "topic-changed", true},
+	}
+
+	for _, test := range tests {
+		if enabled := config.HandlerEnabled(test.Channel, test.EventType); enabled != test.Expected {
+			t.Errorf("%s in %s: Expected: %#v, got %#v", test.EventType, test.Channel, test.Expected, enabled)
+		}
+	}
+}
+
+func TestDescribeDiagnostic(t *testing.T) {
+	tests := []struct {
+		Diagnostic gerrit.Diagnostic
+		Expected   string
+	}{
+		{
+			gerrit.Diagnostic{Kind: gerrit.Connected, Message: "Gerrit connection reestablished."},
+			"Gerrit connection reestablished.",
+		},
+		{
+			gerrit.Diagnostic{Kind: gerrit.ConnectFailed, Message: "Failed to dial", Err: errors.New("connection refused")},
+			"Failed to dial: connection refused",
+		},
+		{
+			gerrit.Diagnostic{Kind: gerrit.HostKeyChanged, Message: "Refusing to connect to Gerrit", Err: &gerrit.HostKeyChangedError{Host: "gerrit:29418", Fingerprint: "SHA256:new", Expected: []string{"SHA256:old"}}},
+			"WARNING: Refusing to connect to Gerrit, its host key for gerrit:29418 has CHANGED: it is now SHA256:new, but expected SHA256:old. Someone may be intercepting the connection. If the key was changed on purpose, update the known hosts or fingerprint in the config.",
+		},
+	}
+	for _, test := range tests {
+		if got := describeDiagnostic(test.Diagnostic); got != test.Expected {
+			t.Errorf("Expected: %#v, got %#v", test.Expected, got)
+		}
+	}
 }
-*/
@@ -25,10 +25,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -76,56 +80,304 @@ type GithubCompareResponse struct {
 	Commits  []GithubCommit `json:"commits"`
 }
 
-func handleGithubWebApi(resultsChannel chan string, directTo string, commitz [][]string) {
-	defer func() { close(resultsChannel) }()
+type GithubUser struct {
+	Login string `json:"login"`
+}
+
+// https://api.github.com/repos/<owner>/<repo>/issues/<number>, which is
+// also how pull requests are found.
+type GithubIssue struct {
+	Number  int        `json:"number"`
+	Title   string     `json:"title"`
+	State   string     `json:"state"`
+	Draft   bool       `json:"draft"`
+	HtmlUrl string     `json:"html_url"`
+	User    GithubUser `json:"user"`
+
+	// Only set for pull requests.
+	PullRequest *struct {
+		MergedAt *time.Time `json:"merged_at"`
+	} `json:"pull_request"`
+}
+
+// https://api.github.com/repos/<owner>/<repo>
+type GithubRepo struct {
+	FullName string `json:"full_name"`
+}
+
+// A GithubError is an error reply from Github.
+type GithubError struct {
+	StatusCode int
+	Message    string `json:"message"`
+}
+
+func (this *GithubError) Error() string {
+	if len(this.Message) > 0 {
+		return fmt.Sprintf("HTTP status %d: %s", this.StatusCode, this.Message)
+	}
+	return fmt.Sprintf("HTTP status %d %s", this.StatusCode, http.StatusText(this.StatusCode))
+}
 
-	hclient := http.Client{
-		Timeout: time.Duration(10 * time.Second),
+func isGithubNotFound(err error) bool {
+	var githubErr *GithubError
+	return errors.As(err, &githubErr) && githubErr.StatusCode == http.StatusNotFound
+}
+
+// A githubClient talks to Github's REST API, keeping to its rate limit: once
+// that's used up, requests fail without being made until it resets.
+type githubClient struct {
+	url    string
+	token  string
+	client *http.Client
+	limit  *rateLimit
+	now    func() time.Time
+}
+
+func newGithubClient(config GithubConfig, lookups *lookupService) *githubClient {
+	return &githubClient{
+		url:    strings.TrimSuffix(config.Url, "/"),
+		token:  config.Token,
+		client: lookups.client,
+		limit:  lookups.RateLimit("github"),
+		now:    lookups.now,
 	}
+}
 
-	// commitz:
-	// [1] is the repo (e.g. qtbase)
-	// [2] is the sha
-	for _, repoAndSha := range commitz {
-		repo := repoAndSha[1]
-		sha := repoAndSha[2]
+func (this *githubClient) get(ctx context.Context, path string, result interface{}) error {
+	if exhausted, until := this.limit.Exhausted(this.now()); exhausted {
+		return fmt.Errorf("Github's rate limit is used up until %s", until.Format(time.RFC3339))
+	}
 
-		var githubLookup string
-		var ok bool
-		if githubLookup, ok = repoNameToGithubMap[repo]; !ok {
-			// sorry, not found. alter githubWhitelist.
-			resultsChannel <- fmt.Sprintf("I don't know where to find commit %s in repository %s", sha, repo)
-			continue
+	req, err := http.NewRequestWithContext(ctx, "GET", this.url+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if len(this.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+this.token)
+	}
+	res, err := this.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("while fetching HTTP: %s", err.Error())
+	}
+
+	jsonBlob, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return fmt.Errorf("while reading response: %s", err.Error())
+	}
+	this.updateLimit(res)
+
+	if res.StatusCode != http.StatusOK {
+		githubErr := &GithubError{}
+		json.Unmarshal(jsonBlob, githubErr)
+		githubErr.StatusCode = res.StatusCode
+		return githubErr
+	}
+
+	err = json.Unmarshal(jsonBlob, result)
+	if err != nil {
+		return fmt.Errorf("while parsing JSON: %s", err.Error())
+	}
+	return nil
+}
+
+// Note what the reply says of the rate limit.
+func (this *githubClient) updateLimit(res *http.Response) {
+	asOf, err := http.ParseTime(res.Header.Get("Date"))
+	if err != nil {
+		asOf = this.now()
+	}
+
+	// a secondary rate limit says how long to wait instead
+	if retryAfter, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil &&
+		(res.StatusCode == http.StatusForbidden || res.StatusCode == http.StatusTooManyRequests) {
+		this.limit.Update(0, this.now().Add(time.Duration(retryAfter)*time.Second), asOf)
+		return
+	}
+
+	remaining, err := strconv.Atoi(res.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(res.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+	this.limit.Update(remaining, time.Unix(reset, 0), asOf)
+}
+
+// Find where a bare repository name (e.g. qtbase) lives: where it's
+// configured to, or else in the configured organization, if it's there. As
+// anything of the form word/<hex> is looked for in the organization, not
+// finding it there isn't worth mentioning.
+func (this *githubClient) resolveRepo(ctx context.Context, config GithubConfig, name string) (string, error) {
+	if fullName, ok := config.Repos[name]; ok {
+		return fullName, nil
+	}
+	if len(config.Org) > 0 {
+		var repo GithubRepo
+		err := this.get(ctx, "/repos/"+config.Org+"/"+name, &repo)
+		if err == nil && len(repo.FullName) > 0 {
+			return repo.FullName, nil
+		} else if err != nil && !isGithubNotFound(err) {
+			return "", err
 		}
+		return "", errNotAReference
+	}
+	// sorry, not found. alter github.repos or github.org in the config.
+	return "", userErrorf("I don't know where to find repository %s", name)
+}
+
+// Expands references to pull requests and issues (e.g. qt/qtbase#123),
+// commits (e.g. qtbase/<sha>) and ranges of commits (e.g.
+// qtbase/<sha>..<sha>) in the known repositories, or the configured
+// organization.
+type githubExpander struct{}
+
+func (this githubExpander) Pattern(config *Config) *regexp.Regexp {
+	// pull requests and issues are only recognised in the known repositories
+	// or the organization, as are commits.
+	var fullNames, names []string
+	for _, name := range config.Github.RepoNames() {
+		fullNames = append(fullNames, regexp.QuoteMeta(config.Github.Repos[name]))
+		names = append(names, regexp.QuoteMeta(name))
+	}
+	if len(config.Github.Org) > 0 {
+		fullNames = append(fullNames, regexp.QuoteMeta(config.Github.Org)+`/[A-Za-z0-9_.-]+`)
+		names = append(names, `[A-Za-z0-9_.-]+`)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	// and not as part of a path, e.g. in a link
+	issuePattern := `\b(` + strings.Join(fullNames, "|") + `)#([0-9]+)\b`
+	commitPattern := `\b(` + strings.Join(names, "|") + `)\/([0-9a-f]{7,40})(?:\.\.\.?([0-9a-f]{7,40}))?\b`
+	return regexp.MustCompile(`(?:^|[^A-Za-z0-9_./:-])(?P<ref>` + issuePattern + `|` + commitPattern + `)`)
+}
+
+// What's found for a commit: the repository it was looked up in, and the
+// reply.
+type githubCommitLookup struct {
+	Repo   string
+	Commit GithubCommitResponse
+}
+
+// What's found for a range of commits.
+type githubCompareLookup struct {
+	Repo    string
+	Compare GithubCompareResponse
+}
+
+// What's found for a pull request or issue.
+type githubIssueLookup struct {
+	Repo  string
+	Issue GithubIssue
+}
 
-		res, err := hclient.Get("https://api.github.com/repos/" + githubLookup + "/commits/" + sha)
-		if err != nil {
-			resultsChannel <- fmt.Sprintf("Error retrieving commit %s from repository %s (while fetching HTTP): %s", sha, repo, err.Error())
-			continue
+func (this githubExpander) Fetch(ctx context.Context, lookups *lookupService, config *Config, match []string) (interface{}, error) {
+	github := newGithubClient(config.Github, lookups)
+
+	// [1] is the reference, without what came before it
+	// [2] and [3] are the full repo and number of a pull request or issue
+	if len(match[2]) > 0 {
+		var issue GithubIssue
+		err := github.get(ctx, "/repos/"+match[2]+"/issues/"+match[3], &issue)
+		if isGithubNotFound(err) {
+			return nil, userErrorf("%s doesn't exist", match[0])
+		} else if err != nil {
+			return nil, err
 		}
+		return &githubIssueLookup{Repo: match[2], Issue: issue}, nil
+	}
+
+	// [4] is the repo (e.g. qtbase)
+	// [5] is the sha, and [6] the end of the range, if it's one
+	repo := match[4]
+	sha := match[5]
+
+	githubLookup, err := github.resolveRepo(ctx, config.Github, repo)
+	if err != nil {
+		return nil, err
+	}
 
-		jsonBlob, err := ioutil.ReadAll(res.Body)
-		res.Body.Close()
-		if err != nil {
-			resultsChannel <- fmt.Sprintf("Error retrieving commit %s from repository %s (while reading response): %s", sha, repo, err.Error())
-			continue
+	// anything of the form word/<hex> is looked for in the organization, so
+	// it not being there isn't worth mentioning either
+	_, known := config.Github.Repos[repo]
+
+	if len(match[6]) > 0 {
+		var compare GithubCompareResponse
+		err := github.get(ctx, "/repos/"+githubLookup+"/compare/"+sha+"..."+match[6], &compare)
+		if isGithubNotFound(err) && !known {
+			return nil, errNotAReference
+		} else if isGithubNotFound(err) {
+			return nil, userErrorf("%s doesn't exist", match[0])
+		} else if err != nil {
+			return nil, err
 		}
+		return &githubCompareLookup{Repo: repo, Compare: compare}, nil
+	}
 
-		var commit GithubCommitResponse
-		err = json.Unmarshal(jsonBlob, &commit)
-		if err != nil {
-			resultsChannel <- fmt.Sprintf("Error retrieving commit %s from repository %s (while parsing JSON): %s", sha, repo, err.Error())
-			continue
+	var commit GithubCommitResponse
+	err = github.get(ctx, "/repos/"+githubLookup+"/commits/"+sha, &commit)
+	notFound := isGithubNotFound(err) || (err == nil && len(commit.HtmlUrl) == 0)
+	if notFound && !known {
+		return nil, errNotAReference
+	} else if notFound {
+		return nil, userErrorf("There's no commit %s in %s", sha, githubLookup)
+	} else if err != nil {
+		return nil, err
+	}
+	return &githubCommitLookup{Repo: repo, Commit: commit}, nil
+}
+
+// How many commits in a range are named.
+const githubCompareSummaries = 3
+
+func (this githubExpander) Format(config *Config, found interface{}) string {
+	switch lookup := found.(type) {
+	case *githubIssueLookup:
+		state := lookup.Issue.State
+		if lookup.Issue.PullRequest != nil && lookup.Issue.PullRequest.MergedAt != nil {
+			state = "merged"
+		} else if lookup.Issue.Draft && state == "open" {
+			state = "draft"
 		}
+		return fmt.Sprintf("[%s] %s from %s - %s (%s)",
+			lookup.Repo, lookup.Issue.Title, lookup.Issue.User.Login,
+			lookup.Issue.HtmlUrl, state)
 
-		idx := strings.Index(commit.Commit.Message, "\n")
-		if idx < 0 {
-			idx = len(commit.Commit.Message)
+	case *githubCompareLookup:
+		compare := lookup.Compare
+		var summaries []string
+		for idx, commit := range compare.Commits {
+			if idx == githubCompareSummaries {
+				summaries = append(summaries, fmt.Sprintf("and %d more", compare.AheadBy-idx))
+				break
+			}
+			summaries = append(summaries, commit.Commit.Summary())
 		}
-		summary := commit.Commit.Message[0:idx]
 
-		resultsChannel <- fmt.Sprintf("%s[%s] %s from %s - %s",
-			directTo, repo, summary, commit.Commit.Author.Name,
-			commit.HtmlUrl)
+		count := fmt.Sprintf("%d commits", compare.AheadBy)
+		if compare.AheadBy == 1 {
+			count = "1 commit"
+		}
+		if compare.BehindBy > 0 {
+			count += fmt.Sprintf(" (and %d behind)", compare.BehindBy)
+		}
+		if len(summaries) == 0 {
+			return fmt.Sprintf("[%s] %s - %s", lookup.Repo, count, compare.HtmlUrl)
+		}
+		return fmt.Sprintf("[%s] %s: %s - %s", lookup.Repo, count, strings.Join(summaries, "; "), compare.HtmlUrl)
 	}
+
+	lookup := found.(*githubCommitLookup)
+	return fmt.Sprintf("[%s] %s from %s - %s",
+		lookup.Repo, lookup.Commit.Commit.Summary(), lookup.Commit.Commit.Author.Name,
+		lookup.Commit.HtmlUrl)
+}
+
+func init() {
+	registerExpander("github", true, githubExpander{})
 }
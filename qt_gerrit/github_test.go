@@ -0,0 +1,246 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A stand-in for Github, which answers from a fixed set of replies, and has
+// a rate limit of remaining more requests until reset.
+type fakeGithub struct {
+	*httptest.Server
+
+	mutex     sync.Mutex
+	remaining int
+	reset     time.Time
+	requests  []string
+}
+
+var fakeGithubReplies = map[string]string{
+	"/repos/qt/qtbase/commits/abcdef1":    `{"html_url": "https://github.com/qt/qtbase/commit/abcdef1", "commit": {"author": {"name": "Alice"}, "message": "Fix the frobnicator\n\nIt was broken."}}`,
+	"/repos/qt/qtwayland":                 `{"full_name": "qt/qtwayland"}`,
+	"/repos/qt/qtwayland/commits/1234567": `{"html_url": "https://github.com/qt/qtwayland/commit/1234567", "commit": {"author": {"name": "Bob"}, "message": "Add a surface"}}`,
+	"/repos/qt/qtbase/compare/abcdef1...1234567": `{"html_url": "https://github.com/qt/qtbase/compare/abcdef1...1234567", "ahead_by": 4, "behind_by": 0, "commits": [
+		{"sha": "1", "commit": {"message": "One"}}, {"sha": "2", "commit": {"message": "Two\n\nDetails"}},
+		{"sha": "3", "commit": {"message": "Three"}}, {"sha": "4", "commit": {"message": "Four"}}]}`,
+	"/repos/qt/qtbase/compare/abcdef1...7654321": `{"html_url": "https://github.com/qt/qtbase/compare/abcdef1...7654321", "ahead_by": 1, "behind_by": 2, "commits": [
+		{"sha": "1", "commit": {"message": "One"}}]}`,
+	"/repos/qt/qt5/compare/c253d71a...3ea073ad": `{"html_url": "https://github.com/qt/qt5/compare/c253d71a...3ea073ad", "status": "ahead", "ahead_by": 2, "behind_by": 0, "commits": [
+		{"sha": "1", "commit": {"author": {"name": "Alice"}, "message": "Update submodules"}}, {"sha": "2", "commit": {"author": {"name": "Bob"}, "message": "Bump version"}}]}`,
+	"/repos/qt/qt5/compare/c253d71a...7654321a": `{"html_url": "https://github.com/qt/qt5/compare/c253d71a...7654321a", "status": "diverged", "ahead_by": 1, "behind_by": 2, "commits": [
+		{"sha": "1", "commit": {"author": {"name": "Alice"}, "message": "Redo it"}}]}`,
+	"/repos/qt/qt5/compare/c253d71a...0badf00d": `{"html_url": "https://github.com/qt/qt5/compare/c253d71a...0badf00d", "status": "behind", "ahead_by": 0, "behind_by": 3, "commits": []}`,
+	"/repos/qt/qtbase/issues/12": `{"number": 12, "title": "Fix it", "state": "closed", "html_url": "https://github.com/qt/qtbase/pull/12", "user": {"login": "alice"},
+		"pull_request": {"merged_at": "2023-11-14T21:13:20Z"}}`,
+	"/repos/qt/qtbase/issues/13": `{"number": 13, "title": "Try it", "state": "open", "draft": true, "html_url": "https://github.com/qt/qtbase/pull/13", "user": {"login": "bob"},
+		"pull_request": {"merged_at": null}}`,
+	"/repos/qt/qtbase/issues/14": `{"number": 14, "title": "It crashes", "state": "open", "html_url": "https://github.com/qt/qtbase/issues/14", "user": {"login": "carol"}}`,
+}
+
+func startFakeGithub(t *testing.T) *fakeGithub {
+	github := &fakeGithub{remaining: 100}
+	github.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		github.mutex.Lock()
+		defer github.mutex.Unlock()
+		github.requests = append(github.requests, r.URL.Path+" "+r.Header.Get("Authorization"))
+
+		if github.remaining == 0 {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", fmt.Sprintf("%d", github.reset.Unix()))
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, `{"message": "API rate limit exceeded"}`)
+			return
+		}
+		github.remaining--
+		w.Header().Set("X-RateLimit-Remaining", fmt.Sprintf("%d", github.remaining))
+		w.Header().Set("X-RateLimit-Reset", fmt.Sprintf("%d", github.reset.Unix()))
+
+		if reply, ok := fakeGithubReplies[r.URL.Path]; ok {
+			fmt.Fprintf(w, "%s", reply)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"message": "Not Found"}`)
+	}))
+	return github
+}
+
+func (this *fakeGithub) Requests() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return append([]string{}, this.requests...)
+}
+
+func (this *fakeGithub) SetLimit(remaining int, reset time.Time) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.remaining, this.reset = remaining, reset
+}
+
+func TestGithubReferences(t *testing.T) {
+	config := testConfig("https://api.example.org")
+	text := "qt/qtbase#12, qtbase/abcdef1..1234567 and qtwayland/1234567, but not qtbase/abc or https://example.org/qt/qtbase#top"
+	config.Github.Org = "qt"
+
+	describe := func() string {
+		var got []string
+		for _, ref := range config.findReferences("#qt", text) {
+			got = append(got, ref.match[0])
+		}
+		return strings.Join(got, ", ")
+	}
+
+	expected := "qt/qtbase#12, qtbase/abcdef1..1234567, qtwayland/1234567"
+	if got := describe(); got != expected {
+		t.Errorf("Expected: %#v, got %#v", expected, got)
+	}
+
+	// nor anything in a path, or another organization
+	for _, text = range []string{
+		"https://stackoverflow.com/questions/12345678/foo",
+		"see /usr/lib/1234567",
+		"someone/else#12",
+	} {
+		if got := describe(); got != "" {
+			t.Errorf("Expected no references in %#v, got %#v", text, got)
+		}
+	}
+	text = "(qt/qtwayland#3, qtwayland/1234567)"
+	expected = "qt/qtwayland#3, qtwayland/1234567"
+	if got := describe(); got != expected {
+		t.Errorf("Expected: %#v, got %#v", expected, got)
+	}
+	text = "qt/qtbase#12, qtbase/abcdef1..1234567 and qtwayland/1234567, but not qtbase/abc or https://example.org/qt/qtbase#top"
+
+	// without an organization (the default), only the configured
+	// repositories are known
+	config.Github.Org = ""
+	expected = "qt/qtbase#12, qtbase/abcdef1..1234567"
+	if got := describe(); got != expected {
+		t.Errorf("Expected: %#v, got %#v", expected, got)
+	}
+
+	for _, text = range []string{
+		"https://stackoverflow.com/questions/12345678/foo",
+		"https://testresults.qt.io/coin/integration/qt/qtbase/tasks/1698765432",
+		"picked to 6.5/1234567",
+		"qt/qtwayland#3",
+	} {
+		if got := describe(); got != "" {
+			t.Errorf("Expected no references in %#v, got %#v", text, got)
+		}
+	}
+}
+
+func TestGithubExpander(t *testing.T) {
+	github := startFakeGithub(t)
+	defer github.Close()
+	config := testConfig(github.URL)
+	config.Github.Token = "secret"
+	config.Github.Org = "qt"
+	lookups, _ := newTestLookupService(config)
+
+	tests := []struct {
+		Text     string
+		Expected string
+	}{
+		{"qtbase/abcdef1", "[qtbase] Fix the frobnicator from Alice - https://github.com/qt/qtbase/commit/abcdef1"},
+		{"qtbase/fedcba9", "There's no commit fedcba9 in qt/qtbase"},
+		{"qtwayland/1234567", "[qtwayland] Add a surface from Bob - https://github.com/qt/qtwayland/commit/1234567"},
+		{"qtnowhere/1234567", ""},
+		{"qtwayland/fedcba9", ""},
+		{"qtwayland/1234567..fedcba9", ""},
+		{"questions/12345678", ""},
+		{"qtbase/abcdef1..1234567", "[qtbase] 4 commits: One; Two; Three; and 1 more - https://github.com/qt/qtbase/compare/abcdef1...1234567"},
+		{"qtbase/abcdef1...7654321", "[qtbase] 1 commit (and 2 behind): One - https://github.com/qt/qtbase/compare/abcdef1...7654321"},
+		{"qt/qtbase#12", "[qt/qtbase] Fix it from alice - https://github.com/qt/qtbase/pull/12 (merged)"},
+		{"qt/qtbase#13", "[qt/qtbase] Try it from bob - https://github.com/qt/qtbase/pull/13 (draft)"},
+		{"qt/qtbase#14", "[qt/qtbase] It crashes from carol - https://github.com/qt/qtbase/issues/14 (open)"},
+		{"qt/qtbase#15", "qt/qtbase#15 doesn't exist"},
+	}
+	for _, test := range tests {
+		refs := config.findReferences("#qt", test.Text)
+		if len(refs) != 1 {
+			t.Errorf("Expected one reference in %s, got %#v", test.Text, refs)
+			continue
+		}
+		if got := describeReference(context.Background(), lookups, config, refs[0]); got != test.Expected {
+			t.Errorf("Expected: %#v, got %#v", test.Expected, got)
+		}
+	}
+
+	for _, request := range github.Requests() {
+		if !strings.HasSuffix(request, " Bearer secret") {
+			t.Errorf("Expected the token to be sent, got %#v", request)
+		}
+	}
+}
+
+func TestGithubRateLimit(t *testing.T) {
+	github := startFakeGithub(t)
+	defer github.Close()
+	config := testConfig(github.URL)
+	lookups, clock := newTestLookupService(config)
+	refs := config.findReferences("#qt", "qtbase/abcdef1")
+
+	// the first request uses up the limit, and Github is then asked nothing
+	// until it resets, an hour later
+	github.SetLimit(1, clock.Now().Add(time.Hour))
+	if got := describeReference(context.Background(), lookups, config, refs[0]); !strings.HasPrefix(got, "[qtbase]") {
+		t.Errorf("Expected the commit to be described, got %#v", got)
+	}
+	clock.Advance(59 * time.Minute)
+	if got := describeReference(context.Background(), lookups, config, refs[0]); got != "Couldn't look up qtbase/abcdef1" {
+		t.Errorf("Expected: %#v, got %#v", "Couldn't look up qtbase/abcdef1", got)
+	}
+	if len(github.Requests()) != 1 {
+		t.Errorf("Expected one request, got %#v", github.Requests())
+	}
+
+	clock.Advance(time.Minute)
+	github.SetLimit(5, clock.Now().Add(time.Hour))
+	if got := describeReference(context.Background(), lookups, config, refs[0]); !strings.HasPrefix(got, "[qtbase]") {
+		t.Errorf("Expected the commit to be described, got %#v", got)
+	}
+
+	// nor once Github says no, unexpectedly
+	github.SetLimit(0, clock.Now().Add(time.Hour))
+	for idx := 0; idx < 2; idx++ {
+		if got := describeReference(context.Background(), lookups, config, refs[0]); got != "Couldn't look up qtbase/abcdef1" {
+			t.Errorf("Expected: %#v, got %#v", "Couldn't look up qtbase/abcdef1", got)
+		}
+	}
+	if len(github.Requests()) != 3 {
+		t.Errorf("Expected three requests, got %#v", github.Requests())
+	}
+}
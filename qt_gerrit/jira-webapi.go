@@ -25,68 +25,261 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"regexp"
+	"strings"
 	"time"
 )
 
-// TODO: utterly incomplete, because this is a big response and we only care about a
-// small fraction of it right now.
+type JiraNamed struct {
+	Name string `json:"name"`
+}
+
+type JiraUser struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// JiraTime is a time as Jira gives it, e.g. 2023-11-14T22:13:20.000+0100.
+type JiraTime struct {
+	time.Time
+}
+
+func (this *JiraTime) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	if len(str) == 0 {
+		this.Time = time.Time{}
+		return nil
+	}
+	t, err := time.Parse("2006-01-02T15:04:05.000-0700", str)
+	if err != nil {
+		return err
+	}
+	this.Time = t
+	return nil
+}
+
+// Only the parts of an issue that are shown are decoded; the rest is a lot.
 type JiraBug struct {
+	Key    string `json:"key"`
 	Fields struct {
-		Summary string `json:"summary"`
-		Status  struct {
-			Name string `json:"name"`
-		} `json:"status"`
+		Summary     string      `json:"summary"`
+		Status      JiraNamed   `json:"status"`
+		Resolution  *JiraNamed  `json:"resolution"`
+		Priority    *JiraNamed  `json:"priority"`
+		Assignee    *JiraUser   `json:"assignee"`
+		FixVersions []JiraNamed `json:"fixVersions"`
+		Components  []JiraNamed `json:"components"`
+		Created     JiraTime    `json:"created"`
+		Updated     JiraTime    `json:"updated"`
 	} `json:"fields"`
+}
 
-	ErrorMessages []string `json:"errorMessages"`
+// The fields of JiraBug, for asking for only those.
+var jiraFields = []string{"summary", "status", "resolution", "priority", "assignee", "fixVersions", "components", "created", "updated"}
+
+func jiraNames(named []JiraNamed) string {
+	names := make([]string, len(named))
+	for idx, n := range named {
+		names[idx] = n.Name
+	}
+	return strings.Join(names, ", ")
 }
 
-func handleJiraWebApi(resultsChannel chan string, directTo string, bugs []string) {
-	defer func() { close(resultsChannel) }()
+// Describe the issue, e.g. "It crashes - https://bugreports.qt.io/browse/QTBUG-1
+// (Closed: Done, P1: Critical, assigned to Alice, fix 6.7.0, component Core)".
+func (this *JiraBug) Describe(jiraUrl string) string {
+	status := this.Fields.Status.Name
+	if this.Fields.Resolution != nil && len(this.Fields.Resolution.Name) > 0 {
+		status += ": " + this.Fields.Resolution.Name
+	}
+	details := []string{status}
+	if this.Fields.Priority != nil && len(this.Fields.Priority.Name) > 0 {
+		details = append(details, this.Fields.Priority.Name)
+	}
+	if this.Fields.Assignee != nil && len(this.Fields.Assignee.DisplayName) > 0 {
+		details = append(details, "assigned to "+this.Fields.Assignee.DisplayName)
+	}
+	if len(this.Fields.FixVersions) > 0 {
+		details = append(details, "fix "+jiraNames(this.Fields.FixVersions))
+	}
+	if len(this.Fields.Components) == 1 {
+		details = append(details, "component "+jiraNames(this.Fields.Components))
+	} else if len(this.Fields.Components) > 1 {
+		details = append(details, "components "+jiraNames(this.Fields.Components))
+	}
+
+	return fmt.Sprintf("%s - %s/browse/%s (%s)",
+		this.Fields.Summary,
+		strings.TrimSuffix(jiraUrl, "/"),
+		this.Key,
+		strings.Join(details, ", "))
+}
+
+type JiraSearchResult struct {
+	StartAt    int       `json:"startAt"`
+	MaxResults int       `json:"maxResults"`
+	Total      int       `json:"total"`
+	Issues     []JiraBug `json:"issues"`
+}
 
-	hclient := http.Client{
-		Timeout: time.Duration(10 * time.Second),
+// A JiraError is an error reply from Jira, with whatever it said was wrong.
+type JiraError struct {
+	StatusCode int
+	Messages   []string
+}
+
+func (this *JiraError) Error() string {
+	if len(this.Messages) > 0 {
+		return strings.Join(this.Messages, "; ")
 	}
+	return fmt.Sprintf("HTTP status %d %s", this.StatusCode, http.StatusText(this.StatusCode))
+}
 
-	for _, bugId := range bugs {
-		res, err := hclient.Get("https://bugreports.qt.io/rest/api/2/issue/" + bugId)
-		if err != nil {
-			resultsChannel <- fmt.Sprintf("Error retrieving bug %s (while fetching HTTP): %s", bugId, err.Error())
-			continue
-		}
+// A jiraClient talks to Jira's REST API, authenticating with an API token
+// if one is configured: as a bearer token (a personal access token, on Jira
+// Server and Data Center), or with basic auth if there's a user too (as
+// Jira Cloud wants).
+type jiraClient struct {
+	url    string
+	user   string
+	token  string
+	client *http.Client
+}
 
-		jsonBlob, err := ioutil.ReadAll(res.Body)
-		res.Body.Close()
-		if err != nil {
-			resultsChannel <- fmt.Sprintf("Error retrieving bug %s (while reading response): %s", bugId, err.Error())
-			continue
-		}
+func newJiraClient(config JiraConfig, hclient *http.Client) *jiraClient {
+	return &jiraClient{
+		url:    strings.TrimSuffix(config.Url, "/"),
+		user:   config.User,
+		token:  config.Token,
+		client: hclient,
+	}
+}
 
-		var bug JiraBug
-		err = json.Unmarshal(jsonBlob, &bug)
-		if err != nil {
-			resultsChannel <- fmt.Sprintf("Error retrieving bug %s (while parsing JSON): %s", bugId, err.Error())
-			continue
+// Make a request of Jira, decoding the reply into result.
+func (this *jiraClient) do(ctx context.Context, method string, path string, body interface{}, result interface{}) error {
+	var reqBody []byte
+	if body != nil {
+		var err error
+		if reqBody, err = json.Marshal(body); err != nil {
+			return err
 		}
+	}
 
-		if len(bug.ErrorMessages) > 0 {
-			resultsChannel <- fmt.Sprintf("Error retrieving bug %s: %s", bugId, bug.ErrorMessages[0])
-			continue
+	req, err := http.NewRequestWithContext(ctx, method, this.url+path, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if len(this.token) > 0 {
+		if len(this.user) > 0 {
+			req.SetBasicAuth(this.user, this.token)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+this.token)
 		}
+	}
 
-		if len(bug.Fields.Summary) == 0 {
-			resultsChannel <- fmt.Sprintf("Error retrieving bug %s: malformed reply", bugId)
-			continue
+	res, err := this.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("while fetching HTTP: %s", err.Error())
+	}
+	jsonBlob, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return fmt.Errorf("while reading response: %s", err.Error())
+	}
+
+	if res.StatusCode != http.StatusOK {
+		jiraErr := &JiraError{StatusCode: res.StatusCode}
+		var reply struct {
+			ErrorMessages []string          `json:"errorMessages"`
+			Errors        map[string]string `json:"errors"`
+		}
+		if json.Unmarshal(jsonBlob, &reply) == nil {
+			jiraErr.Messages = reply.ErrorMessages
+			for _, message := range reply.Errors {
+				jiraErr.Messages = append(jiraErr.Messages, message)
+			}
 		}
+		return jiraErr
+	}
+
+	if err := json.Unmarshal(jsonBlob, result); err != nil {
+		return fmt.Errorf("while parsing JSON: %s", err.Error())
+	}
+	return nil
+}
+
+// GetIssue looks up the issue with the given key, e.g. QTBUG-123.
+func (this *jiraClient) GetIssue(ctx context.Context, key string) (*JiraBug, error) {
+	var bug JiraBug
+	if err := this.do(ctx, "GET", "/rest/api/2/issue/"+key, nil, &bug); err != nil {
+		return nil, err
+	}
+	if len(bug.Fields.Summary) == 0 {
+		return nil, errors.New("malformed reply")
+	}
+	if len(bug.Key) == 0 {
+		bug.Key = key
+	}
+	return &bug, nil
+}
 
-		resultsChannel <- fmt.Sprintf("%s%s - https://bugreports.qt.io/browse/%s (%s)",
-			directTo,
-			bug.Fields.Summary,
-			bugId,
-			bug.Fields.Status.Name)
+// Search returns the first max issues matching jql, and how many there are
+// in all. It's a POST, so it never comes from the cache.
+func (this *jiraClient) Search(ctx context.Context, jql string, startAt int, max int) (*JiraSearchResult, error) {
+	query := map[string]interface{}{
+		"jql":        jql,
+		"startAt":    startAt,
+		"maxResults": max,
+		"fields":     jiraFields,
 	}
+	var result JiraSearchResult
+	if err := this.do(ctx, "POST", "/rest/api/2/search", query, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Expands Jira issue keys, e.g. QTBUG-123.
+type jiraExpander struct{}
+
+var jiraIssueRegex = regexp.MustCompile(`\b(Q[A-Z]+-[0-9]+)\b`)
+
+func (this jiraExpander) Pattern(config *Config) *regexp.Regexp {
+	return jiraIssueRegex
+}
+
+func (this jiraExpander) Fetch(ctx context.Context, lookups *lookupService, config *Config, match []string) (interface{}, error) {
+	bugId := match[1]
+	bug, err := newJiraClient(config.Jira, lookups.client).GetIssue(ctx, bugId)
+
+	var jiraErr *JiraError
+	if errors.As(err, &jiraErr) && len(jiraErr.Messages) > 0 && jiraErr.StatusCode < 500 {
+		// e.g. that it doesn't exist, or isn't visible
+		return nil, userErrorf("%s: %s", bugId, jiraErr.Messages[0])
+	} else if err != nil {
+		return nil, err
+	}
+	return bug, nil
+}
+
+func (this jiraExpander) Format(config *Config, found interface{}) string {
+	return found.(*JiraBug).Describe(config.Jira.Url)
+}
+
+func init() {
+	registerExpander("jira", true, jiraExpander{})
 }
@@ -0,0 +1,191 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var jiraSearchRegex = regexp.MustCompile(`^!jira\s+search\s+(.+)$`)
+
+// Search Jira for jql, as asked to with !jira search, sending the issues
+// found to resultsChannel, prefixed with directTo.
+func runJiraSearch(lookups *lookupService, config *Config, resultsChannel chan string, directTo string, jql string) {
+	defer func() { close(resultsChannel) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Lookup.Deadline)
+	defer cancel()
+
+	jiraUrl := strings.TrimSuffix(config.Jira.Url, "/")
+	result, err := newJiraClient(config.Jira, lookups.client).Search(ctx, jql, 0, config.Jira.SearchResults)
+	var jiraErr *JiraError
+	if errors.As(err, &jiraErr) && jiraErr.StatusCode == http.StatusBadRequest && len(jiraErr.Messages) > 0 {
+		// most likely, the query is wrong; say how
+		resultsChannel <- directTo + jiraErr.Messages[0]
+		return
+	} else if err != nil {
+		fmt.Printf("Failed to search Jira for %s: %s\n", jql, err.Error())
+		resultsChannel <- directTo + "Couldn't search Jira"
+		return
+	}
+
+	if len(result.Issues) == 0 {
+		resultsChannel <- directTo + "No issues match"
+		return
+	}
+	for _, issue := range result.Issues {
+		resultsChannel <- fmt.Sprintf("%s[%s] %s", directTo, issue.Key, issue.Describe(jiraUrl))
+	}
+	if result.Total > len(result.Issues) {
+		resultsChannel <- fmt.Sprintf("%s...and %d more - %s/issues/?jql=%s", directTo, result.Total-len(result.Issues), jiraUrl, url.QueryEscape(jql))
+	}
+}
+
+// The most issues asked for at once when polling a watch. Any more are paged
+// through.
+const jiraWatchMaxResults = 50
+
+// Search Jira for every issue matching jql, a page at a time.
+func searchAllJira(ctx context.Context, jira *jiraClient, jql string) ([]JiraBug, error) {
+	var issues []JiraBug
+	for {
+		result, err := jira.Search(ctx, jql, len(issues), jiraWatchMaxResults)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, result.Issues...)
+		if len(result.Issues) == 0 || len(issues) >= result.Total {
+			return issues, nil
+		}
+	}
+}
+
+// What's known about one watch.
+type jiraWatchState struct {
+	// When Jira was last asked successfully, i.e. what the next poll should
+	// find the changes since. Zero until the first poll.
+	since time.Time
+
+	// When to next ask Jira.
+	next time.Time
+
+	// The last updated time of the issues recently seen, so that the same
+	// change isn't announced twice.
+	seen map[string]time.Time
+}
+
+// A jiraWatcher polls Jira for the issues updated recently that match the
+// configured watches, and announces those that are new or changed.
+type jiraWatcher struct {
+	lookups *lookupService
+	now     func() time.Time
+	state   map[string]*jiraWatchState
+}
+
+func newJiraWatcher(lookups *lookupService) *jiraWatcher {
+	return &jiraWatcher{
+		lookups: lookups,
+		now:     time.Now,
+		state:   map[string]*jiraWatchState{},
+	}
+}
+
+// Poll Jira for the watches that are due, passing what should be announced
+// to emit. The first poll of a watch only learns what there is.
+func (this *jiraWatcher) Poll(config *Config, emit func(channel string, line string)) {
+	jira := newJiraClient(config.Jira, this.lookups.client)
+	jiraUrl := strings.TrimSuffix(config.Jira.Url, "/")
+
+	current := map[string]bool{}
+	for _, watch := range config.Jira.Watches {
+		key := watch.Channel + " " + watch.Jql
+		current[key] = true
+		state, ok := this.state[key]
+		if !ok {
+			state = &jiraWatchState{seen: map[string]time.Time{}}
+			this.state[key] = state
+		}
+
+		now := this.now()
+		if now.Before(state.next) {
+			continue
+		}
+		state.next = now.Add(watch.Interval)
+
+		// Jira only compares to the minute, and in the user's timezone if
+		// given a date, so ask relative to now, with a minute to spare.
+		window := watch.Interval
+		if !state.since.IsZero() {
+			window = now.Sub(state.since)
+		}
+		minutes := int(math.Ceil(window.Minutes())) + 1
+		jql := fmt.Sprintf("(%s) AND updated >= \"-%dm\" ORDER BY updated ASC", watch.Jql, minutes)
+
+		ctx, cancel := context.WithTimeout(context.Background(), config.Lookup.Deadline)
+		issues, err := searchAllJira(ctx, jira, jql)
+		cancel()
+		if err != nil {
+			fmt.Printf("Failed to poll Jira for %s: %s\n", watch.Jql, err.Error())
+			continue
+		}
+
+		for _, issue := range issues {
+			updated := issue.Fields.Updated.Time
+			last, known := state.seen[issue.Key]
+			state.seen[issue.Key] = updated
+			if state.since.IsZero() || (known && !updated.After(last)) {
+				continue
+			}
+
+			what := "Updated"
+			if !known && !issue.Fields.Created.Before(state.since.Add(-time.Minute)) {
+				what = "New"
+			}
+			emit(watch.Channel, fmt.Sprintf("[%s] %s: %s", issue.Key, what, issue.Describe(jiraUrl)))
+		}
+
+		// what's older than the window won't be asked about again
+		for issueKey, updated := range state.seen {
+			if updated.Before(now.Add(-time.Duration(minutes+1) * time.Minute)) {
+				delete(state.seen, issueKey)
+			}
+		}
+		state.since = now
+	}
+
+	for key := range this.state {
+		if !current[key] {
+			delete(this.state, key)
+		}
+	}
+}
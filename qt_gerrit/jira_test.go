@@ -0,0 +1,318 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A stand-in for Jira, with a few issues, which a search returns all of.
+type fakeJira struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	issues   map[string]string
+	order    []string
+	auth     []string
+	searches []string
+}
+
+func startFakeJira(t *testing.T) *fakeJira {
+	jira := &fakeJira{issues: map[string]string{}}
+	jira.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jira.mutex.Lock()
+		defer jira.mutex.Unlock()
+		jira.auth = append(jira.auth, r.Header.Get("Authorization"))
+
+		if r.URL.Path == "/rest/api/2/search" && r.Method == "POST" {
+			var query struct {
+				Jql        string `json:"jql"`
+				StartAt    int    `json:"startAt"`
+				MaxResults int    `json:"maxResults"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+				t.Errorf("Bad search: %s", err)
+			}
+			jira.searches = append(jira.searches, query.Jql)
+			if strings.Contains(query.Jql, "bogus") {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, `{"errorMessages": ["Field 'bogus' does not exist."]}`)
+				return
+			}
+			var found []string
+			for idx, key := range jira.order {
+				if idx >= query.StartAt && idx < query.StartAt+query.MaxResults {
+					found = append(found, jira.issues[key])
+				}
+			}
+			fmt.Fprintf(w, `{"startAt": %d, "maxResults": %d, "total": %d, "issues": [%s]}`, query.StartAt, query.MaxResults, len(jira.order), strings.Join(found, ","))
+			return
+		}
+
+		if issue, ok := jira.issues[strings.TrimPrefix(r.URL.Path, "/rest/api/2/issue/")]; ok {
+			fmt.Fprintf(w, "%s", issue)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"errorMessages": ["Issue does not exist or you do not have permission to see it."], "errors": {}}`)
+	}))
+	return jira
+}
+
+func (this *fakeJira) SetIssue(key string, fields string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if _, ok := this.issues[key]; !ok {
+		this.order = append(this.order, key)
+	}
+	this.issues[key] = fmt.Sprintf(`{"key": %q, "fields": {%s}}`, key, fields)
+}
+
+func (this *fakeJira) Searches() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return append([]string{}, this.searches...)
+}
+
+func (this *fakeJira) Auth() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return append([]string{}, this.auth...)
+}
+
+const testJiraIssue = `"summary": "It crashes", "status": {"name": "Closed"}, "resolution": {"name": "Done"},
+	"priority": {"name": "P1: Critical"}, "assignee": {"name": "alice", "displayName": "Alice"},
+	"fixVersions": [{"name": "6.7.0"}, {"name": "6.8.0"}], "components": [{"name": "Core: Other"}],
+	"created": "2023-11-14T22:13:20.000+0100", "updated": "2023-11-15T09:00:00.000+0100"`
+
+func TestJiraIssue(t *testing.T) {
+	jira := startFakeJira(t)
+	defer jira.Close()
+	jira.SetIssue("QTBUG-1", testJiraIssue)
+	jira.SetIssue("QTBUG-2", `"summary": "It's slow", "status": {"name": "Open"}, "resolution": null, "assignee": null`)
+	config := testConfig(jira.URL)
+	lookups, _ := newTestLookupService(config)
+
+	tests := []struct {
+		Text     string
+		Expected string
+	}{
+		{"QTBUG-1", "It crashes - " + jira.URL + "/browse/QTBUG-1 (Closed: Done, P1: Critical, assigned to Alice, fix 6.7.0, 6.8.0, component Core: Other)"},
+		{"QTBUG-2", "It's slow - " + jira.URL + "/browse/QTBUG-2 (Open)"},
+		{"QTBUG-3", "QTBUG-3: Issue does not exist or you do not have permission to see it."},
+	}
+	for _, test := range tests {
+		refs := config.findReferences("#qt", test.Text)
+		if got := describeReference(context.Background(), lookups, config, refs[0]); got != test.Expected {
+			t.Errorf("Expected: %#v, got %#v", test.Expected, got)
+		}
+	}
+
+	bug, err := newJiraClient(config.Jira, lookups.client).GetIssue(context.Background(), "QTBUG-1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := time.Date(2023, 11, 14, 21, 13, 20, 0, time.UTC)
+	if !bug.Fields.Created.Equal(expected) {
+		t.Errorf("Expected: %#v, got %#v", expected, bug.Fields.Created.Time)
+	}
+}
+
+func TestJiraAuth(t *testing.T) {
+	jira := startFakeJira(t)
+	defer jira.Close()
+	jira.SetIssue("QTBUG-1", testJiraIssue)
+	config := testConfig(jira.URL)
+	lookups, _ := newTestLookupService(config)
+
+	config.Jira.Token = "secret"
+	newJiraClient(config.Jira, lookups.client).GetIssue(context.Background(), "QTBUG-1")
+	config.Jira.User = "bot@example.org"
+	newJiraClient(config.Jira, lookups.client).GetIssue(context.Background(), "QTBUG-1")
+	config.Jira.User, config.Jira.Token = "", ""
+	newJiraClient(config.Jira, lookups.client).GetIssue(context.Background(), "QTBUG-1")
+
+	expected := []string{"Bearer secret", "Basic Ym90QGV4YW1wbGUub3JnOnNlY3JldA==", ""}
+	if got := jira.Auth(); strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected: %#v, got %#v", expected, got)
+	}
+}
+
+func TestJiraSearch(t *testing.T) {
+	jira := startFakeJira(t)
+	defer jira.Close()
+	config := testConfig(jira.URL)
+	config.Jira.SearchResults = 2
+	lookups, _ := newTestLookupService(config)
+
+	search := func(jql string) []string {
+		results := make(chan string)
+		go runJiraSearch(lookups, config, results, "alice: ", jql)
+		var got []string
+		for line := range results {
+			got = append(got, line)
+		}
+		return got
+	}
+	check := func(got []string, expected []string) {
+		if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("Expected: %#v, got %#v", expected, got)
+		}
+	}
+
+	check(search("project = QTBUG"), []string{"alice: No issues match"})
+	check(search("bogus = 1"), []string{"alice: Field 'bogus' does not exist."})
+
+	jira.SetIssue("QTBUG-1", testJiraIssue)
+	jira.SetIssue("QTBUG-2", `"summary": "It's slow", "status": {"name": "Open"}`)
+	jira.SetIssue("QTBUG-3", `"summary": "It's ugly", "status": {"name": "Open"}`)
+	check(search("project = QTBUG"), []string{
+		"alice: [QTBUG-1] It crashes - " + jira.URL + "/browse/QTBUG-1 (Closed: Done, P1: Critical, assigned to Alice, fix 6.7.0, 6.8.0, component Core: Other)",
+		"alice: [QTBUG-2] It's slow - " + jira.URL + "/browse/QTBUG-2 (Open)",
+		"alice: ...and 1 more - " + jira.URL + "/issues/?jql=project+%3D+QTBUG",
+	})
+
+	if got := jira.Searches(); len(got) != 3 || got[2] != "project = QTBUG" {
+		t.Errorf("Expected three searches, got %#v", got)
+	}
+}
+
+func TestJiraSearchCommand(t *testing.T) {
+	tests := []struct {
+		Text     string
+		Expected string
+	}{
+		{"!jira search project = QTBUG", "project = QTBUG"},
+		{"!jira  search   assignee = currentUser()", "assignee = currentUser()"},
+		{"!jira search", ""},
+		{"see !jira search x", ""},
+	}
+	for _, test := range tests {
+		got := ""
+		if match := jiraSearchRegex.FindStringSubmatch(test.Text); match != nil {
+			got = match[1]
+		}
+		if got != test.Expected {
+			t.Errorf("Expected: %#v, got %#v", test.Expected, got)
+		}
+	}
+}
+
+func TestJiraWatch(t *testing.T) {
+	jira := startFakeJira(t)
+	defer jira.Close()
+	config := testConfig(jira.URL)
+	config.Jira.Watches = []JiraWatchConfig{{Channel: "#qt-labs", Jql: "priority = P0", Interval: 5 * time.Minute}}
+	lookups, clock := newTestLookupService(config)
+	watcher := newJiraWatcher(lookups)
+	watcher.now = clock.Now
+
+	var got []string
+	poll := func() {
+		watcher.Poll(config, func(channel string, line string) {
+			got = append(got, channel+" "+line)
+		})
+	}
+	stamp := func(d time.Duration) string {
+		return clock.Now().Add(d).UTC().Format("2006-01-02T15:04:05.000-0700")
+	}
+
+	// what's there to begin with isn't announced
+	jira.SetIssue("QTBUG-1", fmt.Sprintf(`"summary": "Old", "status": {"name": "Open"}, "created": %q, "updated": %q`, stamp(-time.Hour), stamp(-time.Minute)))
+	poll()
+
+	// nor asked about again until the interval is up
+	clock.Advance(time.Minute)
+	jira.SetIssue("QTBUG-2", fmt.Sprintf(`"summary": "New", "status": {"name": "Open"}, "created": %q, "updated": %q`, stamp(0), stamp(0)))
+	poll()
+	if len(got) != 0 || len(jira.Searches()) != 1 {
+		t.Errorf("Expected nothing, got %#v after %#v", got, jira.Searches())
+	}
+
+	clock.Advance(4 * time.Minute)
+	poll()
+	clock.Advance(5 * time.Minute)
+	jira.SetIssue("QTBUG-1", fmt.Sprintf(`"summary": "Old", "status": {"name": "Closed"}, "created": %q, "updated": %q`, stamp(-time.Hour), stamp(0)))
+	poll()
+
+	expected := []string{
+		"#qt-labs [QTBUG-2] New: New - " + jira.URL + "/browse/QTBUG-2 (Open)",
+		"#qt-labs [QTBUG-1] Updated: Old - " + jira.URL + "/browse/QTBUG-1 (Closed)",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected: %#v, got %#v", expected, got)
+	}
+
+	expectedSearches := []string{
+		`(priority = P0) AND updated >= "-6m" ORDER BY updated ASC`,
+		`(priority = P0) AND updated >= "-6m" ORDER BY updated ASC`,
+		`(priority = P0) AND updated >= "-6m" ORDER BY updated ASC`,
+	}
+	if got := jira.Searches(); strings.Join(got, "\n") != strings.Join(expectedSearches, "\n") {
+		t.Errorf("Expected: %#v, got %#v", expectedSearches, got)
+	}
+}
+
+func TestJiraWatchPages(t *testing.T) {
+	jira := startFakeJira(t)
+	defer jira.Close()
+	config := testConfig(jira.URL)
+	config.Jira.Watches = []JiraWatchConfig{{Channel: "#qt-labs", Jql: "priority = P0", Interval: 5 * time.Minute}}
+	lookups, clock := newTestLookupService(config)
+	watcher := newJiraWatcher(lookups)
+	watcher.now = clock.Now
+
+	got := 0
+	poll := func() {
+		watcher.Poll(config, func(channel string, line string) {
+			got++
+		})
+	}
+	setIssues := func() {
+		stamp := clock.Now().UTC().Format("2006-01-02T15:04:05.000-0700")
+		for idx := 1; idx <= jiraWatchMaxResults+10; idx++ {
+			jira.SetIssue(fmt.Sprintf("QTBUG-%d", idx), fmt.Sprintf(`"summary": "Bug", "status": {"name": "Open"}, "created": "2023-11-14T22:13:20.000+0100", "updated": %q`, stamp))
+		}
+	}
+
+	setIssues()
+	poll()
+	clock.Advance(5 * time.Minute)
+	setIssues()
+	poll()
+
+	// every issue that changed is announced, not only the first page
+	if got != jiraWatchMaxResults+10 || len(jira.Searches()) != 4 {
+		t.Errorf("Expected: %#v, got %#v after %#v", jiraWatchMaxResults+10, got, jira.Searches())
+	}
+}
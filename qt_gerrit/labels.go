@@ -0,0 +1,155 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A LabelConfig describes how votes on a Gerrit label are shown.
+type LabelConfig struct {
+	// The short form of the label, e.g. C for Code-Review. If empty, the
+	// label's name is used.
+	Short string `toml:"short"`
+
+	// Other names Gerrit may use for the label, e.g. CRVW in older versions.
+	Aliases []string `toml:"aliases"`
+
+	// The colours (see colorize) positive and negative votes are shown in.
+	// If empty, they aren't coloured.
+	PositiveColor string `toml:"positive_color"`
+	NegativeColor string `toml:"negative_color"`
+}
+
+func defaultLabels() map[string]LabelConfig {
+	return map[string]LabelConfig{
+		"Code-Review":   {Short: "C", Aliases: []string{"CRVW"}},
+		"Sanity-Review": {Short: "S", Aliases: []string{"SRVW"}},
+		"Verified":      {Short: "V", Aliases: []string{"VRIF"}},
+	}
+}
+
+// Return the names of the configured labels, sorted.
+func (this *Config) labelNames() []string {
+	names := make([]string, 0, len(this.Labels))
+	for name := range this.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check the label settings.
+func (this *Config) validateLabels() []error {
+	var errs []error
+	aliases := map[string]string{}
+	for _, name := range this.labelNames() {
+		label := this.Labels[name]
+		for _, color := range []string{label.PositiveColor, label.NegativeColor} {
+			if _, ok := ircColors[color]; len(color) > 0 && !ok {
+				errs = append(errs, fmt.Errorf("labels.%s: unknown colour %q", name, color))
+			}
+		}
+		for _, alias := range label.Aliases {
+			if other, ok := aliases[strings.ToLower(alias)]; ok {
+				errs = append(errs, fmt.Errorf("labels.%s: alias %s is already used by %s", name, alias, other))
+				continue
+			}
+			aliases[strings.ToLower(alias)] = name
+		}
+	}
+	return errs
+}
+
+// LabelName returns the configured name of the label called name (which may
+// be an alias), or name itself if it isn't configured.
+func (this *Config) LabelName(name string) string {
+	if _, ok := this.Labels[name]; ok {
+		return name
+	}
+	for configured, label := range this.Labels {
+		if strings.EqualFold(configured, name) {
+			return configured
+		}
+		for _, alias := range label.Aliases {
+			if strings.EqualFold(alias, name) {
+				return configured
+			}
+		}
+	}
+	return name
+}
+
+// Label returns the settings for the label called name. Unknown labels are
+// shown by their name, without colour.
+func (this *Config) Label(name string) LabelConfig {
+	label := this.Labels[this.LabelName(name)]
+	if len(label.Short) == 0 {
+		label.Short = name
+	}
+	return label
+}
+
+// Format a vote on label, colouring it as configured.
+func (this *LabelConfig) formatValue(value int64) string {
+	str := fmt.Sprintf("%d", value)
+	color := ""
+	if value > 0 {
+		color = this.PositiveColor
+	} else if value < 0 {
+		color = this.NegativeColor
+	}
+	if len(color) > 0 {
+		str, _ = colorize(color, str)
+	}
+	return str
+}
+
+// Return the short form of an approval label.
+func labelShortName(name string) string {
+	return getConfig().Label(name).Short
+}
+
+// Describe an approval, e.g. "C: 2", or "C: 2 (was 1)" if a previous vote was
+// changed.
+func describeApproval(approval GerritApproval) string {
+	label := getConfig().Label(approval.Type)
+	str := fmt.Sprintf("%s: %s", label.Short, label.formatValue(int64(approval.Value)))
+	if approval.OldValue != nil && *approval.OldValue != 0 && *approval.OldValue != approval.Value {
+		str += fmt.Sprintf(" (was %s)", label.formatValue(int64(*approval.OldValue)))
+	}
+	return str
+}
+
+// Describe a set of approvals, e.g. "C: 2 S: 1".
+func describeApprovals(approvals []GerritApproval) string {
+	parts := make([]string, len(approvals))
+	for idx, approval := range approvals {
+		parts[idx] = describeApproval(approval)
+	}
+	return strings.Join(parts, " ")
+}
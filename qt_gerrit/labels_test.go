@@ -0,0 +1,98 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChangedApprovals(t *testing.T) {
+	msg := testEvent(t, `{"type": "comment-added", "approvals": [{"type": "Code-Review", "value": "2", "oldValue": "1"}, {"type": "Verified", "value": "1"}, {"type": "Sanity-Review", "value": "1", "oldValue": "1"}]}`)
+	approvals := msg.ChangedApprovals()
+	if len(approvals) != 1 || approvals[0].Type != "Code-Review" || *approvals[0].OldValue != 1 {
+		t.Errorf("Expected only the Code-Review vote, got %#v", approvals)
+	}
+
+	// a plain comment, with the author's votes as they were
+	msg = testEvent(t, `{"type": "comment-added", "approvals": [{"type": "Code-Review", "value": "2"}, {"type": "Verified", "value": "1"}]}`)
+	if approvals := msg.ChangedApprovals(); len(approvals) != 0 {
+		t.Errorf("Expected no changed votes without oldValue, got %#v", approvals)
+	}
+
+	// unless Gerrit is too old to say
+	old := getConfig()
+	defer setConfig(old)
+	config := defaultConfig()
+	config.Gerrit.LegacyApprovals = true
+	setConfig(config)
+	if approvals := msg.ChangedApprovals(); len(approvals) != 2 {
+		t.Errorf("Expected both votes without oldValue, got %#v", approvals)
+	}
+	setConfig(old)
+
+	msg = testEvent(t, `{"type": "comment-added", "approvals": [{"type": "Code-Review", "value": "2", "oldValue": "2"}]}`)
+	if approvals := msg.ChangedApprovals(); len(approvals) != 0 {
+		t.Errorf("Expected no changed votes, got %#v", approvals)
+	}
+}
+
+func TestDescribeApprovals(t *testing.T) {
+	old := getConfig()
+	defer setConfig(old)
+
+	config := defaultConfig()
+	config.Labels["Code-Review"] = LabelConfig{Short: "CR", Aliases: []string{"CRVW"}, PositiveColor: "green", NegativeColor: "red"}
+	config.Labels["Build"] = LabelConfig{}
+	setConfig(config)
+
+	msg := testEvent(t, `{"type": "comment-added", "approvals": [{"type": "CRVW", "value": "-2", "oldValue": "1"}, {"type": "Verified", "value": "1", "oldValue": "0"}, {"type": "Build", "value": "1", "oldValue": "-1"}, {"type": "API-Review", "value": "0", "oldValue": "2"}]}`)
+	expected := "CR: \x0304-2\x03 (was \x03031\x03) V: 1 Build: 1 (was -1) API-Review: 0 (was 2)"
+	if str := describeApprovals(msg.ChangedApprovals()); str != expected {
+		t.Errorf("Expected: %#v, got %#v", expected, str)
+	}
+
+	if name := config.LabelName("code-review"); name != "Code-Review" {
+		t.Errorf("Expected: %#v, got %#v", "Code-Review", name)
+	}
+	if name := config.LabelName("Custom"); name != "Custom" {
+		t.Errorf("Expected: %#v, got %#v", "Custom", name)
+	}
+}
+
+func TestLabelErrors(t *testing.T) {
+	config := defaultConfig()
+	config.Labels["Code-Review"] = LabelConfig{Short: "C", PositiveColor: "mauve"}
+	config.Labels["Sanity"] = LabelConfig{Aliases: []string{"srvw"}}
+
+	var messages []string
+	for _, err := range config.validateLabels() {
+		messages = append(messages, err.Error())
+	}
+	expected := `labels.Code-Review: unknown colour "mauve",labels.Sanity-Review: alias SRVW is already used by Sanity`
+	if str := strings.Join(messages, ","); str != expected {
+		t.Errorf("Expected: %#v, got %#v", expected, str)
+	}
+}
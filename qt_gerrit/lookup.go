@@ -0,0 +1,330 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LookupConfig controls how the things mentioned in channels (Jira issues,
+// Gerrit changes, GitHub commits) are looked up.
+type LookupConfig struct {
+	// How long a reply is reused for before asking again. Once that's up,
+	// the request is made conditional, so an unchanged reply costs little.
+	// Zero disables caching.
+	CacheTTL time.Duration `toml:"cache_ttl"`
+
+	// How many replies to keep, at most.
+	CacheSize int `toml:"cache_size"`
+
+	// How long to stay quiet about something that was just looked up in the
+	// same channel, when it's mentioned again. Zero disables this.
+	Cooldown time.Duration `toml:"cooldown"`
+
+	// How long the lookups for one message may take, in all.
+	Deadline time.Duration `toml:"deadline"`
+}
+
+func defaultLookupConfig() LookupConfig {
+	return LookupConfig{
+		CacheTTL:  10 * time.Minute,
+		CacheSize: 1000,
+		Cooldown:  5 * time.Minute,
+		Deadline:  15 * time.Second,
+	}
+}
+
+func (this *LookupConfig) validate() []error {
+	var errs []error
+	if this.CacheTTL < 0 {
+		errs = append(errs, fmt.Errorf("lookup.cache_ttl: must not be negative"))
+	}
+	if this.CacheSize < 0 {
+		errs = append(errs, fmt.Errorf("lookup.cache_size: must not be negative"))
+	}
+	if this.Cooldown < 0 {
+		errs = append(errs, fmt.Errorf("lookup.cooldown: must not be negative"))
+	}
+	if this.Deadline <= 0 {
+		errs = append(errs, fmt.Errorf("lookup.deadline: must be positive"))
+	}
+	return errs
+}
+
+// A reply, as kept in the cache.
+type cachedResponse struct {
+	status  int
+	header  http.Header
+	body    []byte
+	fetched time.Time
+}
+
+func (this *cachedResponse) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", this.status, http.StatusText(this.status)),
+		StatusCode:    this.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        this.header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(this.body)),
+		ContentLength: int64(len(this.body)),
+		Request:       req,
+	}
+}
+
+// Whether the reply may be reused.
+func (this *cachedResponse) cacheable() bool {
+	return this.status == http.StatusOK && !strings.Contains(this.header.Get("Cache-Control"), "no-store")
+}
+
+// A request that is being made, which others wanting the same reply wait for.
+type inflightRequest struct {
+	done     chan struct{}
+	response *cachedResponse
+	err      error
+}
+
+// A responseCache is an http.RoundTripper that keeps the replies to GET
+// requests for a while, revalidates them with If-None-Match and
+// If-Modified-Since once they're stale, and makes concurrent requests for the
+// same thing only once.
+type responseCache struct {
+	next   http.RoundTripper
+	config func() *Config
+	now    func() time.Time
+
+	mutex    sync.Mutex
+	entries  map[string]*cachedResponse
+	inflight map[string]*inflightRequest
+}
+
+func (this *responseCache) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" {
+		return this.next.RoundTrip(req)
+	}
+
+	// replies may differ by who's asking
+	key := req.URL.String() + " " + req.Header.Get("Authorization")
+	ttl := this.config().Lookup.CacheTTL
+
+	this.mutex.Lock()
+	stale := this.entries[key]
+	if stale != nil && this.now().Sub(stale.fetched) < ttl {
+		this.mutex.Unlock()
+		return stale.response(req), nil
+	}
+	call, ok := this.inflight[key]
+	if !ok {
+		call = &inflightRequest{done: make(chan struct{})}
+		this.inflight[key] = call
+
+		// the request is shared, so it mustn't be given up on just because
+		// whoever asked first has.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(req.Context()), this.config().Lookup.Deadline)
+		go func() {
+			defer cancel()
+			call.response, call.err = this.fetch(req.WithContext(ctx), stale)
+
+			this.mutex.Lock()
+			delete(this.inflight, key)
+			if call.err == nil && ttl > 0 && call.response.cacheable() {
+				this.entries[key] = call.response
+				this.evict(this.config().Lookup.CacheSize)
+			}
+			this.mutex.Unlock()
+			close(call.done)
+		}()
+	}
+	this.mutex.Unlock()
+
+	select {
+	case <-call.done:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	if call.err != nil && req.Context().Err() != nil {
+		// it ran out of time too, which is what matters here
+		return nil, req.Context().Err()
+	} else if call.err != nil {
+		return nil, call.err
+	}
+	return call.response.response(req), nil
+}
+
+// Make the request, conditional on the reply having changed since stale.
+func (this *responseCache) fetch(req *http.Request, stale *cachedResponse) (*cachedResponse, error) {
+	if stale != nil {
+		req = req.Clone(req.Context())
+		if etag := stale.header.Get("ETag"); len(etag) > 0 {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if modified := stale.header.Get("Last-Modified"); len(modified) > 0 {
+			req.Header.Set("If-Modified-Since", modified)
+		}
+	}
+
+	res, err := this.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusNotModified && stale != nil {
+		return &cachedResponse{status: stale.status, header: stale.header, body: stale.body, fetched: this.now()}, nil
+	}
+	return &cachedResponse{status: res.StatusCode, header: res.Header, body: body, fetched: this.now()}, nil
+}
+
+// Drop the oldest entries, until there are at most size. Called with the
+// mutex held.
+func (this *responseCache) evict(size int) {
+	for len(this.entries) > size {
+		oldestKey := ""
+		var oldest time.Time
+		for key, entry := range this.entries {
+			if len(oldestKey) == 0 || entry.fetched.Before(oldest) {
+				oldestKey, oldest = key, entry.fetched
+			}
+		}
+		delete(this.entries, oldestKey)
+	}
+}
+
+// The lookupService is shared by everything that looks up what's mentioned in
+// channels: it provides an HTTP client with a cache in front, and keeps track
+// of what was recently looked up where, so it isn't repeated.
+type lookupService struct {
+	client *http.Client
+	config func() *Config
+	now    func() time.Time
+
+	mutex    sync.Mutex
+	expanded map[string]time.Time
+	limits   map[string]*rateLimit
+}
+
+func newLookupService() *lookupService {
+	service := &lookupService{
+		config:   getConfig,
+		now:      time.Now,
+		expanded: map[string]time.Time{},
+		limits:   map[string]*rateLimit{},
+	}
+	cache := &responseCache{
+		next:     http.DefaultTransport,
+		config:   func() *Config { return service.config() },
+		now:      func() time.Time { return service.now() },
+		entries:  map[string]*cachedResponse{},
+		inflight: map[string]*inflightRequest{},
+	}
+	service.client = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: cache,
+	}
+	return service
+}
+
+// ShouldExpand returns true if key (e.g. "jira:QTBUG-1") should be looked up
+// for channel, i.e. if it wasn't within the cooldown, and remembers that it
+// was.
+func (this *lookupService) ShouldExpand(channel string, key string) bool {
+	cooldown := this.config().Lookup.Cooldown
+	if cooldown <= 0 {
+		return true
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	now := this.now()
+	for existing, when := range this.expanded {
+		if now.Sub(when) >= cooldown {
+			delete(this.expanded, existing)
+		}
+	}
+
+	key = strings.ToLower(channel + " " + key)
+	if _, ok := this.expanded[key]; ok {
+		return false
+	}
+	this.expanded[key] = now
+	return true
+}
+
+// RateLimit returns what's known of the rate limit of service (e.g. github).
+func (this *lookupService) RateLimit(service string) *rateLimit {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	limit, ok := this.limits[service]
+	if !ok {
+		limit = &rateLimit{remaining: -1}
+		this.limits[service] = limit
+	}
+	return limit
+}
+
+// A rateLimit is what a service last said about how many more requests it
+// will take, and when that resets, so it isn't asked again once it said no.
+type rateLimit struct {
+	mutex     sync.Mutex
+	remaining int // -1 if unknown
+	reset     time.Time
+	asOf      time.Time
+}
+
+// Update records what a reply from asOf said. Replies older than the last
+// one recorded, e.g. from the cache, are ignored.
+func (this *rateLimit) Update(remaining int, reset time.Time, asOf time.Time) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if asOf.Before(this.asOf) {
+		return
+	}
+	this.remaining, this.reset, this.asOf = remaining, reset, asOf
+}
+
+// Exhausted returns true, and when that changes, if no requests should be
+// made at now.
+func (this *rateLimit) Exhausted(now time.Time) (bool, time.Time) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.remaining == 0 && now.Before(this.reset) {
+		return true, this.reset
+	}
+	return false, time.Time{}
+}
@@ -0,0 +1,317 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fake clock for the lookup service.
+type testClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (this *testClock) Now() time.Time {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.now
+}
+
+func (this *testClock) Advance(d time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.now = this.now.Add(d)
+}
+
+// A config with every service at url (cgit at url/cgit), and nothing cached,
+// for tests to adjust. #qt-docs and #qt-all have the docs and cgit expanders
+// enabled (and #qt-docs has Jira's disabled).
+func testConfig(url string) *Config {
+	config := defaultConfig()
+	config.Gerrit.Url = url
+	config.Jira.Url = url
+	config.Github.Url = url
+	config.Cgit.Url = url + "/cgit"
+	config.Docs.Url = url
+	config.Lookup.CacheTTL = 0
+	config.IRC.Channels = []string{"#qt", "#qt-labs"}
+	config.Channels = map[string]ChannelConfig{
+		"#qt-docs": {Expanders: map[string]bool{"docs": true, "cgit": true, "jira": false}},
+		"#qt-all":  {Expanders: map[string]bool{"docs": true, "cgit": true}},
+	}
+	return config
+}
+
+func newTestLookupService(config *Config) (*lookupService, *testClock) {
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	lookups := newLookupService()
+	lookups.config = func() *Config { return config }
+	lookups.now = clock.Now
+	return lookups, clock
+}
+
+func testGet(t *testing.T, client *http.Client, url string) (int, string) {
+	res, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	return res.StatusCode, string(body)
+}
+
+func TestLookupCache(t *testing.T) {
+	var mutex sync.Mutex
+	var requests []string
+	version := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		requests = append(requests, r.URL.Path+" "+r.Header.Get("If-None-Match")+" "+r.Header.Get("If-Modified-Since"))
+
+		switch r.URL.Path {
+		case "/etag":
+			etag := fmt.Sprintf(`"v%d"`, version)
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			fmt.Fprintf(w, "version %d", version)
+		case "/modified":
+			w.Header().Set("Last-Modified", "Tue, 14 Nov 2023 22:13:20 GMT")
+			if len(r.Header.Get("If-Modified-Since")) > 0 {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			fmt.Fprintf(w, "modified")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "not found")
+		}
+	}))
+	defer server.Close()
+	takeRequests := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		taken := requests
+		requests = nil
+		return taken
+	}
+
+	config := defaultConfig()
+	lookups, clock := newTestLookupService(config)
+
+	for idx := 0; idx < 3; idx++ {
+		if status, body := testGet(t, lookups.client, server.URL+"/etag"); status != 200 || body != "version 1" {
+			t.Errorf("Expected: %#v, got %d %#v", "version 1", status, body)
+		}
+	}
+	if got := takeRequests(); len(got) != 1 {
+		t.Errorf("Expected one request, got %#v", got)
+	}
+
+	// once stale, it's revalidated
+	clock.Advance(config.Lookup.CacheTTL)
+	if status, body := testGet(t, lookups.client, server.URL+"/etag"); status != 200 || body != "version 1" {
+		t.Errorf("Expected: %#v, got %d %#v", "version 1", status, body)
+	}
+	if got := takeRequests(); len(got) != 1 || got[0] != `/etag "v1" ` {
+		t.Errorf("Expected a conditional request, got %#v", got)
+	}
+
+	// and fresh again after that
+	testGet(t, lookups.client, server.URL+"/etag")
+	if got := takeRequests(); len(got) != 0 {
+		t.Errorf("Expected no requests, got %#v", got)
+	}
+
+	mutex.Lock()
+	version = 2
+	mutex.Unlock()
+	clock.Advance(config.Lookup.CacheTTL)
+	if _, body := testGet(t, lookups.client, server.URL+"/etag"); body != "version 2" {
+		t.Errorf("Expected: %#v, got %#v", "version 2", body)
+	}
+
+	testGet(t, lookups.client, server.URL+"/modified")
+	clock.Advance(config.Lookup.CacheTTL)
+	if _, body := testGet(t, lookups.client, server.URL+"/modified"); body != "modified" {
+		t.Errorf("Expected: %#v, got %#v", "modified", body)
+	}
+	takeRequests()
+
+	// failures aren't cached
+	for idx := 0; idx < 2; idx++ {
+		if status, _ := testGet(t, lookups.client, server.URL+"/missing"); status != 404 {
+			t.Errorf("Expected: %#v, got %#v", 404, status)
+		}
+	}
+	if got := takeRequests(); len(got) != 2 {
+		t.Errorf("Expected two requests, got %#v", got)
+	}
+
+	// nor is anything, without a TTL
+	config.Lookup.CacheTTL = 0
+	testGet(t, lookups.client, server.URL+"/etag")
+	testGet(t, lookups.client, server.URL+"/etag")
+	if got := takeRequests(); len(got) != 2 {
+		t.Errorf("Expected two requests, got %#v", got)
+	}
+}
+
+func TestLookupCacheSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s", r.URL.Path)
+	}))
+	defer server.Close()
+
+	config := defaultConfig()
+	config.Lookup.CacheSize = 2
+	lookups, clock := newTestLookupService(config)
+	for _, path := range []string{"/a", "/b", "/c"} {
+		testGet(t, lookups.client, server.URL+path)
+		clock.Advance(time.Second)
+	}
+
+	cache := lookups.client.Transport.(*responseCache)
+	if _, ok := cache.entries[server.URL+"/a "]; ok || len(cache.entries) != 2 {
+		t.Errorf("Expected /a to have been evicted, got %#v", cache.entries)
+	}
+}
+
+func TestLookupInflight(t *testing.T) {
+	started := make(chan bool, 10)
+	release := make(chan bool)
+	var mutex sync.Mutex
+	count := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		count++
+		mutex.Unlock()
+		started <- true
+		<-release
+		fmt.Fprintf(w, "QTBUG-1")
+	}))
+	defer server.Close()
+
+	lookups, _ := newTestLookupService(defaultConfig())
+	var wg sync.WaitGroup
+	bodies := make([]string, 3)
+	get := func(idx int) {
+		defer wg.Done()
+		_, bodies[idx] = testGet(t, lookups.client, server.URL+"/issue")
+	}
+
+	wg.Add(1)
+	go get(0)
+	<-started
+	wg.Add(2)
+	go get(1)
+	go get(2)
+	time.Sleep(50 * time.Millisecond) // let them find the request in flight
+	close(release)
+	wg.Wait()
+
+	if count != 1 {
+		t.Errorf("Expected one request, got %d", count)
+	}
+	for _, body := range bodies {
+		if body != "QTBUG-1" {
+			t.Errorf("Expected: %#v, got %#v", "QTBUG-1", body)
+		}
+	}
+}
+
+func TestLookupInflightCancelled(t *testing.T) {
+	started := make(chan bool, 10)
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+		fmt.Fprintf(w, "QTBUG-1")
+	}))
+	defer server.Close()
+
+	lookups, _ := newTestLookupService(defaultConfig())
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/issue", nil)
+	failed := make(chan error)
+	go func() {
+		_, err := lookups.client.Do(req)
+		failed <- err
+	}()
+	<-started
+
+	result := make(chan string)
+	go func() {
+		_, body := testGet(t, lookups.client, server.URL+"/issue")
+		result <- body
+	}()
+	time.Sleep(50 * time.Millisecond) // let it find the request in flight
+
+	// whoever asked first giving up only fails them
+	cancel()
+	if err := <-failed; err == nil {
+		t.Errorf("Expected the cancelled request to fail")
+	}
+	close(release)
+	if body := <-result; body != "QTBUG-1" {
+		t.Errorf("Expected: %#v, got %#v", "QTBUG-1", body)
+	}
+}
+
+func TestLookupCooldown(t *testing.T) {
+	config := defaultConfig()
+	lookups, clock := newTestLookupService(config)
+
+	if !lookups.ShouldExpand("#qt", "jira QTBUG-1") {
+		t.Errorf("Expected the first mention to be expanded")
+	}
+	if lookups.ShouldExpand("#QT", "jira qtbug-1") {
+		t.Errorf("Expected a repeat to be suppressed")
+	}
+	if !lookups.ShouldExpand("#qt-labs", "jira QTBUG-1") {
+		t.Errorf("Expected another channel to be expanded")
+	}
+
+	clock.Advance(config.Lookup.Cooldown)
+	if !lookups.ShouldExpand("#qt", "jira QTBUG-1") {
+		t.Errorf("Expected a mention after the cooldown to be expanded")
+	}
+
+	config.Lookup.Cooldown = 0
+	if !lookups.ShouldExpand("#qt", "jira QTBUG-1") {
+		t.Errorf("Expected no cooldown when disabled")
+	}
+}
@@ -25,16 +25,24 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"github.com/rburchell/gobo/lib/gerrit"
+	"github.com/rburchell/gobo/lib/irc/bouncer"
 	"github.com/rburchell/gobo/lib/irc/client"
+	"github.com/rburchell/gobo/lib/irc/logger"
 	"github.com/rburchell/gobo/lib/irc/parser"
+	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
 	"strings"
+	"syscall"
 	"time"
 )
 
-func messageDrainer(c *client.IrcClient, origin string, messageChan chan string) {
+func messageDrainer(say func(target string, line string), origin string, messageChan chan string) {
 	for {
 		select {
 		case msg := <-messageChan:
@@ -42,117 +50,287 @@ func messageDrainer(c *client.IrcClient, origin string, messageChan chan string)
 				return
 			}
 
-			c.WriteMessage(origin, msg)
+			say(origin, msg)
 		}
 	}
 }
 
+// Reload the configuration from path, applying what can be applied without
+// reconnecting. On error, the existing configuration stays in effect.
+func reloadConfig(c *client.IrcClient, path string) error {
+	config, err := LoadConfig(path)
+	if err != nil {
+		fmt.Printf("Not reloading config:\n%s\n", err.Error())
+		return err
+	}
+
+	old := getConfig()
+	for _, channel := range config.IRC.Channels {
+		if !old.IRC.HasChannel(channel) {
+			c.Join(channel)
+		}
+	}
+	for _, channel := range old.IRC.Channels {
+		if !config.IRC.HasChannel(channel) {
+			c.Part(channel)
+		}
+	}
+
+	if config.IRC.Server != old.IRC.Server ||
+		config.IRC.Nick != old.IRC.Nick ||
+		config.IRC.User != old.IRC.User ||
+		config.IRC.RealName != old.IRC.RealName ||
+		config.IRC.NickServUser != old.IRC.NickServUser ||
+		config.IRC.NickServPass != old.IRC.NickServPass ||
+		config.IRC.LogDir != old.IRC.LogDir ||
+		config.IRC.BouncerListen != old.IRC.BouncerListen ||
+		config.IRC.BouncerPass != old.IRC.BouncerPass {
+		fmt.Printf("IRC connection settings changed, restart to apply them\n")
+	}
+	if config.Gerrit.Host != old.Gerrit.Host ||
+		config.Gerrit.User != old.Gerrit.User ||
+		config.Gerrit.PrivateKey != old.Gerrit.PrivateKey ||
+		config.Gerrit.KnownHosts != old.Gerrit.KnownHosts ||
+		config.Gerrit.HostKeyFingerprint != old.Gerrit.HostKeyFingerprint ||
+		config.Gerrit.TrustOnFirstUse != old.Gerrit.TrustOnFirstUse ||
+		strings.Join(config.Gerrit.Ciphers, ",") != strings.Join(old.Gerrit.Ciphers, ",") ||
+		strings.Join(config.Gerrit.KeyExchanges, ",") != strings.Join(old.Gerrit.KeyExchanges, ",") ||
+		config.Gerrit.StateFile != old.Gerrit.StateFile ||
+		config.Gerrit.MaxReplay != old.Gerrit.MaxReplay {
+		fmt.Printf("Gerrit connection settings changed, restart to apply them\n")
+	}
+	if config.Watch.File != old.Watch.File {
+		fmt.Printf("watch.file changed, restart to apply it\n")
+	}
+	if config.HTTP.Listen != old.HTTP.Listen {
+		fmt.Printf("http.listen changed, restart to apply it\n")
+	}
+
+	setConfig(config)
+	fmt.Printf("Reloaded config\n")
+	return nil
+}
+
+// Lets go of everything held back, and leaves IRC.
+func shutdown(co *coalescer, writeLine func(string)) {
+	co.flush()
+	writeLine("QUIT :Shutting down")
+}
+
 func main() {
-	// TODO: move all env var checks here.
-	if len(gerritChannel) == 0 {
-		panic("Must provide environment variable GERRIT_CHANNEL")
+	configPath := flag.String("config", os.Getenv("QT_GERRIT_CONFIG"), "path to the TOML config file")
+	flag.Parse()
+
+	config, err := LoadConfig(*configPath)
+	if err != nil {
+		fmt.Printf("Invalid config:\n%s\n", err.Error())
+		os.Exit(1)
+	}
+	setConfig(config)
+
+	c := client.NewClient(config.IRC.Nick, config.IRC.User, config.IRC.RealName, config.IRC.NickServUser, config.IRC.NickServPass)
+
+	if len(config.IRC.LogDir) > 0 {
+		logger.New(c, logger.Config{Directory: config.IRC.LogDir, Text: true, JSON: true})
+	}
+
+	if len(config.IRC.BouncerListen) > 0 {
+		b := bouncer.New(c, bouncer.Config{Password: config.IRC.BouncerPass})
+		go func() {
+			if err := b.ListenAndServe(config.IRC.BouncerListen); err != nil {
+				fmt.Printf("Bouncer failed: %s\n", err.Error())
+			}
+		}()
+	}
+
+	// everything said goes through these, to be counted
+	say := func(target string, line string) {
+		monitor.Sent("PRIVMSG")
+		c.WriteMessage(target, line)
+	}
+	notice := func(target string, line string) {
+		monitor.Sent("NOTICE")
+		c.WriteNotice(target, line)
+	}
+
+	co := newCoalescer(say)
+
+	// all of these must be set before the HTTP listener starts reading them.
+	monitor.queueDepth = co.Pending
+	monitor.ircRegistered = c.Registered
+	monitor.ircNick = c.CurrentNick
+	monitor.ircChannels = func() []string {
+		var names []string
+		for _, channel := range c.Channels() {
+			names = append(names, channel.Name)
+		}
+		return names
 	}
+	if len(config.HTTP.Listen) > 0 {
+		go func() {
+			if err := http.ListenAndServe(config.HTTP.Listen, monitor.Handler()); err != nil {
+				fmt.Printf("HTTP listener failed: %s\n", err.Error())
+			}
+		}()
+	}
+
+	gerritConfig, err := gerritClientConfig(config.Gerrit)
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		os.Exit(1)
+	}
+	gc := gerrit.NewClient(gerritConfig)
 
-	nsUser := os.Getenv("NICKSERV_USER")
-	if len(nsUser) == 0 {
-		panic("Must provide environment variable NICKSERV_USER")
+	reload := func() error {
+		old := getConfig()
+		if err := reloadConfig(c, *configPath); err != nil {
+			return err
+		}
+		co.reconfigured(old, getConfig())
+		return nil
 	}
 
-	nsPass := os.Getenv("NICKSERV_PASS")
-	if len(nsPass) == 0 {
-		panic("Must provide environment variable NICKSERV_PASS")
+	admin := &adminCommands{
+		getConfig: getConfig,
+		setConfig: setConfig,
+		join:      c.Join,
+		part:      c.Part,
+		reload:    reload,
+		reconnect: gc.Reconnect,
+		mutes:     mutes,
+		monitor:   monitor,
+		now:       time.Now,
 	}
 
-	c := client.NewClient("qt_gerrit", "qt_gerrit", "Qt IRC Bot", nsUser, nsPass)
+	lookups := newLookupService()
+	refLookups = lookups
+
+	watches, err := loadWatchList(config.Watch.File)
+	if err != nil {
+		fmt.Printf("Failed to load watches: %s\n", err.Error())
+		os.Exit(1)
+	}
+	// to know who's who, and who's online, for watches
+	c.RequestCap("account-tag")
+	c.RequestCap("account-notify")
+	c.RequestCap("extended-join")
+	watches.online = c.AccountNick
 
 	c.AddCallback(client.OnMessage, func(c *client.IrcClient, command *parser.IrcMessage) {
+		config := getConfig()
+
 		directRegex := regexp.MustCompile(`^([^ ]+[,:] )`)
 		directTo := directRegex.FindString(command.Parameters[1]) // was this directed at someone?
 		if len(directTo) == 0 {
 			directTo = command.Prefix.Nick + ": " // if not, default to sender of the message
 		}
 
-		// Jira
-		br := regexp.MustCompile(`\b(Q[A-Z]+-[0-9]+)\b`)
-		bugs := br.FindAllString(command.Parameters[1], -1)
-
-		jiraChan := make(chan string)
-		go handleJiraWebApi(jiraChan, directTo, bugs)
-		go messageDrainer(c, command.Parameters[0], jiraChan)
-
-		// Gerrit
-		cr := regexp.MustCompile(`(I[0-9a-f]{40})`)
-		changes := cr.FindAllString(command.Parameters[1], -1)
-
-		cr2 := regexp.MustCompile(`https:\/\/codereview\.qt\-project\.org\/(?:\#\/c\/)?([0-9]+|[0-9]+)\/?`)
-		changes2 := cr2.FindAllStringSubmatch(command.Parameters[1], -1)
-
-		for _, change := range changes2 {
-			changes = append(changes, change[1])
+		account := command.Account()
+		// admin commands are only taken privately
+		if strings.EqualFold(command.Parameters[0], c.CurrentNick()) {
+			if replies, ok := admin.Command(command.Prefix, account, command.Parameters[1]); ok {
+				for _, reply := range replies {
+					notice(command.Prefix.Nick, reply)
+				}
+				return
+			}
+		}
+		if replies, ok := watches.Command(config, account, command.Parameters[1]); ok {
+			for _, reply := range replies {
+				notice(command.Prefix.Nick, reply)
+			}
+			return
 		}
 
-		gerritChan := make(chan string)
-		go handleGerritWebApi(gerritChan, directTo, changes)
-		go messageDrainer(c, command.Parameters[0], gerritChan)
+		if match := jiraSearchRegex.FindStringSubmatch(command.Parameters[1]); match != nil {
+			resultsChan := make(chan string)
+			go runJiraSearch(lookups, config, resultsChan, directTo, match[1])
+			go messageDrainer(say, command.Parameters[0], resultsChan)
+			return
+		}
 
-		// Github
-		commitre := regexp.MustCompile(`(` + strings.Join(validBareRepoNames, "|") + `)\/([0-9a-f]+)`)
-		commitz := commitre.FindAllStringSubmatch(command.Parameters[1], -1)
+		var refs []reference
+		for _, ref := range config.findReferences(command.Parameters[0], command.Parameters[1]) {
+			if lookups.ShouldExpand(command.Parameters[0], ref.expander+" "+ref.match[0]) {
+				refs = append(refs, ref)
+			}
+		}
 
-		ghChan := make(chan string)
-		go handleGithubWebApi(ghChan, directTo, commitz)
-		go messageDrainer(c, command.Parameters[0], ghChan)
+		if len(refs) > 0 {
+			resultsChan := make(chan string)
+			go runExpansions(lookups, config, resultsChan, directTo, refs)
+			go messageDrainer(say, command.Parameters[0], resultsChan)
+		}
 	})
 
-	ircServer := os.Getenv("IRC_SERVER")
-	if len(ircServer) == 0 {
-		panic("Must provide environment variable IRC_SERVER")
-	}
+	jiraWatcher := newJiraWatcher(lookups)
+	go func() {
+		for {
+			jiraWatcher.Poll(getConfig(), say)
+			time.Sleep(30 * time.Second)
+		}
+	}()
+
+	reporter := newReporter(lookups)
+	go func() {
+		for {
+			reporter.Tick(getConfig(), say)
+			time.Sleep(20 * time.Second)
+		}
+	}()
 
 	c.AddCallback(client.OnConnected, func(c *client.IrcClient, command *parser.IrcMessage) {
-		fmt.Printf("Connected to IRC\n", command)
+		fmt.Printf("Connected to IRC\n")
+		monitor.Connected("irc")
 	})
 
-	ircChannels := os.Getenv("IRC_CHANNELS")
-	if len(ircChannels) == 0 {
-		panic("Must provide environment variable IRC_CHANNELS")
+	for _, channel := range config.IRC.Channels {
+		c.Join(channel)
 	}
+	go c.Run(config.IRC.Server)
 
-	c.Join(ircChannels)
-	go c.Run(ircServer)
+	go gc.Run(context.Background())
 
-	gc := NewClient()
-	go gc.Run()
+	// Gerrit events are dispatched in order, apart from IRC, as describing
+	// some of them (e.g. ref updates) means looking things up.
+	go func() {
+		for event := range gc.Events() {
+			msg, err := newGerritMessage(event)
+			if err != nil {
+				fmt.Printf("Failed to flatten Gerrit event: %s\n", err.Error())
+				continue
+			}
+			monitor.EventReceived(msg.Type)
+			time.Sleep(1 * time.Second) // poor man's throttling so Gerrit doesn't flood us off
+			dispatchGerritEvent(co, watches, notice, getConfig(), msg)
+			fmt.Printf("Gerrit: Message: %s\n", msg.OriginalJson)
+		}
+	}()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
 	for {
 		select {
+		case <-hup:
+			reload()
+		case <-quit:
+			shutdown(co, c.WriteLine)
+			os.Exit(0)
 		case command := <-c.CommandChannel:
 			c.ProcessCallbacks(command)
-		case msg := <-gc.DiagnosticsChannel:
-			str := fmt.Sprintf("[DIAGNOSTICS] %s", msg)
-			c.WriteMessage(gerritChannel, str)
-		case msg := <-gc.MessageChannel:
-			time.Sleep(1 * time.Second) // poor man's throttling so Gerrit doesn't flood us off
-			if msg.Type == "comment-added" {
-				handleCommentAdded(c, msg)
-			} else if msg.Type == "patchset-created" {
-				handlePatchSetCreated(c, msg)
-			} else if msg.Type == "change-merged" {
-				handleChangeMerged(c, msg)
-			} else if msg.Type == "merge-failed" {
-				handleMergeFailed(c, msg)
-			} else if msg.Type == "reviewer-added" {
-				// ignore, too spammy
-			} else if msg.Type == "ref-updated" {
-				refUpdateChan := make(chan string)
-				go handleRefUpdate(refUpdateChan, msg)
-				go messageDrainer(c, gerritChannel, refUpdateChan)
-			} else if msg.Type == "change-abandoned" {
-				handleChangeAbandoned(c, msg)
-			} else if msg.Type == "change-deferred" {
-				handleChangeDeferred(c, msg)
+		case diag := <-gc.Diagnostics():
+			monitor.GerritDiagnostic(diag)
+			if diag.Kind == gerrit.BadEvent {
+				fmt.Printf("BAD JSON: %s\n", diag.Raw)
+			}
+			str := fmt.Sprintf("[DIAGNOSTICS] %s", describeDiagnostic(diag))
+			fmt.Printf("Gerrit: %s\n", str)
+			if channel := getConfig().Admin.Channel; len(channel) > 0 {
+				say(channel, str)
 			}
-			println(fmt.Sprintf("Gerrit: Message: %s\n", msg.OriginalJson))
 		}
 	}
 }
@@ -0,0 +1,175 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// A commit that a ref update added.
+type RefCommit struct {
+	Sha     string
+	Subject string
+	Author  string
+}
+
+// RefChange describes what a ref-updated event changed, for its template.
+type RefChange struct {
+	// created, deleted, updated, or forced (for a non-fast-forward update,
+	// which is only noticed if the commits were looked up).
+	Kind string
+
+	// The ref as Gerrit gave it (e.g. refs/heads/dev), and its name without
+	// refs/heads/ or refs/tags/ (e.g. dev).
+	RefName string
+	Name    string
+	Tag     bool
+
+	OldRev string
+	NewRev string
+
+	// If the commits were looked up, how many the update added and dropped,
+	// and the first few it added, oldest first.
+	LookedUp bool
+	Count    int
+	Dropped  int
+	Commits  []RefCommit
+
+	// Where to see what changed on cgit, if it's configured and there's
+	// something to see.
+	Url string
+}
+
+// Whether rev is Gerrit's way of saying there's no commit, i.e. all zeroes.
+func isZeroRev(rev string) bool {
+	return len(rev) > 0 && len(strings.Trim(rev, "0")) == 0
+}
+
+func pluralize(count int, singular string) string {
+	if count == 1 {
+		return fmt.Sprintf("1 %s", singular)
+	}
+	return fmt.Sprintf("%d %ss", count, singular)
+}
+
+// Summary describes the change, e.g. "created tag v6.7.0 at 3ea073ad".
+func (this RefChange) Summary() string {
+	kind := "branch"
+	if this.Tag {
+		kind = "tag"
+	} else if !strings.HasPrefix(this.RefName, "refs/heads/") && strings.HasPrefix(this.RefName, "refs/") {
+		kind = "ref"
+	}
+
+	var commits []string
+	for _, commit := range this.Commits {
+		commits = append(commits, fmt.Sprintf("%s (%s)", firstLine(commit.Subject), commit.Author))
+	}
+	if this.Count > len(this.Commits) && len(this.Commits) > 0 {
+		commits = append(commits, fmt.Sprintf("and %d more", this.Count-len(this.Commits)))
+	}
+	list := strings.Join(commits, "; ")
+
+	switch this.Kind {
+	case "created":
+		return fmt.Sprintf("created %s %s at %s", kind, this.Name, this.NewRev)
+	case "deleted":
+		return fmt.Sprintf("deleted %s %s, which was at %s", kind, this.Name, this.OldRev)
+	case "forced":
+		if this.Count == 0 {
+			return fmt.Sprintf("force-pushed %s back by %s", this.RefName, pluralize(this.Dropped, "commit"))
+		}
+		return fmt.Sprintf("force-pushed %s, dropping %s and adding %d: %s", this.RefName, pluralize(this.Dropped, "commit"), this.Count, list)
+	}
+	if this.LookedUp && len(list) > 0 {
+		return fmt.Sprintf("updated %s with %s: %s", this.RefName, pluralize(this.Count, "commit"), list)
+	}
+	return fmt.Sprintf("updated %s from %s to %s", this.RefName, this.OldRev, this.NewRev)
+}
+
+// Work out what update changed: from the event, and, if lookups isn't nil
+// and gerrit.ref_commits is set, by asking Github (which mirrors Gerrit's
+// projects under the same names) to compare the old and new commits.
+func describeRefUpdate(lookups *lookupService, config *Config, update GerritRefUpdate) RefChange {
+	change := RefChange{
+		Kind:    "updated",
+		RefName: update.RefName,
+		Name:    strings.TrimPrefix(strings.TrimPrefix(update.RefName, "refs/heads/"), "refs/tags/"),
+		Tag:     strings.HasPrefix(update.RefName, "refs/tags/"),
+		OldRev:  update.OldRev,
+		NewRev:  update.NewRev,
+	}
+	if isZeroRev(update.OldRev) {
+		change.Kind = "created"
+	} else if isZeroRev(update.NewRev) {
+		change.Kind = "deleted"
+	}
+
+	cgitUrl := strings.TrimSuffix(config.Cgit.Url, "/")
+	if len(cgitUrl) > 0 {
+		repoUrl := cgitUrl + "/" + update.Project + ".git"
+		switch {
+		case change.Kind == "created" && change.Tag:
+			change.Url = repoUrl + "/tag/?h=" + change.Name
+		case change.Kind == "created":
+			change.Url = repoUrl + "/log/?h=" + change.Name
+		case change.Kind == "updated":
+			change.Url = repoUrl + "/log/?qt=range&q=" + update.OldRev + "..." + update.NewRev
+		}
+	}
+
+	if change.Kind != "updated" || change.Tag || lookups == nil || config.Gerrit.RefCommits == 0 {
+		return change
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Lookup.Deadline)
+	defer cancel()
+	var compare GithubCompareResponse
+	err := newGithubClient(config.Github, lookups).get(ctx, "/repos/"+update.Project+"/compare/"+update.OldRev+"..."+update.NewRev, &compare)
+	if err != nil {
+		fmt.Printf("Failed to look up what %s in %s changed: %s\n", update.RefName, update.Project, err.Error())
+		return change
+	}
+
+	change.LookedUp = true
+	change.Count = compare.AheadBy
+	change.Dropped = compare.BehindBy
+	if compare.BehindBy > 0 {
+		change.Kind = "forced"
+	}
+	for idx, commit := range compare.Commits {
+		if idx == config.Gerrit.RefCommits {
+			break
+		}
+		change.Commits = append(change.Commits, RefCommit{
+			Sha:     commit.Sha,
+			Subject: commit.Commit.Summary(),
+			Author:  commit.Commit.Author.Name,
+		})
+	}
+	return change
+}
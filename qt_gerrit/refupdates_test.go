@@ -0,0 +1,147 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDescribeRefUpdate(t *testing.T) {
+	github := startFakeGithub(t)
+	defer github.Close()
+	config := testConfig(github.URL)
+	config.Cgit.Url = "https://code.qt.io/cgit"
+	config.Gerrit.RefCommits = 1
+	lookups, _ := newTestLookupService(config)
+	zero := "0000000000000000000000000000000000000000"
+
+	tests := []struct {
+		Update   GerritRefUpdate
+		Lookups  *lookupService
+		Expected string
+	}{
+		{
+			GerritRefUpdate{Project: "qt/qt5", RefName: "refs/heads/5.6", OldRev: "c253d71a", NewRev: "3ea073ad"},
+			nil,
+			"updated refs/heads/5.6 from c253d71a to 3ea073ad - https://code.qt.io/cgit/qt/qt5.git/log/?qt=range&q=c253d71a...3ea073ad",
+		},
+		{
+			GerritRefUpdate{Project: "qt/qt5", RefName: "refs/heads/5.6", OldRev: "c253d71a", NewRev: "3ea073ad"},
+			lookups,
+			"updated refs/heads/5.6 with 2 commits: Update submodules (Alice); and 1 more - https://code.qt.io/cgit/qt/qt5.git/log/?qt=range&q=c253d71a...3ea073ad",
+		},
+		{
+			GerritRefUpdate{Project: "qt/qt5", RefName: "refs/heads/wip/x", OldRev: "c253d71a", NewRev: "7654321a"},
+			lookups,
+			"force-pushed refs/heads/wip/x, dropping 2 commits and adding 1: Redo it (Alice) - https://code.qt.io/cgit/qt/qt5.git/log/?qt=range&q=c253d71a...7654321a",
+		},
+		{
+			GerritRefUpdate{Project: "qt/qt5", RefName: "refs/heads/wip/x", OldRev: "c253d71a", NewRev: "0badf00d"},
+			lookups,
+			"force-pushed refs/heads/wip/x back by 3 commits - https://code.qt.io/cgit/qt/qt5.git/log/?qt=range&q=c253d71a...0badf00d",
+		},
+		{
+			// Github doesn't have it; say what's known
+			GerritRefUpdate{Project: "qt/qt5", RefName: "refs/heads/5.6", OldRev: "c253d71a", NewRev: "ffffffff"},
+			lookups,
+			"updated refs/heads/5.6 from c253d71a to ffffffff - https://code.qt.io/cgit/qt/qt5.git/log/?qt=range&q=c253d71a...ffffffff",
+		},
+		{
+			GerritRefUpdate{Project: "qt/qtbase", RefName: "refs/tags/v6.7.0", OldRev: zero, NewRev: "3ea073ad3ea073ad3ea073ad3ea073ad3ea073ad"},
+			lookups,
+			"created tag v6.7.0 at 3ea073ad3ea073ad3ea073ad3ea073ad3ea073ad - https://code.qt.io/cgit/qt/qtbase.git/tag/?h=v6.7.0",
+		},
+		{
+			GerritRefUpdate{Project: "qt/qtbase", RefName: "refs/heads/6.8", OldRev: zero, NewRev: "3ea073ad"},
+			lookups,
+			"created branch 6.8 at 3ea073ad - https://code.qt.io/cgit/qt/qtbase.git/log/?h=6.8",
+		},
+		{
+			GerritRefUpdate{Project: "qt/qtbase", RefName: "refs/heads/wip/x", OldRev: "c253d71a", NewRev: zero},
+			lookups,
+			"deleted branch wip/x, which was at c253d71a",
+		},
+		{
+			GerritRefUpdate{Project: "qt/qtbase", RefName: "refs/meta/config", OldRev: zero, NewRev: "3ea073ad"},
+			nil,
+			"created ref refs/meta/config at 3ea073ad - https://code.qt.io/cgit/qt/qtbase.git/log/?h=refs/meta/config",
+		},
+	}
+
+	for _, test := range tests {
+		change := describeRefUpdate(test.Lookups, config, test.Update)
+		got := change.Summary()
+		if len(change.Url) > 0 {
+			got += " - " + change.Url
+		}
+		if got != test.Expected {
+			t.Errorf("Expected: %#v, got %#v", test.Expected, got)
+		}
+	}
+
+	// creating and deleting refs, and tags, aren't looked up
+	if len(github.Requests()) != 4 {
+		t.Errorf("Expected 4 requests, got %#v", github.Requests())
+	}
+}
+
+func TestRefUpdatesInOrder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	config := testConfig(server.URL)
+	config.Gerrit.Channel = "#qt-gerrit"
+	config.Gerrit.Projects = []string{"qt/qt5", "qt/qtbase"}
+	config.Gerrit.RefCommits = 1
+	oldConfig, oldLookups := getConfig(), refLookups
+	setConfig(config)
+	refLookups, _ = newTestLookupService(config)
+	defer func() {
+		setConfig(oldConfig)
+		refLookups = oldLookups
+	}()
+
+	var announced []string
+	co := newCoalescer(func(channel string, line string) {
+		announced = append(announced, line)
+	})
+	co.config = func() *Config { return config }
+
+	// the ref update is announced before what came after it, even though
+	// Github takes a while to answer about it
+	dispatchGerritEvent(co, nil, nil, config, testEvent(t, `{"type": "ref-updated", "submitter": {"name": "Qt CI Bot"}, "refUpdate": {"oldRev": "c253d71a", "newRev": "3ea073ad", "refName": "refs/heads/5.6", "project": "qt/qt5"}}`))
+	dispatchGerritEvent(co, nil, nil, config, testEvent(t, `{"type": "change-merged", "submitter": {"name": "Bob"}, "patchSet": {"number": "2", "uploader": {"name": "Alice"}}, `+testChange+`}`))
+
+	if len(announced) != 2 || !strings.HasPrefix(announced[0], "[qt/qt5] Qt CI Bot updated refs/heads/5.6 from c253d71a to 3ea073ad") {
+		t.Errorf("Expected the ref update first, got %#v", announced)
+	}
+}
@@ -0,0 +1,266 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/rburchell/gobo/lib/gerrit"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReportConfig is a report posted to a channel on a schedule, listing the
+// changes in some state.
+type ReportConfig struct {
+	Channel string `toml:"channel"`
+
+	// When to post it, as in a crontab, e.g. "0 9 * * 1-5" for 9:00 on
+	// weekdays (in the bot's timezone).
+	Schedule string `toml:"schedule"`
+	schedule *cronSchedule
+
+	// What to report: waiting (changes waiting for review for more than
+	// Days), vetoed (changes with a -2 no one touched for more than Days),
+	// staging-failures (changes that failed to integrate in the last Days),
+	// or query (whatever Query finds).
+	Kind string `toml:"kind"`
+
+	// Narrows down the changes reported (e.g. project:qt/qtbase), or for
+	// query reports, is the whole query.
+	Query string `toml:"query"`
+
+	Days int `toml:"days"`
+
+	// How many changes are listed, at most.
+	Limit int `toml:"limit"`
+
+	// What to call the changes found, replacing e.g. "waiting for review
+	// for more than 3 days".
+	Title string `toml:"title"`
+}
+
+// A kind of report: how to find its changes, and how to describe them, for
+// a number of days.
+type reportKind struct {
+	query    func(days int) string
+	describe func(days int) string
+	days     int // unless configured otherwise
+}
+
+func pluralDays(days int) string {
+	if days == 1 {
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", days)
+}
+
+var reportKinds = map[string]reportKind{
+	"waiting": {
+		query: func(days int) string {
+			return fmt.Sprintf("status:open -is:wip -is:private age:%dd -label:Code-Review=-2 -label:Code-Review=2", days)
+		},
+		describe: func(days int) string {
+			return "waiting for review for more than " + pluralDays(days)
+		},
+		days: 3,
+	},
+	"vetoed": {
+		query: func(days int) string {
+			return fmt.Sprintf("status:open -is:private label:Code-Review=-2 age:%dd", days)
+		},
+		describe: func(days int) string {
+			return "with a -2, untouched for more than " + pluralDays(days)
+		},
+		days: 14,
+	},
+	"staging-failures": {
+		query: func(days int) string {
+			return fmt.Sprintf(`-is:private comment:"Continuous Integration: Failed" -age:%dd`, days)
+		},
+		describe: func(days int) string {
+			return "that failed to integrate in the last " + pluralDays(days)
+		},
+		days: 1,
+	},
+	"query": {
+		query:    func(days int) string { return "" },
+		describe: func(days int) string { return "found" },
+	},
+}
+
+func (this *ReportConfig) validate(name string, config *Config) []error {
+	var errs []error
+	if len(this.Channel) == 0 {
+		errs = append(errs, fmt.Errorf("%s.channel: must be set", name))
+	} else if !config.IRC.HasChannel(this.Channel) {
+		errs = append(errs, fmt.Errorf("%s.channel: %s is not in irc.channels", name, this.Channel))
+	}
+
+	var err error
+	if this.schedule, err = parseCron(this.Schedule); err != nil {
+		errs = append(errs, fmt.Errorf("%s.schedule: %s", name, err.Error()))
+	}
+
+	kind, ok := reportKinds[this.Kind]
+	if !ok {
+		errs = append(errs, fmt.Errorf("%s.kind: %q is not one of query, staging-failures, vetoed or waiting", name, this.Kind))
+	}
+	if this.Kind == "query" && len(strings.TrimSpace(this.Query)) == 0 {
+		errs = append(errs, fmt.Errorf("%s.query: must be set for query reports", name))
+	}
+	if this.Days == 0 {
+		this.Days = kind.days
+	} else if this.Days < 0 {
+		errs = append(errs, fmt.Errorf("%s.days: must not be negative", name))
+	}
+	if this.Limit == 0 {
+		this.Limit = 5
+	} else if this.Limit < 0 {
+		errs = append(errs, fmt.Errorf("%s.limit: must not be negative", name))
+	}
+	return errs
+}
+
+// The Gerrit query finding the changes to report.
+func (this *ReportConfig) GerritQuery() string {
+	query := reportKinds[this.Kind].query(this.Days)
+	if len(this.Query) > 0 {
+		if len(query) > 0 {
+			query += " "
+		}
+		query += this.Query
+	}
+	return query
+}
+
+// The most changes counted for a report.
+const reportMaxChanges = 100
+
+// Describe a duration roughly, e.g. "5 days".
+func describeAge(d time.Duration) string {
+	switch {
+	case d < 2*time.Hour:
+		return fmt.Sprintf("%d minutes", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	}
+	return fmt.Sprintf("%d days", int(d.Hours()/24))
+}
+
+// Run a report, returning the lines to post: nothing if no changes were
+// found.
+func runReport(ctx context.Context, lookups *lookupService, config *Config, report *ReportConfig, now time.Time) ([]string, error) {
+	query := report.GerritQuery()
+	changes, err := newGerritRestClient(config.Gerrit, lookups.client).QueryChanges(ctx, query, reportMaxChanges)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	gerritUrl := strings.TrimSuffix(config.Gerrit.Url, "/")
+	title := report.Title
+	if len(title) == 0 {
+		title = reportKinds[report.Kind].describe(report.Days)
+	}
+	count := fmt.Sprintf("%d changes", len(changes))
+	if len(changes) == 1 {
+		count = "1 change"
+	} else if len(changes) == reportMaxChanges {
+		count = fmt.Sprintf("%d+ changes", len(changes))
+	}
+
+	lines := []string{fmt.Sprintf("%s %s:", count, title)}
+	for idx, change := range changes {
+		if idx == report.Limit {
+			lines = append(lines, fmt.Sprintf("...and %d more - %s/q/%s", len(changes)-idx, gerritUrl, url.PathEscape(query)))
+			break
+		}
+		lines = append(lines, describeReportedChange(gerritUrl, change, now))
+	}
+	return lines, nil
+}
+
+func describeReportedChange(gerritUrl string, change *gerrit.ChangeInfo, now time.Time) string {
+	return fmt.Sprintf("[%s/%s] %s from %s - %s/%d (updated %s ago)",
+		change.Project, change.Branch, change.Subject, change.Owner.Name,
+		gerritUrl, change.Number, describeAge(now.Sub(change.Updated.Time)))
+}
+
+// A reporter runs the configured reports when they're due.
+type reporter struct {
+	lookups *lookupService
+	now     func() time.Time
+	last    time.Time // the last minute reports were run for
+}
+
+func newReporter(lookups *lookupService) *reporter {
+	return &reporter{lookups: lookups, now: time.Now}
+}
+
+// Run the reports due this minute, unless that was already done, passing
+// the lines to post to write. Call this more than once a minute.
+func (this *reporter) Tick(config *Config, write func(channel string, line string)) {
+	minute := this.now().Truncate(time.Minute)
+	if !minute.After(this.last) {
+		return
+	}
+	this.last = minute
+
+	// run them all at once, so a slow one doesn't hold up the rest, but post
+	// them in order, so they don't end up interleaved
+	results := make([][]string, len(config.Reports))
+	var wg sync.WaitGroup
+	for idx := range config.Reports {
+		report := &config.Reports[idx]
+		if report.schedule == nil || !report.schedule.Matches(minute) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(idx int, report *ReportConfig) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), config.Lookup.Deadline)
+			defer cancel()
+			lines, err := runReport(ctx, this.lookups, config, report, this.now())
+			if err != nil {
+				fmt.Printf("Failed to run report[%d] (%s): %s\n", idx, report.GerritQuery(), err.Error())
+				return
+			}
+			results[idx] = lines
+		}(idx, report)
+	}
+	wg.Wait()
+
+	for idx, lines := range results {
+		for _, line := range lines {
+			write(config.Reports[idx].Channel, line)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A stand-in for Gerrit's change queries, which finds count changes for
+// anything (taking delay to do it), and remembers what was asked.
+type fakeGerritQueries struct {
+	*httptest.Server
+	mutex   sync.Mutex
+	count   int
+	delay   time.Duration
+	queries []string
+}
+
+func startFakeGerritQueries(count int) *fakeGerritQueries {
+	gerrit := &fakeGerritQueries{count: count}
+	gerrit.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gerrit.mutex.Lock()
+		delay := gerrit.delay
+		gerrit.mutex.Unlock()
+		time.Sleep(delay)
+
+		gerrit.mutex.Lock()
+		defer gerrit.mutex.Unlock()
+		if r.URL.Path != "/changes/" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		gerrit.queries = append(gerrit.queries, r.URL.Query().Get("q")+" n="+r.URL.Query().Get("n"))
+
+		var changes []string
+		for idx := 0; idx < gerrit.count; idx++ {
+			changes = append(changes, fmt.Sprintf(`{"project": "qt/qtbase", "branch": "dev", "subject": "Fix %d", "_number": %d, "owner": {"name": "Alice"}, "updated": "2023-11-%02d 20:00:00.000000000"}`, idx+1, 1000+idx, 10-idx))
+		}
+		fmt.Fprintf(w, ")]}'\n[%s]", strings.Join(changes, ","))
+	}))
+	return gerrit
+}
+
+func (this *fakeGerritQueries) Queries() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return append([]string(nil), this.queries...)
+}
+
+func TestReportConfig(t *testing.T) {
+	config := defaultConfig()
+	config.IRC.Channels = []string{"#qt"}
+	config.Reports = []ReportConfig{
+		{Channel: "#qt", Schedule: "0 9 * * 1-5", Kind: "waiting", Query: "project:qt/qtbase"},
+		{Channel: "#elsewhere", Schedule: "0 9 * *", Kind: "late", Limit: -1},
+		{Channel: "#qt", Schedule: "0 9 * * *", Kind: "query", Days: -1},
+	}
+
+	expected := []string{
+		`report[1].channel: #elsewhere is not in irc.channels`,
+		`report[1].schedule: "0 9 * *" should have 5 fields (minute hour day month weekday)`,
+		`report[1].kind: "late" is not one of query, staging-failures, vetoed or waiting`,
+		`report[1].limit: must not be negative`,
+		`report[2].query: must be set for query reports`,
+		`report[2].days: must not be negative`,
+	}
+	var got []string
+	for _, err := range config.validate() {
+		if strings.HasPrefix(err.Error(), "report[") {
+			got = append(got, err.Error())
+		}
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected: %#v, got %#v", expected, got)
+	}
+
+	report := config.Reports[0]
+	if report.Days != 3 || report.Limit != 5 {
+		t.Errorf("Expected the defaults for a waiting report, got %#v", report)
+	}
+	query := "status:open -is:wip -is:private age:3d -label:Code-Review=-2 -label:Code-Review=2 project:qt/qtbase"
+	if got := report.GerritQuery(); got != query {
+		t.Errorf("Expected: %#v, got %#v", query, got)
+	}
+}
+
+func TestReportQueries(t *testing.T) {
+	tests := []struct {
+		Report   ReportConfig
+		Expected string
+	}{
+		{ReportConfig{Kind: "vetoed"}, "status:open -is:private label:Code-Review=-2 age:14d"},
+		{ReportConfig{Kind: "vetoed", Days: 30, Query: "branch:dev"}, "status:open -is:private label:Code-Review=-2 age:30d branch:dev"},
+		{ReportConfig{Kind: "staging-failures"}, `-is:private comment:"Continuous Integration: Failed" -age:1d`},
+		{ReportConfig{Kind: "query", Query: "is:open owner:alice"}, "is:open owner:alice"},
+	}
+	for _, test := range tests {
+		config := testConfig("https://example.org")
+		test.Report.Channel = "#qt"
+		test.Report.Schedule = "* * * * *"
+		if errs := test.Report.validate("report", config); len(errs) > 0 {
+			t.Errorf("Unexpected errors: %#v", errs)
+		}
+		if got := test.Report.GerritQuery(); got != test.Expected {
+			t.Errorf("Expected: %#v, got %#v", test.Expected, got)
+		}
+	}
+}
+
+func TestReporter(t *testing.T) {
+	gerrit := startFakeGerritQueries(3)
+	defer gerrit.Close()
+	config := testConfig(gerrit.URL)
+	config.Reports = []ReportConfig{
+		{Channel: "#qt", Schedule: "0 9 * * 1-5", Kind: "waiting", Limit: 2},
+		{Channel: "#qt-labs", Schedule: "30 9 * * *", Kind: "staging-failures", Title: "that failed to integrate today"},
+	}
+	for idx := range config.Reports {
+		if errs := config.Reports[idx].validate("report", config); len(errs) > 0 {
+			t.Fatalf("Unexpected errors: %#v", errs)
+		}
+	}
+	lookups, _ := newTestLookupService(config)
+	reporter := newReporter(lookups)
+	// Tuesday, 2023-11-14
+	clock := &testClock{now: time.Date(2023, 11, 14, 8, 59, 40, 0, time.UTC)}
+	reporter.now = clock.Now
+
+	var lines []string
+	tick := func() {
+		reporter.Tick(config, func(channel string, line string) {
+			lines = append(lines, channel+" "+line)
+		})
+	}
+
+	tick()
+	if len(lines) != 0 || len(gerrit.Queries()) != 0 {
+		t.Errorf("Expected nothing before 9:00, got %#v %#v", lines, gerrit.Queries())
+	}
+
+	clock.Advance(20 * time.Second)
+	tick()
+	clock.Advance(20 * time.Second)
+	tick()
+	expected := []string{
+		"#qt 3 changes waiting for review for more than 3 days:",
+		"#qt [qt/qtbase/dev] Fix 1 from Alice - " + gerrit.URL + "/1000 (updated 3 days ago)",
+		"#qt [qt/qtbase/dev] Fix 2 from Alice - " + gerrit.URL + "/1001 (updated 4 days ago)",
+		"#qt ...and 1 more - " + gerrit.URL + "/q/status:open%20-is:wip%20-is:private%20age:3d%20-label:Code-Review=-2%20-label:Code-Review=2",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected: %#v, got %#v", expected, lines)
+	}
+	if got := len(gerrit.Queries()); got != 1 {
+		t.Errorf("Expected one query in the minute, got %d", got)
+	}
+
+	lines = nil
+	gerrit.mutex.Lock()
+	gerrit.count = 1
+	gerrit.mutex.Unlock()
+	clock.Advance(30 * time.Minute)
+	tick()
+	expected = []string{
+		"#qt-labs 1 change that failed to integrate today:",
+		"#qt-labs [qt/qtbase/dev] Fix 1 from Alice - " + gerrit.URL + "/1000 (updated 3 days ago)",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected: %#v, got %#v", expected, lines)
+	}
+
+	// nothing's posted when nothing's found
+	lines = nil
+	gerrit.mutex.Lock()
+	gerrit.count = 0
+	gerrit.mutex.Unlock()
+	clock.Advance(24 * time.Hour)
+	tick()
+	if len(lines) != 0 {
+		t.Errorf("Expected nothing posted, got %#v", lines)
+	}
+
+	queries := []string{
+		"status:open -is:wip -is:private age:3d -label:Code-Review=-2 -label:Code-Review=2 n=100",
+		`-is:private comment:"Continuous Integration: Failed" -age:1d n=100`,
+		`-is:private comment:"Continuous Integration: Failed" -age:1d n=100`,
+	}
+	if got := gerrit.Queries(); strings.Join(got, "\n") != strings.Join(queries, "\n") {
+		t.Errorf("Expected: %#v, got %#v", queries, got)
+	}
+}
+
+func TestReportsTogether(t *testing.T) {
+	gerrit := startFakeGerritQueries(1)
+	defer gerrit.Close()
+	gerrit.delay = 200 * time.Millisecond
+	config := testConfig(gerrit.URL)
+	config.Reports = []ReportConfig{
+		{Channel: "#qt", Schedule: "0 9 * * *", Kind: "waiting", Days: 1},
+		{Channel: "#qt-labs", Schedule: "0 9 * * *", Kind: "staging-failures"},
+	}
+	for idx := range config.Reports {
+		if errs := config.Reports[idx].validate("report", config); len(errs) > 0 {
+			t.Fatalf("Unexpected errors: %#v", errs)
+		}
+	}
+	lookups, _ := newTestLookupService(config)
+	reporter := newReporter(lookups)
+	reporter.now = (&testClock{now: time.Date(2023, 11, 14, 9, 0, 0, 0, time.UTC)}).Now
+
+	var lines []string
+	start := time.Now()
+	reporter.Tick(config, func(channel string, line string) {
+		lines = append(lines, channel+" "+line)
+	})
+	if elapsed := time.Since(start); elapsed >= 2*gerrit.delay {
+		t.Errorf("Expected the reports to run at once, took %s", elapsed)
+	}
+
+	// still posted in order
+	expected := []string{
+		"#qt 1 change waiting for review for more than 1 day:",
+		"#qt [qt/qtbase/dev] Fix 1 from Alice - " + gerrit.URL + "/1000 (updated 3 days ago)",
+		"#qt-labs 1 change that failed to integrate in the last 1 day:",
+		"#qt-labs [qt/qtbase/dev] Fix 1 from Alice - " + gerrit.URL + "/1000 (updated 3 days ago)",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected: %#v, got %#v", expected, lines)
+	}
+}
@@ -0,0 +1,151 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// A RouteConfig sends the Gerrit events matching all of its patterns to a
+// channel. An empty pattern list matches anything.
+//
+// Patterns are globs (where * and ? match any run of characters, or any
+// single character), unless they're written as /regex/.
+type RouteConfig struct {
+	Channel       string   `toml:"channel"`
+	Projects      []string `toml:"projects"`
+	Branches      []string `toml:"branches"`
+	Events        []string `toml:"events"`
+	ExcludeEvents []string `toml:"exclude_events"`
+
+	projects      patternList
+	branches      patternList
+	events        patternList
+	excludeEvents patternList
+}
+
+type patternList []*regexp.Regexp
+
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		return regexp.Compile(pattern[1 : len(pattern)-1])
+	}
+
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.Replace(expr, `\*`, `.*`, -1)
+	expr = strings.Replace(expr, `\?`, `.`, -1)
+	return regexp.Compile("^" + expr + "$")
+}
+
+func compilePatterns(patterns []string) (patternList, error) {
+	list := make(patternList, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := compilePattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %s", pattern, err.Error())
+		}
+		list = append(list, re)
+	}
+	return list, nil
+}
+
+func (this patternList) matchAny(str string) bool {
+	for _, re := range this {
+		if re.MatchString(str) {
+			return true
+		}
+	}
+	return false
+}
+
+// compile prepares the route's patterns for matching.
+func (this *RouteConfig) compile() []error {
+	var errs []error
+	var err error
+	if this.projects, err = compilePatterns(this.Projects); err != nil {
+		errs = append(errs, fmt.Errorf("projects: %s", err.Error()))
+	}
+	if this.branches, err = compilePatterns(this.Branches); err != nil {
+		errs = append(errs, fmt.Errorf("branches: %s", err.Error()))
+	}
+	if this.events, err = compilePatterns(this.Events); err != nil {
+		errs = append(errs, fmt.Errorf("events: %s", err.Error()))
+	}
+	if this.excludeEvents, err = compilePatterns(this.ExcludeEvents); err != nil {
+		errs = append(errs, fmt.Errorf("exclude_events: %s", err.Error()))
+	}
+	return errs
+}
+
+// Matches returns true if msg should be sent to the route's channel.
+func (this *RouteConfig) Matches(msg *GerritMessage) bool {
+	if len(this.projects) > 0 && !this.projects.matchAny(msg.ProjectName()) {
+		return false
+	}
+	if len(this.branches) > 0 && !this.branches.matchAny(msg.BranchName()) {
+		return false
+	}
+	if len(this.events) > 0 && !this.events.matchAny(msg.Type) {
+		return false
+	}
+	if this.excludeEvents.matchAny(msg.Type) {
+		return false
+	}
+	return true
+}
+
+// RouteChannels returns the channels msg should be announced in: those of every
+// route it matches, or the Gerrit channel if it matches none.
+func (this *Config) RouteChannels(msg *GerritMessage) []string {
+	if !this.Gerrit.WantsProject(msg.ProjectName()) {
+		return nil
+	}
+
+	var channels []string
+	for idx := range this.Routes {
+		route := &this.Routes[idx]
+		if !route.Matches(msg) {
+			continue
+		}
+
+		seen := false
+		for _, channel := range channels {
+			if strings.EqualFold(channel, route.Channel) {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			channels = append(channels, route.Channel)
+		}
+	}
+
+	if len(channels) == 0 {
+		channels = append(channels, this.Gerrit.Channel)
+	}
+	return channels
+}
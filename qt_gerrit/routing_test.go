@@ -0,0 +1,104 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRouting(t *testing.T) {
+	config := defaultConfig()
+	config.IRC.Channels = []string{"#qt-gerrit", "#qt-qml", "#qt-releases"}
+	config.Gerrit.Channel = "#qt-gerrit"
+	config.Routes = []RouteConfig{
+		{
+			Channel:       "#qt-qml",
+			Projects:      []string{"qt/qtdeclarative", "qt/qtquick*"},
+			Branches:      []string{"dev"},
+			ExcludeEvents: []string{"comment-added"},
+		},
+		{
+			Channel:  "#qt-releases",
+			Branches: []string{`/^[0-9]+\.[0-9]+$/`},
+		},
+		{
+			Channel: "#QT-QML",
+			Events:  []string{"change-merged"},
+		},
+	}
+	for idx := range config.Routes {
+		if errs := config.Routes[idx].compile(); len(errs) > 0 {
+			t.Fatalf("Unexpected errors: %v", errs)
+		}
+	}
+
+	tests := []struct {
+		Type     string
+		Project  string
+		Branch   string
+		Expected string
+	}{
+		{"patchset-created", "qt/qtdeclarative", "dev", "#qt-qml"},
+		{"patchset-created", "qt/qtquickcontrols2", "dev", "#qt-qml"},
+		{"comment-added", "qt/qtdeclarative", "dev", "#qt-gerrit"},
+		{"patchset-created", "qt/qtdeclarative", "5.15", "#qt-releases"},
+		{"patchset-created", "qt/qtdeclarative", "5.15.2", "#qt-gerrit"},
+		{"patchset-created", "qt/qtbase", "dev", "#qt-gerrit"},
+		{"change-merged", "qt/qtdeclarative", "dev", "#qt-qml"},
+		{"change-merged", "qt/qtbase", "6.2", "#qt-releases,#QT-QML"},
+	}
+
+	for _, test := range tests {
+		msg := &GerritMessage{Type: test.Type}
+		msg.Change.Project = test.Project
+		msg.Change.Branch = test.Branch
+
+		channels := strings.Join(config.RouteChannels(msg), ",")
+		if channels != test.Expected {
+			t.Errorf("%s on %s/%s: Expected: %#v, got %#v", test.Type, test.Project, test.Branch, test.Expected, channels)
+		}
+	}
+
+	msg := &GerritMessage{Type: "ref-updated"}
+	msg.RefUpdate.Project = "qt/qtdeclarative"
+	msg.RefUpdate.RefName = "refs/heads/dev"
+	if channels := strings.Join(config.RouteChannels(msg), ","); channels != "#qt-qml" {
+		t.Errorf("Expected: %#v, got %#v", "#qt-qml", channels)
+	}
+
+	config.Gerrit.Projects = []string{"qt/qtbase"}
+	if channels := config.RouteChannels(msg); len(channels) != 0 {
+		t.Errorf("Expected no channels, got %#v", channels)
+	}
+}
+
+func TestRoutePatternErrors(t *testing.T) {
+	route := RouteConfig{Channel: "#qt", Projects: []string{"/qt(/"}}
+	errs := route.compile()
+	if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), `projects: bad pattern "/qt(/"`) {
+		t.Errorf("Expected a projects error, got %v", errs)
+	}
+}
@@ -0,0 +1,378 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/rburchell/gobo/lib/gerrit"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPConfig configures the HTTP listener reporting on how the bot is doing:
+// /healthz, /metrics (for Prometheus) and /debug/state.
+type HTTPConfig struct {
+	// Where to listen, e.g. 127.0.0.1:9090. If empty, nothing is.
+	Listen string `toml:"listen"`
+}
+
+func (this *HTTPConfig) validate() []error {
+	if len(this.Listen) == 0 {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(this.Listen); err != nil {
+		return []error{fmt.Errorf("http.listen: %s", err.Error())}
+	}
+	return nil
+}
+
+// The upper bounds of the buckets lookups are counted in, by how many
+// seconds they took.
+var lookupBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type lookupStats struct {
+	buckets []int64 // how many took at most each of lookupBuckets
+	count   int64
+	errors  int64
+	seconds float64
+}
+
+// A botMonitor keeps track of how the bot is doing.
+type botMonitor struct {
+	now func() time.Time
+
+	// What's found out from elsewhere, when asked.
+	ircRegistered func() bool
+	ircNick       func() string
+	ircChannels   func() []string
+	queueDepth    func() int
+
+	mutex           sync.Mutex
+	started         time.Time
+	gerritConnected bool
+	gerritChanged   time.Time // when it last connected, or lost the connection
+	lastEvent       time.Time
+	lastDiagnostic  string
+	connected       map[string]bool  // the services that connected before
+	reconnects      map[string]int64 // by service: irc or gerrit
+	events          map[string]int64 // Gerrit events received, by type
+	sent            map[string]int64 // lines sent to IRC, by command
+	lookups         map[string]*lookupStats
+}
+
+// The monitor of the running bot.
+var monitor = newBotMonitor(time.Now)
+
+func newBotMonitor(now func() time.Time) *botMonitor {
+	return &botMonitor{
+		now:           now,
+		ircRegistered: func() bool { return false },
+		ircNick:       func() string { return "" },
+		ircChannels:   func() []string { return nil },
+		queueDepth:    func() int { return 0 },
+		started:       now(),
+		connected:     map[string]bool{},
+		reconnects:    map[string]int64{"irc": 0, "gerrit": 0},
+		events:        map[string]int64{},
+		sent:          map[string]int64{},
+		lookups:       map[string]*lookupStats{},
+	}
+}
+
+// Connected notes that service (irc or gerrit) connected, which is a
+// reconnection unless it's the first time.
+func (this *botMonitor) Connected(service string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.connectedLocked(service)
+}
+
+func (this *botMonitor) connectedLocked(service string) {
+	if this.connected[service] {
+		this.reconnects[service]++
+	}
+	this.connected[service] = true
+}
+
+// GerritDiagnostic notes what a diagnostic says about the connection to
+// Gerrit.
+func (this *botMonitor) GerritDiagnostic(diag gerrit.Diagnostic) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	switch diag.Kind {
+	case gerrit.Connected:
+		this.connectedLocked("gerrit")
+		this.gerritConnected = true
+		this.gerritChanged = this.now()
+	case gerrit.Disconnected, gerrit.ConnectFailed, gerrit.HostKeyChanged:
+		if this.gerritConnected {
+			this.gerritChanged = this.now()
+		}
+		this.gerritConnected = false
+	}
+	this.lastDiagnostic = describeDiagnostic(diag)
+}
+
+// EventReceived notes that a Gerrit event of the given type came in.
+func (this *botMonitor) EventReceived(eventType string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.events[eventType]++
+	this.lastEvent = this.now()
+}
+
+// Sent notes that a line was sent to IRC with the given command, e.g.
+// PRIVMSG.
+func (this *botMonitor) Sent(command string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.sent[command]++
+}
+
+// LookedUp notes that an expander took so long to look something up, and
+// whether it failed (rather than, say, finding it doesn't exist).
+func (this *botMonitor) LookedUp(expander string, took time.Duration, failed bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	stats, ok := this.lookups[expander]
+	if !ok {
+		stats = &lookupStats{buckets: make([]int64, len(lookupBuckets))}
+		this.lookups[expander] = stats
+	}
+	for idx, bound := range lookupBuckets {
+		if took.Seconds() <= bound {
+			stats.buckets[idx]++
+		}
+	}
+	stats.count++
+	stats.seconds += took.Seconds()
+	if failed {
+		stats.errors++
+	}
+}
+
+// Problems returns what's wrong with the bot, if anything.
+func (this *botMonitor) Problems() []string {
+	var problems []string
+	if !this.ircRegistered() {
+		problems = append(problems, "not registered with the IRC server")
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if !this.gerritConnected {
+		problem := "not connected to Gerrit"
+		if !this.gerritChanged.IsZero() {
+			problem += fmt.Sprintf(" since %s", this.gerritChanged.UTC().Format(time.RFC3339))
+		}
+		problems = append(problems, problem)
+	}
+	return problems
+}
+
+// Handler returns the HTTP handler for /healthz, /metrics and /debug/state.
+func (this *botMonitor) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", this.serveHealth)
+	mux.HandleFunc("/metrics", this.serveMetrics)
+	mux.HandleFunc("/debug/state", this.serveState)
+	return mux
+}
+
+func (this *botMonitor) serveHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	problems := this.Problems()
+	if len(problems) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "%s\n", strings.Join(problems, "\n"))
+		return
+	}
+	fmt.Fprintf(w, "ok\n")
+}
+
+// Quote a label value for Prometheus.
+func metricLabel(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+func metricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func boolMetric(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
+
+func sortedKeys(counts map[string]int64) []string {
+	var keys []string
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeMetricHeader(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeCounters(w io.Writer, name string, help string, label string, counts map[string]int64) {
+	writeMetricHeader(w, name, "counter", help)
+	for _, key := range sortedKeys(counts) {
+		fmt.Fprintf(w, "%s{%s=%s} %d\n", name, label, metricLabel(key), counts[key])
+	}
+}
+
+// WriteMetrics writes the metrics in Prometheus' text format.
+func (this *botMonitor) WriteMetrics(w io.Writer) {
+	registered := this.ircRegistered()
+	queueDepth := this.queueDepth()
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	writeMetricHeader(w, "qt_gerrit_start_time_seconds", "gauge", "When the bot started, in seconds since the epoch.")
+	fmt.Fprintf(w, "qt_gerrit_start_time_seconds %d\n", this.started.Unix())
+	writeMetricHeader(w, "qt_gerrit_irc_registered", "gauge", "Whether the bot is registered with the IRC server.")
+	fmt.Fprintf(w, "qt_gerrit_irc_registered %s\n", boolMetric(registered))
+	writeMetricHeader(w, "qt_gerrit_gerrit_connected", "gauge", "Whether the bot is connected to Gerrit's event stream.")
+	fmt.Fprintf(w, "qt_gerrit_gerrit_connected %s\n", boolMetric(this.gerritConnected))
+	writeMetricHeader(w, "qt_gerrit_queue_depth", "gauge", "Gerrit events held back to be coalesced or put in a digest.")
+	fmt.Fprintf(w, "qt_gerrit_queue_depth %d\n", queueDepth)
+	writeCounters(w, "qt_gerrit_reconnects_total", "Reconnections, by service.", "service", this.reconnects)
+	writeCounters(w, "qt_gerrit_gerrit_events_total", "Gerrit events received, by type.", "type", this.events)
+	writeCounters(w, "qt_gerrit_irc_lines_sent_total", "Lines sent to IRC, by command.", "command", this.sent)
+
+	var expanders []string
+	errors := map[string]int64{}
+	for expander, stats := range this.lookups {
+		expanders = append(expanders, expander)
+		errors[expander] = stats.errors
+	}
+	sort.Strings(expanders)
+
+	writeMetricHeader(w, "qt_gerrit_lookup_duration_seconds", "histogram", "How long lookups took, by expander.")
+	for _, expander := range expanders {
+		stats := this.lookups[expander]
+		label := "expander=" + metricLabel(expander)
+		for idx, bound := range lookupBuckets {
+			fmt.Fprintf(w, "qt_gerrit_lookup_duration_seconds_bucket{%s,le=\"%s\"} %d\n", label, metricValue(bound), stats.buckets[idx])
+		}
+		fmt.Fprintf(w, "qt_gerrit_lookup_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", label, stats.count)
+		fmt.Fprintf(w, "qt_gerrit_lookup_duration_seconds_sum{%s} %s\n", label, metricValue(stats.seconds))
+		fmt.Fprintf(w, "qt_gerrit_lookup_duration_seconds_count{%s} %d\n", label, stats.count)
+	}
+	writeCounters(w, "qt_gerrit_lookup_errors_total", "Lookups that failed, by expander.", "expander", errors)
+}
+
+func (this *botMonitor) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	this.WriteMetrics(w)
+}
+
+// The state of the bot, as dumped by /debug/state.
+type monitorState struct {
+	Started string `json:"started"`
+	Uptime  string `json:"uptime"`
+	IRC     struct {
+		Registered bool     `json:"registered"`
+		Nick       string   `json:"nick"`
+		Channels   []string `json:"channels"`
+	} `json:"irc"`
+	Gerrit struct {
+		Connected      bool   `json:"connected"`
+		Since          string `json:"since,omitempty"`
+		LastEvent      string `json:"last_event,omitempty"`
+		LastDiagnostic string `json:"last_diagnostic,omitempty"`
+	} `json:"gerrit"`
+	QueueDepth int                    `json:"queue_depth"`
+	Reconnects map[string]int64       `json:"reconnects"`
+	Events     map[string]int64       `json:"events"`
+	Sent       map[string]int64       `json:"sent"`
+	Lookups    map[string]lookupState `json:"lookups"`
+}
+
+type lookupState struct {
+	Count   int64   `json:"count"`
+	Errors  int64   `json:"errors"`
+	Seconds float64 `json:"seconds"`
+}
+
+func formatStateTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func copyCounts(counts map[string]int64) map[string]int64 {
+	copied := map[string]int64{}
+	for key, count := range counts {
+		copied[key] = count
+	}
+	return copied
+}
+
+// State returns the state of the bot.
+func (this *botMonitor) State() monitorState {
+	var state monitorState
+	state.IRC.Registered = this.ircRegistered()
+	state.IRC.Nick = this.ircNick()
+	state.IRC.Channels = this.ircChannels()
+	state.QueueDepth = this.queueDepth()
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	state.Started = formatStateTime(this.started)
+	state.Uptime = this.now().Sub(this.started).Truncate(time.Second).String()
+	state.Gerrit.Connected = this.gerritConnected
+	state.Gerrit.Since = formatStateTime(this.gerritChanged)
+	state.Gerrit.LastEvent = formatStateTime(this.lastEvent)
+	state.Gerrit.LastDiagnostic = this.lastDiagnostic
+	state.Reconnects = copyCounts(this.reconnects)
+	state.Events = copyCounts(this.events)
+	state.Sent = copyCounts(this.sent)
+	state.Lookups = map[string]lookupState{}
+	for expander, stats := range this.lookups {
+		state.Lookups[expander] = lookupState{Count: stats.count, Errors: stats.errors, Seconds: stats.seconds}
+	}
+	return state
+}
+
+func (this *botMonitor) serveState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(this.State())
+}
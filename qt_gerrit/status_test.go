@@ -0,0 +1,190 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/rburchell/gobo/lib/gerrit"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testRequest(t *testing.T, handler http.Handler, path string) (int, string) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+	return recorder.Code, recorder.Body.String()
+}
+
+func TestHealth(t *testing.T) {
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	monitor := newBotMonitor(clock.Now)
+	registered := false
+	monitor.ircRegistered = func() bool { return registered }
+	handler := monitor.Handler()
+
+	expected := "not registered with the IRC server\nnot connected to Gerrit\n"
+	if code, body := testRequest(t, handler, "/healthz"); code != http.StatusServiceUnavailable || body != expected {
+		t.Errorf("Expected: 503 %#v, got %d %#v", expected, code, body)
+	}
+
+	registered = true
+	monitor.GerritDiagnostic(gerrit.Diagnostic{Kind: gerrit.Connected, Message: "Gerrit connection reestablished."})
+	if code, body := testRequest(t, handler, "/healthz"); code != http.StatusOK || body != "ok\n" {
+		t.Errorf("Expected: 200 \"ok\\n\", got %d %#v", code, body)
+	}
+
+	clock.Advance(time.Minute)
+	monitor.GerritDiagnostic(gerrit.Diagnostic{Kind: gerrit.Disconnected, Message: "Lost the connection to Gerrit", Err: errors.New("EOF")})
+	clock.Advance(time.Minute)
+	monitor.GerritDiagnostic(gerrit.Diagnostic{Kind: gerrit.ConnectFailed, Message: "Failed to dial", Err: errors.New("refused")})
+	expected = "not connected to Gerrit since 2023-11-14T22:14:20Z\n"
+	if code, body := testRequest(t, handler, "/healthz"); code != http.StatusServiceUnavailable || body != expected {
+		t.Errorf("Expected: 503 %#v, got %d %#v", expected, code, body)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	monitor := newBotMonitor(clock.Now)
+	monitor.ircRegistered = func() bool { return true }
+	monitor.queueDepth = func() int { return 4 }
+
+	monitor.Connected("irc")
+	monitor.Connected("irc")
+	monitor.GerritDiagnostic(gerrit.Diagnostic{Kind: gerrit.Connected})
+	monitor.EventReceived("comment-added")
+	monitor.EventReceived("comment-added")
+	monitor.EventReceived(`odd"type`)
+	monitor.Sent("PRIVMSG")
+	monitor.Sent("NOTICE")
+	monitor.Sent("PRIVMSG")
+	monitor.LookedUp("jira", 200*time.Millisecond, false)
+	monitor.LookedUp("jira", 3*time.Second, true)
+	monitor.LookedUp("docs", 50*time.Millisecond, false)
+
+	expected := `# HELP qt_gerrit_start_time_seconds When the bot started, in seconds since the epoch.
+# TYPE qt_gerrit_start_time_seconds gauge
+qt_gerrit_start_time_seconds 1700000000
+# HELP qt_gerrit_irc_registered Whether the bot is registered with the IRC server.
+# TYPE qt_gerrit_irc_registered gauge
+qt_gerrit_irc_registered 1
+# HELP qt_gerrit_gerrit_connected Whether the bot is connected to Gerrit's event stream.
+# TYPE qt_gerrit_gerrit_connected gauge
+qt_gerrit_gerrit_connected 1
+# HELP qt_gerrit_queue_depth Gerrit events held back to be coalesced or put in a digest.
+# TYPE qt_gerrit_queue_depth gauge
+qt_gerrit_queue_depth 4
+# HELP qt_gerrit_reconnects_total Reconnections, by service.
+# TYPE qt_gerrit_reconnects_total counter
+qt_gerrit_reconnects_total{service="gerrit"} 0
+qt_gerrit_reconnects_total{service="irc"} 1
+# HELP qt_gerrit_gerrit_events_total Gerrit events received, by type.
+# TYPE qt_gerrit_gerrit_events_total counter
+qt_gerrit_gerrit_events_total{type="comment-added"} 2
+qt_gerrit_gerrit_events_total{type="odd\"type"} 1
+# HELP qt_gerrit_irc_lines_sent_total Lines sent to IRC, by command.
+# TYPE qt_gerrit_irc_lines_sent_total counter
+qt_gerrit_irc_lines_sent_total{command="NOTICE"} 1
+qt_gerrit_irc_lines_sent_total{command="PRIVMSG"} 2
+# HELP qt_gerrit_lookup_duration_seconds How long lookups took, by expander.
+# TYPE qt_gerrit_lookup_duration_seconds histogram
+qt_gerrit_lookup_duration_seconds_bucket{expander="docs",le="0.1"} 1
+qt_gerrit_lookup_duration_seconds_bucket{expander="docs",le="0.25"} 1
+qt_gerrit_lookup_duration_seconds_bucket{expander="docs",le="0.5"} 1
+qt_gerrit_lookup_duration_seconds_bucket{expander="docs",le="1"} 1
+qt_gerrit_lookup_duration_seconds_bucket{expander="docs",le="2.5"} 1
+qt_gerrit_lookup_duration_seconds_bucket{expander="docs",le="5"} 1
+qt_gerrit_lookup_duration_seconds_bucket{expander="docs",le="10"} 1
+qt_gerrit_lookup_duration_seconds_bucket{expander="docs",le="+Inf"} 1
+qt_gerrit_lookup_duration_seconds_sum{expander="docs"} 0.05
+qt_gerrit_lookup_duration_seconds_count{expander="docs"} 1
+qt_gerrit_lookup_duration_seconds_bucket{expander="jira",le="0.1"} 0
+qt_gerrit_lookup_duration_seconds_bucket{expander="jira",le="0.25"} 1
+qt_gerrit_lookup_duration_seconds_bucket{expander="jira",le="0.5"} 1
+qt_gerrit_lookup_duration_seconds_bucket{expander="jira",le="1"} 1
+qt_gerrit_lookup_duration_seconds_bucket{expander="jira",le="2.5"} 1
+qt_gerrit_lookup_duration_seconds_bucket{expander="jira",le="5"} 2
+qt_gerrit_lookup_duration_seconds_bucket{expander="jira",le="10"} 2
+qt_gerrit_lookup_duration_seconds_bucket{expander="jira",le="+Inf"} 2
+qt_gerrit_lookup_duration_seconds_sum{expander="jira"} 3.2
+qt_gerrit_lookup_duration_seconds_count{expander="jira"} 2
+# HELP qt_gerrit_lookup_errors_total Lookups that failed, by expander.
+# TYPE qt_gerrit_lookup_errors_total counter
+qt_gerrit_lookup_errors_total{expander="docs"} 0
+qt_gerrit_lookup_errors_total{expander="jira"} 1
+`
+	code, body := testRequest(t, monitor.Handler(), "/metrics")
+	if code != http.StatusOK || body != expected {
+		t.Errorf("Expected: %#v, got %d %#v", expected, code, body)
+	}
+}
+
+func TestDebugState(t *testing.T) {
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	monitor := newBotMonitor(clock.Now)
+	monitor.ircRegistered = func() bool { return true }
+	monitor.ircNick = func() string { return "qt_gerrit_" }
+	monitor.ircChannels = func() []string { return []string{"#qt", "#qt-labs"} }
+
+	clock.Advance(90 * time.Second)
+	monitor.GerritDiagnostic(gerrit.Diagnostic{Kind: gerrit.Connected, Message: "Gerrit connection reestablished."})
+	clock.Advance(time.Hour)
+	monitor.EventReceived("change-merged")
+	monitor.LookedUp("gerrit", time.Second, true)
+
+	code, body := testRequest(t, monitor.Handler(), "/debug/state")
+	if code != http.StatusOK {
+		t.Fatalf("Expected: 200, got %d", code)
+	}
+	var state monitorState
+	if err := json.Unmarshal([]byte(body), &state); err != nil {
+		t.Fatalf("Unexpected error: %s in %s", err, body)
+	}
+
+	tests := []struct {
+		Got      interface{}
+		Expected interface{}
+	}{
+		{state.Started, "2023-11-14T22:13:20Z"},
+		{state.Uptime, "1h1m30s"},
+		{state.IRC.Nick, "qt_gerrit_"},
+		{strings.Join(state.IRC.Channels, ","), "#qt,#qt-labs"},
+		{state.Gerrit.Connected, true},
+		{state.Gerrit.Since, "2023-11-14T22:14:50Z"},
+		{state.Gerrit.LastEvent, "2023-11-14T23:14:50Z"},
+		{state.Gerrit.LastDiagnostic, "Gerrit connection reestablished."},
+		{state.Events["change-merged"], int64(1)},
+		{state.Lookups["gerrit"], lookupState{Count: 1, Errors: 1, Seconds: 1}},
+	}
+	for _, test := range tests {
+		if test.Got != test.Expected {
+			t.Errorf("Expected: %#v, got %#v", test.Expected, test.Got)
+		}
+	}
+}
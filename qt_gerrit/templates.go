@@ -0,0 +1,204 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+)
+
+// The templates used to announce each type of Gerrit event, unless they're
+// overridden in the config. Each is executed with the *GerritMessage, except
+// for "summary", which describes a group of events with an *EventSummary.
+var defaultTemplateText = map[string]string{
+	"summary": `{{.Count}} {{.Description}} {{list .Places}}{{if .Actors}} by {{list .Actors}}{{end}}`,
+	"comment-added": `[{{.Change.Project}}/{{.Change.Branch}}] {{.Change.Subject}} from {{.PatchSet.Uploader.Name}} ` +
+		`{{with .ChangedApprovals}}reviewed by {{$.Author.Name}}: {{approvals .}}{{else}}commented by {{.Author.Name}}{{end}} - {{.Change.Url}}`,
+	"patchset-created": `[{{.Change.Project}}/{{.Change.Branch}}] {{.Change.Subject}} ` +
+		`{{if eq .PatchSet.Number 1}}pushed{{else}}updated{{end}} by {{.PatchSet.Uploader.Name}} - {{.Change.Url}}`,
+	"change-merged": `[{{.Change.Project}}/{{.Change.Branch}}] {{.Change.Subject}} authored by {{.PatchSet.Uploader.Name}} ` +
+		`was cherry-picked by {{.Submitter.Name}} - {{.Change.Url}}`,
+	"change-deferred": `[{{.Change.Project}}/{{.Change.Branch}}] {{.Change.Subject}} owned by {{.Change.Owner.Name}} ` +
+		`was deferred by {{.Deferrer.Name}} - {{.Change.Url}}`,
+	"change-abandoned": `[{{.Change.Project}}/{{.Change.Branch}}] {{.Change.Subject}} owned by {{.Change.Owner.Name}} ` +
+		`was abandoned by {{.Abandoner.Name}} - {{.Change.Url}}`,
+	"change-restored": `[{{.Change.Project}}/{{.Change.Branch}}] {{.Change.Subject}} owned by {{.Change.Owner.Name}} ` +
+		`was restored by {{.Restorer.Name}} - {{.Change.Url}}`,
+	"merge-failed": `[{{.Change.Project}}/{{.Change.Branch}}] {{.Submitter.Name}} tried to cherry-pick {{.Change.Subject}}, ` +
+		`but the merge failed because: {{firstline .Reason}} - {{.Change.Url}}`,
+	"ref-updated": `[{{.RefUpdate.Project}}] {{.Submitter.Name}} {{.RefChange.Summary}}{{with .RefChange.Url}} - {{.}}{{end}}`,
+	"reviewer-added": `[{{.Change.Project}}/{{.Change.Branch}}] {{.Change.Subject}} from {{.Change.Owner.Name}}: ` +
+		`{{.Reviewer.Name}} was added as a reviewer by {{.Adder.Name}} - {{.Change.Url}}`,
+	"topic-changed": `[{{.Change.Project}}/{{.Change.Branch}}] {{.Change.Subject}} owned by {{.Change.Owner.Name}} ` +
+		`{{if .Change.Topic}}had its topic set to {{printf "%q" .Change.Topic}}{{else}}had its topic removed{{end}} by {{.Changer.Name}} - {{.Change.Url}}`,
+	"hashtags-changed": `[{{.Change.Project}}/{{.Change.Branch}}] {{.Change.Subject}} owned by {{.Change.Owner.Name}} ` +
+		`had its hashtags changed by {{.Editor.Name}}:{{range .Added}} +{{.}}{{end}}{{range .Removed}} -{{.}}{{end}} - {{.Change.Url}}`,
+	"wip-state-changed": `[{{.Change.Project}}/{{.Change.Branch}}] {{.Change.Subject}} owned by {{.Change.Owner.Name}} ` +
+		`was marked as {{if .Change.Wip}}work in progress{{else}}ready for review{{end}} by {{.Changer.Name}} - {{.Change.Url}}`,
+	"private-state-changed": `[{{.Change.Project}}/{{.Change.Branch}}] {{.Change.Subject}} owned by {{.Change.Owner.Name}} ` +
+		`was made {{if .Change.Private}}private{{else}}public{{end}} by {{.Changer.Name}} - {{.Change.Url}}`,
+	"vote-deleted": `[{{.Change.Project}}/{{.Change.Branch}}] {{.Change.Subject}} owned by {{.Change.Owner.Name}} ` +
+		`had a vote by {{.Reviewer.Name}} removed by {{.Remover.Name}} - {{.Change.Url}}`,
+	"assignee-changed": `[{{.Change.Project}}/{{.Change.Branch}}] {{.Change.Subject}} owned by {{.Change.Owner.Name}} ` +
+		`{{if .Change.Assignee.Name}}was assigned to {{.Change.Assignee.Name}}{{else}}was unassigned{{end}} by {{.Changer.Name}} - {{.Change.Url}}`,
+	"project-created": `[{{.CreatedProject}}] Project created, with HEAD {{.ProjectHead}}`,
+}
+
+var defaultTemplates = map[string]*template.Template{}
+
+func init() {
+	for name, text := range defaultTemplateText {
+		defaultTemplates[name] = template.Must(parseTemplate(name, text))
+	}
+}
+
+// mIRC colour codes, by name.
+var ircColors = map[string]int{
+	"white":      0,
+	"black":      1,
+	"blue":       2,
+	"green":      3,
+	"red":        4,
+	"brown":      5,
+	"purple":     6,
+	"orange":     7,
+	"yellow":     8,
+	"lightgreen": 9,
+	"cyan":       10,
+	"lightcyan":  11,
+	"lightblue":  12,
+	"pink":       13,
+	"grey":       14,
+	"lightgrey":  15,
+
+	"darkred":   5,
+	"darkgreen": 3,
+}
+
+// Wrap text in the IRC formatting for the named colour.
+func colorize(color string, text string) (string, error) {
+	code, ok := ircColors[color]
+	if !ok {
+		return "", fmt.Errorf("unknown colour %q", color)
+	}
+	return fmt.Sprintf("\x03%02d%s\x03", code, text), nil
+}
+
+// Cut text down to at most length characters, marking where it was cut.
+func truncate(length int, text string) string {
+	if utf8.RuneCountInString(text) <= length {
+		return text
+	}
+	if length <= 3 {
+		return string([]rune(text)[:length])
+	}
+	return string([]rune(text)[:length-3]) + "..."
+}
+
+var gerritChangeUrlRegex = regexp.MustCompile(`^(https?://[^/]+)/(?:c/.+/\+/|#/c/)?([0-9]+)(?:/.*)?$`)
+
+// Shorten Gerrit change URLs (e.g. https://host/c/qt/qtbase/+/1234/2) to the
+// https://host/1234 form. Other URLs are returned unchanged.
+func shortUrl(url string) string {
+	return gerritChangeUrlRegex.ReplaceAllString(url, "$1/$2")
+}
+
+func firstLine(text string) string {
+	if idx := strings.Index(text, "\n"); idx >= 0 {
+		return text[:idx]
+	}
+	return text
+}
+
+var templateFuncs = template.FuncMap{
+	"color":     colorize,
+	"truncate":  truncate,
+	"shorturl":  shortUrl,
+	"firstline": firstLine,
+	"label":     labelShortName,
+	"vote":      describeApproval,
+	"approvals": describeApprovals,
+	"join":      strings.Join,
+	"list":      summarizeList,
+}
+
+func parseTemplate(name string, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// Render data (a *GerritMessage or *EventSummary) with tmpl, returning the
+// lines to announce. Empty lines are dropped, so a template can decide not to
+// announce anything.
+func renderTemplate(tmpl *template.Template, data interface{}) ([]string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// compileTemplates parses a set of template overrides from the config.
+func compileTemplates(name string, texts map[string]string) (map[string]*template.Template, []error) {
+	var errs []error
+	templates := map[string]*template.Template{}
+	for eventType, text := range texts {
+		if _, ok := defaultTemplates[eventType]; !ok {
+			errs = append(errs, fmt.Errorf("%s.%s: unknown event type", name, eventType))
+			continue
+		}
+		tmpl, err := parseTemplate(eventType, text)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.%s: %s", name, eventType, err.Error()))
+			continue
+		}
+		templates[eventType] = tmpl
+	}
+	return templates, errs
+}
+
+// Template returns the template to announce events of eventType with in
+// channel.
+func (this *Config) Template(channel string, eventType string) *template.Template {
+	if config, ok := this.Channel(channel); ok {
+		if tmpl, ok := config.templates[eventType]; ok {
+			return tmpl
+		}
+	}
+	if tmpl, ok := this.Gerrit.templates[eventType]; ok {
+		return tmpl
+	}
+	return defaultTemplates[eventType]
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTemplateHelpers(t *testing.T) {
+	if str, _ := colorize("red", "-2"); str != "\x0304-2\x03" {
+		t.Errorf("Expected: %#v, got %#v", "\x0304-2\x03", str)
+	}
+	if _, err := colorize("mauve", "-2"); err == nil {
+		t.Errorf("Expected an error for an unknown colour")
+	}
+
+	truncateTests := []struct {
+		Length   int
+		Text     string
+		Expected string
+	}{
+		{10, "short", "short"},
+		{8, "much too long", "much ..."},
+		{5, "äöüäöüäöü", "äö..."},
+		{2, "long", "lo"},
+	}
+	for _, test := range truncateTests {
+		if str := truncate(test.Length, test.Text); str != test.Expected {
+			t.Errorf("Expected: %#v, got %#v", test.Expected, str)
+		}
+	}
+
+	urlTests := []struct {
+		Url      string
+		Expected string
+	}{
+		{"https://codereview.qt-project.org/c/qt/qtbase/+/1234", "https://codereview.qt-project.org/1234"},
+		{"https://codereview.qt-project.org/c/qt/qtbase/+/1234/2", "https://codereview.qt-project.org/1234"},
+		{"https://codereview.qt-project.org/#/c/1234/", "https://codereview.qt-project.org/1234"},
+		{"https://codereview.qt-project.org/1234", "https://codereview.qt-project.org/1234"},
+		{"https://bugreports.qt.io/browse/QTBUG-1", "https://bugreports.qt.io/browse/QTBUG-1"},
+	}
+	for _, test := range urlTests {
+		if str := shortUrl(test.Url); str != test.Expected {
+			t.Errorf("Expected: %#v, got %#v", test.Expected, str)
+		}
+	}
+}
+
+func TestTemplateOverrides(t *testing.T) {
+	config := defaultConfig()
+	config.IRC.Channels = []string{"#qt-gerrit", "#qt-qml"}
+	config.Gerrit.Templates = map[string]string{
+		"change-merged": `merged: {{truncate 10 .Change.Subject}}`,
+	}
+	config.Channels = map[string]ChannelConfig{
+		"#qt-qml": {Templates: map[string]string{
+			"change-merged":   `{{color "green" "merged"}} {{shorturl .Change.Url}}`,
+			"comment-added":   `{{range .Approvals}}{{label .Type}}{{.Value}}{{"\n"}}{{end}}`,
+			"no-such-event":   `nope`,
+			"change-deferred": `{{.Change.Subject`,
+		}},
+	}
+
+	var messages []string
+	for _, err := range config.validate() {
+		if strings.Contains(err.Error(), ".templates.") {
+			messages = append(messages, err.Error())
+		}
+	}
+	if len(messages) != 2 ||
+		!strings.Contains(strings.Join(messages, "\n"), "channel.#qt-qml.templates.no-such-event: unknown event type") ||
+		!strings.Contains(strings.Join(messages, "\n"), "channel.#qt-qml.templates.change-deferred: template: change-deferred:1:") {
+		t.Errorf("Expected two template errors, got %#v", messages)
+	}
+
+	msg := testEvent(t, `{"type": "change-merged", "approvals": [{"type": "Code-Review", "value": "2"}, {"type": "Custom-Label", "value": "1"}], "change": {"subject": "A rather long subject", "url": "https://codereview.qt-project.org/c/qt/qtbase/+/1234"}}`)
+	tests := []struct {
+		Channel   string
+		EventType string
+		Expected  string
+	}{
+		{"#qt-gerrit", "change-merged", "merged: A rathe..."},
+		{"#QT-QML", "change-merged", "\x0303merged\x03 https://codereview.qt-project.org/1234"},
+		{"#qt-qml", "comment-added", "C2,Custom-Label1"},
+	}
+	for _, test := range tests {
+		lines, err := renderTemplate(config.Template(test.Channel, test.EventType), msg)
+		if err != nil {
+			t.Errorf("Unexpected error: %s", err)
+		}
+		if str := strings.Join(lines, ","); str != test.Expected {
+			t.Errorf("Expected: %#v, got %#v", test.Expected, str)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// WatchConfig controls the watches people can set up, to have Gerrit events
+// sent to them privately.
+type WatchConfig struct {
+	// Where watches are kept. If empty, they're forgotten on restart.
+	File string `toml:"file"`
+
+	// How many watches each account may have, at most.
+	Max int `toml:"max"`
+}
+
+func defaultWatchConfig() WatchConfig {
+	return WatchConfig{
+		Max: 20,
+	}
+}
+
+func (this *WatchConfig) validate() []error {
+	var errs []error
+	if this.Max < 1 {
+		errs = append(errs, fmt.Errorf("watch.max: must be at least 1"))
+	}
+	return errs
+}
+
+// A Watch is one thing someone wants to hear about: a project (e.g.
+// qt/qtbase), the changes owned by someone (a Gerrit username, or "me"), or
+// a change (by number).
+type Watch struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+func (this Watch) String() string {
+	return this.Kind + " " + this.Value
+}
+
+// The kinds of watches there are, and what each is given.
+var watchKinds = map[string]string{
+	"project": "a project, e.g. qt/qtbase",
+	"owner":   "a Gerrit username, or me",
+	"change":  "a change number",
+}
+
+// A watcher is someone with watches, known by their IRC account.
+type watcher struct {
+	// Their Gerrit username, if they linked it. Linking isn't verified with
+	// Gerrit: it only decides who "owner me" means, and which events are the
+	// watcher's own (and so not sent to them). Nothing is sent that couldn't
+	// be had by watching the project anyway, and private changes never are.
+	Gerrit string `json:"gerrit,omitempty"`
+
+	Watches []Watch `json:"watches,omitempty"`
+}
+
+// Whether msg is something this watcher wants to hear about.
+func (this *watcher) wants(msg *GerritMessage) bool {
+	for _, watch := range this.Watches {
+		switch watch.Kind {
+		case "project":
+			if watch.Value == msg.ProjectName() {
+				return true
+			}
+		case "owner":
+			owner := watch.Value
+			if owner == "me" {
+				owner = this.Gerrit
+			}
+			if len(owner) > 0 && owner == msg.Change.Owner.Username {
+				return true
+			}
+		case "change":
+			if watch.Value == strconv.FormatInt(int64(msg.Change.Number), 10) && msg.Change.Number != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// A watchList keeps everyone's watches, keyed by IRC account, and saves
+// them to a file (if it has one) whenever they change.
+type watchList struct {
+	path string
+
+	// Finds the nick of whoever is logged in to an account, if they're
+	// online, which is who notices go to. Without it, nobody gets any.
+	online func(account string) (string, bool)
+
+	mutex    sync.Mutex
+	watchers map[string]*watcher
+}
+
+// Load the watches kept in path. A missing file is an empty list; an empty
+// path is a list that's never saved.
+func loadWatchList(path string) (*watchList, error) {
+	list := &watchList{path: path, watchers: map[string]*watcher{}}
+	if len(path) == 0 {
+		return list, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return list, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &list.watchers); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return list, nil
+}
+
+// Write the watches out. Called with the mutex held.
+func (this *watchList) save() {
+	if len(this.path) == 0 {
+		return
+	}
+	data, err := json.MarshalIndent(this.watchers, "", "\t")
+	if err == nil {
+		tmp := this.path + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0600); err == nil {
+			err = os.Rename(tmp, this.path)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Failed to save watches to %s: %s\n", this.path, err.Error())
+	}
+}
+
+// Recipients returns the nicks of those who want to hear about msg, sorted.
+// Nobody hears about what they did themselves, or about private changes, and
+// those who aren't online don't hear about anything.
+func (this *watchList) Recipients(msg *GerritMessage) []string {
+	if msg.Change.Private || this.online == nil {
+		return nil
+	}
+	actor := msg.Actor().Username
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	var nicks []string
+	for account, w := range this.watchers {
+		if len(actor) > 0 && actor == w.Gerrit {
+			continue
+		}
+		if !w.wants(msg) {
+			continue
+		}
+		if nick, ok := this.online(account); ok {
+			nicks = append(nicks, nick)
+		}
+	}
+	sort.Strings(nicks)
+	return nicks
+}
+
+// Command runs a watch command (e.g. "!watch project qt/qtbase") from
+// someone logged in as account (empty if they aren't), returning the
+// replies, or false if text isn't a watch command.
+func (this *watchList) Command(config *Config, account string, text string) ([]string, bool) {
+	args := strings.Fields(text)
+	if len(args) == 0 {
+		return nil, false
+	}
+	switch args[0] {
+	case "!watch", "!unwatch", "!watches", "!link", "!unlink":
+	default:
+		return nil, false
+	}
+
+	if len(account) == 0 {
+		return []string{"You need to be identified with NickServ for that."}, true
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	key := strings.ToLower(account)
+	w, ok := this.watchers[key]
+	if !ok {
+		w = &watcher{}
+	}
+
+	var replies []string
+	switch args[0] {
+	case "!link":
+		if len(args) != 2 {
+			return []string{"Usage: !link <Gerrit username>"}, true
+		}
+		w.Gerrit = args[1]
+		replies = []string{fmt.Sprintf("Your account is now linked to %s on Gerrit.", w.Gerrit)}
+
+	case "!unlink":
+		w.Gerrit = ""
+		replies = []string{"Your account is no longer linked to Gerrit."}
+
+	case "!watches":
+		if len(w.Watches) == 0 {
+			return []string{"You aren't watching anything."}, true
+		}
+		for _, watch := range w.Watches {
+			replies = append(replies, "Watching "+watch.String())
+		}
+		return replies, true
+
+	case "!watch":
+		if len(args) != 3 {
+			return []string{"Usage: !watch project|owner|change <what>"}, true
+		}
+		watch := Watch{Kind: args[1], Value: args[2]}
+		what, ok := watchKinds[watch.Kind]
+		if !ok {
+			return []string{fmt.Sprintf("Can't watch a %s; you can watch a project, owner or change.", watch.Kind)}, true
+		}
+		if _, err := strconv.Atoi(watch.Value); watch.Kind == "change" && err != nil {
+			return []string{fmt.Sprintf("A change to watch is %s.", what)}, true
+		}
+		if watch.Kind == "owner" && watch.Value == "me" && len(w.Gerrit) == 0 {
+			return []string{"Link your account to Gerrit first, with !link <Gerrit username>."}, true
+		}
+		for _, existing := range w.Watches {
+			if existing == watch {
+				return []string{"You're already watching " + watch.String()}, true
+			}
+		}
+		if len(w.Watches) >= config.Watch.Max {
+			return []string{fmt.Sprintf("You can't watch more than %d things.", config.Watch.Max)}, true
+		}
+		w.Watches = append(w.Watches, watch)
+		replies = []string{"Now watching " + watch.String()}
+
+	case "!unwatch":
+		if len(args) == 2 && args[1] == "all" {
+			w.Watches = nil
+			replies = []string{"No longer watching anything."}
+			break
+		}
+		if len(args) != 3 {
+			return []string{"Usage: !unwatch project|owner|change <what>, or !unwatch all"}, true
+		}
+		watch := Watch{Kind: args[1], Value: args[2]}
+		found := false
+		for idx, existing := range w.Watches {
+			if existing == watch {
+				w.Watches = append(w.Watches[:idx], w.Watches[idx+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return []string{"You weren't watching " + watch.String()}, true
+		}
+		replies = []string{"No longer watching " + watch.String()}
+	}
+
+	if len(w.Watches) == 0 && len(w.Gerrit) == 0 {
+		delete(this.watchers, key)
+	} else {
+		this.watchers[key] = w
+	}
+	this.save()
+	return replies, true
+}
+
+// Announces to those watching an event, by private notice.
+type noticeAnnouncer struct {
+	config *Config
+	nicks  []string
+	notify func(nick string, line string)
+}
+
+func (this *noticeAnnouncer) Announce(msg *GerritMessage) {
+	lines, err := renderTemplate(this.config.Template("", msg.Type), msg)
+	if err != nil {
+		fmt.Printf("Failed to render %s for watchers: %s\n", msg.Type, err.Error())
+		return
+	}
+	for _, nick := range this.nicks {
+		for _, line := range lines {
+			this.notify(nick, line)
+		}
+	}
+}
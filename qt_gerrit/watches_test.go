@@ -0,0 +1,198 @@
+/*
+ * Copyright (C) 2026 Robin Burchell <robin+git@viroteck.net>
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  - Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ *  - Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE AUTHOR AND CONTRIBUTORS ``AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR OR CONTRIBUTORS BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+ * THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Pretends those in nicks (by account) are online, using the given nick.
+func testOnline(nicks map[string]string) func(account string) (string, bool) {
+	return func(account string) (string, bool) {
+		nick, ok := nicks[account]
+		return nick, ok
+	}
+}
+
+func TestWatchCommands(t *testing.T) {
+	config := defaultConfig()
+	config.Watch.Max = 2
+	watches, _ := loadWatchList("")
+
+	tests := []struct {
+		Account  string
+		Text     string
+		Expected string
+	}{
+		{"", "!watch project qt/qtbase", "You need to be identified with NickServ for that."},
+		{"alice", "hello", ""},
+		{"alice", "!watches", "You aren't watching anything."},
+		{"alice", "!watch project", "Usage: !watch project|owner|change <what>"},
+		{"alice", "!watch branch dev", "Can't watch a branch; you can watch a project, owner or change."},
+		{"alice", "!watch change I0123", "A change to watch is a change number."},
+		{"alice", "!watch owner me", "Link your account to Gerrit first, with !link <Gerrit username>."},
+		{"alice", "!link alice.g", "Your account is now linked to alice.g on Gerrit."},
+		{"alice", "!watch owner me", "Now watching owner me"},
+		{"alice", "!watch owner me", "You're already watching owner me"},
+		{"alice", "!watch change 1234", "Now watching change 1234"},
+		{"alice", "!watch project qt/qtbase", "You can't watch more than 2 things."},
+		{"alice", "!watches", "Watching owner me|Watching change 1234"},
+		{"alice", "!unwatch change 1234", "No longer watching change 1234"},
+		{"alice", "!unwatch change 1234", "You weren't watching change 1234"},
+		{"bob", "!watch project qt/qtbase", "Now watching project qt/qtbase"},
+		{"carol", "!watch change 1234", "Now watching change 1234"},
+		{"carol", "!unwatch all", "No longer watching anything."},
+	}
+	for _, test := range tests {
+		replies, ok := watches.Command(config, test.Account, test.Text)
+		if got := strings.Join(replies, "|"); got != test.Expected || ok != (len(test.Expected) > 0) {
+			t.Errorf("%s: Expected: %#v, got %#v", test.Text, test.Expected, got)
+		}
+	}
+
+	if len(watches.watchers) != 2 {
+		t.Errorf("Expected watchers alice and bob, got %#v", watches.watchers)
+	}
+}
+
+func TestWatchRecipients(t *testing.T) {
+	config := defaultConfig()
+	watches, _ := loadWatchList("")
+	watches.Command(config, "alice", "!link alice")
+	watches.Command(config, "alice", "!watch owner me")
+	watches.Command(config, "Bob", "!watch project qt/qtbase")
+	watches.Command(config, "carol", "!watch change 1234")
+	watches.Command(config, "dave", "!watch owner alice")
+	watches.Command(config, "frank", "!watch project qt/qtbase")
+	// frank isn't online, and bob has changed nick since
+	watches.online = testOnline(map[string]string{"alice": "alice", "bob": "bob_away", "carol": "carol", "dave": "dave"})
+
+	tests := []struct {
+		Json     string
+		Expected string
+	}{
+		{
+			`{"type": "comment-added", "author": {"name": "Erin", "username": "erin"}, ` + testChange + `}`,
+			"bob_away",
+		},
+		{
+			`{"type": "comment-added", "author": {"name": "Erin", "username": "erin"}, "change": {"project": "qt/qtdoc", "number": 1234, "owner": {"username": "alice"}}}`,
+			"alice carol dave",
+		},
+		{
+			// not to alice, who did it
+			`{"type": "patchset-created", "patchSet": {"uploader": {"username": "alice"}}, "change": {"project": "qt/qtdoc", "number": 1234, "owner": {"username": "alice"}}}`,
+			"carol dave",
+		},
+		{
+			`{"type": "comment-added", "author": {"username": "erin"}, "change": {"project": "qt/qtbase", "number": 1234, "private": true, "owner": {"username": "alice"}}}`,
+			"",
+		},
+		{
+			`{"type": "ref-updated", "submitter": {"username": "erin"}, "refUpdate": {"project": "qt/qtbase", "refName": "refs/heads/dev"}}`,
+			"bob_away",
+		},
+	}
+	for _, test := range tests {
+		msg := testEvent(t, test.Json)
+		if got := strings.Join(watches.Recipients(msg), " "); got != test.Expected {
+			t.Errorf("Expected: %#v, got %#v", test.Expected, got)
+		}
+	}
+}
+
+func TestWatchNotices(t *testing.T) {
+	config := defaultConfig()
+	config.Gerrit.Channel = "#qt-gerrit"
+	config.Gerrit.Projects = []string{"qt/qtbase"}
+	watches, _ := loadWatchList("")
+	watches.Command(config, "bob", "!watch project qt/qtbase")
+	watches.Command(config, "bob", "!watch project qt/qtdoc")
+	watches.online = testOnline(map[string]string{"bob": "bob"})
+
+	var announced, notices []string
+	co := newCoalescer(func(channel string, line string) {
+		announced = append(announced, channel+" "+line)
+	})
+	co.config = func() *Config { return config }
+	notify := func(nick string, line string) {
+		notices = append(notices, nick+" "+line)
+	}
+
+	msg := testEvent(t, `{"type": "comment-added", "author": {"name": "Bob"}, "patchSet": {"number": "2", "uploader": {"name": "Alice"}}, `+testChange+`}`)
+	dispatchGerritEvent(co, watches, notify, config, msg)
+	msg = testEvent(t, `{"type": "comment-added", "author": {"name": "Bob"}, "change": {"project": "qt/qtdoc"}}`)
+	dispatchGerritEvent(co, watches, notify, config, msg)
+
+	expected := "#qt-gerrit [qt/qtbase/dev] Fix it from Alice commented by Bob - https://codereview.qt-project.org/1234"
+	if strings.Join(announced, "\n") != expected {
+		t.Errorf("Expected: %#v, got %#v", expected, announced)
+	}
+	// only what's published at all is sent to watchers
+	expected = "bob [qt/qtbase/dev] Fix it from Alice commented by Bob - https://codereview.qt-project.org/1234"
+	if strings.Join(notices, "\n") != expected {
+		t.Errorf("Expected: %#v, got %#v", expected, notices)
+	}
+}
+
+func TestWatchPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qt_gerrit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "watches.json")
+
+	config := defaultConfig()
+	watches, err := loadWatchList(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	watches.Command(config, "alice", "!link alice")
+	watches.Command(config, "alice", "!watch owner me")
+
+	watches, err = loadWatchList(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	watches.online = testOnline(map[string]string{"alice": "alice_"})
+	replies, _ := watches.Command(config, "Alice", "!watches")
+	if got := strings.Join(replies, "|"); got != "Watching owner me" {
+		t.Errorf("Expected: %#v, got %#v", "Watching owner me", got)
+	}
+	msg := testEvent(t, `{"type": "comment-added", "author": {"username": "bob"}, "change": {"project": "qt/qtbase", "owner": {"username": "alice"}}}`)
+	if got := strings.Join(watches.Recipients(msg), " "); got != "alice_" {
+		t.Errorf("Expected: %#v, got %#v", "alice_", got)
+	}
+
+	ioutil.WriteFile(path, []byte("{"), 0600)
+	if _, err := loadWatchList(path); err == nil {
+		t.Errorf("Expected an error loading a broken file")
+	}
+}